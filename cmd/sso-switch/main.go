@@ -9,21 +9,34 @@ import (
 	"strings"
 
 	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/mock"
 	"github.com/marcogenualdo/sso-switch/internal/auth/oidc"
 	"github.com/marcogenualdo/sso-switch/internal/auth/saml"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
 	"github.com/marcogenualdo/sso-switch/internal/server"
+	"github.com/marcogenualdo/sso-switch/pkg/provider"
 )
 
 const version = "1.0.0"
 
 func main() {
+	registerProviders()
+
 	configPath := flag.String("config", "/etc/sso-switch/config.yaml", "path to configuration file")
 	configPathShort := flag.String("c", "/etc/sso-switch/config.yaml", "path to configuration file (short)")
 	showVersion := flag.Bool("version", false, "show version and exit")
 	showHelp := flag.Bool("help", false, "show help and exit")
-	flag.Parse()
+	profile := flag.String("profile", os.Getenv("SSO_SWITCH_PROFILE"), "config profile to apply (default $SSO_SWITCH_PROFILE)")
+	check := flag.Bool("check", false, "validate config, print the URLs to register at each IdP and exit")
+
+	// dump-config is a subcommand taking the same flags.
+	args := os.Args[1:]
+	dump := len(args) > 0 && args[0] == "dump-config"
+	if dump {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
 
 	if *showVersion {
 		fmt.Printf("SSO Proxy v%s\n", version)
@@ -34,6 +47,8 @@ func main() {
 		fmt.Println("SSO Proxy - Multi-IdP SSO Proxy for OIDC and SAML")
 		fmt.Println("\nUsage:")
 		flag.PrintDefaults()
+		fmt.Println("\nCommands:")
+		fmt.Println("  dump-config\tprint the effective config, with defaults applied and secrets redacted, and exit")
 		os.Exit(0)
 	}
 
@@ -42,14 +57,63 @@ func main() {
 		cfgPath = *configPathShort
 	}
 
-	if err := run(cfgPath); err != nil {
+	if dump {
+		if err := dumpConfig(cfgPath, *profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *check {
+		if err := checkConfig(cfgPath, *profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if err := run(cfgPath, *profile); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run(configPath string) error {
-	cfg, err := config.Load(configPath)
+// registerProviders registers the built-in provider types. Other types can
+// be added without changing this file, by calling provider.Register from an
+// init function in another file of this package.
+func registerProviders() {
+	provider.Register("oidc", func(ctx context.Context, providerCfg provider.Config, env provider.Env) (provider.Provider, error) {
+		var shared *oidc.Provider
+		if ref := providerCfg.OIDC.SharedDiscovery; ref != "" {
+			shared = env.Providers[ref].(*oidc.Provider)
+		}
+		p, err := oidc.NewProvider(ctx, providerCfg, env.Config.OIDC, shared, env.Cache, env.Codec, env.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+
+	provider.Register("saml", func(ctx context.Context, providerCfg provider.Config, env provider.Env) (provider.Provider, error) {
+		p, err := saml.NewProvider(ctx, providerCfg, env.Config.SAML, env.Cache, env.Codec, env.Config.Server.BaseURL, env.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+
+	provider.Register("mock", func(ctx context.Context, providerCfg provider.Config, env provider.Env) (provider.Provider, error) {
+		p, err := mock.NewProvider(providerCfg, env.Cache)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+}
+
+func run(configPath, profile string) error {
+	cfg, err := config.Load(configPath, profile)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
@@ -59,47 +123,59 @@ func run(configPath string) error {
 	}
 
 	logger := setupLogger(cfg.Logging)
-	logger.Info("starting sso-switch", "version", version)
+	logger.Info("starting sso-switch", "version", version, "profile", profile)
+
+	for _, warning := range cfg.Warnings() {
+		logger.Warn("config adjusted", "warning", warning)
+	}
+
+	if cfg.DevMode {
+		logger.Warn("dev_mode is enabled, do not use this configuration in production")
+	}
 
 	cacheInstance, err := cache.New(cfg.Cache)
 	if err != nil {
 		return fmt.Errorf("failed to create cache: %w", err)
 	}
-	logger.Info("cache initialized", "type", cfg.Cache.Type)
+	logger.Info("cache initialized", "type", cfg.Cache.Type, "serialization", cfg.Cache.Serialization)
+
+	codec, err := cache.NewCodec(cfg.Cache.Serialization)
+	if err != nil {
+		return fmt.Errorf("failed to create cache codec: %w", err)
+	}
 
 	ctx := context.Background()
 	providers := make(map[string]auth.Provider)
 
 	for _, providerCfg := range cfg.Providers {
-		var provider auth.Provider
-		var err error
-
-		switch providerCfg.Type {
-		case "oidc":
-			provider, err = oidc.NewProvider(ctx, providerCfg, cacheInstance)
-			if err != nil {
-				return fmt.Errorf("failed to create OIDC provider %s: %w", providerCfg.ID, err)
-			}
-
-		case "saml":
-			provider, err = saml.NewProvider(ctx, providerCfg, cacheInstance, cfg.Server.BaseURL)
-			if err != nil {
-				return fmt.Errorf("failed to create SAML provider %s: %w", providerCfg.ID, err)
-			}
-
-		default:
-			return fmt.Errorf("unsupported provider type: %s", providerCfg.Type)
+		p, err := provider.New(ctx, providerCfg, provider.Env{
+			Config:    *cfg,
+			Cache:     cacheInstance,
+			Codec:     codec,
+			Logger:    logger,
+			Providers: providers,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s provider %s: %w", providerCfg.Type, providerCfg.ID, err)
 		}
 
-		providers[providerCfg.ID] = provider
+		providers[providerCfg.ID] = p
 		logger.Info("provider initialized",
 			"id", providerCfg.ID,
 			"name", providerCfg.Name,
 			"type", providerCfg.Type,
 		)
+
+		for _, endpoint := range auth.Endpoints(cfg.Server.BaseURL, providerCfg) {
+			logger.Info("provider endpoint",
+				"id", providerCfg.ID,
+				"name", endpoint.Name,
+				"url", endpoint.URL,
+			)
+		}
 	}
 
-	srv, err := server.New(*cfg, cacheInstance, providers, logger)
+	srv, err := server.New(*cfg, cacheInstance, codec, providers, logger)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
@@ -107,6 +183,49 @@ func run(configPath string) error {
 	return srv.Start()
 }
 
+func checkConfig(configPath, profile string) error {
+	cfg, err := config.Load(configPath, profile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	for _, warning := range cfg.Warnings() {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	for _, providerCfg := range cfg.Providers {
+		fmt.Printf("%s (%s)\n", providerCfg.ID, providerCfg.Type)
+		for _, endpoint := range auth.Endpoints(cfg.Server.BaseURL, providerCfg) {
+			fmt.Printf("  %-20s %s\n", endpoint.Name, endpoint.URL)
+		}
+	}
+
+	return nil
+}
+
+func dumpConfig(configPath, profile string) error {
+	cfg, err := config.Load(configPath, profile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	data, err := cfg.RedactedYAML()
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	os.Stdout.Write(data)
+	return nil
+}
+
 func setupLogger(cfg config.LoggingConfig) *slog.Logger {
 	var level slog.Level
 	switch strings.ToLower(cfg.Level) {
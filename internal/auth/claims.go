@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/claimexpr"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+var ErrEmailNotVerified = errors.New("email not verified")
+
+// Claims set by the SAML provider from the assertion's AuthnStatement.
+const (
+	AuthnContextClassRefClaim = "authn_context_class_ref"
+	AuthnInstantClaim         = "authn_instant"
+)
+
+// AuthTime returns when the user authenticated at the IdP, from the OIDC
+// auth_time claim or the SAML AuthnInstant.
+func AuthTime(claims map[string]interface{}) (time.Time, bool) {
+	switch v := claims["auth_time"].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case int64:
+		return time.Unix(v, 0), true
+	case uint64:
+		return time.Unix(int64(v), 0), true
+	}
+
+	if instant, ok := claims[AuthnInstantClaim].(string); ok {
+		if t, err := time.Parse(time.RFC3339, instant); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// CheckEmailVerified fails if the email_verified claim is present and false.
+// IdPs send it either as a boolean or as the string "true"/"false".
+func CheckEmailVerified(userInfo map[string]interface{}) error {
+	value, exists := userInfo["email_verified"]
+	if !exists {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if !v {
+			return ErrEmailNotVerified
+		}
+	case string:
+		if strings.EqualFold(v, "false") {
+			return ErrEmailNotVerified
+		}
+	}
+	return nil
+}
+
+// AcceptanceError is returned when the claims of a login fail the provider's
+// accept_if expression. Its message is the configured one.
+type AcceptanceError struct {
+	Message string
+}
+
+func (e *AcceptanceError) Error() string {
+	return e.Message
+}
+
+const defaultAcceptanceMessage = "your account is not permitted to sign in here"
+
+// Acceptor checks logins against a provider's accept_if rule.
+type Acceptor struct {
+	expr    *claimexpr.Expr
+	message string
+}
+
+// NewAcceptor compiles rule. A nil rule returns a nil Acceptor, which accepts
+// every login.
+func NewAcceptor(rule *config.AcceptRule) (*Acceptor, error) {
+	if rule == nil {
+		return nil, nil
+	}
+
+	expr, err := claimexpr.Compile(rule.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid accept_if expression: %w", err)
+	}
+
+	message := rule.Message
+	if message == "" {
+		message = defaultAcceptanceMessage
+	}
+	return &Acceptor{expr: expr, message: message}, nil
+}
+
+// Check returns an AcceptanceError when userInfo does not satisfy the
+// expression. An expression that cannot be evaluated also rejects the login.
+func (a *Acceptor) Check(userInfo map[string]interface{}) error {
+	if a == nil {
+		return nil
+	}
+
+	accepted, err := a.expr.Eval(userInfo)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate accept_if %q: %w", a.expr, err)
+	}
+	if !accepted {
+		return &AcceptanceError{Message: a.message}
+	}
+	return nil
+}
+
+// AssuranceLevel maps the authentication context of a login, the OIDC acr
+// claim or the SAML AuthnContextClassRef, to its level in levels. Unmapped
+// contexts get 0.
+func AssuranceLevel(userInfo map[string]interface{}, levels map[string]int) int {
+	for _, claim := range []string{"acr", AuthnContextClassRefClaim} {
+		if value, ok := userInfo[claim].(string); ok && value != "" {
+			return levels[strings.TrimSpace(value)]
+		}
+	}
+	return 0
+}
+
+type computedClaim struct {
+	name   string
+	tmpl   *template.Template
+	fields []string
+}
+
+// ClaimComputer fills a provider's claim aliases and evaluates its computed
+// claims against its user info.
+type ClaimComputer struct {
+	aliases map[string][]string
+	claims  []computedClaim
+}
+
+func NewClaimComputer(claims []config.ComputedClaim, aliases map[string][]string) (*ClaimComputer, error) {
+	cc := &ClaimComputer{aliases: aliases}
+
+	for _, claim := range claims {
+		tmpl, err := template.New(claim.Name).
+			Option("missingkey=error").
+			Funcs(claimFuncs).
+			Parse(claim.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse computed claim %s: %w", claim.Name, err)
+		}
+
+		fields := make(map[string]bool)
+		templateFields(tmpl.Tree.Root, fields)
+		cc.claims = append(cc.claims, computedClaim{name: claim.Name, tmpl: tmpl, fields: slices.Sorted(maps.Keys(fields))})
+	}
+
+	return cc, nil
+}
+
+// Apply first sets each aliased claim that is missing from the first of its
+// source claims present, then evaluates each template in order and merges the
+// results into userInfo. Templates only see the claims computed before them,
+// so a claim cannot depend on itself, and read claims the login lacks as "".
+// A computed claim the IdP also sent fails, rather than replacing it.
+func (cc *ClaimComputer) Apply(userInfo map[string]interface{}) error {
+	for claim, sources := range cc.aliases {
+		if _, exists := userInfo[claim]; exists {
+			continue
+		}
+		for _, source := range sources {
+			if value, exists := userInfo[source]; exists {
+				userInfo[claim] = value
+				break
+			}
+		}
+	}
+
+	for _, claim := range cc.claims {
+		if _, exists := userInfo[claim.name]; exists {
+			return fmt.Errorf("computed claim %s collides with a claim of the login", claim.name)
+		}
+
+		data := userInfo
+		for _, field := range claim.fields {
+			if _, exists := userInfo[field]; !exists {
+				data = maps.Clone(userInfo)
+				break
+			}
+		}
+		for _, field := range claim.fields {
+			if _, exists := data[field]; !exists {
+				data[field] = ""
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := claim.tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to compute claim %s: %w", claim.name, err)
+		}
+
+		value := strings.TrimSpace(buf.String())
+		if value == "true" || value == "false" {
+			userInfo[claim.name] = value == "true"
+		} else {
+			userInfo[claim.name] = value
+		}
+	}
+
+	return nil
+}
+
+// Merge returns the claims of base updated with fresh and recomputed. The
+// claims computed from base are dropped first, so they do not collide.
+func (cc *ClaimComputer) Merge(base, fresh map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(base)+len(fresh))
+	maps.Copy(merged, base)
+	for _, claim := range cc.claims {
+		delete(merged, claim.name)
+	}
+	maps.Copy(merged, fresh)
+
+	if err := cc.Apply(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// templateFields collects the claims node reads from the template data, such
+// as groups in {{ has .groups "admins" }}.
+func templateFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, fields)
+	case *parse.IfNode:
+		templateFields(&n.BranchNode, fields)
+	case *parse.RangeNode:
+		templateFields(&n.BranchNode, fields)
+	case *parse.WithNode:
+		templateFields(&n.BranchNode, fields)
+	case *parse.BranchNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.TemplateNode:
+		templateFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, fields)
+		}
+	case *parse.ChainNode:
+		templateFields(n.Node, fields)
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	}
+}
+
+var claimFuncs = template.FuncMap{
+	"has":   hasValue,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  joinValues,
+}
+
+func hasValue(collection interface{}, value string) bool {
+	switch v := collection.(type) {
+	case string:
+		return v == value
+	case []string:
+		for _, item := range v {
+			if item == value {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if fmt.Sprintf("%v", item) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinValues(collection interface{}, sep string) string {
+	switch v := collection.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, sep)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = fmt.Sprintf("%v", item)
+		}
+		return strings.Join(parts, sep)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestClaimComputerApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		userInfo map[string]interface{}
+		want     interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "string",
+			template: `{{ lower .email }}`,
+			userInfo: map[string]interface{}{"email": "Alice@Corp.com"},
+			want:     "alice@corp.com",
+		},
+		{
+			name:     "boolean",
+			template: `{{ has .groups "admins" }}`,
+			userInfo: map[string]interface{}{"groups": []interface{}{"admins"}},
+			want:     true,
+		},
+		{
+			name:     "missing claim",
+			template: `{{ .department }}`,
+			userInfo: map[string]interface{}{"sub": "alice"},
+			want:     "",
+		},
+		{
+			name:     "missing claim in a function",
+			template: `{{ has .groups "admins" }}`,
+			userInfo: map[string]interface{}{"sub": "alice"},
+			want:     false,
+		},
+		{
+			name:     "missing claim in a condition",
+			template: `{{ if .department }}{{ .department }}{{ else }}none{{ end }}`,
+			userInfo: map[string]interface{}{"sub": "alice"},
+			want:     "none",
+		},
+		{
+			name:     "missing nested key",
+			template: `{{ .org.id }}`,
+			userInfo: map[string]interface{}{"org": map[string]interface{}{"name": "acme"}},
+			wantErr:  true,
+		},
+		{
+			name:     "collides with an IdP claim",
+			template: `{{ .email }}`,
+			userInfo: map[string]interface{}{"email": "alice@corp.com", "result": "from the IdP"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc, err := NewClaimComputer([]config.ComputedClaim{{Name: "result", Template: tt.template}}, nil)
+			if err != nil {
+				t.Fatalf("NewClaimComputer: %v", err)
+			}
+
+			err = cc.Apply(tt.userInfo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := tt.userInfo["result"]; got != tt.want {
+				t.Errorf("result = %#v, want %#v", got, tt.want)
+			}
+			if _, exists := tt.userInfo["department"]; exists {
+				t.Error("missing claims were added to the user info")
+			}
+		})
+	}
+}
+
+func TestClaimComputerMerge(t *testing.T) {
+	cc, err := NewClaimComputer([]config.ComputedClaim{{Name: "domain", Template: `{{ .hd }}`}}, nil)
+	if err != nil {
+		t.Fatalf("NewClaimComputer: %v", err)
+	}
+
+	base := map[string]interface{}{"hd": "corp.com", "department": "engineering"}
+	if err := cc.Apply(base); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	merged, err := cc.Merge(base, map[string]interface{}{"hd": "example.com"})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	tests := []struct {
+		claim string
+		want  interface{}
+	}{
+		{claim: "domain", want: "example.com"},
+		{claim: "department", want: "engineering"},
+	}
+	for _, tt := range tests {
+		if got := merged[tt.claim]; got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.claim, got, tt.want)
+		}
+	}
+}
+
+func TestCheckEmailVerified(t *testing.T) {
+	tests := []struct {
+		name     string
+		userInfo map[string]interface{}
+		wantErr  bool
+	}{
+		{name: "verified bool", userInfo: map[string]interface{}{"email_verified": true}},
+		{name: "unverified bool", userInfo: map[string]interface{}{"email_verified": false}, wantErr: true},
+		{name: "verified string", userInfo: map[string]interface{}{"email_verified": "true"}},
+		{name: "unverified string", userInfo: map[string]interface{}{"email_verified": "False"}, wantErr: true},
+		{name: "missing claim", userInfo: map[string]interface{}{"email": "alice@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckEmailVerified(tt.userInfo)
+			if tt.wantErr {
+				if !errors.Is(err, ErrEmailNotVerified) {
+					t.Errorf("CheckEmailVerified error = %v, want ErrEmailNotVerified", err)
+				}
+			} else if err != nil {
+				t.Errorf("CheckEmailVerified error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestAssuranceLevel(t *testing.T) {
+	levels := map[string]int{
+		"urn:mace:incommon:iap:silver":                                      2,
+		"urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport": 1,
+		"urn:oasis:names:tc:SAML:2.0:ac:classes:X509":                       3,
+	}
+
+	tests := []struct {
+		name     string
+		userInfo map[string]interface{}
+		want     int
+	}{
+		{name: "oidc acr", userInfo: map[string]interface{}{"acr": "urn:mace:incommon:iap:silver"}, want: 2},
+		{name: "saml context", userInfo: map[string]interface{}{AuthnContextClassRefClaim: "urn:oasis:names:tc:SAML:2.0:ac:classes:X509"}, want: 3},
+		{name: "surrounding whitespace", userInfo: map[string]interface{}{AuthnContextClassRefClaim: " urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport\n"}, want: 1},
+		{name: "acr takes precedence", userInfo: map[string]interface{}{"acr": "urn:mace:incommon:iap:silver", AuthnContextClassRefClaim: "urn:oasis:names:tc:SAML:2.0:ac:classes:X509"}, want: 2},
+		{name: "unmapped", userInfo: map[string]interface{}{"acr": "0"}},
+		{name: "missing", userInfo: map[string]interface{}{"sub": "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AssuranceLevel(tt.userInfo, levels); got != tt.want {
+				t.Errorf("AssuranceLevel = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthTime(t *testing.T) {
+	instant := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "oidc auth_time", claims: map[string]interface{}{"auth_time": float64(instant.Unix())}, want: instant, wantOK: true},
+		{name: "saml authn instant", claims: map[string]interface{}{AuthnInstantClaim: "2026-03-01T09:30:00Z"}, want: instant, wantOK: true},
+		{name: "auth_time wins", claims: map[string]interface{}{"auth_time": float64(instant.Unix()), AuthnInstantClaim: "2020-01-01T00:00:00Z"}, want: instant, wantOK: true},
+		{name: "malformed instant", claims: map[string]interface{}{AuthnInstantClaim: "yesterday"}},
+		{name: "missing", claims: map[string]interface{}{"sub": "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AuthTime(tt.claims)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("AuthTime = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "net/http"
+
+// NewIdPClient returns the HTTP client for requests to an IdP, which adds
+// headers to every request. Without headers it is http.DefaultClient.
+func NewIdPClient(headers map[string]string) *http.Client {
+	if len(headers) == 0 {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &headerTransport{base: http.DefaultTransport, headers: headers},
+	}
+}
+
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
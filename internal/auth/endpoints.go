@@ -0,0 +1,77 @@
+package auth
+
+import "github.com/marcogenualdo/sso-switch/internal/config"
+
+func OIDCCallbackPath(providerID string) string {
+	return "/auth/oidc/" + providerID + "/callback"
+}
+
+func OIDCSilentCallbackPath(providerID string) string {
+	return "/auth/oidc/" + providerID + "/silent/callback"
+}
+
+func OIDCElevateCallbackPath(providerID string) string {
+	return "/auth/oidc/" + providerID + "/elevate/callback"
+}
+
+func SAMLACSPath(providerID string) string {
+	return "/auth/saml/" + providerID + "/acs"
+}
+
+// SAMLSharedACSPath is the ACS used by every SAML provider when
+// saml.shared_acs is enabled.
+const SAMLSharedACSPath = config.SAMLSharedACSPath
+
+func SAMLMetadataPath(providerID string) string {
+	return "/auth/saml/" + providerID + "/metadata"
+}
+
+func MockLoginPath(providerID string) string {
+	return "/auth/mock/" + providerID + "/login"
+}
+
+func MockCallbackPath(providerID string) string {
+	return "/auth/mock/" + providerID + "/callback"
+}
+
+// CallbackPath is the callback of providers of types registered with
+// provider.Register, which their InitiateAuth gets as redirectURL. The
+// callback is served like the OIDC one: HandleCallback creates the session.
+func CallbackPath(providerType, providerID string) string {
+	return "/auth/" + providerType + "/" + providerID + "/callback"
+}
+
+// Endpoint is a URL that has to be registered at the IdP.
+type Endpoint struct {
+	Name string
+	URL  string
+}
+
+// Endpoints returns the URLs a provider will use at runtime, so operators can
+// register them at the IdP.
+func Endpoints(baseURL string, providerCfg config.ProviderConfig) []Endpoint {
+	switch providerCfg.Type {
+	case "oidc":
+		return []Endpoint{
+			{Name: "redirect_uri", URL: baseURL + OIDCCallbackPath(providerCfg.ID)},
+			{Name: "silent_redirect_uri", URL: baseURL + OIDCSilentCallbackPath(providerCfg.ID)},
+			{Name: "elevate_redirect_uri", URL: baseURL + OIDCElevateCallbackPath(providerCfg.ID)},
+		}
+	case "saml":
+		endpoints := []Endpoint{
+			{Name: "metadata_url", URL: baseURL + SAMLMetadataPath(providerCfg.ID)},
+		}
+		if providerCfg.SAML != nil {
+			endpoints = append(endpoints,
+				Endpoint{Name: "sp_entity_id", URL: providerCfg.SAML.SPEntityID},
+				Endpoint{Name: "acs_url", URL: providerCfg.SAML.ACSURL},
+			)
+		}
+		return endpoints
+	case "mock":
+		return nil
+	}
+	return []Endpoint{
+		{Name: "callback_url", URL: baseURL + CallbackPath(providerCfg.Type, providerCfg.ID)},
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestEndpoints(t *testing.T) {
+	const baseURL = "https://sso.example.com"
+
+	tests := []struct {
+		name        string
+		providerCfg config.ProviderConfig
+		want        []Endpoint
+	}{
+		{
+			name:        "oidc",
+			providerCfg: config.ProviderConfig{ID: "corp", Type: "oidc"},
+			want: []Endpoint{
+				{Name: "redirect_uri", URL: baseURL + OIDCCallbackPath("corp")},
+				{Name: "silent_redirect_uri", URL: baseURL + OIDCSilentCallbackPath("corp")},
+				{Name: "elevate_redirect_uri", URL: baseURL + OIDCElevateCallbackPath("corp")},
+			},
+		},
+		{
+			name: "saml",
+			providerCfg: config.ProviderConfig{ID: "partner", Type: "saml", SAML: &config.SAMLConfig{
+				SPEntityID: "https://sso.example.com/saml",
+				ACSURL:     baseURL + SAMLACSPath("partner"),
+			}},
+			want: []Endpoint{
+				{Name: "metadata_url", URL: baseURL + SAMLMetadataPath("partner")},
+				{Name: "sp_entity_id", URL: "https://sso.example.com/saml"},
+				{Name: "acs_url", URL: baseURL + SAMLACSPath("partner")},
+			},
+		},
+		{
+			name:        "saml without settings",
+			providerCfg: config.ProviderConfig{ID: "partner", Type: "saml"},
+			want: []Endpoint{
+				{Name: "metadata_url", URL: baseURL + SAMLMetadataPath("partner")},
+			},
+		},
+		{
+			name:        "mock",
+			providerCfg: config.ProviderConfig{ID: "dev", Type: "mock"},
+		},
+		{
+			name:        "registered type",
+			providerCfg: config.ProviderConfig{ID: "gh", Type: "github"},
+			want: []Endpoint{
+				{Name: "callback_url", URL: baseURL + CallbackPath("github", "gh")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Endpoints(baseURL, tt.providerCfg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Endpoints = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "oidc callback", got: OIDCCallbackPath("corp"), want: "/auth/oidc/corp/callback"},
+		{name: "oidc silent callback", got: OIDCSilentCallbackPath("corp"), want: "/auth/oidc/corp/silent/callback"},
+		{name: "oidc elevate callback", got: OIDCElevateCallbackPath("corp"), want: "/auth/oidc/corp/elevate/callback"},
+		{name: "saml acs", got: SAMLACSPath("partner"), want: "/auth/saml/partner/acs"},
+		{name: "saml metadata", got: SAMLMetadataPath("partner"), want: "/auth/saml/partner/metadata"},
+		{name: "registered callback", got: CallbackPath("github", "gh"), want: "/auth/github/gh/callback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("path = %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// sessionTTL is the lifetime of mock sessions, which have no IdP expiry.
+const sessionTTL = 24 * time.Hour
+
+// Provider logs users in with whatever claims they submit in a form, without
+// an external IdP. It is meant for local development and requires dev_mode.
+type Provider struct {
+	id             string
+	name           string
+	cfg            config.MockConfig
+	headerMappings map[string]config.HeaderMapping
+	computedClaims *auth.ClaimComputer
+	acceptIf       *auth.Acceptor
+	requireEmail   bool
+	cache          cache.Cache
+}
+
+func NewProvider(providerCfg config.ProviderConfig, cache cache.Cache) (*Provider, error) {
+	computedClaims, err := auth.NewClaimComputer(providerCfg.ComputedClaims, providerCfg.ClaimAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	acceptIf, err := auth.NewAcceptor(providerCfg.AcceptIf)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config.MockConfig
+	if providerCfg.Mock != nil {
+		cfg = *providerCfg.Mock
+	}
+
+	return &Provider{
+		id:             providerCfg.ID,
+		name:           providerCfg.Name,
+		cfg:            cfg,
+		headerMappings: providerCfg.HeaderMappings,
+		computedClaims: computedClaims,
+		acceptIf:       acceptIf,
+		requireEmail:   providerCfg.RequireEmailVerified,
+		cache:          cache,
+	}, nil
+}
+
+func (p *Provider) ID() string {
+	return p.id
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Type() string {
+	return "mock"
+}
+
+func (p *Provider) GetHeaderMappings() map[string]config.HeaderMapping {
+	return p.headerMappings
+}
+
+// DefaultClaims returns the claims the login form is prefilled with.
+func (p *Provider) DefaultClaims() map[string]interface{} {
+	return p.cfg.Claims
+}
+
+// InitiateAuth sends the user to the claims form at redirectURL, with a
+// single-use state that the form posts back.
+func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.AuthRedirect, error) {
+	state := uuid.New().String()
+
+	return &auth.AuthRedirect{
+		URL:       redirectURL + "?state=" + url.QueryEscape(state),
+		Method:    "GET",
+		CacheKey:  "mock:state:" + state,
+		CacheData: []byte(p.id),
+		CacheTTL:  5 * time.Minute,
+	}, nil
+}
+
+// HandleCallback creates a session from the claims form, whose claims field
+// holds a JSON object.
+func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth.Session, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse form: %w", err)
+	}
+
+	state := req.PostForm.Get("state")
+	if state == "" {
+		return nil, fmt.Errorf("missing state parameter")
+	}
+
+	providerID, err := p.cache.Get(ctx, "mock:state:"+state)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired state: %w", err)
+	}
+	if string(providerID) != p.id {
+		return nil, fmt.Errorf("provider mismatch")
+	}
+
+	p.cache.Delete(ctx, "mock:state:"+state)
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(req.PostForm.Get("claims")), &claims); err != nil {
+		return nil, fmt.Errorf("claims must be a JSON object: %w", err)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("claims must include a sub")
+	}
+
+	if p.requireEmail {
+		if err := auth.CheckEmailVerified(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.computedClaims.Apply(claims); err != nil {
+		return nil, err
+	}
+
+	if err := p.acceptIf.Check(claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &auth.Session{
+		ID:           uuid.New().String(),
+		ProviderID:   p.id,
+		ProviderType: "mock",
+		UserInfo:     claims,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(sessionTTL),
+		CSRFToken:    uuid.New().String(),
+	}
+
+	return session, nil
+}
+
+func (p *Provider) ValidateSession(ctx context.Context, session *auth.Session) error {
+	if session.ProviderID != p.id {
+		return fmt.Errorf("provider mismatch")
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return fmt.Errorf("session expired")
+	}
+
+	return nil
+}
+
+func (p *Provider) RefreshSession(ctx context.Context, session *auth.Session) (*auth.Session, error) {
+	return nil, &auth.RefreshError{
+		Reason: auth.RefreshReasonUnsupported,
+		Err:    fmt.Errorf("mock sessions cannot be refreshed"),
+	}
+}
@@ -0,0 +1,84 @@
+package mock
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestHandleCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  string
+		state   func(issued string) string
+		wantErr string
+	}{
+		{name: "session", claims: `{"sub": "dev-user", "email": "dev@example.com"}`},
+		{name: "missing sub", claims: `{"email": "dev@example.com"}`, wantErr: "claims must include a sub"},
+		{name: "not an object", claims: `["dev-user"]`, wantErr: "claims must be a JSON object"},
+		{name: "unknown state", claims: `{"sub": "dev-user"}`, state: func(string) string { return "forged" }, wantErr: "invalid or expired state"},
+		{name: "missing state", claims: `{"sub": "dev-user"}`, state: func(string) string { return "" }, wantErr: "missing state parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			p, err := NewProvider(config.ProviderConfig{ID: "dev", Name: "Dev", Type: "mock"}, c)
+			if err != nil {
+				t.Fatalf("NewProvider: %v", err)
+			}
+
+			redirect, err := p.InitiateAuth(ctx, "/auth/mock/dev/login")
+			if err != nil {
+				t.Fatalf("InitiateAuth: %v", err)
+			}
+			if err := c.Set(ctx, redirect.CacheKey, redirect.CacheData.([]byte), redirect.CacheTTL); err != nil {
+				t.Fatalf("store state: %v", err)
+			}
+			formURL, err := url.Parse(redirect.URL)
+			if err != nil {
+				t.Fatalf("parse redirect: %v", err)
+			}
+			state := formURL.Query().Get("state")
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+
+			form := url.Values{"state": {state}, "claims": {tt.claims}}
+			req := httptest.NewRequest("POST", "/auth/mock/dev/callback", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			session, err := p.HandleCallback(ctx, req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("HandleCallback error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandleCallback: %v", err)
+			}
+
+			if session.ProviderID != "dev" || session.ProviderType != "mock" || session.UserInfo["sub"] != "dev-user" {
+				t.Errorf("session = %+v, want a mock session for dev-user", session)
+			}
+			if err := p.ValidateSession(ctx, session); err != nil {
+				t.Errorf("ValidateSession: %v", err)
+			}
+
+			replay := httptest.NewRequest("POST", "/auth/mock/dev/callback", strings.NewReader(form.Encode()))
+			replay.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if _, err := p.HandleCallback(ctx, replay); err == nil {
+				t.Error("state accepted twice")
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionTTL  = time.Minute
+)
+
+// clientAssertionSigner builds the signed JWTs of private_key_jwt client
+// authentication (OIDC Core 9, RFC 7523).
+type clientAssertionSigner struct {
+	clientID string
+	audience string
+	keyID    string
+	alg      string
+	key      crypto.Signer
+}
+
+func newClientAssertionSigner(clientID, audience, keyPath, keyID string) (*clientAssertionSigner, error) {
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyData)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("failed to decode private key PEM")
+	}
+
+	key, err := parseSigningKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var alg string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = "ES256"
+		case elliptic.P384():
+			alg = "ES384"
+		case elliptic.P521():
+			alg = "ES512"
+		default:
+			return nil, fmt.Errorf("unsupported private key curve: %s", k.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("private key must be RSA or ECDSA")
+	}
+
+	return &clientAssertionSigner{
+		clientID: clientID,
+		audience: audience,
+		keyID:    keyID,
+		alg:      alg,
+		key:      key,
+	}, nil
+}
+
+func parseSigningKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key must be RSA or ECDSA")
+	}
+	return signer, nil
+}
+
+// sign returns a fresh assertion with the client as issuer and subject and
+// the token endpoint as audience.
+func (s *clientAssertionSigner) sign() (string, error) {
+	header := map[string]string{"alg": s.alg, "typ": "JWT"}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+
+	jti, err := generateNonce()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": s.clientID,
+		"sub": s.clientID,
+		"aud": s.audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionTTL).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	signature, err := s.signBytes([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s *clientAssertionSigner) signBytes(data []byte) ([]byte, error) {
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		digest := crypto.SHA256.New()
+		digest.Write(data)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		hash := map[string]crypto.Hash{"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512}[s.alg]
+		digest := hash.New()
+		digest.Write(data)
+		r, sig, err := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		// JWS wants r and s as fixed-size big-endian integers, not ASN.1.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		r.FillBytes(out[:size])
+		sig.FillBytes(out[size:])
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type")
+	}
+}
+
+// params returns the form parameters that authenticate the client.
+func (s *clientAssertionSigner) params() (url.Values, error) {
+	assertion, err := s.sign()
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"client_id":             {s.clientID},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+	}, nil
+}
+
+// clientAssertionTransport adds a fresh client assertion to the form body of
+// POST requests to the token endpoint, which covers both the code exchange
+// and refreshes made by the oauth2 package.
+type clientAssertionTransport struct {
+	signer        *clientAssertionSigner
+	tokenEndpoint string
+	base          http.RoundTripper
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.URL.String() != t.tokenEndpoint || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token request: %w", err)
+	}
+	params, err := t.signer.params()
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(form, params)
+
+	encoded := form.Encode()
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(strings.NewReader(encoded))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(encoded)), nil
+	}
+	req.ContentLength = int64(len(encoded))
+	return t.base.RoundTrip(req)
+}
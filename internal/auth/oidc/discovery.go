@@ -0,0 +1,150 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+)
+
+// maxJWKSSize bounds the signing keys read for the discovery cache.
+const maxJWKSSize = 1 << 20
+
+// discoveryCache persists a provider's discovery document and signing keys,
+// under oidc:discovery:<id> and oidc:jwks:<id>.
+type discoveryCache struct {
+	cache      cache.Cache
+	client     *http.Client
+	providerID string
+	ttl        time.Duration
+}
+
+// discover fetches the issuer's discovery document and returns the provider
+// with the raw document.
+func discover(ctx context.Context, issuer string) (*oidc.Provider, []byte, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw json.RawMessage
+	if err := provider.Claims(&raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse discovery document: %w", err)
+	}
+	return provider, raw, nil
+}
+
+// store persists the discovery document raw and the keys at its jwks_uri.
+func (dc *discoveryCache) store(ctx context.Context, raw []byte) error {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(raw, &discovery); err != nil {
+		return fmt.Errorf("failed to parse discovery document: %w", err)
+	}
+
+	keys, err := fetchJWKS(ctx, dc.client, discovery.JWKSURI)
+	if err != nil {
+		return err
+	}
+	if _, err := parseJWKS(keys); err != nil {
+		return err
+	}
+
+	if err := dc.cache.Set(ctx, "oidc:discovery:"+dc.providerID, raw, dc.ttl); err != nil {
+		return fmt.Errorf("failed to cache discovery document: %w", err)
+	}
+	if err := dc.cache.Set(ctx, "oidc:jwks:"+dc.providerID, keys, dc.ttl); err != nil {
+		return fmt.Errorf("failed to cache jwks: %w", err)
+	}
+	return nil
+}
+
+// load builds a provider from the persisted discovery document, which it
+// also returns.
+func (dc *discoveryCache) load(ctx context.Context) (*oidc.Provider, []byte, error) {
+	raw, err := dc.cache.Get(ctx, "oidc:discovery:"+dc.providerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc struct {
+		Issuer        string   `json:"issuer"`
+		AuthURL       string   `json:"authorization_endpoint"`
+		TokenURL      string   `json:"token_endpoint"`
+		DeviceAuthURL string   `json:"device_authorization_endpoint"`
+		UserInfoURL   string   `json:"userinfo_endpoint"`
+		JWKSURL       string   `json:"jwks_uri"`
+		Algorithms    []string `json:"id_token_signing_alg_values_supported"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse cached discovery document: %w", err)
+	}
+
+	providerCfg := oidc.ProviderConfig{
+		IssuerURL:     doc.Issuer,
+		AuthURL:       doc.AuthURL,
+		TokenURL:      doc.TokenURL,
+		DeviceAuthURL: doc.DeviceAuthURL,
+		UserInfoURL:   doc.UserInfoURL,
+		JWKSURL:       doc.JWKSURL,
+		Algorithms:    doc.Algorithms,
+	}
+	return providerCfg.NewProvider(ctx), raw, nil
+}
+
+// keys returns the persisted signing keys.
+func (dc *discoveryCache) keys(ctx context.Context) ([]crypto.PublicKey, error) {
+	data, err := dc.cache.Get(ctx, "oidc:jwks:"+dc.providerID)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+func parseJWKS(data []byte) ([]crypto.PublicKey, error) {
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	var keys []crypto.PublicKey
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if public := key.Public(); public.Key != nil {
+			keys = append(keys, public.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks has no signing keys")
+	}
+	return keys, nil
+}
+
+// cachedKeySet verifies with the persisted signing keys when the remote keys
+// cannot be fetched.
+type cachedKeySet struct {
+	remote oidc.KeySet
+	dc     *discoveryCache
+}
+
+func (ks *cachedKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	payload, err := ks.remote.VerifySignature(ctx, jwt)
+	if err == nil || !isKeyFetchError(err) {
+		return payload, err
+	}
+
+	keys, cacheErr := ks.dc.keys(ctx)
+	if cacheErr != nil {
+		return nil, err
+	}
+	return (&oidc.StaticKeySet{PublicKeys: keys}).VerifySignature(ctx, jwt)
+}
@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// verifyTokenHashes checks the at_hash and c_hash claims of an ID token, when
+// present, against the access token and authorization code it was issued
+// with, to detect substituted tokens (OIDC Core 3.1.3.8 and 3.3.2.11).
+func verifyTokenHashes(rawIDToken string, claims map[string]interface{}, accessToken, code string) error {
+	atHash, _ := claims["at_hash"].(string)
+	cHash, _ := claims["c_hash"].(string)
+	if atHash == "" && cHash == "" {
+		return nil
+	}
+
+	newHash, err := tokenHashFunc(rawIDToken)
+	if err != nil {
+		return err
+	}
+
+	if atHash != "" && !tokenHashMatches(newHash, atHash, accessToken) {
+		return fmt.Errorf("at_hash does not match the access token")
+	}
+	if cHash != "" && !tokenHashMatches(newHash, cHash, code) {
+		return fmt.Errorf("c_hash does not match the authorization code")
+	}
+	return nil
+}
+
+// tokenHashMatches reports whether claim is the base64url encoded left half
+// of the hash of value.
+func tokenHashMatches(newHash func() hash.Hash, claim, value string) bool {
+	h := newHash()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	expected := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(claim)) == 1
+}
+
+// tokenHashFunc returns the hash function of the ID token's signing
+// algorithm, read from its JOSE header.
+func tokenHashFunc(rawIDToken string) (func() hash.Hash, error) {
+	encoded, _, ok := strings.Cut(rawIDToken, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+
+	switch header.Alg {
+	case "RS256", "ES256", "PS256", "HS256":
+		return sha256.New, nil
+	case "RS384", "ES384", "PS384", "HS384":
+		return sha512.New384, nil
+	case "RS512", "ES512", "PS512", "HS512", "EdDSA":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported ID token algorithm for token hashes: %s", header.Alg)
+	}
+}
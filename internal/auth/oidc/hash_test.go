@@ -0,0 +1,91 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// leftHalfHash is the at_hash or c_hash of value for an RS256 ID token.
+func leftHalfHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func TestTokenHashes(t *testing.T) {
+	// The fake IdP issues this access token for the code "code".
+	const accessToken = "access-authorization_code"
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		skip    bool
+		wantErr string
+	}{
+		{name: "no hashes"},
+		{name: "valid at_hash", claims: map[string]interface{}{"at_hash": leftHalfHash(accessToken)}},
+		{name: "valid c_hash", claims: map[string]interface{}{"c_hash": leftHalfHash("code")}},
+		{name: "valid both", claims: map[string]interface{}{"at_hash": leftHalfHash(accessToken), "c_hash": leftHalfHash("code")}},
+		{name: "tampered at_hash", claims: map[string]interface{}{"at_hash": leftHalfHash("other-token")}, wantErr: "at_hash does not match the access token"},
+		{name: "tampered c_hash", claims: map[string]interface{}{"c_hash": leftHalfHash("other-code")}, wantErr: "c_hash does not match the authorization code"},
+		{name: "tampered but skipped", claims: map[string]interface{}{"at_hash": leftHalfHash("other-token")}, skip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			idp.set(func(idp *fakeIdP) { idp.idTokenClaims = tt.claims })
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.SkipTokenHashCheck = tt.skip
+			p := env.newProvider(t, providerCfg, nil)
+
+			_, err := env.login(t, p, idp, "https://sso.example.com/auth/oidc/corp/callback")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("login: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("login error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenHashFunc(t *testing.T) {
+	tests := []struct {
+		alg      string
+		wantSize int
+		wantErr  bool
+	}{
+		{alg: "RS256", wantSize: 32},
+		{alg: "ES384", wantSize: 48},
+		{alg: "PS512", wantSize: 64},
+		{alg: "EdDSA", wantSize: 64},
+		{alg: "none", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + tt.alg + `"}`))
+			newHash, err := tokenHashFunc(header + ".payload.signature")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("tokenHashFunc succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tokenHashFunc: %v", err)
+			}
+			if size := newHash().Size(); size != tt.wantSize {
+				t.Errorf("hash size = %d, want %d", size, tt.wantSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// fakeIdP is an OIDC identity provider serving discovery, JWKS, token and
+// userinfo endpoints under its issuer URL.
+type fakeIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	issuer string
+	alg    jose.SignatureAlgorithm
+	key    crypto.Signer
+
+	mu             sync.Mutex
+	nonce          string
+	idTokenClaims  map[string]interface{}
+	userInfo       map[string]interface{}
+	tokenRequests  []url.Values
+	revokeRequests []url.Values
+	revokeStatus   int
+	discoveryHits  int
+	// requestHeaders holds the headers of the last request to each path.
+	requestHeaders map[string]http.Header
+	jwksHits       int
+	jwksDown       bool
+	omitRefreshIDT bool
+
+	accessTokenIsIDToken bool
+	// accessTokenClaims, when set, makes the access token a JWT with the ID
+	// token claims overridden by these; nil values remove a claim.
+	accessTokenClaims map[string]interface{}
+}
+
+// newFakeIdP starts an IdP signing with alg, one of RS256, PS256 or ES256.
+func newFakeIdP(t *testing.T, alg jose.SignatureAlgorithm) *fakeIdP {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case jose.ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	idp := &fakeIdP{t: t, alg: alg, key: key}
+	idp.server = httptest.NewServer(http.HandlerFunc(idp.serveHTTP))
+	idp.issuer = idp.server.URL
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdP) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	if idp.requestHeaders == nil {
+		idp.requestHeaders = make(map[string]http.Header)
+	}
+	idp.requestHeaders[r.URL.Path] = r.Header.Clone()
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		idp.discoveryHits++
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                idp.issuer,
+			"authorization_endpoint":                idp.issuer + "/authorize",
+			"token_endpoint":                        idp.issuer + "/token",
+			"userinfo_endpoint":                     idp.issuer + "/userinfo",
+			"jwks_uri":                              idp.issuer + "/jwks",
+			"revocation_endpoint":                   idp.issuer + "/revoke",
+			"id_token_signing_alg_values_supported": []string{string(idp.alg)},
+		})
+	case "/jwks":
+		idp.jwksHits++
+		if idp.jwksDown {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			Key:       idp.key.Public(),
+			KeyID:     "test",
+			Algorithm: string(idp.alg),
+			Use:       "sig",
+		}}})
+	case "/token":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		idp.tokenRequests = append(idp.tokenRequests, r.PostForm)
+
+		response := map[string]interface{}{
+			"access_token":  "access-" + r.PostForm.Get("grant_type"),
+			"refresh_token": "refresh",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		}
+		if r.PostForm.Get("grant_type") == "authorization_code" || !idp.omitRefreshIDT {
+			response["id_token"] = idp.signLocked(idp.tokenClaimsLocked())
+		}
+		if idp.accessTokenIsIDToken {
+			response["access_token"] = idp.signLocked(idp.tokenClaimsLocked())
+		}
+		if idp.accessTokenClaims != nil {
+			claims := idp.tokenClaimsLocked()
+			for name, value := range idp.accessTokenClaims {
+				if value == nil {
+					delete(claims, name)
+					continue
+				}
+				claims[name] = value
+			}
+			response["access_token"] = idp.signLocked(claims)
+		}
+		writeJSON(w, response)
+	case "/userinfo":
+		writeJSON(w, idp.userInfo)
+	case "/revoke":
+		if user, pass, ok := r.BasicAuth(); !ok || user != "client" || pass != "secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		idp.revokeRequests = append(idp.revokeRequests, r.PostForm)
+		if idp.revokeStatus != 0 {
+			w.WriteHeader(idp.revokeStatus)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (idp *fakeIdP) tokenClaimsLocked() map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": idp.issuer,
+		"aud": "client",
+		"sub": "alice",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	if idp.nonce != "" {
+		claims["nonce"] = idp.nonce
+	}
+	for name, value := range idp.idTokenClaims {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func (idp *fakeIdP) signLocked(claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: idp.alg,
+		Key:       jose.JSONWebKey{Key: idp.key, KeyID: "test"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		idp.t.Fatalf("create signer: %v", err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Fatalf("marshal claims: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		idp.t.Fatalf("sign token: %v", err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		idp.t.Fatalf("serialize token: %v", err)
+	}
+	return token
+}
+
+// set updates the IdP state under its lock.
+func (idp *fakeIdP) set(fn func(idp *fakeIdP)) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	fn(idp)
+}
+
+func (idp *fakeIdP) lastTokenRequest() url.Values {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	if len(idp.tokenRequests) == 0 {
+		idp.t.Fatal("no token request was made")
+	}
+	return idp.tokenRequests[len(idp.tokenRequests)-1]
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// testEnv holds the cache, codec and OIDC defaults shared by the providers
+// of a test.
+type testEnv struct {
+	cache    cache.Cache
+	codec    *cache.Codec
+	defaults config.OIDCDefaults
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("create codec: %v", err)
+	}
+	return &testEnv{cache: c, codec: codec, defaults: testDefaults()}
+}
+
+func testProviderConfig(id string, idp *fakeIdP) config.ProviderConfig {
+	return config.ProviderConfig{
+		ID:   id,
+		Name: id,
+		Type: "oidc",
+		OIDC: &config.OIDCConfig{
+			Issuer:       idp.issuer,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"openid", "email"},
+		},
+	}
+}
+
+func testDefaults() config.OIDCDefaults {
+	return config.OIDCDefaults{
+		JWKS: config.JWKSConfig{
+			RetryBackoff:     time.Millisecond,
+			FailureThreshold: 5,
+			NegativeCacheTTL: time.Second,
+		},
+	}
+}
+
+func (env *testEnv) newProvider(t *testing.T, providerCfg config.ProviderConfig, shared *Provider) *Provider {
+	t.Helper()
+
+	p, err := NewProvider(context.Background(), providerCfg, env.defaults, shared, env.cache, env.codec, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return p
+}
+
+// login runs an authorization code flow against idp and returns the
+// resulting session.
+func (env *testEnv) login(t *testing.T, p *Provider, idp *fakeIdP, redirectURL string) (*auth.Session, error) {
+	t.Helper()
+
+	redirect, err := p.InitiateAuth(context.Background(), redirectURL)
+	if err != nil {
+		t.Fatalf("InitiateAuth: %v", err)
+	}
+	return env.complete(t, p, idp, redirect)
+}
+
+// complete finishes the flow started by redirect as if the IdP redirected
+// back with a code.
+func (env *testEnv) complete(t *testing.T, p *Provider, idp *fakeIdP, redirect *auth.AuthRedirect) (*auth.Session, error) {
+	t.Helper()
+
+	if err := env.cache.Set(context.Background(), redirect.CacheKey, redirect.CacheData.([]byte), redirect.CacheTTL); err != nil {
+		t.Fatalf("store state: %v", err)
+	}
+
+	authURL, err := url.Parse(redirect.URL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	idp.set(func(idp *fakeIdP) { idp.nonce = authURL.Query().Get("nonce") })
+
+	callback := httptest.NewRequest("GET", "/callback?code=code&state="+url.QueryEscape(authURL.Query().Get("state")), nil)
+	return p.HandleCallback(context.Background(), callback)
+}
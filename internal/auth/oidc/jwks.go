@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// resilientKeySet wraps a remote key set with retries on key fetch failures.
+// Once FailureThreshold consecutive verifications failed to fetch keys, the
+// circuit opens and verification fails fast until NegativeCacheTTL elapses.
+type resilientKeySet struct {
+	cfg config.JWKSConfig
+
+	mu        sync.Mutex
+	keySet    oidc.KeySet
+	failures  int
+	lastErr   error
+	openUntil time.Time
+}
+
+func newResilientKeySet(keySet oidc.KeySet, cfg config.JWKSConfig) *resilientKeySet {
+	return &resilientKeySet{
+		keySet: keySet,
+		cfg:    cfg,
+	}
+}
+
+func (ks *resilientKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	if err := ks.circuitError(); err != nil {
+		return nil, err
+	}
+
+	backoff := ks.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		payload, err := ks.current().VerifySignature(ctx, jwt)
+		if err == nil || !isKeyFetchError(err) {
+			ks.recordSuccess()
+			return payload, err
+		}
+
+		if attempt >= ks.maxRetries() {
+			ks.recordFailure(err)
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			ks.recordFailure(err)
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (ks *resilientKeySet) maxRetries() int {
+	if ks.cfg.MaxRetries == nil {
+		return 0
+	}
+	return *ks.cfg.MaxRetries
+}
+
+// Replace swaps in a new key set, e.g. one with an empty cache so that the
+// keys are fetched again, and closes the circuit.
+func (ks *resilientKeySet) Replace(keySet oidc.KeySet) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keySet = keySet
+	ks.failures = 0
+	ks.lastErr = nil
+	ks.openUntil = time.Time{}
+}
+
+func (ks *resilientKeySet) current() oidc.KeySet {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.keySet
+}
+
+// Health returns the last key fetch error while fetches are failing.
+func (ks *resilientKeySet) Health() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.failures == 0 {
+		return nil
+	}
+	return fmt.Errorf("jwks fetch failing (%d consecutive): %w", ks.failures, ks.lastErr)
+}
+
+func (ks *resilientKeySet) circuitError() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if time.Now().Before(ks.openUntil) {
+		return fmt.Errorf("jwks unavailable, retrying after %s: %w", ks.openUntil.Format(time.RFC3339), ks.lastErr)
+	}
+	return nil
+}
+
+func (ks *resilientKeySet) recordSuccess() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.failures = 0
+	ks.lastErr = nil
+	ks.openUntil = time.Time{}
+}
+
+func (ks *resilientKeySet) recordFailure(err error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.failures++
+	ks.lastErr = err
+	if ks.failures >= ks.cfg.FailureThreshold {
+		ks.openUntil = time.Now().Add(ks.cfg.NegativeCacheTTL)
+	}
+}
+
+// keyFetchError is returned by remoteKeySet when the signing keys cannot be
+// fetched, as opposed to a token failing verification.
+type keyFetchError struct {
+	err error
+}
+
+func (e *keyFetchError) Error() string {
+	return "fetching keys: " + e.err.Error()
+}
+
+func (e *keyFetchError) Unwrap() error {
+	return e.err
+}
+
+// isKeyFetchError reports whether err comes from fetching the remote keys
+// rather than from an invalid signature.
+func isKeyFetchError(err error) bool {
+	var fetchErr *keyFetchError
+	return errors.As(err, &fetchErr)
+}
+
+// remoteKeySet verifies tokens with the keys published at jwksURI. The keys
+// are cached and fetched again when no cached key verifies a token, so that
+// rotated keys are picked up. Fetch failures are returned as keyFetchError.
+type remoteKeySet struct {
+	client  *http.Client
+	jwksURI string
+
+	mu   sync.Mutex
+	keys []crypto.PublicKey
+}
+
+func newRemoteKeySet(client *http.Client, jwksURI string) *remoteKeySet {
+	return &remoteKeySet{client: client, jwksURI: jwksURI}
+}
+
+func (ks *remoteKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	ks.mu.Lock()
+	keys := ks.keys
+	ks.mu.Unlock()
+
+	if len(keys) > 0 {
+		if payload, err := (&oidc.StaticKeySet{PublicKeys: keys}).VerifySignature(ctx, jwt); err == nil {
+			return payload, nil
+		}
+	}
+
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		return nil, &keyFetchError{err: err}
+	}
+	return (&oidc.StaticKeySet{PublicKeys: keys}).VerifySignature(ctx, jwt)
+}
+
+// fetch replaces the cached keys with the ones currently published.
+// Concurrent callers wait for a single fetch.
+func (ks *remoteKeySet) fetch(ctx context.Context) ([]crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	data, err := fetchJWKS(ctx, ks.client, ks.jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	ks.keys = keys
+	return keys, nil
+}
+
+// fetchJWKS returns the raw key set published at jwksURI.
+func fetchJWKS(ctx context.Context, client *http.Client, jwksURI string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jwks request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks: %w", err)
+	}
+	return data, nil
+}
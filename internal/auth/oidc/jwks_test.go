@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestIsKeyFetchError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "fetch error", err: &keyFetchError{err: errors.New("connection refused")}, want: true},
+		{name: "wrapped fetch error", err: fmt.Errorf("verify: %w", &keyFetchError{err: errors.New("timeout")}), want: true},
+		{name: "message only", err: errors.New("fetching keys failed"), want: false},
+		{name: "invalid signature", err: errors.New("failed to verify signature"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKeyFetchError(tt.err); got != tt.want {
+				t.Errorf("isKeyFetchError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	var token string
+	idp.set(func(idp *fakeIdP) { token = idp.signLocked(idp.tokenClaimsLocked()) })
+
+	ks := newRemoteKeySet(http.DefaultClient, idp.issuer+"/jwks")
+	for range 2 {
+		if _, err := ks.VerifySignature(context.Background(), token); err != nil {
+			t.Fatalf("VerifySignature: %v", err)
+		}
+	}
+	if idp.jwksHits != 1 {
+		t.Errorf("jwks fetched %d times, want the cached keys reused", idp.jwksHits)
+	}
+
+	other := newFakeIdP(t, jose.RS256)
+	var forged string
+	other.set(func(other *fakeIdP) { forged = other.signLocked(other.tokenClaimsLocked()) })
+	_, err := ks.VerifySignature(context.Background(), forged)
+	if err == nil {
+		t.Fatal("token signed with an unknown key verified")
+	}
+	if isKeyFetchError(err) {
+		t.Errorf("invalid signature reported as a key fetch error: %v", err)
+	}
+
+	idp.set(func(idp *fakeIdP) { idp.jwksDown = true })
+	ks = newRemoteKeySet(http.DefaultClient, idp.issuer+"/jwks")
+	if _, err := ks.VerifySignature(context.Background(), token); !isKeyFetchError(err) {
+		t.Errorf("VerifySignature with the jwks_uri down = %v, want a key fetch error", err)
+	}
+}
+
+func TestResilientKeySetRetries(t *testing.T) {
+	retries := func(n int) *int { return &n }
+
+	tests := []struct {
+		name       string
+		maxRetries *int
+		wantHits   int
+	}{
+		{name: "unset", maxRetries: nil, wantHits: 1},
+		{name: "disabled", maxRetries: retries(0), wantHits: 1},
+		{name: "two retries", maxRetries: retries(2), wantHits: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			var token string
+			idp.set(func(idp *fakeIdP) {
+				idp.jwksDown = true
+				token = idp.signLocked(idp.tokenClaimsLocked())
+			})
+
+			ks := newResilientKeySet(newRemoteKeySet(http.DefaultClient, idp.issuer+"/jwks"), config.JWKSConfig{
+				MaxRetries:       tt.maxRetries,
+				RetryBackoff:     time.Millisecond,
+				FailureThreshold: 5,
+				NegativeCacheTTL: time.Second,
+			})
+			if _, err := ks.VerifySignature(context.Background(), token); err == nil {
+				t.Fatal("VerifySignature succeeded with the jwks_uri down")
+			}
+			if idp.jwksHits != tt.wantHits {
+				t.Errorf("jwks fetched %d times, want %d", idp.jwksHits, tt.wantHits)
+			}
+		})
+	}
+}
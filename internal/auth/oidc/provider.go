@@ -1,13 +1,22 @@
 package oidc
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
@@ -22,22 +31,65 @@ type Provider struct {
 	id             string
 	name           string
 	cfg            config.OIDCConfig
-	headerMappings map[string]string
+	headerMappings map[string]config.HeaderMapping
+	computedClaims *auth.ClaimComputer
+	acceptIf       *auth.Acceptor
+	requireEmail   bool
 	cache          cache.Cache
-
-	provider      *oidc.Provider
-	oauth2Config  oauth2.Config
-	verifier      *oidc.IDTokenVerifier
+	codec          *cache.Codec
+	client         *http.Client
+	logger         *slog.Logger
+
+	provider           *oidc.Provider
+	oauth2Config       oauth2.Config
+	verifier           *oidc.IDTokenVerifier
+	accessVerifier     *oidc.IDTokenVerifier
+	keySet             *resilientKeySet
+	discoveryCache     *discoveryCache
+	sharedWith         *Provider
+	clockSkew          time.Duration
+	revocationEndpoint string
+	endSessionEndpoint string
+	claimsRequest      string
+	assertionSigner    *clientAssertionSigner
 }
 
-func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache cache.Cache) (*Provider, error) {
+// NewProvider creates an OIDC provider. When shared is set, the provider
+// still discovers its own endpoints but reuses the signing keys of shared
+// instead of fetching them.
+func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, defaults config.OIDCDefaults, shared *Provider, cache cache.Cache, codec *cache.Codec, logger *slog.Logger) (*Provider, error) {
 	if providerCfg.OIDC == nil {
 		return nil, fmt.Errorf("OIDC config is required")
 	}
 
-	provider, err := oidc.NewProvider(ctx, providerCfg.OIDC.Issuer)
-	if err != nil {
+	client := auth.NewIdPClient(providerCfg.IDPRequestHeaders)
+	ctx = oidc.ClientContext(ctx, client)
+
+	var dc *discoveryCache
+	if defaults.DiscoveryCache.Enabled {
+		dc = &discoveryCache{
+			cache:      cache,
+			client:     client,
+			providerID: providerCfg.ID,
+			ttl:        defaults.DiscoveryCache.TTL,
+		}
+	}
+
+	provider, rawDiscovery, err := discover(ctx, providerCfg.OIDC.Issuer)
+	switch {
+	case err != nil && dc != nil:
+		var cacheErr error
+		provider, rawDiscovery, cacheErr = dc.load(ctx)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
+		}
+		logger.Warn("discovery failed, starting from the cached discovery document", "provider", providerCfg.ID, "error", err)
+	case err != nil:
 		return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
+	case dc != nil:
+		if err := dc.store(ctx, rawDiscovery); err != nil {
+			logger.Warn("failed to cache discovery document", "provider", providerCfg.ID, "error", err)
+		}
 	}
 
 	oauth2Config := oauth2.Config{
@@ -47,19 +99,119 @@ func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache c
 		Scopes:       providerCfg.OIDC.Scopes,
 	}
 
-	verifier := provider.Verifier(&oidc.Config{
-		ClientID: providerCfg.OIDC.ClientID,
+	var assertionSigner *clientAssertionSigner
+	if providerCfg.OIDC.ClientAuthMethod == "private_key_jwt" {
+		tokenEndpoint := provider.Endpoint().TokenURL
+		assertionSigner, err = newClientAssertionSigner(providerCfg.OIDC.ClientID, tokenEndpoint, providerCfg.OIDC.PrivateKeyPath, providerCfg.OIDC.PrivateKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load private_key_jwt key: %w", err)
+		}
+
+		// The assertion replaces the secret: the oauth2 package sends the
+		// client_id alone in the body and the transport signs each token
+		// request, exchanges and refreshes alike.
+		oauth2Config.ClientSecret = ""
+		oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client = &http.Client{
+			Transport: &clientAssertionTransport{signer: assertionSigner, tokenEndpoint: tokenEndpoint, base: base},
+		}
+	}
+
+	computedClaims, err := auth.NewClaimComputer(providerCfg.ComputedClaims, providerCfg.ClaimAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	acceptIf, err := auth.NewAcceptor(providerCfg.AcceptIf)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovery struct {
+		JWKSURI            string   `json:"jwks_uri"`
+		RevocationEndpoint string   `json:"revocation_endpoint"`
+		EndSessionEndpoint string   `json:"end_session_endpoint"`
+		SigningAlgs        []string `json:"id_token_signing_alg_values_supported"`
+	}
+	if err := json.Unmarshal(rawDiscovery, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery document: %w", err)
+	}
+	if providerCfg.OIDC.RevokeOnLogout && discovery.RevocationEndpoint == "" {
+		return nil, fmt.Errorf("revoke_on_logout is set but the provider has no revocation_endpoint")
+	}
+
+	var endSessionEndpoint string
+	if providerCfg.OIDC.RPInitiatedLogout {
+		endSessionEndpoint = discovery.EndSessionEndpoint
+		if endSessionEndpoint == "" {
+			logger.Warn("rp_initiated_logout is set but the provider has no end_session_endpoint, logging out locally only", "provider", providerCfg.ID)
+		}
+	}
+
+	var claimsRequest bytes.Buffer
+	if providerCfg.OIDC.ClaimsRequest != "" {
+		if err := json.Compact(&claimsRequest, []byte(providerCfg.OIDC.ClaimsRequest)); err != nil {
+			return nil, fmt.Errorf("invalid claims_request: %w", err)
+		}
+	}
+
+	var keySet *resilientKeySet
+	if shared != nil {
+		keySet = shared.keySet
+	} else {
+		keySet = newResilientKeySet(newKeySet(client, discovery.JWKSURI, dc), defaults.JWKS)
+	}
+	// With a clock skew, token times are checked by verify instead.
+	// go-oidc only accepts RS256 unless told otherwise, so the algorithms
+	// advertised by the IdP are allowed.
+	verifier := oidc.NewVerifier(providerCfg.OIDC.Issuer, keySet, &oidc.Config{
+		ClientID:             providerCfg.OIDC.ClientID,
+		SupportedSigningAlgs: discovery.SigningAlgs,
+		SkipExpiryCheck:      defaults.ClockSkew > 0,
 	})
 
+	var accessVerifier *oidc.IDTokenVerifier
+	if providerCfg.OIDC.ValidateAccessToken {
+		audience := providerCfg.OIDC.AccessTokenAudience
+		if audience == "" {
+			audience = providerCfg.OIDC.ClientID
+		}
+		accessVerifier = oidc.NewVerifier(providerCfg.OIDC.Issuer, keySet, &oidc.Config{
+			ClientID:             audience,
+			SupportedSigningAlgs: discovery.SigningAlgs,
+			SkipExpiryCheck:      defaults.ClockSkew > 0,
+		})
+	}
+
 	return &Provider{
 		id:             providerCfg.ID,
 		name:           providerCfg.Name,
 		cfg:            *providerCfg.OIDC,
 		headerMappings: providerCfg.HeaderMappings,
+		computedClaims: computedClaims,
+		acceptIf:       acceptIf,
+		requireEmail:   providerCfg.RequireEmailVerified,
 		cache:          cache,
+		codec:          codec,
+		client:         client,
+		logger:         logger,
 		provider:       provider,
 		oauth2Config:   oauth2Config,
 		verifier:       verifier,
+		accessVerifier: accessVerifier,
+		discoveryCache: dc,
+		sharedWith:     shared,
+		clockSkew:      defaults.ClockSkew,
+		keySet:         keySet,
+
+		revocationEndpoint: discovery.RevocationEndpoint,
+		endSessionEndpoint: endSessionEndpoint,
+		claimsRequest:      claimsRequest.String(),
+		assertionSigner:    assertionSigner,
 	}, nil
 }
 
@@ -75,11 +227,104 @@ func (p *Provider) Type() string {
 	return "oidc"
 }
 
-func (p *Provider) GetHeaderMappings() map[string]string {
+func (p *Provider) GetHeaderMappings() map[string]config.HeaderMapping {
 	return p.headerMappings
 }
 
+// Health reports key fetch failures seen while verifying tokens.
+func (p *Provider) Health() error {
+	return p.keySet.Health()
+}
+
+// RefreshKeys re-fetches the discovery document and drops the cached signing
+// keys, so that the next verification fetches them from the current jwks_uri.
+// With the discovery cache, the persisted document and keys are updated too.
+// Providers with shared_discovery refresh the signing keys they share.
+func (p *Provider) RefreshKeys(ctx context.Context) error {
+	if p.sharedWith != nil {
+		return p.sharedWith.RefreshKeys(ctx)
+	}
+
+	ctx = oidc.ClientContext(ctx, p.client)
+	_, rawDiscovery, err := discover(ctx, p.cfg.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(rawDiscovery, &discovery); err != nil {
+		return fmt.Errorf("failed to parse discovery document: %w", err)
+	}
+
+	if p.discoveryCache != nil {
+		if err := p.discoveryCache.store(ctx, rawDiscovery); err != nil {
+			return err
+		}
+	}
+
+	p.keySet.Replace(newKeySet(p.client, discovery.JWKSURI, p.discoveryCache))
+	return nil
+}
+
+// newKeySet returns the remote key set at jwksURI, falling back to the keys
+// persisted in dc, when set, while they cannot be fetched.
+func newKeySet(client *http.Client, jwksURI string, dc *discoveryCache) oidc.KeySet {
+	remote := newRemoteKeySet(client, jwksURI)
+	if dc == nil {
+		return remote
+	}
+	return &cachedKeySet{remote: remote, dc: dc}
+}
+
 func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.AuthRedirect, error) {
+	return p.initiateAuth(redirectURL, p.oauth2Config.Scopes)
+}
+
+// InitiateAuthWithOptions requests the configured scopes plus the additional
+// scopes, each of which must be in allowed_additional_scopes, and adds the
+// extra parameters, each of which must be in allowed_extra_params.
+func (p *Provider) InitiateAuthWithOptions(ctx context.Context, redirectURL string, opts auth.LoginOptions) (*auth.AuthRedirect, error) {
+	scopes := append([]string(nil), p.oauth2Config.Scopes...)
+	for _, scope := range opts.AdditionalScopes {
+		if !slices.Contains(p.cfg.AllowedAdditionalScopes, scope) {
+			return nil, fmt.Errorf("%w: %s", auth.ErrScopeNotAllowed, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	authOpts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("scope", strings.Join(scopes, " "))}
+	for name, value := range opts.ExtraParams {
+		if !slices.Contains(p.cfg.AllowedExtraParams, name) {
+			return nil, fmt.Errorf("%w: %s", auth.ErrParamNotAllowed, name)
+		}
+		authOpts = append(authOpts, oauth2.SetAuthURLParam(name, value))
+	}
+
+	return p.initiateAuth(redirectURL, scopes, authOpts...)
+}
+
+// InitiateSilentAuth starts an authorization request with prompt=none, so the
+// IdP answers immediately with either a code or login_required.
+func (p *Provider) InitiateSilentAuth(ctx context.Context, redirectURL string) (*auth.AuthRedirect, error) {
+	return p.initiateAuth(redirectURL, p.oauth2Config.Scopes, oauth2.SetAuthURLParam("prompt", "none"))
+}
+
+// InitiateElevation starts an authorization request with prompt=login, so the
+// user has to authenticate interactively even with an active IdP session.
+// max_age=0 makes the IdP include auth_time in the ID token, so that the
+// caller can check the login was fresh.
+func (p *Provider) InitiateElevation(ctx context.Context, redirectURL string) (*auth.AuthRedirect, error) {
+	return p.initiateAuth(redirectURL, p.oauth2Config.Scopes,
+		oauth2.SetAuthURLParam("prompt", "login"),
+		oauth2.SetAuthURLParam("max_age", "0"),
+	)
+}
+
+func (p *Provider) initiateAuth(redirectURL string, scopes []string, opts ...oauth2.AuthCodeOption) (*auth.AuthRedirect, error) {
 	codeVerifier, err := generateCodeVerifier()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate code verifier: %w", err)
@@ -87,15 +332,35 @@ func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.
 
 	codeChallenge := generateCodeChallenge(codeVerifier)
 
+	nonce, err := generateNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
 	state := uuid.New().String()
 
-	p.oauth2Config.RedirectURL = redirectURL
+	// Flow-specific options come last, so that their prompt wins.
+	var configured []oauth2.AuthCodeOption
+	if p.cfg.Prompt != "" {
+		configured = append(configured, oauth2.SetAuthURLParam("prompt", p.cfg.Prompt))
+	}
+	if p.cfg.MaxAge > 0 {
+		configured = append(configured, oauth2.SetAuthURLParam("max_age", strconv.Itoa(p.cfg.MaxAge)))
+	}
+	opts = append(configured, opts...)
 
-	authURL := p.oauth2Config.AuthCodeURL(
-		state,
+	// The redirect URI differs between login flows, so it is passed per
+	// request rather than set on the shared oauth2 config.
+	opts = append(opts,
+		oauth2.SetAuthURLParam("redirect_uri", redirectURL),
 		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
 		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("nonce", nonce),
 	)
+	if p.claimsRequest != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("claims", p.claimsRequest))
+	}
+	authURL := p.oauth2Config.AuthCodeURL(state, opts...)
 
 	if p.cfg.HD != "" {
 		authURL += "&hd=" + p.cfg.HD
@@ -105,11 +370,13 @@ func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.
 		State:        state,
 		ProviderID:   p.id,
 		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
 		RedirectURL:  redirectURL,
+		Scopes:       scopes,
 		CreatedAt:    time.Now(),
 	}
 
-	stateData, err := json.Marshal(oidcState)
+	stateData, err := p.codec.Marshal(oidcState)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal state: %w", err)
 	}
@@ -127,6 +394,16 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 	code := req.URL.Query().Get("code")
 	state := req.URL.Query().Get("state")
 
+	if errCode := req.URL.Query().Get("error"); errCode != "" {
+		if state != "" {
+			p.cache.Delete(ctx, "oidc:state:"+state)
+		}
+		return nil, &auth.IdPError{
+			Code:        errCode,
+			Description: req.URL.Query().Get("error_description"),
+		}
+	}
+
 	if code == "" {
 		return nil, fmt.Errorf("missing code parameter")
 	}
@@ -140,7 +417,7 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 	}
 
 	var oidcState auth.OIDCState
-	if err := json.Unmarshal(stateData, &oidcState); err != nil {
+	if err := p.codec.Unmarshal(stateData, &oidcState); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
 	}
 
@@ -150,11 +427,10 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 
 	p.cache.Delete(ctx, "oidc:state:"+state)
 
-	p.oauth2Config.RedirectURL = oidcState.RedirectURL
-
 	oauth2Token, err := p.oauth2Config.Exchange(
-		ctx,
+		oidc.ClientContext(ctx, p.client),
 		code,
+		oauth2.SetAuthURLParam("redirect_uri", oidcState.RedirectURL),
 		oauth2.SetAuthURLParam("code_verifier", oidcState.CodeVerifier),
 	)
 	if err != nil {
@@ -166,16 +442,63 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 		return nil, fmt.Errorf("no id_token in token response")
 	}
 
-	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err := p.verifyAccessToken(ctx, oauth2Token.AccessToken); err != nil {
+		return nil, err
+	}
+
+	idToken, err := p.verify(ctx, p.verifier, rawIDToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
 
+	if idToken.Nonce == "" {
+		return nil, fmt.Errorf("ID token has no nonce")
+	}
+	if idToken.Nonce != oidcState.Nonce {
+		return nil, fmt.Errorf("ID token nonce does not match the authorization request")
+	}
+
 	var claims map[string]interface{}
 	if err := idToken.Claims(&claims); err != nil {
 		return nil, fmt.Errorf("failed to parse claims: %w", err)
 	}
 
+	if err := p.checkAuthTime(claims); err != nil {
+		return nil, err
+	}
+
+	if !p.cfg.SkipTokenHashCheck {
+		if err := verifyTokenHashes(rawIDToken, claims, oauth2Token.AccessToken, code); err != nil {
+			return nil, fmt.Errorf("failed to verify ID token: %w", err)
+		}
+	}
+
+	if p.cfg.FetchUserInfo {
+		userInfo, err := p.fetchUserInfo(ctx, oauth2Token.AccessToken, idToken.Subject)
+		switch {
+		case err == nil:
+			maps.Copy(claims, userInfo)
+		case p.cfg.UserInfoRequired:
+			return nil, err
+		default:
+			p.logger.Warn("userinfo fetch failed, using ID token claims only", "provider", p.id, "error", err)
+		}
+	}
+
+	if p.requireEmail {
+		if err := auth.CheckEmailVerified(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.computedClaims.Apply(claims); err != nil {
+		return nil, err
+	}
+
+	if err := p.acceptIf.Check(claims); err != nil {
+		return nil, err
+	}
+
 	sessionID := uuid.New().String()
 	session := &auth.Session{
 		ID:           sessionID,
@@ -188,7 +511,10 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 		RefreshToken: oauth2Token.RefreshToken,
 		IDToken:      rawIDToken,
 		TokenExpiry:  oauth2Token.Expiry,
+		Scopes:       grantedScopes(oauth2Token, oidcState.Scopes),
 		CSRFToken:    uuid.New().String(),
+
+		UserInfoRefreshedAt: time.Now(),
 	}
 
 	return session, nil
@@ -208,32 +534,62 @@ func (p *Provider) ValidateSession(ctx context.Context, session *auth.Session) e
 
 func (p *Provider) RefreshSession(ctx context.Context, session *auth.Session) (*auth.Session, error) {
 	if session.RefreshToken == "" {
-		return nil, fmt.Errorf("no refresh token available")
+		return nil, &auth.RefreshError{
+			Reason: auth.RefreshReasonNoRefreshToken,
+			Err:    fmt.Errorf("no refresh token available"),
+		}
 	}
 
-	tokenSource := p.oauth2Config.TokenSource(ctx, &oauth2.Token{
+	tokenSource := p.oauth2Config.TokenSource(oidc.ClientContext(ctx, p.client), &oauth2.Token{
 		RefreshToken: session.RefreshToken,
 	})
 
 	newToken, err := tokenSource.Token()
 	if err != nil {
-		return nil, fmt.Errorf("failed to refresh token: %w", err)
+		return nil, &auth.RefreshError{
+			Reason: classifyRefreshError(err),
+			Err:    fmt.Errorf("failed to refresh token: %w", err),
+		}
+	}
+
+	if err := p.verifyAccessToken(ctx, newToken.AccessToken); err != nil {
+		return nil, &auth.RefreshError{
+			Reason: auth.RefreshReasonInvalidToken,
+			Err:    err,
+		}
 	}
 
 	rawIDToken, ok := newToken.Extra("id_token").(string)
 	if ok {
-		idToken, err := p.verifier.Verify(ctx, rawIDToken)
+		idToken, err := p.verify(ctx, p.verifier, rawIDToken)
 		if err != nil {
-			return nil, fmt.Errorf("failed to verify refreshed ID token: %w", err)
+			return nil, &auth.RefreshError{
+				Reason: auth.RefreshReasonInvalidToken,
+				Err:    fmt.Errorf("failed to verify refreshed ID token: %w", err),
+			}
 		}
 
 		var claims map[string]interface{}
 		if err := idToken.Claims(&claims); err != nil {
-			return nil, fmt.Errorf("failed to parse refreshed claims: %w", err)
+			return nil, &auth.RefreshError{
+				Reason: auth.RefreshReasonInvalidToken,
+				Err:    fmt.Errorf("failed to parse refreshed claims: %w", err),
+			}
+		}
+
+		// The refreshed ID token updates the claims read at login, keeping
+		// those that came from the UserInfo endpoint.
+		merged, err := p.computedClaims.Merge(session.UserInfo, claims)
+		if err != nil {
+			return nil, &auth.RefreshError{
+				Reason: auth.RefreshReasonInvalidToken,
+				Err:    err,
+			}
 		}
 
-		session.UserInfo = claims
+		session.UserInfo = merged
 		session.IDToken = rawIDToken
+		session.UserInfoRefreshedAt = time.Now()
 	}
 
 	session.AccessToken = newToken.AccessToken
@@ -246,6 +602,153 @@ func (p *Provider) RefreshSession(ctx context.Context, session *auth.Session) (*
 	return session, nil
 }
 
+// RefreshUserInfo merges the claims from the UserInfo endpoint into
+// session.UserInfo once userinfo_refresh_interval has passed since they were
+// last read. Failed attempts also wait for the interval, so an unavailable
+// IdP is not called on every request.
+func (p *Provider) RefreshUserInfo(ctx context.Context, session *auth.Session) (bool, error) {
+	interval := p.cfg.UserInfoRefreshInterval
+	if interval <= 0 || time.Since(session.UserInfoRefreshedAt) < interval {
+		return false, nil
+	}
+	session.UserInfoRefreshedAt = time.Now()
+
+	fresh, err := p.fetchUserInfo(ctx, session.AccessToken, auth.Subject(session))
+	if err != nil {
+		return true, err
+	}
+
+	claims, err := p.computedClaims.Merge(session.UserInfo, fresh)
+	if err != nil {
+		return true, err
+	}
+
+	session.UserInfo = claims
+	return true, nil
+}
+
+// fetchUserInfo returns the claims from the UserInfo endpoint, which must be
+// about subject.
+func (p *Provider) fetchUserInfo(ctx context.Context, accessToken, subject string) (map[string]interface{}, error) {
+	userInfo, err := p.provider.UserInfo(oidc.ClientContext(ctx, p.client), oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: accessToken,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
+	}
+
+	if userInfo.Subject != subject {
+		return nil, fmt.Errorf("userinfo subject %q does not match the ID token subject %q", userInfo.Subject, subject)
+	}
+
+	var claims map[string]interface{}
+	if err := userInfo.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse userinfo claims: %w", err)
+	}
+	return claims, nil
+}
+
+// EndSessionURL returns the end_session_endpoint URL that ends the user's IdP
+// session and redirects back to postLogoutRedirectURI, when
+// rp_initiated_logout is enabled and the IdP advertises the endpoint.
+func (p *Provider) EndSessionURL(session *auth.Session, postLogoutRedirectURI string) string {
+	if p.endSessionEndpoint == "" {
+		return ""
+	}
+
+	endSessionURL, err := url.Parse(p.endSessionEndpoint)
+	if err != nil {
+		return ""
+	}
+
+	query := endSessionURL.Query()
+	if session.IDToken != "" {
+		query.Set("id_token_hint", session.IDToken)
+	}
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	endSessionURL.RawQuery = query.Encode()
+	return endSessionURL.String()
+}
+
+// RevokeSession revokes the session's refresh and access tokens at the IdP
+// (RFC 7009). It is a no-op unless revoke_on_logout is enabled.
+func (p *Provider) RevokeSession(ctx context.Context, session *auth.Session) error {
+	if !p.cfg.RevokeOnLogout {
+		return nil
+	}
+
+	var errs []error
+	if session.RefreshToken != "" {
+		if err := p.revokeToken(ctx, session.RefreshToken, "refresh_token"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if session.AccessToken != "" {
+		if err := p.revokeToken(ctx, session.AccessToken, "access_token"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Provider) revokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {tokenTypeHint},
+	}
+	if p.assertionSigner != nil {
+		params, err := p.assertionSigner.params()
+		if err != nil {
+			return err
+		}
+		maps.Copy(form, params)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.revocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revocation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.assertionSigner == nil {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke %s: %w", tokenTypeHint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation of %s returned status %d", tokenTypeHint, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func classifyRefreshError(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode == "invalid_grant" {
+			return auth.RefreshReasonInvalidGrant
+		}
+		if retrieveErr.ErrorCode == "temporarily_unavailable" || retrieveErr.ErrorCode == "server_error" ||
+			(retrieveErr.Response != nil && retrieveErr.Response.StatusCode >= 500) {
+			return auth.RefreshReasonIdPUnavailable
+		}
+		return auth.RefreshReasonIdPError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return auth.RefreshReasonNetwork
+	}
+
+	return auth.RefreshReasonUnknown
+}
+
 func generateCodeVerifier() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
@@ -254,6 +757,102 @@ func generateCodeVerifier() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
+// notBeforeLeeway is the minimum tolerance on nbf and iat, matching go-oidc.
+const notBeforeLeeway = 5 * time.Minute
+
+// verify verifies a JWT with verifier. With clock_skew set, the verifiers
+// skip go-oidc's expiry check, and exp, nbf and iat are checked here with
+// the skew as tolerance.
+func (p *Provider) verify(ctx context.Context, verifier *oidc.IDTokenVerifier, rawToken string) (*oidc.IDToken, error) {
+	token, err := verifier.Verify(ctx, rawToken)
+	if err != nil || p.clockSkew == 0 {
+		return token, err
+	}
+	if err := checkTokenTimes(token, p.clockSkew, time.Now()); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func checkTokenTimes(token *oidc.IDToken, skew time.Duration, now time.Time) error {
+	if now.After(token.Expiry.Add(skew)) {
+		return &oidc.TokenExpiredError{Expiry: token.Expiry}
+	}
+
+	var times struct {
+		NotBefore *float64 `json:"nbf"`
+	}
+	if err := token.Claims(&times); err != nil {
+		return fmt.Errorf("failed to parse token times: %w", err)
+	}
+
+	leeway := max(skew, notBeforeLeeway)
+	if times.NotBefore != nil {
+		if nbf := time.Unix(int64(*times.NotBefore), 0); now.Add(leeway).Before(nbf) {
+			return fmt.Errorf("token not valid before %s", nbf.UTC().Format(time.RFC3339))
+		}
+	}
+	if now.Add(leeway).Before(token.IssuedAt) {
+		return fmt.Errorf("token issued in the future at %s", token.IssuedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// verifyAccessToken checks the signature, exp and aud of a JWT access token
+// when validate_access_token is set, so that backends are not sent an
+// unusable token.
+func (p *Provider) verifyAccessToken(ctx context.Context, accessToken string) error {
+	if p.accessVerifier == nil {
+		return nil
+	}
+	if _, err := p.verify(ctx, p.accessVerifier, accessToken); err != nil {
+		return fmt.Errorf("failed to verify access token: %w", err)
+	}
+	return nil
+}
+
+// authTimeLeeway tolerates clock skew between the IdP and this server when
+// checking auth_time against max_age.
+const authTimeLeeway = time.Minute
+
+// checkAuthTime rejects logins whose auth_time is older than max_age. The
+// claim is required when max_age is set.
+func (p *Provider) checkAuthTime(claims map[string]interface{}) error {
+	if p.cfg.MaxAge <= 0 {
+		return nil
+	}
+
+	authTime, ok := claims["auth_time"].(float64)
+	if !ok {
+		return fmt.Errorf("ID token has no auth_time, required by max_age")
+	}
+
+	maxAge := time.Duration(p.cfg.MaxAge)*time.Second + authTimeLeeway
+	if age := time.Since(time.Unix(int64(authTime), 0)); age > maxAge {
+		return fmt.Errorf("user authenticated %s ago, longer than max_age %ds", age.Round(time.Second), p.cfg.MaxAge)
+	}
+	return nil
+}
+
+// generateNonce returns the nonce that binds an ID token to the
+// authorization request, so that replayed tokens are rejected.
+func generateNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// grantedScopes returns the scopes from the token response, which the IdP
+// only includes when they differ from the requested ones.
+func grantedScopes(token *oauth2.Token, requested []string) []string {
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	return requested
+}
+
 func generateCodeChallenge(verifier string) string {
 	hash := sha256.Sum256([]byte(verifier))
 	return base64.RawURLEncoding.EncodeToString(hash[:])
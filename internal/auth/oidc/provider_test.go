@@ -0,0 +1,606 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+func TestRedirectURIPerFlow(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	p := env.newProvider(t, testProviderConfig("corp", idp), nil)
+
+	tests := []struct {
+		name        string
+		redirectURL string
+	}{
+		{name: "login", redirectURL: "https://sso.example.com/auth/oidc/corp/callback"},
+		{name: "silent", redirectURL: "https://sso.example.com/auth/oidc/corp/silent/callback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := env.login(t, p, idp, tt.redirectURL); err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if got := idp.lastTokenRequest().Get("redirect_uri"); got != tt.redirectURL {
+				t.Errorf("token request redirect_uri = %q, want %q", got, tt.redirectURL)
+			}
+		})
+	}
+}
+
+func TestConcurrentInitiateAuth(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	p := env.newProvider(t, testProviderConfig("corp", idp), nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			redirectURL := fmt.Sprintf("https://sso.example.com/callback/%d", i)
+			redirect, err := p.InitiateAuth(context.Background(), redirectURL)
+			if err != nil {
+				errs <- err
+				return
+			}
+			authURL, err := url.Parse(redirect.URL)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got := authURL.Query().Get("redirect_uri"); got != redirectURL {
+				errs <- fmt.Errorf("redirect_uri = %q, want %q", got, redirectURL)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	for _, alg := range []jose.SignatureAlgorithm{jose.RS256, jose.PS256, jose.ES256} {
+		t.Run(string(alg), func(t *testing.T) {
+			idp := newFakeIdP(t, alg)
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.ValidateAccessToken = true
+			p := env.newProvider(t, providerCfg, nil)
+
+			// The access token is the ID token itself, so that the access
+			// token verifier is exercised with the same algorithm.
+			idp.set(func(idp *fakeIdP) { idp.accessTokenIsIDToken = true })
+
+			session, err := env.login(t, p, idp, "https://sso.example.com/callback")
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if got := session.UserInfo["sub"]; got != "alice" {
+				t.Errorf("sub = %v, want alice", got)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsUserInfoClaims(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	idp.set(func(idp *fakeIdP) {
+		idp.idTokenClaims = map[string]interface{}{"email": "alice@example.com"}
+		idp.userInfo = map[string]interface{}{"sub": "alice", "department": "engineering"}
+	})
+
+	env := newTestEnv(t)
+	providerCfg := testProviderConfig("corp", idp)
+	providerCfg.OIDC.FetchUserInfo = true
+	p := env.newProvider(t, providerCfg, nil)
+
+	session, err := env.login(t, p, idp, "https://sso.example.com/callback")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if got := session.UserInfo["department"]; got != "engineering" {
+		t.Fatalf("department after login = %v, want engineering", got)
+	}
+
+	idp.set(func(idp *fakeIdP) {
+		idp.nonce = ""
+		idp.idTokenClaims = map[string]interface{}{"email": "alice@corp.example.com"}
+	})
+	session, err = p.RefreshSession(context.Background(), session)
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+
+	tests := []struct {
+		claim string
+		want  interface{}
+	}{
+		{claim: "department", want: "engineering"},
+		{claim: "email", want: "alice@corp.example.com"},
+		{claim: "sub", want: "alice"},
+	}
+	for _, tt := range tests {
+		if got := session.UserInfo[tt.claim]; got != tt.want {
+			t.Errorf("%s after refresh = %v, want %v", tt.claim, got, tt.want)
+		}
+	}
+}
+
+func TestSharedDiscovery(t *testing.T) {
+	tenantA := newFakeIdP(t, jose.RS256)
+	tenantB := newFakeIdP(t, jose.RS256)
+	tenantB.set(func(idp *fakeIdP) { idp.key = tenantA.key })
+
+	env := newTestEnv(t)
+	a := env.newProvider(t, testProviderConfig("tenant-a", tenantA), nil)
+	providerCfg := testProviderConfig("tenant-b", tenantB)
+	providerCfg.OIDC.SharedDiscovery = "tenant-a"
+	b := env.newProvider(t, providerCfg, a)
+
+	tests := []struct {
+		name     string
+		provider *Provider
+		idp      *fakeIdP
+	}{
+		{name: "tenant-a", provider: a, idp: tenantA},
+		{name: "tenant-b", provider: b, idp: tenantB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect, err := tt.provider.InitiateAuth(context.Background(), "https://sso.example.com/callback")
+			if err != nil {
+				t.Fatalf("InitiateAuth: %v", err)
+			}
+			if want := tt.idp.issuer + "/authorize?"; !strings.HasPrefix(redirect.URL, want) {
+				t.Errorf("auth URL = %s, want the tenant's endpoint %s", redirect.URL, want)
+			}
+
+			if _, err := env.complete(t, tt.provider, tt.idp, redirect); err != nil {
+				t.Fatalf("complete login: %v", err)
+			}
+			tt.idp.set(func(idp *fakeIdP) {
+				if len(idp.tokenRequests) != 1 {
+					t.Errorf("tenant token requests = %d, want 1", len(idp.tokenRequests))
+				}
+				if idp.discoveryHits != 1 {
+					t.Errorf("tenant discovery fetches = %d, want 1", idp.discoveryHits)
+				}
+			})
+		})
+	}
+
+	tenantA.set(func(idp *fakeIdP) {
+		if idp.jwksHits != 1 {
+			t.Errorf("shared JWKS fetches = %d, want 1", idp.jwksHits)
+		}
+	})
+	tenantB.set(func(idp *fakeIdP) {
+		if idp.jwksHits != 0 {
+			t.Errorf("tenant-b JWKS fetches = %d, want 0", idp.jwksHits)
+		}
+	})
+}
+
+func TestElevationParams(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	providerCfg := testProviderConfig("corp", idp)
+	providerCfg.OIDC.MaxAge = 3600
+	p := env.newProvider(t, providerCfg, nil)
+
+	redirect, err := p.InitiateElevation(context.Background(), "https://sso.example.com/auth/oidc/corp/elevate/callback")
+	if err != nil {
+		t.Fatalf("InitiateElevation: %v", err)
+	}
+	authURL, err := url.Parse(redirect.URL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{param: "prompt", want: "login"},
+		{param: "max_age", want: "0"},
+	}
+	for _, tt := range tests {
+		if got := authURL.Query().Get(tt.param); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.param, got, tt.want)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name         string
+		revoke       bool
+		session      *auth.Session
+		revokeStatus int
+		wantHints    []string
+		wantErr      bool
+	}{
+		{
+			name:      "both tokens",
+			revoke:    true,
+			session:   &auth.Session{AccessToken: "access", RefreshToken: "refresh"},
+			wantHints: []string{"refresh_token", "access_token"},
+		},
+		{
+			name:      "access token only",
+			revoke:    true,
+			session:   &auth.Session{AccessToken: "access"},
+			wantHints: []string{"access_token"},
+		},
+		{
+			name:         "revocation rejected",
+			revoke:       true,
+			session:      &auth.Session{AccessToken: "access"},
+			revokeStatus: http.StatusServiceUnavailable,
+			wantHints:    []string{"access_token"},
+			wantErr:      true,
+		},
+		{
+			name:    "disabled",
+			session: &auth.Session{AccessToken: "access", RefreshToken: "refresh"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			idp.set(func(idp *fakeIdP) { idp.revokeStatus = tt.revokeStatus })
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.RevokeOnLogout = tt.revoke
+			p := env.newProvider(t, providerCfg, nil)
+
+			err := p.RevokeSession(context.Background(), tt.session)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RevokeSession error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			idp.mu.Lock()
+			defer idp.mu.Unlock()
+			var hints []string
+			for _, form := range idp.revokeRequests {
+				hints = append(hints, form.Get("token_type_hint"))
+				want := tt.session.AccessToken
+				if form.Get("token_type_hint") == "refresh_token" {
+					want = tt.session.RefreshToken
+				}
+				if got := form.Get("token"); got != want {
+					t.Errorf("revoked token = %q, want %q", got, want)
+				}
+			}
+			if !slices.Equal(hints, tt.wantHints) {
+				t.Errorf("revoked token types = %v, want %v", hints, tt.wantHints)
+			}
+		})
+	}
+}
+
+func TestInitiateAuthWithAdditionalScopes(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional []string
+		wantScopes []string
+		wantErr    error
+	}{
+		{name: "allowed scope appended", additional: []string{"calendar.read"}, wantScopes: []string{"openid", "email", "calendar.read"}},
+		{name: "configured scope not repeated", additional: []string{"email"}, wantScopes: []string{"openid", "email"}},
+		{name: "disallowed scope", additional: []string{"admin"}, wantErr: auth.ErrScopeNotAllowed},
+		{name: "one disallowed among allowed", additional: []string{"calendar.read", "admin"}, wantErr: auth.ErrScopeNotAllowed},
+		{name: "none", wantScopes: []string{"openid", "email"}},
+	}
+
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	providerCfg := testProviderConfig("corp", idp)
+	providerCfg.OIDC.AllowedAdditionalScopes = []string{"calendar.read", "email"}
+	p := env.newProvider(t, providerCfg, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect, err := p.InitiateAuthWithOptions(context.Background(), "https://sso.example.com/auth/oidc/corp/callback",
+				auth.LoginOptions{AdditionalScopes: tt.additional})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("InitiateAuthWithOptions error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			authURL, err := url.Parse(redirect.URL)
+			if err != nil {
+				t.Fatalf("parse redirect: %v", err)
+			}
+			if got := strings.Fields(authURL.Query().Get("scope")); !slices.Equal(got, tt.wantScopes) {
+				t.Errorf("requested scopes = %v, want %v", got, tt.wantScopes)
+			}
+
+			session, err := env.complete(t, p, idp, redirect)
+			if err != nil {
+				t.Fatalf("complete login: %v", err)
+			}
+			if !slices.Equal(session.Scopes, tt.wantScopes) {
+				t.Errorf("session scopes = %v, want %v", session.Scopes, tt.wantScopes)
+			}
+		})
+	}
+}
+
+func TestRefreshKeys(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	p := env.newProvider(t, testProviderConfig("corp", idp), nil)
+
+	if _, err := env.login(t, p, idp, "/app"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var discoveryHits, jwksHits int
+	idp.set(func(idp *fakeIdP) {
+		idp.key = rotated
+		discoveryHits, jwksHits = idp.discoveryHits, idp.jwksHits
+	})
+
+	if err := p.RefreshKeys(context.Background()); err != nil {
+		t.Fatalf("RefreshKeys: %v", err)
+	}
+	if _, err := env.login(t, p, idp, "/app"); err != nil {
+		t.Fatalf("login after key rotation: %v", err)
+	}
+
+	idp.set(func(idp *fakeIdP) {
+		if idp.discoveryHits != discoveryHits+1 {
+			t.Errorf("discovery fetched %d times by the refresh, want 1", idp.discoveryHits-discoveryHits)
+		}
+		if idp.jwksHits != jwksHits+1 {
+			t.Errorf("jwks fetched %d times after the refresh, want 1", idp.jwksHits-jwksHits)
+		}
+	})
+}
+
+func TestClaimsRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		claimsRequest string
+		want          string
+	}{
+		{
+			name: "compacted",
+			claimsRequest: `{
+  "id_token": {"acr": {"essential": true}}
+}`,
+			want: `{"id_token":{"acr":{"essential":true}}}`,
+		},
+		{name: "unset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.ClaimsRequest = tt.claimsRequest
+			p := env.newProvider(t, providerCfg, nil)
+
+			redirect, err := p.InitiateAuth(context.Background(), "https://sso.example.com/auth/oidc/corp/callback")
+			if err != nil {
+				t.Fatalf("InitiateAuth: %v", err)
+			}
+			authURL, err := url.Parse(redirect.URL)
+			if err != nil {
+				t.Fatalf("parse auth URL: %v", err)
+			}
+			if got := authURL.Query().Get("claims"); got != tt.want {
+				t.Errorf("claims = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDPRequestHeaders(t *testing.T) {
+	idp := newFakeIdP(t, jose.RS256)
+	env := newTestEnv(t)
+	providerCfg := testProviderConfig("corp", idp)
+	providerCfg.IDPRequestHeaders = map[string]string{"X-Api-Key": "gateway-key"}
+	providerCfg.OIDC.RevokeOnLogout = true
+	p := env.newProvider(t, providerCfg, nil)
+
+	session, err := env.login(t, p, idp, "https://sso.example.com/auth/oidc/corp/callback")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := p.RefreshSession(context.Background(), session); err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if err := p.RevokeSession(context.Background(), session); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	idp.set(func(idp *fakeIdP) {
+		for _, path := range []string{"/.well-known/openid-configuration", "/jwks", "/token", "/revoke"} {
+			headers, ok := idp.requestHeaders[path]
+			if !ok {
+				t.Errorf("%s was not requested", path)
+				continue
+			}
+			if got := headers.Get("X-Api-Key"); got != "gateway-key" {
+				t.Errorf("%s X-Api-Key = %q, want %q", path, got, "gateway-key")
+			}
+		}
+	})
+}
+
+func TestRefreshUserInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		age         time.Duration
+		userInfo    map[string]interface{}
+		wantUpdated bool
+		wantErr     bool
+		wantGroups  interface{}
+	}{
+		{name: "due", interval: time.Minute, age: 2 * time.Minute, userInfo: map[string]interface{}{"sub": "alice", "groups": []interface{}{"admins"}}, wantUpdated: true, wantGroups: []interface{}{"admins"}},
+		{name: "not due", interval: time.Minute, age: 30 * time.Second, userInfo: map[string]interface{}{"sub": "alice", "groups": []interface{}{"admins"}}, wantGroups: []interface{}{"users"}},
+		{name: "disabled", age: time.Hour, userInfo: map[string]interface{}{"sub": "alice", "groups": []interface{}{"admins"}}, wantGroups: []interface{}{"users"}},
+		{name: "other subject", interval: time.Minute, age: 2 * time.Minute, userInfo: map[string]interface{}{"sub": "mallory", "groups": []interface{}{"admins"}}, wantUpdated: true, wantErr: true, wantGroups: []interface{}{"users"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			idp.set(func(idp *fakeIdP) { idp.idTokenClaims = map[string]interface{}{"groups": []interface{}{"users"}} })
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.UserInfoRefreshInterval = tt.interval
+			p := env.newProvider(t, providerCfg, nil)
+
+			session, err := env.login(t, p, idp, "https://sso.example.com/auth/oidc/corp/callback")
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			refreshedAt := time.Now().Add(-tt.age)
+			session.UserInfoRefreshedAt = refreshedAt
+			idp.set(func(idp *fakeIdP) { idp.userInfo = tt.userInfo })
+
+			updated, err := p.RefreshUserInfo(context.Background(), session)
+			if updated != tt.wantUpdated {
+				t.Errorf("updated = %v, want %v", updated, tt.wantUpdated)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RefreshUserInfo error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := session.UserInfo["groups"]; !reflect.DeepEqual(got, tt.wantGroups) {
+				t.Errorf("groups = %v, want %v", got, tt.wantGroups)
+			}
+			if got := session.UserInfo["sub"]; got != "alice" {
+				t.Errorf("sub = %v, want alice", got)
+			}
+			if tt.wantUpdated && !session.UserInfoRefreshedAt.After(refreshedAt) {
+				t.Error("UserInfoRefreshedAt not advanced")
+			}
+		})
+	}
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate bool
+		audience string
+		// claims overrides the access token claims; nil keeps an opaque
+		// access token.
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "valid", validate: true, claims: map[string]interface{}{}},
+		{name: "configured audience", validate: true, audience: "https://api.example.com", claims: map[string]interface{}{"aud": "https://api.example.com"}},
+		{name: "wrong audience", validate: true, claims: map[string]interface{}{"aud": "other-client"}, wantErr: true},
+		{name: "client audience with configured audience", validate: true, audience: "https://api.example.com", claims: map[string]interface{}{}, wantErr: true},
+		{name: "expired", validate: true, claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, wantErr: true},
+		{name: "opaque", validate: true, wantErr: true},
+		{name: "disabled", claims: map[string]interface{}{"aud": "other-client"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			env := newTestEnv(t)
+			providerCfg := testProviderConfig("corp", idp)
+			providerCfg.OIDC.ValidateAccessToken = tt.validate
+			providerCfg.OIDC.AccessTokenAudience = tt.audience
+			p := env.newProvider(t, providerCfg, nil)
+
+			idp.set(func(idp *fakeIdP) { idp.accessTokenClaims = tt.claims })
+			session, err := env.login(t, p, idp, "https://sso.example.com/auth/oidc/corp/callback")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "failed to verify access token") {
+					t.Fatalf("login error = %v, want an access token verification error", err)
+				}
+			} else if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if !tt.validate || tt.wantErr {
+				return
+			}
+
+			idp.set(func(idp *fakeIdP) {
+				idp.accessTokenClaims = map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}
+			})
+			_, err = p.RefreshSession(context.Background(), session)
+			var refreshErr *auth.RefreshError
+			if !errors.As(err, &refreshErr) || refreshErr.Reason != auth.RefreshReasonInvalidToken {
+				t.Errorf("RefreshSession with an expired access token = %v, want an invalid token refresh error", err)
+			}
+		})
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	tests := []struct {
+		name string
+		skew time.Duration
+		// claim is set to now plus offset in the ID token.
+		claim   string
+		offset  time.Duration
+		wantErr bool
+	}{
+		{name: "iat in the future within the skew", skew: 10 * time.Minute, claim: "iat", offset: 8 * time.Minute},
+		{name: "iat in the future beyond the skew", skew: time.Minute, claim: "iat", offset: 8 * time.Minute, wantErr: true},
+		{name: "expired within the skew", skew: time.Minute, claim: "exp", offset: -30 * time.Second},
+		{name: "expired beyond the skew", skew: time.Minute, claim: "exp", offset: -2 * time.Minute, wantErr: true},
+		{name: "expired without a skew", claim: "exp", offset: -30 * time.Second, wantErr: true},
+		{name: "nbf in the future beyond the skew", skew: time.Minute, claim: "nbf", offset: 10 * time.Minute, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t, jose.RS256)
+			idp.set(func(idp *fakeIdP) {
+				idp.idTokenClaims = map[string]interface{}{tt.claim: time.Now().Add(tt.offset).Unix()}
+			})
+			env := newTestEnv(t)
+			env.defaults.ClockSkew = tt.skew
+			p := env.newProvider(t, testProviderConfig("corp", idp), nil)
+
+			_, err := env.login(t, p, idp, "https://sso.example.com/auth/oidc/corp/callback")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "failed to verify ID token") {
+					t.Fatalf("login error = %v, want an ID token verification error", err)
+				}
+			} else if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+		})
+	}
+}
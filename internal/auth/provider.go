@@ -2,7 +2,10 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
 )
 
 type Provider interface {
@@ -15,5 +18,130 @@ type Provider interface {
 	ValidateSession(ctx context.Context, session *Session) error
 	RefreshSession(ctx context.Context, session *Session) (*Session, error)
 
-	GetHeaderMappings() map[string]string
+	GetHeaderMappings() map[string]config.HeaderMapping
+}
+
+// SessionRevoker is implemented by providers that can revoke a session's
+// credentials at the IdP.
+type SessionRevoker interface {
+	RevokeSession(ctx context.Context, session *Session) error
+}
+
+// EndSessionInitiator is implemented by providers that can end the user's
+// session at the IdP through a browser redirect (RP-initiated logout).
+// EndSessionURL returns "" when the IdP session is not to be ended.
+type EndSessionInitiator interface {
+	EndSessionURL(session *Session, postLogoutRedirectURI string) string
+}
+
+// ErrScopeNotAllowed and ErrParamNotAllowed are returned when additional
+// scopes or extra parameters outside the provider's allowlists are
+// requested.
+var (
+	ErrScopeNotAllowed = errors.New("scope not allowed")
+	ErrParamNotAllowed = errors.New("parameter not allowed")
+)
+
+// LoginOptions customize a single authorization request.
+type LoginOptions struct {
+	// AdditionalScopes are requested on top of the configured scopes.
+	AdditionalScopes []string
+	// ExtraParams are added to the authorization request, such as audience
+	// or resource.
+	ExtraParams map[string]string
+}
+
+// ScopedAuthInitiator is implemented by providers that can request scopes on
+// top of their configured ones, for incremental authorization, and extra
+// authorization parameters.
+type ScopedAuthInitiator interface {
+	InitiateAuthWithOptions(ctx context.Context, redirectURL string, opts LoginOptions) (*AuthRedirect, error)
+}
+
+// UserInfoRefresher is implemented by providers that periodically re-read
+// a session's claims from the IdP. RefreshUserInfo reports whether session
+// was updated; it is a no-op until the provider's refresh interval has passed.
+type UserInfoRefresher interface {
+	RefreshUserInfo(ctx context.Context, session *Session) (bool, error)
+}
+
+// HealthReporter is implemented by providers that can report runtime failures
+// talking to their IdP.
+type HealthReporter interface {
+	Health() error
+}
+
+// KeyRefresher is implemented by providers that cache the IdP's signing keys
+// and can be forced to fetch them again.
+type KeyRefresher interface {
+	RefreshKeys(ctx context.Context) error
+}
+
+// IdPError is returned by HandleCallback when the IdP redirects back with an
+// OAuth2 error instead of a code.
+type IdPError struct {
+	Code        string
+	Description string
+}
+
+func (e *IdPError) Error() string {
+	if e.Description != "" {
+		return "identity provider returned " + e.Code + ": " + e.Description
+	}
+	return "identity provider returned " + e.Code
+}
+
+// InteractionRequired reports whether the user must sign in interactively,
+// as signalled in response to a prompt=none request.
+func (e *IdPError) InteractionRequired() bool {
+	switch e.Code {
+	case "login_required", "interaction_required", "consent_required", "account_selection_required":
+		return true
+	}
+	return false
+}
+
+// Refresh failure reasons reported by RefreshSession.
+const (
+	RefreshReasonNoRefreshToken = "no_refresh_token"
+	RefreshReasonInvalidGrant   = "invalid_grant"
+	RefreshReasonIdPError       = "idp_error"
+	RefreshReasonIdPUnavailable = "idp_unavailable"
+	RefreshReasonNetwork        = "network"
+	RefreshReasonInvalidToken   = "invalid_token"
+	RefreshReasonUnsupported    = "unsupported"
+	RefreshReasonUnknown        = "unknown"
+)
+
+// RefreshError wraps a RefreshSession failure with a coarse reason category.
+type RefreshError struct {
+	Reason string
+	Err    error
+}
+
+func (e *RefreshError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RefreshError) Unwrap() error {
+	return e.Err
+}
+
+// RefreshRetryable reports whether a refresh failed on a network error or an
+// unavailable IdP, so that retrying may succeed, rather than because the
+// refresh token was rejected.
+func RefreshRetryable(err error) bool {
+	switch RefreshFailureReason(err) {
+	case RefreshReasonNetwork, RefreshReasonIdPUnavailable:
+		return true
+	}
+	return false
+}
+
+func RefreshFailureReason(err error) string {
+	var refreshErr *RefreshError
+	if errors.As(err, &refreshErr) {
+		return refreshErr.Reason
+	}
+	return RefreshReasonUnknown
 }
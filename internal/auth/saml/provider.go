@@ -3,14 +3,21 @@ package saml
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
-	"encoding/json"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/crewjam/saml"
@@ -24,14 +31,34 @@ type Provider struct {
 	id             string
 	name           string
 	cfg            config.SAMLConfig
-	headerMappings map[string]string
+	headerMappings map[string]config.HeaderMapping
+	computedClaims *auth.ClaimComputer
+	acceptIf       *auth.Acceptor
+	requireEmail   bool
 	cache          cache.Cache
+	codec          *cache.Codec
+	logger         *slog.Logger
+
+	// onReplayCacheError is reject or allow, see
+	// config.SAMLDefaults.OnReplayCacheError.
+	onReplayCacheError string
 
 	sp          *saml.ServiceProvider
 	idpMetadata *saml.EntityDescriptor
+
+	metadataMu sync.Mutex
+	metadata   *Metadata
+}
+
+// Metadata is the serialized SP metadata, reused until it is due to be
+// refreshed so that repeated IdP polls get identical documents.
+type Metadata struct {
+	XML       []byte
+	ETag      string
+	ExpiresAt time.Time
 }
 
-func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache cache.Cache, baseURL string) (*Provider, error) {
+func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, defaults config.SAMLDefaults, cache cache.Cache, codec *cache.Codec, baseURL string, logger *slog.Logger) (*Provider, error) {
 	if providerCfg.SAML == nil {
 		return nil, fmt.Errorf("SAML config is required")
 	}
@@ -74,7 +101,17 @@ func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache c
 		}
 	}
 
-	idpMetadata, err := fetchIDPMetadata(ctx, *providerCfg.SAML)
+	computedClaims, err := auth.NewClaimComputer(providerCfg.ComputedClaims, providerCfg.ClaimAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	acceptIf, err := auth.NewAcceptor(providerCfg.AcceptIf)
+	if err != nil {
+		return nil, err
+	}
+
+	idpMetadata, err := fetchIDPMetadata(ctx, auth.NewIdPClient(providerCfg.IDPRequestHeaders), *providerCfg.SAML)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch IdP metadata: %w", err)
 	}
@@ -84,11 +121,17 @@ func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache c
 		return nil, fmt.Errorf("invalid ACS URL: %w", err)
 	}
 
-	metadataURL, err := url.Parse(baseURL + "/auth/saml/" + providerCfg.ID + "/metadata")
+	metadataURL, err := url.Parse(baseURL + auth.SAMLMetadataPath(providerCfg.ID))
 	if err != nil {
 		return nil, fmt.Errorf("invalid metadata URL: %w", err)
 	}
 
+	// The library reads the tolerance from a package variable, which every
+	// provider sets to the same configured value.
+	if defaults.ClockSkewTolerance != nil {
+		saml.MaxClockSkew = *defaults.ClockSkewTolerance
+	}
+
 	sp := &saml.ServiceProvider{
 		EntityID:          providerCfg.SAML.SPEntityID,
 		Key:               key,
@@ -97,6 +140,9 @@ func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache c
 		AcsURL:            *acsURL,
 		IDPMetadata:       idpMetadata,
 		AllowIDPInitiated: true,
+		AuthnNameIDFormat: saml.NameIDFormat(providerCfg.SAML.NameIDFormat),
+
+		MetadataValidDuration: providerCfg.SAML.MetadataValidDuration,
 	}
 
 	return &Provider{
@@ -104,9 +150,16 @@ func NewProvider(ctx context.Context, providerCfg config.ProviderConfig, cache c
 		name:           providerCfg.Name,
 		cfg:            *providerCfg.SAML,
 		headerMappings: providerCfg.HeaderMappings,
+		computedClaims: computedClaims,
+		acceptIf:       acceptIf,
+		requireEmail:   providerCfg.RequireEmailVerified,
 		cache:          cache,
+		codec:          codec,
+		logger:         logger,
 		sp:             sp,
 		idpMetadata:    idpMetadata,
+
+		onReplayCacheError: defaults.OnReplayCacheError,
 	}, nil
 }
 
@@ -122,7 +175,7 @@ func (p *Provider) Type() string {
 	return "saml"
 }
 
-func (p *Provider) GetHeaderMappings() map[string]string {
+func (p *Provider) GetHeaderMappings() map[string]config.HeaderMapping {
 	return p.headerMappings
 }
 
@@ -147,7 +200,7 @@ func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.
 		CreatedAt:  time.Now(),
 	}
 
-	reqData, err := json.Marshal(samlReq)
+	reqData, err := p.codec.Marshal(samlReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
@@ -167,6 +220,52 @@ func (p *Provider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.
 	}, nil
 }
 
+// checkReplay rejects assertions whose ID was already used and records the
+// ID under saml:assertion:<id> until the assertion expires, so that a
+// captured SAMLResponse cannot be posted again while it is still valid.
+func (p *Provider) checkReplay(ctx context.Context, assertion *saml.Assertion) error {
+	if assertion.ID == "" {
+		return fmt.Errorf("assertion has no ID")
+	}
+	key := "saml:assertion:" + assertion.ID
+
+	// Recording the ID is a single atomic step, so that concurrent posts of
+	// the same response cannot both be accepted.
+	stored, err := p.cache.SetNX(ctx, key, []byte(p.id), time.Until(assertionExpiry(assertion)))
+	if err == nil && !stored {
+		return fmt.Errorf("assertion %s was already used", assertion.ID)
+	}
+	if err != nil {
+		if p.onReplayCacheError == "allow" {
+			p.logger.Warn("failed to check assertion replay, accepting the assertion", "provider", p.id, "assertion_id", assertion.ID, "error", err)
+			return nil
+		}
+		return fmt.Errorf("failed to check assertion replay: %w", err)
+	}
+	return nil
+}
+
+// assertionExpiry returns the last time the assertion is accepted: the later
+// of its Conditions and SubjectConfirmationData NotOnOrAfter, plus the
+// allowed clock skew.
+func assertionExpiry(assertion *saml.Assertion) time.Time {
+	var notOnOrAfter time.Time
+	if assertion.Conditions != nil {
+		notOnOrAfter = assertion.Conditions.NotOnOrAfter
+	}
+	if assertion.Subject != nil {
+		for _, confirmation := range assertion.Subject.SubjectConfirmations {
+			if data := confirmation.SubjectConfirmationData; data != nil && data.NotOnOrAfter.After(notOnOrAfter) {
+				notOnOrAfter = data.NotOnOrAfter
+			}
+		}
+	}
+	if notOnOrAfter.IsZero() {
+		notOnOrAfter = time.Now()
+	}
+	return notOnOrAfter.Add(saml.MaxClockSkew)
+}
+
 func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth.Session, error) {
 	err := req.ParseForm()
 	if err != nil {
@@ -178,11 +277,19 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 		return nil, fmt.Errorf("missing SAMLResponse")
 	}
 
+	if err := p.checkDestination(samlResponse); err != nil {
+		return nil, err
+	}
+
 	assertion, err := p.sp.ParseResponse(req, []string{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse SAML response: %w", err)
 	}
 
+	if err := p.checkReplay(ctx, assertion); err != nil {
+		return nil, err
+	}
+
 	claims := make(map[string]interface{})
 
 	if assertion.Subject != nil && assertion.Subject.NameID != nil {
@@ -190,18 +297,37 @@ func (p *Provider) HandleCallback(ctx context.Context, req *http.Request) (*auth
 		claims["name_id_format"] = assertion.Subject.NameID.Format
 	}
 
-	for _, stmt := range assertion.AttributeStatements {
-		for _, attr := range stmt.Attributes {
-			if len(attr.Values) == 1 {
-				claims[attr.Name] = attr.Values[0].Value
-			} else if len(attr.Values) > 1 {
-				values := make([]string, len(attr.Values))
-				for i, v := range attr.Values {
-					values[i] = v.Value
-				}
-				claims[attr.Name] = values
-			}
+	for _, stmt := range assertion.AuthnStatements {
+		if !stmt.AuthnInstant.IsZero() {
+			claims[auth.AuthnInstantClaim] = stmt.AuthnInstant.UTC().Format(time.RFC3339)
 		}
+		if ref := stmt.AuthnContext.AuthnContextClassRef; ref != nil && ref.Value != "" {
+			claims[auth.AuthnContextClassRefClaim] = strings.TrimSpace(ref.Value)
+		}
+	}
+
+	addAttributeClaims(claims, assertion.AttributeStatements)
+
+	// Expose AuthnInstant like the OIDC auth_time claim, unless an attribute
+	// already has that name.
+	if _, exists := claims["auth_time"]; !exists {
+		if authTime, ok := auth.AuthTime(claims); ok {
+			claims["auth_time"] = float64(authTime.Unix())
+		}
+	}
+
+	if p.requireEmail {
+		if err := auth.CheckEmailVerified(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.computedClaims.Apply(claims); err != nil {
+		return nil, err
+	}
+
+	if err := p.acceptIf.Check(claims); err != nil {
+		return nil, err
 	}
 
 	assertionData, err := xml.Marshal(assertion)
@@ -242,19 +368,144 @@ func (p *Provider) ValidateSession(ctx context.Context, session *auth.Session) e
 }
 
 func (p *Provider) RefreshSession(ctx context.Context, session *auth.Session) (*auth.Session, error) {
-	return nil, fmt.Errorf("SAML sessions cannot be refreshed")
+	return nil, &auth.RefreshError{
+		Reason: auth.RefreshReasonUnsupported,
+		Err:    fmt.Errorf("SAML sessions cannot be refreshed"),
+	}
 }
 
 func (p *Provider) GetMetadata() (*saml.EntityDescriptor, error) {
-	return p.sp.Metadata(), nil
+	metadata := p.sp.Metadata()
+	metadata.CacheDuration = p.cfg.MetadataValidDuration / 2
+	return metadata, nil
+}
+
+// MetadataDocument returns the serialized SP metadata. The document is
+// regenerated once its cacheDuration (half of the validity) has passed, so
+// it is always valid for at least that long after it is served.
+func (p *Provider) MetadataDocument() (*Metadata, error) {
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+
+	if p.metadata != nil && time.Now().Before(p.metadata.ExpiresAt) {
+		return p.metadata, nil
+	}
+
+	metadata, err := p.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := xml.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	p.metadata = &Metadata{
+		XML:       data,
+		ETag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+		ExpiresAt: time.Now().Add(metadata.CacheDuration),
+	}
+	return p.metadata, nil
+}
+
+// ErrDestinationMismatch is returned when a SAML Response is addressed to a
+// different URL than our ACS, i.e. it was issued for another SP or replayed.
+var ErrDestinationMismatch = errors.New("SAML response destination does not match ACS URL")
+
+// checkDestination requires the Response's Destination attribute to equal
+// expected_destination (the ACS URL by default). Unlike the library check, the
+// URL the request was received on is not accepted, as it depends on how front
+// proxies rewrite it.
+func (p *Provider) checkDestination(samlResponse string) error {
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return fmt.Errorf("failed to decode SAMLResponse: %w", err)
+	}
+
+	var response struct {
+		Destination string `xml:"Destination,attr"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("failed to parse SAMLResponse: %w", err)
+	}
+
+	expected := p.cfg.ExpectedDestination
+	if expected == "" {
+		expected = p.sp.AcsURL.String()
+	}
+	if response.Destination != expected {
+		return fmt.Errorf("%w: got %q, expected %q", ErrDestinationMismatch, response.Destination, expected)
+	}
+	return nil
 }
 
-func fetchIDPMetadata(ctx context.Context, cfg config.SAMLConfig) (*saml.EntityDescriptor, error) {
+// IDPEntityID returns the entity ID of the IdP, i.e. the Issuer of its
+// responses.
+func (p *Provider) IDPEntityID() string {
+	return p.idpMetadata.EntityID
+}
+
+// ResponseIssuer extracts the Issuer of a base64 encoded SAML Response
+// without validating it, falling back to the Issuer of its assertion. It is
+// only fit for routing the response to the provider that will validate it.
+func ResponseIssuer(samlResponse string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode SAMLResponse: %w", err)
+	}
+
+	var response struct {
+		Issuer    string `xml:"Issuer"`
+		Assertion struct {
+			Issuer string `xml:"Issuer"`
+		} `xml:"Assertion"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return "", fmt.Errorf("failed to parse SAMLResponse: %w", err)
+	}
+
+	issuer := strings.TrimSpace(response.Issuer)
+	if issuer == "" {
+		issuer = strings.TrimSpace(response.Assertion.Issuer)
+	}
+	if issuer == "" {
+		return "", fmt.Errorf("SAMLResponse has no issuer")
+	}
+	return issuer, nil
+}
+
+// addAttributeClaims stores the assertion's attributes as claims: a string
+// for a single value, a list otherwise. The same attribute may be split across
+// several statements, so values are accumulated and deduplicated first.
+func addAttributeClaims(claims map[string]interface{}, statements []saml.AttributeStatement) {
+	attrValues := make(map[string][]string)
+	for _, stmt := range statements {
+		for _, attr := range stmt.Attributes {
+			for _, v := range attr.Values {
+				if !slices.Contains(attrValues[attr.Name], v.Value) {
+					attrValues[attr.Name] = append(attrValues[attr.Name], v.Value)
+				}
+			}
+		}
+	}
+
+	for name, values := range attrValues {
+		if len(values) == 1 {
+			claims[name] = values[0]
+		} else {
+			claims[name] = values
+		}
+	}
+}
+
+func fetchIDPMetadata(ctx context.Context, client *http.Client, cfg config.SAMLConfig) (*saml.EntityDescriptor, error) {
 	if cfg.IDPMetadataXML != "" {
-        rawMetadata, err := os.ReadFile(cfg.IDPMetadataXML)
-        if err != nil {
-            return nil, fmt.Errorf("failed to read IdP metadata file at %s: %w", cfg.IDPMetadataXML, err)
-        }
+		rawMetadata, err := os.ReadFile(cfg.IDPMetadataXML)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read IdP metadata file at %s: %w", cfg.IDPMetadataXML, err)
+		}
 		metadata := &saml.EntityDescriptor{}
 		if err := xml.Unmarshal(rawMetadata, metadata); err != nil {
 			return nil, fmt.Errorf("failed to parse IdP metadata XML: %w", err)
@@ -268,7 +519,7 @@ func fetchIDPMetadata(ctx context.Context, cfg config.SAMLConfig) (*saml.EntityD
 			return nil, fmt.Errorf("failed to create metadata request: %w", err)
 		}
 
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch metadata: %w", err)
 		}
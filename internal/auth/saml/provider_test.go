@@ -0,0 +1,177 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func testAttribute(name string, values ...string) saml.Attribute {
+	attr := saml.Attribute{Name: name}
+	for _, v := range values {
+		attr.Values = append(attr.Values, saml.AttributeValue{Value: v})
+	}
+	return attr
+}
+
+func TestAddAttributeClaims(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []saml.AttributeStatement
+		want       map[string]interface{}
+	}{
+		{
+			name: "single value",
+			statements: []saml.AttributeStatement{
+				{Attributes: []saml.Attribute{testAttribute("email", "alice@corp.com")}},
+			},
+			want: map[string]interface{}{"email": "alice@corp.com"},
+		},
+		{
+			name: "multiple values",
+			statements: []saml.AttributeStatement{
+				{Attributes: []saml.Attribute{testAttribute("groups", "admins", "staff")}},
+			},
+			want: map[string]interface{}{"groups": []string{"admins", "staff"}},
+		},
+		{
+			name: "split across statements",
+			statements: []saml.AttributeStatement{
+				{Attributes: []saml.Attribute{testAttribute("groups", "admins", "staff")}},
+				{Attributes: []saml.Attribute{testAttribute("groups", "staff", "ops"), testAttribute("email", "alice@corp.com")}},
+			},
+			want: map[string]interface{}{"groups": []string{"admins", "staff", "ops"}, "email": "alice@corp.com"},
+		},
+		{
+			name: "same value in both statements",
+			statements: []saml.AttributeStatement{
+				{Attributes: []saml.Attribute{testAttribute("email", "alice@corp.com")}},
+				{Attributes: []saml.Attribute{testAttribute("email", "alice@corp.com")}},
+			},
+			want: map[string]interface{}{"email": "alice@corp.com"},
+		},
+		{
+			name: "repeated within a statement",
+			statements: []saml.AttributeStatement{
+				{Attributes: []saml.Attribute{testAttribute("groups", "admins"), testAttribute("groups", "admins", "staff")}},
+			},
+			want: map[string]interface{}{"groups": []string{"admins", "staff"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := make(map[string]interface{})
+			addAttributeClaims(claims, tt.statements)
+			if !reflect.DeepEqual(claims, tt.want) {
+				t.Errorf("claims = %#v, want %#v", claims, tt.want)
+			}
+		})
+	}
+}
+
+func testSAMLResponse(attrs string) string {
+	return base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="r1"` + attrs + `></samlp:Response>`))
+}
+
+func TestCheckDestination(t *testing.T) {
+	const acsURL = "https://sso.example.com/auth/saml/corp/acs"
+
+	tests := []struct {
+		name         string
+		expected     string
+		response     string
+		wantMismatch bool
+		wantErr      bool
+	}{
+		{name: "acs url", response: testSAMLResponse(` Destination="` + acsURL + `"`)},
+		{name: "other sp", response: testSAMLResponse(` Destination="https://other.example.com/acs"`), wantMismatch: true},
+		{name: "no destination", response: testSAMLResponse(""), wantMismatch: true},
+		{name: "expected destination", expected: "https://public.example.com/sso/acs", response: testSAMLResponse(` Destination="https://public.example.com/sso/acs"`)},
+		{name: "acs url when another is expected", expected: "https://public.example.com/sso/acs", response: testSAMLResponse(` Destination="` + acsURL + `"`), wantMismatch: true},
+		{name: "not base64", response: "%%%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acs, err := url.Parse(acsURL)
+			if err != nil {
+				t.Fatalf("parse ACS URL: %v", err)
+			}
+			p := &Provider{
+				cfg: config.SAMLConfig{ExpectedDestination: tt.expected},
+				sp:  &saml.ServiceProvider{AcsURL: *acs},
+			}
+
+			err = p.checkDestination(tt.response)
+			if got := errors.Is(err, ErrDestinationMismatch); got != tt.wantMismatch {
+				t.Errorf("checkDestination() = %v, want mismatch %v", err, tt.wantMismatch)
+			}
+			if (err != nil) != (tt.wantMismatch || tt.wantErr) {
+				t.Errorf("checkDestination() = %v, want error %v", err, tt.wantMismatch || tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataDocument(t *testing.T) {
+	const validity = 48 * time.Hour
+
+	p := &Provider{
+		cfg: config.SAMLConfig{MetadataValidDuration: validity},
+		sp: &saml.ServiceProvider{
+			EntityID:              "https://sso.example.com/saml",
+			AcsURL:                url.URL{Scheme: "https", Host: "sso.example.com", Path: "/auth/saml/corp/acs"},
+			MetadataURL:           url.URL{Scheme: "https", Host: "sso.example.com", Path: "/auth/saml/corp/metadata"},
+			MetadataValidDuration: validity,
+		},
+	}
+
+	first, err := p.MetadataDocument()
+	if err != nil {
+		t.Fatalf("MetadataDocument: %v", err)
+	}
+
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal(first.XML, &descriptor); err != nil {
+		t.Fatalf("unmarshal metadata: %v", err)
+	}
+	if diff := time.Until(descriptor.ValidUntil) - validity; diff < -time.Minute || diff > time.Minute {
+		t.Errorf("validUntil = %s, want about %s from now", descriptor.ValidUntil, validity)
+	}
+	if descriptor.CacheDuration != validity/2 {
+		t.Errorf("cacheDuration = %s, want %s", descriptor.CacheDuration, validity/2)
+	}
+	if diff := time.Until(first.ExpiresAt) - validity/2; diff < -time.Minute || diff > time.Minute {
+		t.Errorf("ExpiresAt = %s, want about %s from now", first.ExpiresAt, validity/2)
+	}
+	if !strings.HasPrefix(first.ETag, `"`) || !strings.HasSuffix(first.ETag, `"`) {
+		t.Errorf("ETag = %s, want a quoted entity tag", first.ETag)
+	}
+
+	second, err := p.MetadataDocument()
+	if err != nil {
+		t.Fatalf("MetadataDocument: %v", err)
+	}
+	if second.ETag != first.ETag || !bytes.Equal(second.XML, first.XML) {
+		t.Error("document changed before its cache duration passed")
+	}
+
+	p.metadata.ExpiresAt = time.Now().Add(-time.Second)
+	renewed, err := p.MetadataDocument()
+	if err != nil {
+		t.Fatalf("MetadataDocument: %v", err)
+	}
+	if !renewed.ExpiresAt.After(time.Now()) {
+		t.Errorf("renewed ExpiresAt = %s, want it in the future", renewed.ExpiresAt)
+	}
+}
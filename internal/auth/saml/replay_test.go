@@ -0,0 +1,105 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// failingCache fails every operation.
+type failingCache struct {
+	cache.Cache
+}
+
+func (failingCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return false, errors.New("cache unavailable")
+}
+
+func newReplayTestProvider(t *testing.T, c cache.Cache, onCacheError string) *Provider {
+	t.Helper()
+
+	return &Provider{
+		id:                 "corp",
+		cache:              c,
+		logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		onReplayCacheError: onCacheError,
+	}
+}
+
+func newMemoryCache(t *testing.T) cache.Cache {
+	t.Helper()
+
+	mc, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryCache: %v", err)
+	}
+	t.Cleanup(func() { mc.Close() })
+	return mc
+}
+
+func testAssertion(id string) *saml.Assertion {
+	return &saml.Assertion{
+		ID:         id,
+		Conditions: &saml.Conditions{NotOnOrAfter: time.Now().Add(5 * time.Minute)},
+	}
+}
+
+func TestCheckReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		cache        func(t *testing.T) cache.Cache
+		onCacheError string
+		ids          []string
+		wantErr      []bool
+	}{
+		{name: "first use", cache: newMemoryCache, onCacheError: "reject", ids: []string{"a"}, wantErr: []bool{false}},
+		{name: "replayed", cache: newMemoryCache, onCacheError: "reject", ids: []string{"a", "a"}, wantErr: []bool{false, true}},
+		{name: "distinct", cache: newMemoryCache, onCacheError: "reject", ids: []string{"a", "b"}, wantErr: []bool{false, false}},
+		{name: "missing id", cache: newMemoryCache, onCacheError: "reject", ids: []string{""}, wantErr: []bool{true}},
+		{name: "cache error rejected", cache: func(*testing.T) cache.Cache { return failingCache{} }, onCacheError: "reject", ids: []string{"a"}, wantErr: []bool{true}},
+		{name: "cache error allowed", cache: func(*testing.T) cache.Cache { return failingCache{} }, onCacheError: "allow", ids: []string{"a"}, wantErr: []bool{false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newReplayTestProvider(t, tt.cache(t), tt.onCacheError)
+			for i, id := range tt.ids {
+				err := p.checkReplay(context.Background(), testAssertion(id))
+				if (err != nil) != tt.wantErr[i] {
+					t.Errorf("assertion %d (%q): err = %v, want error %v", i+1, id, err, tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckReplayConcurrent(t *testing.T) {
+	p := newReplayTestProvider(t, newMemoryCache(t), "reject")
+	assertion := testAssertion("captured")
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.checkReplay(context.Background(), assertion) == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("the same assertion was accepted %d times, want 1", got)
+	}
+}
@@ -2,6 +2,10 @@ package auth
 
 import "time"
 
+// ServiceProviderType marks sessions issued to machine clients through the
+// client credentials grant. ProviderID holds the service client id.
+const ServiceProviderType = "service"
+
 type Session struct {
 	ID           string                 `json:"id"`
 	ProviderID   string                 `json:"provider_id"`
@@ -14,17 +18,80 @@ type Session struct {
 	RefreshToken string    `json:"refresh_token,omitempty"`
 	IDToken      string    `json:"id_token,omitempty"`
 	TokenExpiry  time.Time `json:"token_expiry,omitempty"`
+	Scopes       []string  `json:"scopes,omitempty"`
 
 	Assertion string `json:"assertion,omitempty"`
 
+	// AuthTime is when the user authenticated at the IdP, from the OIDC
+	// auth_time claim or the SAML AuthnInstant. Zero means unknown.
+	AuthTime time.Time `json:"auth_time,omitempty"`
+
+	// AssuranceLevel is the level of assurance of the login, mapped from its
+	// authentication context by assurance_levels. Zero means unknown.
+	AssuranceLevel int `json:"assurance_level,omitempty"`
+
+	// ElevatedUntil is the end of the elevation window entered by
+	// re-authenticating at /auth/elevate.
+	ElevatedUntil time.Time `json:"elevated_until,omitempty"`
+
+	// UserInfoRefreshedAt is when UserInfo was last read from the IdP.
+	UserInfoRefreshedAt time.Time `json:"userinfo_refreshed_at,omitempty"`
+
 	CSRFToken string `json:"csrf_token"`
 }
 
+// Subject returns the user identifier of a session: the OIDC sub claim or
+// the SAML NameID.
+func Subject(session *Session) string {
+	for _, claim := range []string{"sub", "name_id"} {
+		if value, ok := session.UserInfo[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// FilterClaims returns the claims named in allowed, plus the subject claims
+// sub and name_id. An empty allowed returns claims unchanged.
+func FilterClaims(claims map[string]interface{}, allowed []string) map[string]interface{} {
+	if len(allowed) == 0 {
+		return claims
+	}
+
+	filtered := make(map[string]interface{}, len(allowed))
+	for _, claim := range append([]string{"sub", "name_id"}, allowed...) {
+		if value, ok := claims[claim]; ok {
+			filtered[claim] = value
+		}
+	}
+	return filtered
+}
+
+// CapExpiry moves the session's ExpiresAt back to now plus maxTTL when it is
+// later, and reports whether it did. A zero maxTTL leaves it unchanged.
+func CapExpiry(session *Session, maxTTL time.Duration, now time.Time) bool {
+	if maxTTL <= 0 {
+		return false
+	}
+	if ceiling := now.Add(maxTTL); session.ExpiresAt.After(ceiling) {
+		session.ExpiresAt = ceiling
+		return true
+	}
+	return false
+}
+
+// Elevated reports whether session is within its elevation window.
+func Elevated(session *Session) bool {
+	return time.Now().Before(session.ElevatedUntil)
+}
+
 type OIDCState struct {
 	State        string    `json:"state"`
 	ProviderID   string    `json:"provider_id"`
 	CodeVerifier string    `json:"code_verifier"`
+	Nonce        string    `json:"nonce"`
 	RedirectURL  string    `json:"redirect_url"`
+	Scopes       []string  `json:"scopes,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
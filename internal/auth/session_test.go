@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCapExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		maxTTL    time.Duration
+		want      time.Time
+		wantCap   bool
+	}{
+		{name: "far future capped", expiresAt: now.Add(30 * 24 * time.Hour), maxTTL: 8 * time.Hour, want: now.Add(8 * time.Hour), wantCap: true},
+		{name: "within max", expiresAt: now.Add(time.Hour), maxTTL: 8 * time.Hour, want: now.Add(time.Hour)},
+		{name: "at max", expiresAt: now.Add(8 * time.Hour), maxTTL: 8 * time.Hour, want: now.Add(8 * time.Hour)},
+		{name: "disabled", expiresAt: now.Add(30 * 24 * time.Hour), want: now.Add(30 * 24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ExpiresAt: tt.expiresAt}
+
+			if got := CapExpiry(session, tt.maxTTL, now); got != tt.wantCap {
+				t.Errorf("CapExpiry = %v, want %v", got, tt.wantCap)
+			}
+			if !session.ExpiresAt.Equal(tt.want) {
+				t.Errorf("ExpiresAt = %s, want %s", session.ExpiresAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":    "alice",
+		"email":  "alice@example.com",
+		"groups": []interface{}{"admins"},
+		"photo":  "data:image/png;base64,AAAA",
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		allowed []string
+		want    map[string]interface{}
+	}{
+		{name: "no allowlist", claims: claims, want: claims},
+		{
+			name:    "allowlisted",
+			claims:  claims,
+			allowed: []string{"email", "groups"},
+			want:    map[string]interface{}{"sub": "alice", "email": "alice@example.com", "groups": []interface{}{"admins"}},
+		},
+		{
+			name:    "saml subject kept",
+			claims:  map[string]interface{}{"name_id": "alice@corp", "role": "admin", "department": "it"},
+			allowed: []string{"role"},
+			want:    map[string]interface{}{"name_id": "alice@corp", "role": "admin"},
+		},
+		{name: "allowlisted claim missing", claims: claims, allowed: []string{"phone"}, want: map[string]interface{}{"sub": "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterClaims(tt.claims, tt.allowed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterClaims = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
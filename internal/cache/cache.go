@@ -13,21 +13,32 @@ var ErrNotFound = errors.New("key not found")
 type Cache interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores value only if key has no live entry, atomically, and
+	// reports whether it was stored.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
+	// Keys returns the keys of the live entries starting with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
 	Close() error
 }
 
 func New(cfg config.CacheConfig) (Cache, error) {
+	var c Cache
+	var err error
 	switch cfg.Type {
 	case "memory":
-		return NewMemoryCache(), nil
+		c, err = NewMemoryCache(cfg.Memory)
 	case "redis":
 		if cfg.Redis == nil {
 			return nil, errors.New("redis config is required for redis cache type")
 		}
-		return NewRedisCache(*cfg.Redis)
+		c, err = NewRedisCache(*cfg.Redis)
 	default:
 		return nil, errors.New("unsupported cache type: " + cfg.Type)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return WithTimeout(c, cfg.OperationTimeout), nil
 }
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Format bytes prefixed to encoded values, so entries written with another
+// serialization (or before prefixes existed) can still be read.
+const (
+	formatJSON    byte = 0x01
+	formatMsgpack byte = 0x02
+)
+
+// Codec serializes values stored in the cache. Decode accepts every known
+// format regardless of the one used for encoding.
+type Codec struct {
+	format byte
+}
+
+func NewCodec(serialization string) (*Codec, error) {
+	switch serialization {
+	case "", "json":
+		return &Codec{format: formatJSON}, nil
+	case "msgpack":
+		return &Codec{format: formatMsgpack}, nil
+	default:
+		return nil, fmt.Errorf("unsupported serialization: %s", serialization)
+	}
+}
+
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	switch c.format {
+	case formatMsgpack:
+		var buf bytes.Buffer
+		buf.WriteByte(formatMsgpack)
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{formatJSON}, data...), nil
+	}
+}
+
+func (c *Codec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty cache value")
+	}
+
+	switch data[0] {
+	case formatJSON:
+		return json.Unmarshal(data[1:], v)
+	case formatMsgpack:
+		dec := msgpack.NewDecoder(bytes.NewReader(data[1:]))
+		dec.SetCustomStructTag("json")
+		// Integer claims would otherwise decode as the smallest type that
+		// holds them (int8, uint16, ...), which claim handling does not expect.
+		dec.UseLooseInterfaceDecoding(true)
+		return dec.Decode(v)
+	default:
+		// Values written before format prefixes were introduced are plain JSON.
+		return json.Unmarshal(data, v)
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+func testCodecSession() *auth.Session {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return &auth.Session{
+		ID:           "s1",
+		ProviderID:   "corp",
+		ProviderType: "oidc",
+		UserInfo: map[string]interface{}{
+			"sub":       "alice",
+			"groups":    []interface{}{"admins", "staff"},
+			"auth_time": float64(now.Unix()),
+			"level":     3,
+		},
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Hour),
+		RefreshToken: "refresh",
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	session := testCodecSession()
+
+	tests := []struct {
+		name  string
+		write string
+		read  string
+		// wantLevel is the integer claim as decoded: JSON has no integers.
+		wantLevel interface{}
+	}{
+		{name: "json", write: "json", read: "json", wantLevel: float64(3)},
+		{name: "msgpack", write: "msgpack", read: "msgpack", wantLevel: int64(3)},
+		{name: "json read by msgpack", write: "json", read: "msgpack", wantLevel: float64(3)},
+		{name: "msgpack read by json", write: "msgpack", read: "json", wantLevel: int64(3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, err := NewCodec(tt.write)
+			if err != nil {
+				t.Fatalf("NewCodec(%s): %v", tt.write, err)
+			}
+			reader, err := NewCodec(tt.read)
+			if err != nil {
+				t.Fatalf("NewCodec(%s): %v", tt.read, err)
+			}
+
+			data, err := writer.Marshal(session)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var got auth.Session
+			if err := reader.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+
+			if got.ID != session.ID || got.ProviderID != session.ProviderID || got.RefreshToken != session.RefreshToken {
+				t.Errorf("session = %+v, want %+v", got, session)
+			}
+			if !got.CreatedAt.Equal(session.CreatedAt) || !got.ExpiresAt.Equal(session.ExpiresAt) {
+				t.Errorf("times = %s/%s, want %s/%s", got.CreatedAt, got.ExpiresAt, session.CreatedAt, session.ExpiresAt)
+			}
+
+			wantUserInfo := map[string]interface{}{
+				"sub":       "alice",
+				"groups":    []interface{}{"admins", "staff"},
+				"auth_time": float64(session.CreatedAt.Unix()),
+				"level":     tt.wantLevel,
+			}
+			if !reflect.DeepEqual(got.UserInfo, wantUserInfo) {
+				t.Errorf("UserInfo = %#v, want %#v", got.UserInfo, wantUserInfo)
+			}
+			if authTime, ok := auth.AuthTime(got.UserInfo); !ok || !authTime.Equal(session.CreatedAt) {
+				t.Errorf("AuthTime = %s, %v, want %s", authTime, ok, session.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestCodecLegacyJSON(t *testing.T) {
+	legacy := []byte(`{"id":"s1","provider_id":"corp","user_info":{"sub":"alice","level":3},"expires_at":"2026-01-01T13:00:00Z"}`)
+
+	for _, serialization := range []string{"json", "msgpack"} {
+		t.Run(serialization, func(t *testing.T) {
+			codec, err := NewCodec(serialization)
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+
+			var got auth.Session
+			if err := codec.Unmarshal(legacy, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if got.ID != "s1" || got.ProviderID != "corp" || got.UserInfo["sub"] != "alice" || got.UserInfo["level"] != float64(3) {
+				t.Errorf("session = %+v", got)
+			}
+			if want := time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC); !got.ExpiresAt.Equal(want) {
+				t.Errorf("ExpiresAt = %s, want %s", got.ExpiresAt, want)
+			}
+		})
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	if _, err := NewCodec("gob"); err == nil || err.Error() != "unsupported serialization: gob" {
+		t.Errorf("NewCodec(gob) error = %v, want unsupported serialization", err)
+	}
+
+	codec, err := NewCodec("json")
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	var session auth.Session
+	if err := codec.Unmarshal(nil, &session); err == nil {
+		t.Errorf("Unmarshal of an empty value succeeded")
+	}
+	if err := codec.Unmarshal([]byte{formatMsgpack, 0xc1}, &session); err == nil {
+		t.Errorf("Unmarshal of corrupt msgpack succeeded")
+	}
+}
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	session := testCodecSession()
+
+	for _, serialization := range []string{"json", "msgpack"} {
+		b.Run(serialization, func(b *testing.B) {
+			codec, err := NewCodec(serialization)
+			if err != nil {
+				b.Fatalf("NewCodec: %v", err)
+			}
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := codec.Marshal(session); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	session := testCodecSession()
+
+	for _, serialization := range []string{"json", "msgpack"} {
+		b.Run(serialization, func(b *testing.B) {
+			codec, err := NewCodec(serialization)
+			if err != nil {
+				b.Fatalf("NewCodec: %v", err)
+			}
+			data, err := codec.Marshal(session)
+			if err != nil {
+				b.Fatalf("Marshal: %v", err)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				var decoded auth.Session
+				if err := codec.Unmarshal(data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,14 +2,24 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
 )
 
+// MemoryCache keeps entries in process memory. Operations fail without
+// effect when their context is already done.
 type MemoryCache struct {
-	data   map[string]*cacheItem
-	mu     sync.RWMutex
-	stopCh chan struct{}
+	data         map[string]*cacheItem
+	mu           sync.RWMutex
+	stopCh       chan struct{}
+	snapshotPath string
 }
 
 type cacheItem struct {
@@ -17,18 +27,37 @@ type cacheItem struct {
 	expiresAt time.Time
 }
 
-func NewMemoryCache() *MemoryCache {
+// snapshotEntry is the on-disk form of a cache item.
+type snapshotEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewMemoryCache creates an in-memory cache. When a snapshot path is
+// configured, entries saved by the previous Close are restored.
+func NewMemoryCache(cfg config.MemoryConfig) (*MemoryCache, error) {
 	mc := &MemoryCache{
-		data:   make(map[string]*cacheItem),
-		stopCh: make(chan struct{}),
+		data:         make(map[string]*cacheItem),
+		stopCh:       make(chan struct{}),
+		snapshotPath: cfg.SnapshotPath,
+	}
+
+	if mc.snapshotPath != "" {
+		if err := mc.restore(); err != nil {
+			return nil, err
+		}
 	}
 
 	go mc.cleanupExpired()
 
-	return mc
+	return mc, nil
 }
 
 func (mc *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 
@@ -47,6 +76,10 @@ func (mc *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
 }
 
 func (mc *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
@@ -61,7 +94,34 @@ func (mc *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl ti
 	return nil
 }
 
+func (mc *MemoryCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if item, exists := mc.data[key]; exists && time.Now().Before(item.expiresAt) {
+		return false, nil
+	}
+
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+
+	mc.data[key] = &cacheItem{
+		value:     valueCopy,
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	return true, nil
+}
+
 func (mc *MemoryCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
@@ -70,6 +130,10 @@ func (mc *MemoryCache) Delete(ctx context.Context, key string) error {
 }
 
 func (mc *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 
@@ -85,8 +149,96 @@ func (mc *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
 	return true, nil
 }
 
+func (mc *MemoryCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	now := time.Now()
+	var keys []string
+	for key, item := range mc.data {
+		if strings.HasPrefix(key, prefix) && now.Before(item.expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Len returns the number of stored entries, including expired entries that
+// have not been cleaned up yet.
+func (mc *MemoryCache) Len() int {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	return len(mc.data)
+}
+
 func (mc *MemoryCache) Close() error {
 	close(mc.stopCh)
+
+	if mc.snapshotPath != "" {
+		return mc.snapshot()
+	}
+	return nil
+}
+
+// snapshot writes the non-expired entries to the snapshot path. The file is
+// written to a temporary name first so a crash never leaves a partial file.
+func (mc *MemoryCache) snapshot() error {
+	mc.mu.RLock()
+	now := time.Now()
+	entries := make(map[string]snapshotEntry, len(mc.data))
+	for key, item := range mc.data {
+		if now.Before(item.expiresAt) {
+			entries[key] = snapshotEntry{Value: item.value, ExpiresAt: item.expiresAt}
+		}
+	}
+	mc.mu.RUnlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+
+	tmpPath := mc.snapshotPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, mc.snapshotPath); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// restore loads the snapshot, skipping expired entries, and removes the file
+// so that a later crash cannot bring back sessions deleted since.
+func (mc *MemoryCache) restore() error {
+	data, err := os.ReadFile(mc.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+
+	var entries map[string]snapshotEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+
+	now := time.Now()
+	for key, entry := range entries {
+		if now.Before(entry.ExpiresAt) {
+			mc.data[key] = &cacheItem{value: entry.Value, expiresAt: entry.ExpiresAt}
+		}
+	}
+
+	if err := os.Remove(mc.snapshotPath); err != nil {
+		return fmt.Errorf("failed to remove cache snapshot: %w", err)
+	}
 	return nil
 }
 
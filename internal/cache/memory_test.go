@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func newTestMemoryCache(t *testing.T) *MemoryCache {
+	t.Helper()
+
+	mc, err := NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryCache: %v", err)
+	}
+	t.Cleanup(func() { mc.Close() })
+	return mc
+}
+
+func TestMemoryCacheSetNX(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		ttl      time.Duration
+		want     bool
+	}{
+		{name: "absent", want: true},
+		{name: "present", existing: true, ttl: time.Minute, want: false},
+		{name: "expired", existing: true, ttl: -time.Second, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := newTestMemoryCache(t)
+			ctx := context.Background()
+			if tt.existing {
+				mc.Set(ctx, "key", []byte("old"), tt.ttl)
+			}
+
+			stored, err := mc.SetNX(ctx, "key", []byte("new"), time.Minute)
+			if err != nil {
+				t.Fatalf("SetNX: %v", err)
+			}
+			if stored != tt.want {
+				t.Errorf("SetNX stored = %v, want %v", stored, tt.want)
+			}
+
+			want := "old"
+			if tt.want {
+				want = "new"
+			}
+			if value, _ := mc.Get(ctx, "key"); string(value) != want {
+				t.Errorf("value = %q, want %q", value, want)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheSetNXConcurrent(t *testing.T) {
+	mc := newTestMemoryCache(t)
+
+	var stored atomic.Int32
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := mc.SetNX(context.Background(), "key", []byte("v"), time.Minute); err == nil && ok {
+				stored.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := stored.Load(); got != 1 {
+		t.Errorf("SetNX stored the key %d times, want 1", got)
+	}
+}
+
+func TestTimeoutCacheSetNX(t *testing.T) {
+	c := WithTimeout(newTestMemoryCache(t), time.Second)
+
+	for i, want := range []bool{true, false} {
+		stored, err := c.SetNX(context.Background(), "key", []byte("v"), time.Minute)
+		if err != nil {
+			t.Fatalf("SetNX: %v", err)
+		}
+		if stored != want {
+			t.Errorf("call %d: stored = %v, want %v", i+1, stored, want)
+		}
+	}
+}
+
+func TestMemoryCacheSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	mc, err := NewMemoryCache(config.MemoryConfig{SnapshotPath: path})
+	if err != nil {
+		t.Fatalf("NewMemoryCache: %v", err)
+	}
+	if err := mc.Set(ctx, "session:kept", []byte("alice"), time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := mc.Set(ctx, "session:expiring", []byte("bob"), 10*time.Millisecond); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := mc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := NewMemoryCache(config.MemoryConfig{SnapshotPath: path})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+
+	got, err := reopened.Get(ctx, "session:kept")
+	if err != nil || string(got) != "alice" {
+		t.Errorf("Get(session:kept) = %q, %v, want alice", got, err)
+	}
+	if _, err := reopened.Get(ctx, "session:expiring"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(session:expiring) error = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("snapshot not removed after restore: %v", err)
+	}
+}
+
+func TestMemoryCacheSnapshotCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	if _, err := NewMemoryCache(config.MemoryConfig{SnapshotPath: path}); err == nil {
+		t.Error("NewMemoryCache with a corrupt snapshot succeeded")
+	}
+}
@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/marcogenualdo/sso-switch/internal/config"
@@ -14,18 +16,14 @@ type RedisCache struct {
 }
 
 func NewRedisCache(cfg config.RedisConfig) (*RedisCache, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:         cfg.Address,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		PoolSize:     cfg.PoolSize,
-		MaxRetries:   cfg.MaxRetries,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-	})
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	opts, err := redisOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout+cfg.ReadTimeout)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
@@ -35,6 +33,30 @@ func NewRedisCache(cfg config.RedisConfig) (*RedisCache, error) {
 	return &RedisCache{client: client}, nil
 }
 
+// redisOptions builds the client options from url, when set, or from
+// address, password and db.
+func redisOptions(cfg config.RedisConfig) (*redis.Options, error) {
+	opts := &redis.Options{
+		Addr:     cfg.Address,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.URL != "" {
+		var err error
+		opts, err = redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+	}
+
+	opts.PoolSize = cfg.PoolSize
+	opts.MaxRetries = cfg.MaxRetries
+	opts.DialTimeout = cfg.DialTimeout
+	opts.ReadTimeout = cfg.ReadTimeout
+	opts.WriteTimeout = cfg.WriteTimeout
+	return opts, nil
+}
+
 func (rc *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
 	val, err := rc.client.Get(ctx, key).Bytes()
 	if err != nil {
@@ -50,6 +72,10 @@ func (rc *RedisCache) Set(ctx context.Context, key string, value []byte, ttl tim
 	return rc.client.Set(ctx, key, value, ttl).Err()
 }
 
+func (rc *RedisCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return rc.client.SetNX(ctx, key, value, ttl).Result()
+}
+
 func (rc *RedisCache) Delete(ctx context.Context, key string) error {
 	return rc.client.Del(ctx, key).Err()
 }
@@ -62,6 +88,37 @@ func (rc *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
 	return count > 0, nil
 }
 
+// Keys walks the keyspace with SCAN, so that large databases are not
+// blocked. Glob characters in prefix are escaped.
+func (rc *RedisCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(prefix) + "*"
+
+	var keys []string
+	iter := rc.client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// Ping measures the round trip time of a PING command.
+func (rc *RedisCache) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := rc.client.Ping(ctx).Err(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+func (rc *RedisCache) PoolStats() *redis.PoolStats {
+	return rc.client.PoolStats()
+}
+
 func (rc *RedisCache) Close() error {
 	return rc.client.Close()
 }
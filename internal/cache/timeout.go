@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutCache bounds every operation of the wrapped cache by a deadline, on
+// top of any deadline of the caller's context.
+type timeoutCache struct {
+	Cache
+	timeout time.Duration
+}
+
+// WithTimeout wraps c so that each operation fails with
+// context.DeadlineExceeded after timeout. Zero returns c unchanged.
+func WithTimeout(c Cache, timeout time.Duration) Cache {
+	if timeout <= 0 {
+		return c
+	}
+	return &timeoutCache{Cache: c, timeout: timeout}
+}
+
+// Unwrap returns the cache wrapped by WithTimeout, or c itself.
+func Unwrap(c Cache) Cache {
+	if tc, ok := c.(*timeoutCache); ok {
+		return tc.Cache
+	}
+	return c
+}
+
+func (tc *timeoutCache) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.Get(ctx, key)
+}
+
+func (tc *timeoutCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.Set(ctx, key, value, ttl)
+}
+
+func (tc *timeoutCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.SetNX(ctx, key, value, ttl)
+}
+
+func (tc *timeoutCache) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.Delete(ctx, key)
+}
+
+func (tc *timeoutCache) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.Exists(ctx, key)
+}
+
+func (tc *timeoutCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, tc.timeout)
+	defer cancel()
+	return tc.Cache.Keys(ctx, prefix)
+}
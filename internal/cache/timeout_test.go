@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingCache blocks every Get until the context is done.
+type blockingCache struct {
+	Cache
+}
+
+func (blockingCache) Get(ctx context.Context, key string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestWithTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		callerLimit time.Duration
+		wantWrapped bool
+		wantErr     error
+	}{
+		{name: "operation timeout", timeout: 10 * time.Millisecond, callerLimit: time.Minute, wantWrapped: true, wantErr: context.DeadlineExceeded},
+		{name: "caller deadline first", timeout: time.Minute, callerLimit: 10 * time.Millisecond, wantWrapped: true, wantErr: context.DeadlineExceeded},
+		{name: "disabled", callerLimit: 10 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := blockingCache{}
+			c := WithTimeout(inner, tt.timeout)
+
+			if _, wrapped := c.(*timeoutCache); wrapped != tt.wantWrapped {
+				t.Errorf("wrapped = %v, want %v", wrapped, tt.wantWrapped)
+			}
+			if Unwrap(c) != Cache(inner) {
+				t.Error("Unwrap does not return the wrapped cache")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.callerLimit)
+			defer cancel()
+
+			start := time.Now()
+			_, err := c.Get(ctx, "key")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Get error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Get took %s", elapsed)
+			}
+		})
+	}
+}
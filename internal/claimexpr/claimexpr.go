@@ -0,0 +1,529 @@
+// Package claimexpr evaluates boolean expressions over a session's claims,
+// such as
+//
+//	claims.email endsWith "@corp.com" && "employees" in claims.groups
+//
+// Claims are read with claims.name or claims["name"], and nested objects with
+// further selectors. Literals are double-quoted strings, numbers, true, false,
+// null and lists ([...]). Operators, from lowest to highest precedence:
+// ||, &&, !, then ==, !=, <, <=, >, >=, in, startsWith, endsWith, contains
+// and matches (a regular expression literal). Missing claims are null, and a
+// comparison involving a value of the wrong type is false.
+package claimexpr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	source string
+	root   node
+}
+
+// Compile parses source.
+func Compile(source string) (*Expr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval reports whether claims satisfy the expression. It fails when the
+// expression does not evaluate to a boolean.
+func (e *Expr) Eval(claims map[string]interface{}) (bool, error) {
+	value, err := e.root.eval(claims)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value)
+}
+
+type node interface {
+	eval(claims map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ value interface{} }
+
+func (n literal) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type list []node
+
+func (n list) eval(claims map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, len(n))
+	for i, item := range n {
+		value, err := item.eval(claims)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// claimPath selects a claim, then keys of nested objects.
+type claimPath []string
+
+func (n claimPath) eval(claims map[string]interface{}) (interface{}, error) {
+	var value interface{} = claims
+	for _, key := range n {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		value = object[key]
+	}
+	return value, nil
+}
+
+type not struct{ operand node }
+
+func (n not) eval(claims map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(claims)
+	if err != nil {
+		return nil, err
+	}
+	b, err := truthy(value)
+	return !b, err
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n logical) eval(claims map[string]interface{}) (interface{}, error) {
+	value, err := n.left.eval(claims)
+	if err != nil {
+		return nil, err
+	}
+	left, err := truthy(value)
+	if err != nil {
+		return nil, err
+	}
+	if left != n.and {
+		return left, nil
+	}
+
+	value, err = n.right.eval(claims)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(value)
+}
+
+type comparison struct {
+	op          string
+	left, right node
+	pattern     *regexp.Regexp
+}
+
+func (n comparison) eval(claims map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(claims)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "matches" {
+		s, ok := left.(string)
+		return ok && n.pattern.MatchString(s), nil
+	}
+
+	right, err := n.right.eval(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left), nil
+	case "startsWith", "endsWith", "contains":
+		l, lok := left.(string)
+		r, rok := right.(string)
+		if !lok || !rok {
+			return false, nil
+		}
+		switch n.op {
+		case "startsWith":
+			return strings.HasPrefix(l, r), nil
+		case "endsWith":
+			return strings.HasSuffix(l, r), nil
+		}
+		return strings.Contains(l, r), nil
+	}
+
+	l, lok := number(left)
+	r, rok := number(right)
+	if !lok || !rok {
+		return false, nil
+	}
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	}
+	return l >= r, nil
+}
+
+func truthy(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("expected a boolean, got %T", value)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch a.(type) {
+	case string, bool, nil:
+		return a == b
+	}
+	return false
+}
+
+// contains reports whether collection, a list, object or string, holds item:
+// an equal element, a key or a substring.
+func contains(collection, item interface{}) bool {
+	switch c := collection.(type) {
+	case []interface{}:
+		for _, element := range c {
+			if equal(element, item) {
+				return true
+			}
+		}
+	case []string:
+		s, ok := item.(string)
+		if !ok {
+			return false
+		}
+		for _, element := range c {
+			if element == s {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		s, ok := item.(string)
+		if !ok {
+			return false
+		}
+		_, exists := c[s]
+		return exists
+	case string:
+		s, ok := item.(string)
+		return ok && strings.Contains(c, s)
+	}
+	return false
+}
+
+var comparisonOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "startsWith": true, "endsWith": true, "contains": true, "matches": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.next(); tok.text != text || tok.kind == tokenString {
+		return fmt.Errorf("expected %q at offset %d, got %q", text, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().is("!") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.kind == tokenString || !comparisonOps[tok.text] {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	n := comparison{op: tok.text, left: left, right: right}
+	if tok.text == "matches" {
+		lit, isLiteral := right.(literal)
+		pattern, isString := lit.value.(string)
+		if !isLiteral || !isString {
+			return nil, fmt.Errorf("matches at offset %d requires a string literal", tok.pos)
+		}
+		n.pattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at offset %d: %w", tok.pos, err)
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return literal{tok.text}, nil
+	case tokenNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return literal{value}, nil
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch tok.text {
+	case "true":
+		return literal{true}, nil
+	case "false":
+		return literal{false}, nil
+	case "null":
+		return literal{nil}, nil
+	case "claims":
+		return p.parsePath()
+	case "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case "[":
+		var items list
+		for !p.peek().is("]") {
+			if len(items) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			item, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		p.next()
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+func (p *parser) parsePath() (node, error) {
+	var path claimPath
+	for {
+		switch {
+		case p.peek().is("."):
+			p.next()
+			tok := p.next()
+			if tok.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a claim name at offset %d", tok.pos)
+			}
+			path = append(path, tok.text)
+		case p.peek().is("["):
+			p.next()
+			tok := p.next()
+			if tok.kind != tokenString {
+				return nil, fmt.Errorf("expected a quoted claim name at offset %d", tok.pos)
+			}
+			path = append(path, tok.text)
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			if len(path) == 0 {
+				return nil, fmt.Errorf("claims must be followed by a claim name")
+			}
+			return path, nil
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) is(symbol string) bool {
+	return t.kind == tokenSymbol && t.text == symbol
+}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			value, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: i})
+			i = end + 1
+		case isDigit(c) || c == '-' && i+1 < len(source) && isDigit(source[i+1]):
+			end := i + 1
+			for end < len(source) && (isDigit(source[end]) || source[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[i:end], pos: i})
+			i = end
+		case isLetter(c):
+			end := i + 1
+			for end < len(source) && (isLetter(source[end]) || isDigit(source[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[i:end], pos: i})
+			i = end
+		default:
+			symbol := string(c)
+			if i+1 < len(source) {
+				switch two := source[i : i+2]; two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					symbol = two
+				}
+			}
+			if len(symbol) == 1 && !strings.ContainsRune("()[].,!<>", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: symbol, pos: i})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,89 @@
+package claimexpr
+
+import "testing"
+
+func TestEval(t *testing.T) {
+	claims := map[string]interface{}{
+		"email":                     "alice@corp.com",
+		"email_verified":            true,
+		"groups":                    []interface{}{"employees", "admins"},
+		"roles":                     []string{"reader"},
+		"level":                     float64(3),
+		"org":                       map[string]interface{}{"id": "acme", "tier": "gold"},
+		"https://example.com/roles": []interface{}{"owner"},
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "equal", expression: `claims.email == "alice@corp.com"`, want: true},
+		{name: "not equal", expression: `claims.email != "bob@corp.com"`, want: true},
+		{name: "endsWith", expression: `claims.email endsWith "@corp.com"`, want: true},
+		{name: "endsWith mismatch", expression: `claims.email endsWith "@evil.com"`, want: false},
+		{name: "endsWith non-string", expression: `claims.level endsWith "3"`, want: false},
+		{name: "startsWith", expression: `claims.email startsWith "alice@"`, want: true},
+		{name: "contains", expression: `claims.email contains "@corp"`, want: true},
+		{name: "in list claim", expression: `"employees" in claims.groups`, want: true},
+		{name: "in list claim missing", expression: `"contractors" in claims.groups`, want: false},
+		{name: "in string list claim", expression: `"reader" in claims.roles`, want: true},
+		{name: "in literal list", expression: `claims.org.tier in ["gold", "platinum"]`, want: true},
+		{name: "in object keys", expression: `"tier" in claims.org`, want: true},
+		{name: "in substring", expression: `"corp" in claims.email`, want: true},
+		{name: "in missing claim", expression: `"employees" in claims.teams`, want: false},
+		{name: "quoted claim name", expression: `"owner" in claims["https://example.com/roles"]`, want: true},
+		{name: "nested claim", expression: `claims.org.id == "acme"`, want: true},
+		{name: "missing claim is null", expression: `claims.phone == null`, want: true},
+		{name: "numeric comparison", expression: `claims.level >= 3 && claims.level < 4`, want: true},
+		{name: "numeric comparison on string", expression: `claims.email > 1`, want: false},
+		{name: "matches", expression: `claims.email matches "^[a-z]+@corp\\.com$"`, want: true},
+		{name: "boolean claim", expression: `claims.email_verified`, want: true},
+		{name: "negation", expression: `!claims.email_verified`, want: false},
+		{name: "or", expression: `claims.email endsWith "@evil.com" || "admins" in claims.groups`, want: true},
+		{name: "precedence", expression: `false && true || true`, want: true},
+		{name: "parentheses", expression: `false && (true || true)`, want: false},
+		{name: "non-boolean result", expression: `claims.email`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Compile(tt.expression)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.expression, err)
+			}
+			got, err := expr.Eval(claims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "empty", expression: ``},
+		{name: "unterminated string", expression: `claims.email == "alice`},
+		{name: "bare claims", expression: `claims == "x"`},
+		{name: "unknown character", expression: `claims.email ~ "x"`},
+		{name: "matches without literal", expression: `claims.email matches claims.pattern`},
+		{name: "invalid regular expression", expression: `claims.email matches "("`},
+		{name: "unbalanced parentheses", expression: `(claims.email_verified`},
+		{name: "trailing tokens", expression: `claims.email_verified true`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.expression); err == nil {
+				t.Errorf("Compile(%q) succeeded, want an error", tt.expression)
+			}
+		})
+	}
+}
@@ -1,20 +1,43 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server    ServerConfig     `yaml:"server"`
-	Backend   BackendConfig    `yaml:"backend"`
-	Cache     CacheConfig      `yaml:"cache"`
-	Providers []ProviderConfig `yaml:"providers"`
-	Logging   LoggingConfig    `yaml:"logging"`
-	UI        UIConfig         `yaml:"ui"`
+	Server    ServerConfig             `yaml:"server"`
+	Backend   BackendConfig            `yaml:"backend"`
+	Cache     CacheConfig              `yaml:"cache"`
+	Providers []ProviderConfig         `yaml:"providers"`
+	Logging   LoggingConfig            `yaml:"logging"`
+	UI        UIConfig                 `yaml:"ui"`
+	OIDC      OIDCDefaults             `yaml:"oidc"`
+	SAML      SAMLDefaults             `yaml:"saml"`
+	Profiles  map[string]ProfileConfig `yaml:"profiles"`
+
+	ServiceClients []ServiceClientConfig `yaml:"service_clients"`
+
+	Metrics MetricsConfig `yaml:"metrics"`
+	Events  EventsConfig  `yaml:"events"`
+	Admin   AdminConfig   `yaml:"admin"`
+
+	// DevMode enables development-only features such as mock providers. It
+	// refuses production settings like secure cookies and TLS.
+	DevMode bool `yaml:"dev_mode"`
+
+	// AssuranceLevels maps authentication context values, the OIDC acr claim
+	// or the SAML AuthnContextClassRef, to a numeric level of assurance.
+	AssuranceLevels map[string]int `yaml:"assurance_levels"`
+
+	warnings []string
 }
 
 type ServerConfig struct {
@@ -27,34 +50,329 @@ type ServerConfig struct {
 	CookieHTTPOnly bool          `yaml:"cookie_http_only"`
 	CookieSameSite string        `yaml:"cookie_same_site"`
 	SessionTTL     time.Duration `yaml:"session_ttl"`
+	// MaxSessionLifetime caps how long a session may live, counted from
+	// login, regardless of token refreshes. Zero disables the limit.
+	MaxSessionLifetime time.Duration `yaml:"max_session_lifetime"`
+	// MaxTokenTTL caps the expiry a session takes from the IdP's token
+	// expiry or SAML conditions, at login and on refresh, to this long from
+	// then. Zero disables the cap.
+	MaxTokenTTL time.Duration `yaml:"max_token_ttl"`
+	// RefreshRetries is how many times a token refresh that failed on a
+	// network error or an unavailable IdP is retried, waiting
+	// RefreshRetryBackoff (doubled each time) in between. Sessions whose
+	// refresh keeps failing that way are kept and the request gets a 503.
+	// Zero disables retries; unset defaults to 2.
+	RefreshRetries      *int          `yaml:"refresh_retries"`
+	RefreshRetryBackoff time.Duration `yaml:"refresh_retry_backoff"`
+	// RequestTimeout bounds total request handling time. Zero disables it.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxConcurrentRequests sheds load with 503 above this many in-flight
+	// requests. Zero means unlimited.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+	// TrustedProxies lists the IPs/CIDRs whose X-Forwarded-For entries are
+	// trusted when resolving the client IP.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// TLSCertFile and TLSKeyFile enable HTTPS. Both are re-read on SIGHUP.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// ErrorFormat is auto, json, html or text; auto negotiates via Accept.
+	ErrorFormat string `yaml:"error_format"`
+	// CSRFMode is cache (tokens stored in the cache) or double_submit
+	// (stateless, token echoed in a SameSite=Strict cookie).
+	CSRFMode string `yaml:"csrf_mode"`
+	// InstanceID identifies this deployment when several instances share a
+	// cookie domain. It appends a stable suffix to the session cookie name.
+	InstanceID string `yaml:"instance_id"`
+	// MaxLoginRedirects is how many times a user may be sent to the login
+	// page within LoginRedirectWindow before a redirect loop is reported.
+	// Negative disables the check.
+	MaxLoginRedirects   int           `yaml:"max_login_redirects"`
+	LoginRedirectWindow time.Duration `yaml:"login_redirect_window"`
+	// Environment is development or production. Production rejects
+	// insecure settings that development only warns about; empty checks
+	// neither.
+	Environment string `yaml:"environment"`
+	// Mode is proxy (default) or auth_only, which serves only the auth
+	// endpoints and does not mount the reverse proxy.
+	Mode string `yaml:"mode"`
+	// PostLoginRedirect is where users land after a login without a target
+	// of its own. It defaults to / in proxy mode and is required in
+	// auth_only mode, which serves nothing at /.
+	PostLoginRedirect string `yaml:"post_login_redirect"`
+	// SingleSessionPerUser invalidates a user's previous session for the same
+	// provider when they log in again.
+	SingleSessionPerUser bool `yaml:"single_session_per_user"`
+	// SameSiteCompat omits SameSite=None from the session cookie for user
+	// agents known to reject or misinterpret it.
+	SameSiteCompat bool `yaml:"samesite_compat"`
+	// CookiePriority is low, medium or high and sets the Priority attribute
+	// of the session cookie. Empty omits it.
+	CookiePriority string `yaml:"cookie_priority"`
+	// ConcurrentLoginPolicy is allow (default), header, which sets
+	// X-Auth-Concurrent-Sessions to the user's active session count, or
+	// notify, which emits a concurrent_login event when a user logs in while
+	// already having a session.
+	ConcurrentLoginPolicy string `yaml:"concurrent_login_policy"`
+	// OrphanedSessionPolicy handles sessions whose provider is no longer
+	// configured: logout (default) ends them and shows a notice on the select
+	// page, keep accepts them until they expire without validating them at
+	// the provider.
+	OrphanedSessionPolicy string `yaml:"orphaned_session_policy"`
+	// AllowedRedirectDomains lists the hosts, besides the base_url host,
+	// that post-login redirects such as the SAML RelayState may point to. A
+	// leading dot, as in .example.com, also allows every subdomain. Other
+	// targets are replaced with /.
+	AllowedRedirectDomains []string `yaml:"allowed_redirect_domains"`
+	// ElevationWindow enables /auth/elevate, where users re-authenticate
+	// interactively to mark their session elevated for this long. Zero
+	// disables it.
+	ElevationWindow time.Duration `yaml:"elevation_window"`
+	// SessionClaims, when set, lists the claims kept in sessions after
+	// login; the others are dropped to keep sessions small. The subject
+	// claims sub and name_id are always kept. Empty keeps every claim.
+	SessionClaims []string `yaml:"session_claims"`
+
+	UnauthenticatedResponse UnauthenticatedResponseConfig `yaml:"unauthenticated_response"`
 }
 
+// UnauthenticatedResponseConfig controls what proxied requests without a
+// valid session get: a redirect to the select page, a 401 with
+// WWW-Authenticate, or a custom status and body.
+type UnauthenticatedResponseConfig struct {
+	Mode            string `yaml:"mode"`
+	WWWAuthenticate string `yaml:"www_authenticate"`
+	Status          int    `yaml:"status"`
+	Body            string `yaml:"body"`
+	ContentType     string `yaml:"content_type"`
+}
+
+const (
+	ModeProxy    = "proxy"
+	ModeAuthOnly = "auth_only"
+)
+
 type BackendConfig struct {
 	URL          string        `yaml:"url"`
 	Timeout      time.Duration `yaml:"timeout"`
 	PreserveHost bool          `yaml:"preserve_host"`
+	// RewriteRedirects maps Location and Set-Cookie domains that point at the
+	// backend host back to the public base URL.
+	RewriteRedirects bool `yaml:"rewrite_redirects"`
+	// StripPathPrefix removes this prefix from request paths before they are
+	// proxied, so /app/users reaches the backend as /users. Redirect
+	// rewriting adds it back to backend redirects.
+	StripPathPrefix string `yaml:"strip_path_prefix"`
+	// PathRewrite rewrites request paths, after StripPathPrefix, with a
+	// regular expression replacement.
+	PathRewrite *PathRewriteConfig `yaml:"path_rewrite,omitempty"`
+	// Protocol is http (default) or grpc, which talks HTTP/2 to the backend
+	// (h2c for http:// URLs), streams without buffering and accepts h2c from
+	// clients.
+	Protocol string `yaml:"protocol"`
+
+	RoutesByClaim *ClaimRoutesConfig `yaml:"routes_by_claim,omitempty"`
+	// HeaderPreset injects a well-known header set from standard claims.
+	HeaderPreset string `yaml:"header_preset"`
+
+	// ClaimsHeader, when set, carries all session claims as compact JSON.
+	ClaimsHeader        string `yaml:"claims_header"`
+	ClaimsHeaderBase64  bool   `yaml:"claims_header_base64"`
+	ClaimsHeaderMaxSize int    `yaml:"claims_header_max_size"`
+
+	SignHeaders *SignHeadersConfig `yaml:"sign_headers,omitempty"`
+	// HeaderEncryption encrypts the header mappings marked encrypt.
+	HeaderEncryption *HeaderEncryptionConfig `yaml:"header_encryption,omitempty"`
+
+	Buffering BufferingConfig `yaml:"buffering"`
+
+	// InjectQueryParams enables header mappings with a query_param, which
+	// are also injected into the proxied URL. Client-supplied values of
+	// those parameters are removed.
+	InjectQueryParams bool `yaml:"inject_query_params"`
+
+	// InjectClientIP sets X-Auth-Client-IP to the resolved client IP.
+	InjectClientIP bool `yaml:"inject_client_ip"`
+	// GeoIPDatabase is a MaxMind country database used to set
+	// X-Auth-Client-Country.
+	GeoIPDatabase string `yaml:"geoip_database"`
+
+	// PairwiseIDSalt, when set, sets X-Auth-Pairwise-ID to an HMAC of the
+	// user's subject keyed with this salt: a stable pseudonymous id that lets
+	// the backend correlate a user without learning the real subject.
+	PairwiseIDSalt string `yaml:"pairwise_id_salt"`
+
+	// InjectAuthContext sets X-Auth-Methods and X-Auth-Time from the OIDC
+	// amr/auth_time claims or the SAML AuthnStatement.
+	InjectAuthContext bool `yaml:"inject_auth_context"`
+
+	// ForwardHeaders, when set, is the allowlist of original request headers
+	// sent to the backend. Injected headers are always sent.
+	ForwardHeaders []string `yaml:"forward_headers"`
+	// PreserveAuthorization forwards the client's Authorization header
+	// untouched, even when it is not in ForwardHeaders. It is never
+	// forwarded for service clients, whose bearer token is a session.
+	PreserveAuthorization bool `yaml:"preserve_authorization"`
+
+	// StartupCheck is none, warn (log an unreachable backend) or require
+	// (retry, then refuse to start).
+	StartupCheck        string        `yaml:"startup_check"`
+	StartupCheckRetries int           `yaml:"startup_check_retries"`
+	StartupCheckBackoff time.Duration `yaml:"startup_check_backoff"`
+
+	// OnBackendUnauthorized handles 401/403 responses from the backend:
+	// passthrough, reauth (send the user to log in again, once) or branded
+	// (show the access denied page).
+	OnBackendUnauthorized string `yaml:"on_backend_unauthorized"`
+
+	// OnNoRoute handles sessions that match no routes_by_claim route:
+	// default_backend (proxy to url), 404 or branded_error (show a branded
+	// "no application" page).
+	OnNoRoute string `yaml:"on_no_route"`
+}
+
+// BufferingConfig controls how the reverse proxy streams responses.
+type BufferingConfig struct {
+	// FlushInterval is how often buffered response data is flushed to the
+	// client. Zero keeps the default, negative flushes after every write.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// BufferPool reuses copy buffers across requests.
+	BufferPool bool `yaml:"buffer_pool"`
+	// UnbufferedContentTypes are flushed after every write regardless of
+	// FlushInterval (prefixes ending in "/" match a whole type).
+	UnbufferedContentTypes []string `yaml:"unbuffered_content_types"`
+}
+
+// SignHeadersConfig adds a timestamp and an HMAC-SHA256 signature over the
+// injected identity headers, so the backend can detect tampering and replay.
+type SignHeadersConfig struct {
+	Key string `yaml:"key"`
+}
+
+// HeaderEncryptionConfig holds the AES-256-GCM key, base64 encoded, shared
+// with the backend to decrypt encrypted claim headers.
+type HeaderEncryptionConfig struct {
+	Key string `yaml:"key"`
+}
+
+// PathRewriteConfig replaces matches of Pattern in the request path with
+// Replacement, which may refer to submatches as $1 or ${name}.
+type PathRewriteConfig struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// ClaimRoutesConfig routes sessions to a backend chosen by the value of one
+// of their claims.
+type ClaimRoutesConfig struct {
+	Claim  string            `yaml:"claim"`
+	Routes map[string]string `yaml:"routes"`
 }
 
 type CacheConfig struct {
-	Type  string       `yaml:"type"`
-	Redis *RedisConfig `yaml:"redis,omitempty"`
+	Type          string       `yaml:"type"`
+	Serialization string       `yaml:"serialization"`
+	Redis         *RedisConfig `yaml:"redis,omitempty"`
+	Memory        MemoryConfig `yaml:"memory"`
+	// ReadinessInterval is how often /ready probes the cache in the
+	// background.
+	ReadinessInterval time.Duration `yaml:"readiness_interval"`
+	// OperationTimeout bounds every cache operation, whatever the cache
+	// type, including Redis retries.
+	OperationTimeout time.Duration `yaml:"operation_timeout"`
+}
+
+type MemoryConfig struct {
+	// SnapshotPath, when set, saves entries on shutdown and restores them on
+	// startup, so a graceful restart keeps sessions.
+	SnapshotPath string `yaml:"snapshot_path"`
 }
 
 type RedisConfig struct {
+	// URL is a redis:// or rediss:// connection URL. When set, it overrides
+	// address, password, db and TLS.
+	URL        string `yaml:"url"`
 	Address    string `yaml:"address"`
 	Password   string `yaml:"password"`
 	DB         int    `yaml:"db"`
 	PoolSize   int    `yaml:"pool_size"`
 	MaxRetries int    `yaml:"max_retries"`
+
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
 }
 
 type ProviderConfig struct {
-	ID             string            `yaml:"id"`
-	Name           string            `yaml:"name"`
-	Type           string            `yaml:"type"`
-	OIDC           *OIDCConfig       `yaml:"oidc,omitempty"`
-	SAML           *SAMLConfig       `yaml:"saml,omitempty"`
-	HeaderMappings map[string]string `yaml:"header_mappings"`
+	ID   string      `yaml:"id"`
+	Name string      `yaml:"name"`
+	Type string      `yaml:"type"`
+	OIDC *OIDCConfig `yaml:"oidc,omitempty"`
+	SAML *SAMLConfig `yaml:"saml,omitempty"`
+	Mock *MockConfig `yaml:"mock,omitempty"`
+	// Settings holds the options of provider types registered with
+	// provider.Register, which read them in their factory.
+	Settings       map[string]interface{}   `yaml:"settings,omitempty"`
+	HeaderMappings map[string]HeaderMapping `yaml:"header_mappings"`
+	ComputedClaims []ComputedClaim          `yaml:"computed_claims"`
+	// RequireEmailVerified rejects logins whose email_verified claim is false.
+	RequireEmailVerified bool `yaml:"require_email_verified"`
+	// Preset pre-populates claim aliases and header mappings for a known IdP:
+	// azuread, okta, google, keycloak or adfs.
+	Preset string `yaml:"preset"`
+	// ClaimAliases sets a claim from the first of the listed claims present,
+	// unless the claim is already set.
+	ClaimAliases map[string][]string `yaml:"claim_aliases"`
+	// IDPRequestHeaders are added to every request to the IdP: discovery,
+	// keys, token exchange and metadata, e.g. for a gateway API key.
+	IDPRequestHeaders map[string]string `yaml:"idp_request_headers,omitempty"`
+	// AcceptIf rejects logins whose claims do not satisfy an expression.
+	AcceptIf *AcceptRule `yaml:"accept_if,omitempty"`
+}
+
+// AcceptRule is a claim expression (see package claimexpr) that the claims
+// of a login, including computed claims, must satisfy. Message is shown to
+// rejected users.
+type AcceptRule struct {
+	Expression string `yaml:"expression"`
+	Message    string `yaml:"message"`
+}
+
+// HeaderMapping is the header a claim is injected as. In YAML it is either
+// the header name or a mapping with header, encrypt, single_value,
+// query_param, transform and required.
+type HeaderMapping struct {
+	Header string `yaml:"header"`
+	// Encrypt injects the claim encrypted with backend.header_encryption.
+	Encrypt bool `yaml:"encrypt"`
+	// SingleValue is how an array-valued claim is injected: first or last
+	// element, join (default) with commas, or error to reject the request.
+	SingleValue string `yaml:"single_value,omitempty"`
+	// QueryParam additionally injects the claim as this query parameter,
+	// for backends that cannot read headers. Requires
+	// backend.inject_query_params.
+	QueryParam string `yaml:"query_param,omitempty"`
+	// Transform normalizes the injected value with lower, upper, trim or
+	// title, applied in order.
+	Transform []string `yaml:"transform,omitempty"`
+	// Required rejects requests whose session lacks the claim, or has it
+	// empty, instead of proxying them without the header.
+	Required bool `yaml:"required,omitempty"`
+}
+
+func (m *HeaderMapping) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&m.Header)
+	}
+
+	type plain HeaderMapping
+	return value.Decode((*plain)(m))
+}
+
+// ComputedClaim derives a claim from the provider's claims with a Go template.
+// Claims are computed in order, so later templates can use earlier results.
+type ComputedClaim struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template"`
 }
 
 type OIDCConfig struct {
@@ -63,6 +381,184 @@ type OIDCConfig struct {
 	ClientSecret string   `yaml:"client_secret"`
 	Scopes       []string `yaml:"scopes"`
 	HD           string   `yaml:"hd,omitempty"`
+	// ClientAuthMethod is how the client authenticates at the token and
+	// revocation endpoints: client_secret (the default) or private_key_jwt,
+	// which signs an assertion with PrivateKeyPath instead of sending
+	// ClientSecret. PrivateKeyID is sent as the assertion's kid.
+	ClientAuthMethod string `yaml:"client_auth_method"`
+	PrivateKeyPath   string `yaml:"private_key_path"`
+	PrivateKeyID     string `yaml:"private_key_id"`
+	// RevokeOnLogout revokes the session's tokens at the IdP's RFC 7009
+	// revocation_endpoint on logout.
+	RevokeOnLogout bool `yaml:"revoke_on_logout"`
+	// RPInitiatedLogout redirects the browser to the IdP's
+	// end_session_endpoint on logout, to end the IdP session too. IdPs
+	// without the endpoint get a local logout only.
+	RPInitiatedLogout bool `yaml:"rp_initiated_logout"`
+	// AllowedAdditionalScopes lists the scopes that may be requested on top
+	// of Scopes through the additional_scopes parameter of /auth/select.
+	AllowedAdditionalScopes []string `yaml:"allowed_additional_scopes"`
+	// AllowedExtraParams lists the authorization request parameters, such
+	// as audience or resource, that may be set through the query string of
+	// /auth/oidc/{id}/login.
+	AllowedExtraParams []string `yaml:"allowed_extra_params"`
+	// ClaimsRequest is a JSON object sent as the claims authorization request
+	// parameter, to ask for specific or essential claims.
+	ClaimsRequest string `yaml:"claims_request"`
+	// MaxAge, in seconds, is sent as max_age to require an authentication
+	// at the IdP within that time; older auth_time claims are rejected.
+	// Zero omits it.
+	MaxAge int `yaml:"max_age"`
+	// Prompt is sent as the prompt parameter of interactive logins: a
+	// space-separated list of none, login, consent and select_account.
+	Prompt string `yaml:"prompt"`
+	// ValidateAccessToken verifies the access token as a JWT signed with the
+	// provider's keys, with an unexpired exp and AccessTokenAudience (the
+	// client_id by default) in aud, at login and refresh. Logins with an
+	// invalid access token are rejected. Only for IdPs that issue JWT access
+	// tokens.
+	ValidateAccessToken bool   `yaml:"validate_access_token"`
+	AccessTokenAudience string `yaml:"access_token_audience"`
+	// SkipTokenHashCheck disables the at_hash and c_hash checks of ID
+	// tokens, for IdPs that compute them incorrectly.
+	SkipTokenHashCheck bool `yaml:"skip_token_hash_check"`
+	// FetchUserInfo merges the claims from the UserInfo endpoint into the ID
+	// token claims at login. A failed fetch is logged and ignored, unless
+	// UserInfoRequired is set, which fails the login.
+	FetchUserInfo    bool `yaml:"fetch_userinfo"`
+	UserInfoRequired bool `yaml:"userinfo_required"`
+	// UserInfoRefreshInterval re-reads the claims of long-lived sessions
+	// from the UserInfo endpoint at most this often, so that changes at the
+	// IdP reach injected headers. Zero disables it.
+	UserInfoRefreshInterval time.Duration `yaml:"userinfo_refresh_interval"`
+	// SharedDiscovery is the id of an earlier OIDC provider whose signing
+	// keys this provider reuses instead of fetching its own, for tenants of
+	// one IdP that sign with the same keys. The provider still discovers its
+	// own endpoints, and tokens are still checked against Issuer and ClientID.
+	SharedDiscovery string `yaml:"shared_discovery"`
+}
+
+// MockConfig configures a mock provider, which logs users in with the claims
+// entered in a form. Claims prefill the form.
+type MockConfig struct {
+	Claims map[string]interface{} `yaml:"claims"`
+}
+
+// ProfileConfig overrides environment-specific fields when the profile is
+// selected. Empty fields leave the base config untouched.
+type ProfileConfig struct {
+	BaseURL   string                      `yaml:"base_url"`
+	Providers map[string]ProviderOverride `yaml:"providers"`
+}
+
+type ProviderOverride struct {
+	Issuer         string `yaml:"issuer"`
+	IDPMetadataURL string `yaml:"idp_metadata_url"`
+	SPEntityID     string `yaml:"sp_entity_id"`
+	ACSURL         string `yaml:"acs_url"`
+}
+
+// EventsConfig configures outbound webhooks for authentication events.
+type EventsConfig struct {
+	Webhooks     []WebhookConfig `yaml:"webhooks"`
+	QueueSize    int             `yaml:"queue_size"`
+	MaxRetries   int             `yaml:"max_retries"`
+	RetryBackoff time.Duration   `yaml:"retry_backoff"`
+}
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Secret signs each payload with HMAC-SHA256.
+	Secret string `yaml:"secret"`
+	// Events limits delivery to these event types; empty means all.
+	Events  []string      `yaml:"events"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type MetricsConfig struct {
+	// Exemplars attaches the trace id from an incoming W3C traceparent header
+	// to the proxy latency histogram, exposed in the OpenMetrics format.
+	Exemplars bool `yaml:"exemplars"`
+}
+
+// ServiceClientConfig is a machine client allowed to obtain a token through
+// the client credentials grant at /auth/token.
+type ServiceClientConfig struct {
+	ID       string        `yaml:"id"`
+	Secret   string        `yaml:"secret"`
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// OIDCDefaults holds settings shared by every OIDC provider.
+type OIDCDefaults struct {
+	// StrictScopes rejects providers whose scopes omit "openid" instead of
+	// prepending it. Defaults to true.
+	StrictScopes   *bool                `yaml:"strict_scopes"`
+	DefaultScopes  []string             `yaml:"default_scopes"`
+	JWKS           JWKSConfig           `yaml:"jwks"`
+	DiscoveryCache DiscoveryCacheConfig `yaml:"discovery_cache"`
+	// ClockSkew is the tolerance for clock drift from the IdP when checking
+	// the exp, nbf and iat claims of ID and access tokens. Zero keeps
+	// go-oidc's checks: a strict exp and 5 minutes of leeway on nbf.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+}
+
+// SAMLSharedACSPath is the ACS served under saml.shared_acs, which every SAML
+// provider's acs_url must then point at. It lives here rather than with the
+// other endpoint paths in package auth so that validation can check it.
+const SAMLSharedACSPath = "/auth/saml/acs"
+
+// SAMLDefaults holds settings shared by every SAML provider.
+type SAMLDefaults struct {
+	// SharedACS serves one ACS endpoint for all SAML providers and routes
+	// each response to the provider whose IdP entity ID matches its Issuer.
+	// Each provider's acs_url must point at SAMLSharedACSPath.
+	SharedACS bool `yaml:"shared_acs"`
+	// OnReplayCacheError handles assertions whose ID cannot be checked
+	// against or recorded in the cache of used assertions: reject (the
+	// default) fails the login, allow logs a warning and accepts it.
+	OnReplayCacheError string `yaml:"on_replay_cache_error"`
+	// ClockSkewTolerance is the leeway allowed on assertion NotBefore and
+	// NotOnOrAfter times for clock drift between the proxy and the IdPs. The
+	// SAML library applies a single tolerance to every provider. Zero allows
+	// no drift; unset defaults to 30s.
+	ClockSkewTolerance *time.Duration `yaml:"clock_skew_tolerance"`
+}
+
+// JWKSConfig controls how key fetch failures during token verification are
+// retried. After FailureThreshold consecutive failed fetches, verification
+// fails fast for NegativeCacheTTL instead of hitting the IdP again.
+type JWKSConfig struct {
+	// MaxRetries is how many times a failed key fetch is retried. Zero
+	// disables retries; unset defaults to 2.
+	MaxRetries       *int          `yaml:"max_retries"`
+	RetryBackoff     time.Duration `yaml:"retry_backoff"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl"`
+	// RefreshInterval re-fetches every provider's discovery document and
+	// signing keys in the background. Zero disables it.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// DiscoveryCacheConfig persists each provider's discovery document and
+// signing keys to the cache, so that a provider starts from the cached copy
+// when its IdP is unreachable at startup, and tokens are verified with the
+// cached keys while the jwks_uri is down. The copies are re-fetched every
+// RefreshInterval and kept for TTL.
+type DiscoveryCacheConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	TTL             time.Duration `yaml:"ttl"`
+}
+
+// AdminConfig protects the operational endpoints under /auth/admin, which
+// are only served when Secret is set.
+type AdminConfig struct {
+	Secret string `yaml:"secret"`
+	// SessionExportKey is a base64 encoded 32-byte AES key. It enables the
+	// session export and import endpoints, whose payloads it encrypts.
+	// Instances exchanging sessions must share it.
+	SessionExportKey string `yaml:"session_export_key"`
 }
 
 type SAMLConfig struct {
@@ -72,12 +568,28 @@ type SAMLConfig struct {
 	ACSURL          string `yaml:"acs_url"`
 	CertificatePath string `yaml:"certificate_path"`
 	PrivateKeyPath  string `yaml:"private_key_path"`
+	// MetadataValidDuration sets validUntil in the SP metadata. IdPs are asked
+	// to cache it for half as long.
+	MetadataValidDuration time.Duration `yaml:"metadata_valid_duration"`
+	// ExpectedDestination is the Destination SAML Responses must carry.
+	// Defaults to acs_url; set it when a front proxy changes the public URL.
+	ExpectedDestination string `yaml:"expected_destination"`
+	// NameIDFormat is the NameIDPolicy format of authentication requests and
+	// the NameIDFormat of the SP metadata, one of the SAML 2.0 name
+	// identifier format URIs. Empty requests transient.
+	NameIDFormat string `yaml:"nameid_format"`
 }
 
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
 	Output string `yaml:"output"`
+
+	// LogBodies logs proxied request and response bodies at debug level.
+	// Only textual content types are logged, truncated to BodyMaxSize bytes.
+	LogBodies        bool     `yaml:"log_bodies"`
+	BodyMaxSize      int      `yaml:"body_max_size"`
+	BodyContentTypes []string `yaml:"body_content_types"`
 }
 
 type UIConfig struct {
@@ -86,9 +598,22 @@ type UIConfig struct {
 	GradientStart string `yaml:"gradient_start"`
 	GradientEnd   string `yaml:"gradient_end"`
 	LogoPath      string `yaml:"logo_path"`
+	// RememberLastProvider skips the select page for returning users and
+	// sends them to the provider they chose last time.
+	RememberLastProvider bool `yaml:"remember_last_provider"`
+	// LogoutConfirmation shows a signed-out page after logout instead of
+	// redirecting straight to the select page.
+	LogoutConfirmation bool `yaml:"logout_confirmation"`
+	// SelectTemplate is an html/template file replacing the embedded select
+	// page. It is checked at startup; an invalid template fails validation
+	// unless TemplateFallback is set, which uses the embedded one instead.
+	SelectTemplate   string `yaml:"select_template"`
+	TemplateFallback bool   `yaml:"template_fallback"`
 }
 
-func Load(path string) (*Config, error) {
+// Load reads the config at path and, if profile is not empty, merges the
+// named profile over it before applying defaults.
+func Load(path string, profile string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
@@ -99,6 +624,12 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	if profile != "" {
+		if err := cfg.applyProfile(profile); err != nil {
+			return nil, fmt.Errorf("failed to apply profile: %w", err)
+		}
+	}
+
 	if err := cfg.setDefaults(); err != nil {
 		return nil, fmt.Errorf("failed to set defaults: %w", err)
 	}
@@ -110,6 +641,74 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+func (c *Config) applyProfile(name string) error {
+	profile, exists := c.Profiles[name]
+	if !exists {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+
+	if profile.BaseURL != "" {
+		c.Server.BaseURL = profile.BaseURL
+	}
+
+	for id, override := range profile.Providers {
+		provider := c.findProvider(id)
+		if provider == nil {
+			return fmt.Errorf("profile %s: unknown provider: %s", name, id)
+		}
+
+		if provider.OIDC != nil && override.Issuer != "" {
+			provider.OIDC.Issuer = override.Issuer
+		}
+
+		if provider.SAML != nil {
+			if override.IDPMetadataURL != "" {
+				provider.SAML.IDPMetadataURL = override.IDPMetadataURL
+			}
+			if override.SPEntityID != "" {
+				provider.SAML.SPEntityID = override.SPEntityID
+			}
+			if override.ACSURL != "" {
+				provider.SAML.ACSURL = override.ACSURL
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) findProvider(id string) *ProviderConfig {
+	for i := range c.Providers {
+		if c.Providers[i].ID == id {
+			return &c.Providers[i]
+		}
+	}
+	return nil
+}
+
+const DefaultCookieName = "sso-switch-session"
+
+// sharesParentCookieDomain reports whether the cookie domain covers hosts
+// other than the one in base_url.
+func (c *Config) sharesParentCookieDomain() bool {
+	domain := strings.TrimPrefix(strings.ToLower(c.Server.CookieDomain), ".")
+	if domain == "" {
+		return false
+	}
+
+	u, err := url.Parse(c.Server.BaseURL)
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(u.Hostname(), domain)
+}
+
+// Warnings returns non-fatal issues found while loading the config, such as
+// settings that were adjusted automatically.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
 func (c *Config) setDefaults() error {
 	if c.Server.Host == "" {
 		c.Server.Host = "0.0.0.0"
@@ -118,7 +717,14 @@ func (c *Config) setDefaults() error {
 		c.Server.Port = 8080
 	}
 	if c.Server.CookieName == "" {
-		c.Server.CookieName = "sso-switch-session"
+		c.Server.CookieName = DefaultCookieName
+		if c.Server.InstanceID == "" && c.sharesParentCookieDomain() {
+			c.warnings = append(c.warnings, fmt.Sprintf("server: cookie_domain %s is shared with other hosts and cookie_name is the default; set cookie_name or instance_id to avoid collisions with other instances", c.Server.CookieDomain))
+		}
+	}
+	if c.Server.InstanceID != "" {
+		sum := sha256.Sum256([]byte(c.Server.InstanceID))
+		c.Server.CookieName += "-" + hex.EncodeToString(sum[:4])
 	}
 	if c.Server.CookieHTTPOnly == false {
 		c.Server.CookieHTTPOnly = true
@@ -126,17 +732,87 @@ func (c *Config) setDefaults() error {
 	if c.Server.CookieSameSite == "" {
 		c.Server.CookieSameSite = "lax"
 	}
+	if c.Server.ErrorFormat == "" {
+		c.Server.ErrorFormat = "auto"
+	}
+	if c.Server.MaxLoginRedirects == 0 {
+		c.Server.MaxLoginRedirects = 10
+	}
+	if c.Server.LoginRedirectWindow == 0 {
+		c.Server.LoginRedirectWindow = time.Minute
+	}
+	if c.Server.UnauthenticatedResponse.Mode == "" {
+		c.Server.UnauthenticatedResponse.Mode = "redirect"
+	}
+	if c.Server.UnauthenticatedResponse.WWWAuthenticate == "" {
+		c.Server.UnauthenticatedResponse.WWWAuthenticate = `Bearer realm="sso-switch"`
+	}
+	if c.Server.UnauthenticatedResponse.ContentType == "" {
+		c.Server.UnauthenticatedResponse.ContentType = "text/plain; charset=utf-8"
+	}
+	if c.Server.Mode == "" {
+		c.Server.Mode = ModeProxy
+	}
+	if c.Server.PostLoginRedirect == "" && c.Server.Mode == ModeProxy {
+		c.Server.PostLoginRedirect = "/"
+	}
+	if c.Server.CSRFMode == "" {
+		c.Server.CSRFMode = "cache"
+	}
+	if c.Server.ConcurrentLoginPolicy == "" {
+		c.Server.ConcurrentLoginPolicy = "allow"
+	}
+	if c.Server.OrphanedSessionPolicy == "" {
+		c.Server.OrphanedSessionPolicy = "logout"
+	}
 	if c.Server.SessionTTL == 0 {
 		c.Server.SessionTTL = 24 * time.Hour
 	}
+	if c.Server.RefreshRetries == nil {
+		defaultRetries := 2
+		c.Server.RefreshRetries = &defaultRetries
+	}
+	if c.Server.RefreshRetryBackoff == 0 {
+		c.Server.RefreshRetryBackoff = 200 * time.Millisecond
+	}
 
 	if c.Backend.Timeout == 0 {
 		c.Backend.Timeout = 30 * time.Second
 	}
+	if c.Backend.ClaimsHeaderMaxSize == 0 {
+		c.Backend.ClaimsHeaderMaxSize = 8192
+	}
+	if c.Backend.StartupCheck == "" {
+		c.Backend.StartupCheck = "none"
+	}
+	if c.Backend.StartupCheckRetries == 0 {
+		c.Backend.StartupCheckRetries = 5
+	}
+	if c.Backend.StartupCheckBackoff == 0 {
+		c.Backend.StartupCheckBackoff = time.Second
+	}
+	if c.Backend.OnBackendUnauthorized == "" {
+		c.Backend.OnBackendUnauthorized = "passthrough"
+	}
+	if c.Backend.OnNoRoute == "" {
+		c.Backend.OnNoRoute = "default_backend"
+	}
+	if c.Backend.Protocol == "" {
+		c.Backend.Protocol = "http"
+	}
 
 	if c.Cache.Type == "" {
 		c.Cache.Type = "memory"
 	}
+	if c.Cache.Serialization == "" {
+		c.Cache.Serialization = "json"
+	}
+	if c.Cache.ReadinessInterval == 0 {
+		c.Cache.ReadinessInterval = 5 * time.Second
+	}
+	if c.Cache.OperationTimeout == 0 {
+		c.Cache.OperationTimeout = 10 * time.Second
+	}
 
 	if c.Cache.Type == "redis" && c.Cache.Redis != nil {
 		if c.Cache.Redis.PoolSize == 0 {
@@ -145,6 +821,15 @@ func (c *Config) setDefaults() error {
 		if c.Cache.Redis.MaxRetries == 0 {
 			c.Cache.Redis.MaxRetries = 3
 		}
+		if c.Cache.Redis.DialTimeout == 0 {
+			c.Cache.Redis.DialTimeout = 5 * time.Second
+		}
+		if c.Cache.Redis.ReadTimeout == 0 {
+			c.Cache.Redis.ReadTimeout = 3 * time.Second
+		}
+		if c.Cache.Redis.WriteTimeout == 0 {
+			c.Cache.Redis.WriteTimeout = 3 * time.Second
+		}
 	}
 
 	if c.Logging.Level == "" {
@@ -156,6 +841,17 @@ func (c *Config) setDefaults() error {
 	if c.Logging.Output == "" {
 		c.Logging.Output = "stdout"
 	}
+	if c.Logging.BodyMaxSize == 0 {
+		c.Logging.BodyMaxSize = 4096
+	}
+	if len(c.Logging.BodyContentTypes) == 0 {
+		c.Logging.BodyContentTypes = []string{
+			"text/",
+			"application/json",
+			"application/xml",
+			"application/x-www-form-urlencoded",
+		}
+	}
 
 	if c.UI.Enable == nil {
 		defaultEnable := true
@@ -171,9 +867,93 @@ func (c *Config) setDefaults() error {
 		c.UI.GradientEnd = "#127a87"
 	}
 
+	for i := range c.ServiceClients {
+		if c.ServiceClients[i].TokenTTL == 0 {
+			c.ServiceClients[i].TokenTTL = 15 * time.Minute
+		}
+	}
+
+	if c.OIDC.StrictScopes == nil {
+		defaultStrict := true
+		c.OIDC.StrictScopes = &defaultStrict
+	}
+
+	if c.Events.QueueSize == 0 {
+		c.Events.QueueSize = 100
+	}
+	if c.Events.MaxRetries == 0 {
+		c.Events.MaxRetries = 3
+	}
+	if c.Events.RetryBackoff == 0 {
+		c.Events.RetryBackoff = time.Second
+	}
+	for i := range c.Events.Webhooks {
+		if c.Events.Webhooks[i].Timeout == 0 {
+			c.Events.Webhooks[i].Timeout = 5 * time.Second
+		}
+	}
+
+	if c.OIDC.JWKS.MaxRetries == nil {
+		defaultRetries := 2
+		c.OIDC.JWKS.MaxRetries = &defaultRetries
+	}
+	if c.OIDC.JWKS.RetryBackoff == 0 {
+		c.OIDC.JWKS.RetryBackoff = 200 * time.Millisecond
+	}
+	if c.OIDC.JWKS.FailureThreshold == 0 {
+		c.OIDC.JWKS.FailureThreshold = 5
+	}
+	if c.OIDC.JWKS.NegativeCacheTTL == 0 {
+		c.OIDC.JWKS.NegativeCacheTTL = 30 * time.Second
+	}
+	if c.OIDC.DiscoveryCache.RefreshInterval == 0 {
+		c.OIDC.DiscoveryCache.RefreshInterval = time.Hour
+	}
+	if c.OIDC.DiscoveryCache.TTL == 0 {
+		c.OIDC.DiscoveryCache.TTL = 7 * 24 * time.Hour
+	}
+	if c.SAML.OnReplayCacheError == "" {
+		c.SAML.OnReplayCacheError = "reject"
+	}
+	if c.SAML.ClockSkewTolerance == nil {
+		defaultSkew := 30 * time.Second
+		c.SAML.ClockSkewTolerance = &defaultSkew
+	}
+
+	for i := range c.Providers {
+		provider := &c.Providers[i]
+		provider.applyPreset()
+
+		if provider.SAML != nil && provider.SAML.MetadataValidDuration == 0 {
+			provider.SAML.MetadataValidDuration = 48 * time.Hour
+		}
+
+		if provider.OIDC == nil {
+			continue
+		}
+
+		if len(provider.OIDC.Scopes) == 0 && len(c.OIDC.DefaultScopes) > 0 {
+			provider.OIDC.Scopes = append([]string(nil), c.OIDC.DefaultScopes...)
+		}
+
+		if !*c.OIDC.StrictScopes && !hasScope(provider.OIDC.Scopes, "openid") {
+			provider.OIDC.Scopes = append([]string{"openid"}, provider.OIDC.Scopes...)
+			c.warnings = append(c.warnings, fmt.Sprintf("provider %s: 'openid' scope was missing and has been added", provider.ID))
+		}
+	}
+
 	return nil
 }
 
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) loadSecretsFromEnv() error {
 	for i := range c.Providers {
 		provider := &c.Providers[i]
@@ -188,10 +968,49 @@ func (c *Config) loadSecretsFromEnv() error {
 		}
 	}
 
+	for i := range c.ServiceClients {
+		client := &c.ServiceClients[i]
+		if envSecret := os.Getenv(fmt.Sprintf("%s_SERVICE_SECRET", client.ID)); envSecret != "" {
+			client.Secret = envSecret
+		}
+	}
+
+	// WEBHOOK_SECRET only fills webhooks without a secret of their own, so
+	// that a webhook can keep a secret agreed with its receiver.
+	if envSecret := os.Getenv("WEBHOOK_SECRET"); envSecret != "" {
+		for i := range c.Events.Webhooks {
+			if c.Events.Webhooks[i].Secret == "" {
+				c.Events.Webhooks[i].Secret = envSecret
+			}
+		}
+	}
+
+	if envSecret := os.Getenv("ADMIN_SECRET"); envSecret != "" {
+		c.Admin.Secret = envSecret
+	}
+	if envKey := os.Getenv("ADMIN_SESSION_EXPORT_KEY"); envKey != "" {
+		c.Admin.SessionExportKey = envKey
+	}
+
+	if c.Backend.HeaderEncryption != nil {
+		if envKey := os.Getenv("HEADER_ENCRYPTION_KEY"); envKey != "" {
+			c.Backend.HeaderEncryption.Key = envKey
+		}
+	}
+
+	if c.Backend.SignHeaders != nil {
+		if envKey := os.Getenv("SIGN_HEADERS_KEY"); envKey != "" {
+			c.Backend.SignHeaders.Key = envKey
+		}
+	}
+
 	if c.Cache.Type == "redis" && c.Cache.Redis != nil {
 		if envPassword := os.Getenv("REDIS_PASSWORD"); envPassword != "" {
 			c.Cache.Redis.Password = envPassword
 		}
+		if envURL := os.Getenv("REDIS_URL"); envURL != "" {
+			c.Cache.Redis.URL = envURL
+		}
 	}
 
 	return nil
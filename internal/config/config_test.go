@@ -0,0 +1,512 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSections are the top-level sections of a minimal valid config. Tests
+// replace whole sections and add new ones through loadTestConfig.
+var testSections = []struct {
+	name string
+	yaml string
+}{
+	{"server", `
+  base_url: https://sso.example.com
+`},
+	{"backend", `
+  url: http://backend:8080
+`},
+	{"providers", `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email: X-User-Email
+`},
+}
+
+// loadTestConfig loads the minimal config with the given sections replaced
+// or added, and validates it.
+func loadTestConfig(t *testing.T, sections map[string]string) (*Config, error) {
+	t.Helper()
+
+	var sb strings.Builder
+	for _, section := range testSections {
+		body, ok := sections[section.name]
+		if !ok {
+			body = section.yaml
+		}
+		sb.WriteString(section.name + ":" + body + "\n")
+	}
+	for name, body := range sections {
+		if !isTestSection(name) {
+			sb.WriteString(name + ":" + body + "\n")
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := Load(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func isTestSection(name string) bool {
+	for _, section := range testSections {
+		if section.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkError fails the test unless err matches wantErr, a substring of the
+// expected message, or is nil when wantErr is empty.
+func checkError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+
+	switch {
+	case wantErr == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Fatalf("expected error containing %q", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Fatalf("error %q does not contain %q", err, wantErr)
+	}
+}
+
+func TestJWKSMaxRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		oidc    string
+		want    int
+		wantErr string
+	}{
+		{name: "default", oidc: `
+  jwks: {}
+`, want: 2},
+		{name: "disabled", oidc: `
+  jwks:
+    max_retries: 0
+`, want: 0},
+		{name: "custom", oidc: `
+  jwks:
+    max_retries: 5
+`, want: 5},
+		{name: "negative", oidc: `
+  jwks:
+    max_retries: -1
+`, wantErr: "max_retries must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"oidc": tt.oidc})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := *cfg.OIDC.JWKS.MaxRetries; got != tt.want {
+				t.Errorf("max_retries = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSAMLClockSkewTolerance(t *testing.T) {
+	tests := []struct {
+		name    string
+		saml    string
+		want    time.Duration
+		wantErr string
+	}{
+		{name: "default", saml: ` {}`, want: 30 * time.Second},
+		{name: "custom", saml: `
+  clock_skew_tolerance: 2m
+`, want: 2 * time.Minute},
+		{name: "disabled", saml: `
+  clock_skew_tolerance: 0s
+`, want: 0},
+		{name: "negative", saml: `
+  clock_skew_tolerance: -1s
+`, wantErr: "saml clock_skew_tolerance must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"saml": tt.saml})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := *cfg.SAML.ClockSkewTolerance; got != tt.want {
+				t.Errorf("clock_skew_tolerance = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookSecretFromEnv(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		want   string
+	}{
+		{name: "unset", want: "env-secret"},
+		{name: "configured", secret: `
+      secret: own-secret`, want: "own-secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WEBHOOK_SECRET", "env-secret")
+
+			cfg, err := loadTestConfig(t, map[string]string{"events": `
+  webhooks:
+    - url: https://audit.example.com/sso` + tt.secret})
+			checkError(t, err, "")
+			if got := cfg.Events.Webhooks[0].Secret; got != tt.want {
+				t.Errorf("secret = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		oidc        string
+		scopes      string
+		want        []string
+		wantWarning bool
+		wantErr     string
+	}{
+		{name: "explicit scopes", scopes: "[openid, email]", want: []string{"openid", "email"}},
+		{name: "strict without openid", scopes: "[email]", wantErr: "provider corp: 'openid' scope is required"},
+		{name: "lenient without openid", oidc: `
+  strict_scopes: false
+`, scopes: "[email]", want: []string{"openid", "email"}, wantWarning: true},
+		{name: "default scopes", oidc: `
+  default_scopes: [openid, profile]
+`, scopes: "[]", want: []string{"openid", "profile"}},
+		{name: "explicit scopes over default scopes", oidc: `
+  default_scopes: [openid, profile]
+`, scopes: "[openid, email]", want: []string{"openid", "email"}},
+		{name: "lenient default scopes without openid", oidc: `
+  strict_scopes: false
+  default_scopes: [profile]
+`, scopes: "[]", want: []string{"openid", "profile"}, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections := map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: ` + tt.scopes + `
+    header_mappings:
+      email: X-User-Email
+`}
+			if tt.oidc != "" {
+				sections["oidc"] = tt.oidc
+			}
+
+			cfg, err := loadTestConfig(t, sections)
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+
+			if got := cfg.Providers[0].OIDC.Scopes; !slices.Equal(got, tt.want) {
+				t.Errorf("scopes = %v, want %v", got, tt.want)
+			}
+			if got := len(cfg.Warnings()) > 0; got != tt.wantWarning {
+				t.Errorf("warnings = %v, want a warning: %v", cfg.Warnings(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCookieNameCollision(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      string
+		want        string
+		wantWarning bool
+	}{
+		{name: "default", want: DefaultCookieName},
+		{name: "cookie domain of base_url host", server: `
+  cookie_domain: sso.example.com
+`, want: DefaultCookieName},
+		{name: "shared parent domain", server: `
+  cookie_domain: .example.com
+`, want: DefaultCookieName, wantWarning: true},
+		{name: "shared parent domain with cookie_name", server: `
+  cookie_domain: .example.com
+  cookie_name: app-session
+`, want: "app-session"},
+		{name: "shared parent domain with instance_id", server: `
+  cookie_domain: .example.com
+  instance_id: blue
+`, want: DefaultCookieName + "-16477688"},
+		{name: "instance_id suffixes cookie_name", server: `
+  cookie_name: app-session
+  instance_id: blue
+`, want: "app-session-16477688"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": `
+  base_url: https://sso.example.com` + tt.server})
+			checkError(t, err, "")
+
+			if cfg.Server.CookieName != tt.want {
+				t.Errorf("cookie_name = %q, want %q", cfg.Server.CookieName, tt.want)
+			}
+			if got := len(cfg.Warnings()) > 0; got != tt.wantWarning {
+				t.Errorf("warnings = %v, want a warning: %v", cfg.Warnings(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCacheTimeouts(t *testing.T) {
+	tests := []struct {
+		name          string
+		cache         string
+		wantOperation time.Duration
+		wantRead      time.Duration
+		wantErr       string
+	}{
+		{name: "defaults", cache: `
+  type: redis
+  redis:
+    address: localhost:6379
+`, wantOperation: 10 * time.Second, wantRead: 3 * time.Second},
+		{name: "custom", cache: `
+  type: redis
+  operation_timeout: 2s
+  redis:
+    address: localhost:6379
+    read_timeout: 500ms
+`, wantOperation: 2 * time.Second, wantRead: 500 * time.Millisecond},
+		{name: "negative operation timeout", cache: `
+  type: memory
+  operation_timeout: -1s
+`, wantErr: "operation_timeout must not be negative"},
+		{name: "negative redis timeout", cache: `
+  type: redis
+  redis:
+    address: localhost:6379
+    write_timeout: -1s
+`, wantErr: "redis dial_timeout, read_timeout and write_timeout must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"cache": tt.cache})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if cfg.Cache.OperationTimeout != tt.wantOperation {
+				t.Errorf("operation_timeout = %s, want %s", cfg.Cache.OperationTimeout, tt.wantOperation)
+			}
+			if cfg.Cache.Redis.ReadTimeout != tt.wantRead {
+				t.Errorf("read_timeout = %s, want %s", cfg.Cache.Redis.ReadTimeout, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestMaxTokenTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		want    time.Duration
+		wantErr string
+	}{
+		{name: "default", server: `
+  base_url: https://sso.example.com
+`},
+		{name: "custom", server: `
+  base_url: https://sso.example.com
+  max_token_ttl: 12h
+`, want: 12 * time.Hour},
+		{name: "negative", server: `
+  base_url: https://sso.example.com
+  max_token_ttl: -1h
+`, wantErr: "max_token_ttl must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": tt.server})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := cfg.Server.MaxTokenTTL; got != tt.want {
+				t.Errorf("max_token_ttl = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		want    int
+		wantErr string
+	}{
+		{name: "default", server: `
+  base_url: https://sso.example.com
+`, want: 2},
+		{name: "disabled", server: `
+  base_url: https://sso.example.com
+  refresh_retries: 0
+`, want: 0},
+		{name: "custom", server: `
+  base_url: https://sso.example.com
+  refresh_retries: 5
+`, want: 5},
+		{name: "negative", server: `
+  base_url: https://sso.example.com
+  refresh_retries: -1
+`, wantErr: "refresh_retries and refresh_retry_backoff must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": tt.server})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := *cfg.Server.RefreshRetries; got != tt.want {
+				t.Errorf("refresh_retries = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxSessionLifetime(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		wantErr string
+	}{
+		{name: "unset", server: `
+  base_url: https://sso.example.com
+`},
+		{name: "longer than session_ttl", server: `
+  base_url: https://sso.example.com
+  session_ttl: 8h
+  max_session_lifetime: 24h
+`},
+		{name: "equal to session_ttl", server: `
+  base_url: https://sso.example.com
+  session_ttl: 8h
+  max_session_lifetime: 8h
+`},
+		{name: "shorter than session_ttl", server: `
+  base_url: https://sso.example.com
+  session_ttl: 8h
+  max_session_lifetime: 1h
+`, wantErr: "max_session_lifetime must be at least session_ttl (8h0m0s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"server": tt.server})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	const yaml = `
+server:
+  base_url: https://sso.example.com
+backend:
+  url: http://backend:8080
+providers:
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+profiles:
+  staging:
+    base_url: https://sso.staging.example.com
+    providers:
+      corp:
+        issuer: https://idp.staging.example.com
+  partial:
+    base_url: https://sso.partial.example.com
+  broken:
+    providers:
+      missing:
+        issuer: https://idp.example.com
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		profile     string
+		wantBaseURL string
+		wantIssuer  string
+		wantErr     string
+	}{
+		{name: "no profile", wantBaseURL: "https://sso.example.com", wantIssuer: "https://idp.example.com"},
+		{name: "staging", profile: "staging", wantBaseURL: "https://sso.staging.example.com", wantIssuer: "https://idp.staging.example.com"},
+		{name: "empty fields keep base", profile: "partial", wantBaseURL: "https://sso.partial.example.com", wantIssuer: "https://idp.example.com"},
+		{name: "unknown profile", profile: "prod", wantErr: "unknown profile: prod"},
+		{name: "unknown provider", profile: "broken", wantErr: "unknown provider: missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(path, tt.profile)
+			checkError(t, err, tt.wantErr)
+			if err != nil {
+				return
+			}
+
+			if cfg.Server.BaseURL != tt.wantBaseURL {
+				t.Errorf("base_url = %q, want %q", cfg.Server.BaseURL, tt.wantBaseURL)
+			}
+			if got := cfg.Providers[0].OIDC.Issuer; got != tt.wantIssuer {
+				t.Errorf("issuer = %q, want %q", got, tt.wantIssuer)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+// providerPreset holds the claim aliases and header mappings of a well-known
+// IdP. Aliases map a normalized claim to the IdP claims it is read from, in
+// order of preference. acceptIf, when set, is the accept_if rule of providers
+// without one.
+type providerPreset struct {
+	claimAliases   map[string][]string
+	headerMappings map[string]HeaderMapping
+	acceptIf       *AcceptRule
+}
+
+var presetHeaderMappings = map[string]HeaderMapping{
+	"sub":      {Header: "X-User-ID"},
+	"email":    {Header: "X-User-Email"},
+	"name":     {Header: "X-User-Name"},
+	"username": {Header: "X-User-Username"},
+	"groups":   {Header: "X-User-Groups"},
+}
+
+var providerPresets = map[string]providerPreset{
+	"azuread": {
+		// preferred_username and upn are not verified addresses, and the
+		// email claim can be set by any tenant admin: it is only trusted when
+		// Entra ID reports the domain owner verified it (xms_edov).
+		claimAliases: map[string][]string{
+			"email":    {"email"},
+			"username": {"preferred_username", "upn"},
+			"groups":   {"groups", "roles"},
+		},
+		headerMappings: presetHeaderMappings,
+		acceptIf: &AcceptRule{
+			Expression: `claims.email == null || claims.xms_edov == true`,
+			Message:    "Your email address is not from a verified domain.",
+		},
+	},
+	"okta": {
+		claimAliases: map[string][]string{
+			"email":    {"email", "login"},
+			"username": {"preferred_username", "login"},
+			"groups":   {"groups"},
+		},
+		headerMappings: presetHeaderMappings,
+	},
+	"google": {
+		claimAliases: map[string][]string{
+			"username": {"email"},
+			"domain":   {"hd"},
+		},
+		headerMappings: presetHeaderMappings,
+	},
+	"keycloak": {
+		claimAliases: map[string][]string{
+			"username": {"preferred_username"},
+			"groups":   {"groups", "roles"},
+		},
+		headerMappings: presetHeaderMappings,
+	},
+	"adfs": {
+		claimAliases: map[string][]string{
+			"sub": {"sub", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/nameidentifier", "name_id"},
+			"email": {
+				"email",
+				"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+				"upn",
+				"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/upn",
+			},
+			"name": {"name", "unique_name", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name"},
+			"username": {
+				"upn",
+				"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/upn",
+				"unique_name",
+			},
+			"groups": {
+				"group",
+				"http://schemas.xmlsoap.org/claims/Group",
+				"role",
+				"http://schemas.microsoft.com/ws/2008/06/identity/claims/role",
+			},
+		},
+		headerMappings: presetHeaderMappings,
+	},
+}
+
+// applyPreset merges the provider's preset under its explicit claim aliases,
+// header mappings and accept_if rule, so that explicit config wins.
+func (p *ProviderConfig) applyPreset() {
+	preset, ok := providerPresets[p.Preset]
+	if !ok {
+		return
+	}
+
+	if p.ClaimAliases == nil {
+		p.ClaimAliases = make(map[string][]string)
+	}
+	for claim, sources := range preset.claimAliases {
+		if _, exists := p.ClaimAliases[claim]; !exists {
+			p.ClaimAliases[claim] = sources
+		}
+	}
+
+	if p.HeaderMappings == nil {
+		p.HeaderMappings = make(map[string]HeaderMapping)
+	}
+	for claim, mapping := range preset.headerMappings {
+		if _, exists := p.HeaderMappings[claim]; !exists {
+			p.HeaderMappings[claim] = mapping
+		}
+	}
+
+	if p.AcceptIf == nil && preset.acceptIf != nil {
+		rule := *preset.acceptIf
+		p.AcceptIf = &rule
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/claimexpr"
+)
+
+func TestAzureADPreset(t *testing.T) {
+	tests := []struct {
+		name       string
+		acceptIf   string
+		claims     map[string]interface{}
+		wantAccept bool
+	}{
+		{
+			name:       "verified email domain",
+			claims:     map[string]interface{}{"email": "alice@corp.com", "xms_edov": true},
+			wantAccept: true,
+		},
+		{
+			name:   "unverified email domain",
+			claims: map[string]interface{}{"email": "alice@corp.com", "xms_edov": false},
+		},
+		{
+			name:   "email without xms_edov",
+			claims: map[string]interface{}{"email": "alice@corp.com"},
+		},
+		{
+			name:       "no email",
+			claims:     map[string]interface{}{"preferred_username": "alice@corp.com"},
+			wantAccept: true,
+		},
+		{
+			name: "explicit accept_if",
+			acceptIf: `
+    accept_if:
+      expression: 'claims.email endsWith "@corp.com"'`,
+			claims:     map[string]interface{}{"email": "alice@corp.com"},
+			wantAccept: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    preset: azuread
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]` + tt.acceptIf + `
+`})
+			checkError(t, err, "")
+
+			provider := cfg.Providers[0]
+			if got := provider.ClaimAliases["email"]; !slices.Equal(got, []string{"email"}) {
+				t.Errorf("email aliases = %v, want [email]", got)
+			}
+
+			expr, err := claimexpr.Compile(provider.AcceptIf.Expression)
+			if err != nil {
+				t.Fatalf("compile accept_if: %v", err)
+			}
+			accepted, err := expr.Eval(tt.claims)
+			if err != nil {
+				t.Fatalf("eval accept_if: %v", err)
+			}
+			if accepted != tt.wantAccept {
+				t.Errorf("accepted = %v, want %v", accepted, tt.wantAccept)
+			}
+		})
+	}
+}
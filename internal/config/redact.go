@@ -0,0 +1,106 @@
+package config
+
+import (
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secret values in the effective config dump.
+const redacted = "REDACTED"
+
+// RedactedYAML returns the effective config as YAML with client secrets,
+// passwords, IdP request header values and key material replaced. Profiles are left out, as the selected
+// one has already been applied.
+func (c *Config) RedactedYAML() ([]byte, error) {
+	return yaml.Marshal(c.redacted())
+}
+
+// redacted returns a copy of the config with secrets replaced, leaving c
+// untouched.
+func (c *Config) redacted() *Config {
+	r := *c
+	r.Profiles = nil
+
+	r.Providers = make([]ProviderConfig, len(c.Providers))
+	for i, provider := range c.Providers {
+		if provider.OIDC != nil {
+			oidc := *provider.OIDC
+			oidc.ClientSecret = redact(oidc.ClientSecret)
+			provider.OIDC = &oidc
+		}
+		if len(provider.IDPRequestHeaders) > 0 {
+			headers := make(map[string]string, len(provider.IDPRequestHeaders))
+			for name, value := range provider.IDPRequestHeaders {
+				headers[name] = redact(value)
+			}
+			provider.IDPRequestHeaders = headers
+		}
+		if len(provider.Settings) > 0 {
+			// The options of registered provider types are opaque here, so
+			// they are all treated as secrets.
+			settings := make(map[string]interface{}, len(provider.Settings))
+			for name := range provider.Settings {
+				settings[name] = redacted
+			}
+			provider.Settings = settings
+		}
+		r.Providers[i] = provider
+	}
+
+	r.ServiceClients = make([]ServiceClientConfig, len(c.ServiceClients))
+	for i, client := range c.ServiceClients {
+		client.Secret = redact(client.Secret)
+		r.ServiceClients[i] = client
+	}
+
+	r.Events.Webhooks = make([]WebhookConfig, len(c.Events.Webhooks))
+	for i, webhook := range c.Events.Webhooks {
+		webhook.Secret = redact(webhook.Secret)
+		r.Events.Webhooks[i] = webhook
+	}
+
+	r.Admin.Secret = redact(c.Admin.Secret)
+	r.Admin.SessionExportKey = redact(c.Admin.SessionExportKey)
+
+	r.Backend.PairwiseIDSalt = redact(c.Backend.PairwiseIDSalt)
+	if c.Backend.SignHeaders != nil {
+		r.Backend.SignHeaders = &SignHeadersConfig{Key: redact(c.Backend.SignHeaders.Key)}
+	}
+	if c.Backend.HeaderEncryption != nil {
+		r.Backend.HeaderEncryption = &HeaderEncryptionConfig{Key: redact(c.Backend.HeaderEncryption.Key)}
+	}
+
+	if c.Cache.Redis != nil {
+		redis := *c.Cache.Redis
+		redis.Password = redact(redis.Password)
+		redis.URL = redactURLPassword(redis.URL)
+		r.Cache.Redis = &redis
+	}
+
+	return &r
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURLPassword replaces the password of a connection URL. Unparseable
+// URLs are redacted entirely, as they may still contain one.
+func redactURLPassword(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
@@ -1,19 +1,45 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"maps"
+	"net"
+	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/claimexpr"
 )
 
 func (c *Config) Validate() error {
+	if err := c.validateEnvironment(); err != nil {
+		return fmt.Errorf("server config: environment %s: %w", c.Server.Environment, err)
+	}
+
+	if err := c.validateDevMode(); err != nil {
+		return fmt.Errorf("dev_mode: %w", err)
+	}
+
 	if err := c.validateServer(); err != nil {
 		return fmt.Errorf("server config: %w", err)
 	}
 
-	if err := c.validateBackend(); err != nil {
-		return fmt.Errorf("backend config: %w", err)
+	if c.Server.Mode == ModeProxy {
+		if err := c.validateBackend(); err != nil {
+			return fmt.Errorf("backend config: %w", err)
+		}
+	}
+
+	if err := c.validateUI(); err != nil {
+		return fmt.Errorf("ui config: %w", err)
 	}
 
 	if err := c.validateCache(); err != nil {
@@ -24,10 +50,59 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("providers config: %w", err)
 	}
 
+	if err := c.validateJWKS(); err != nil {
+		return fmt.Errorf("oidc jwks config: %w", err)
+	}
+
+	if c.OIDC.ClockSkew < 0 {
+		return fmt.Errorf("oidc clock_skew must not be negative")
+	}
+
+	if dc := c.OIDC.DiscoveryCache; dc.RefreshInterval < 0 || dc.TTL < 0 {
+		return fmt.Errorf("oidc discovery_cache config: refresh_interval and ttl must not be negative")
+	}
+
+	switch c.SAML.OnReplayCacheError {
+	case "reject", "allow":
+	default:
+		return fmt.Errorf("invalid saml on_replay_cache_error: %s (must be reject or allow)", c.SAML.OnReplayCacheError)
+	}
+
+	if c.SAML.ClockSkewTolerance != nil && *c.SAML.ClockSkewTolerance < 0 {
+		return fmt.Errorf("saml clock_skew_tolerance must not be negative")
+	}
+
+	if err := c.validateEvents(); err != nil {
+		return fmt.Errorf("events config: %w", err)
+	}
+
+	if err := c.validateServiceClients(); err != nil {
+		return fmt.Errorf("service clients config: %w", err)
+	}
+
 	if err := c.validateLogging(); err != nil {
 		return fmt.Errorf("logging config: %w", err)
 	}
 
+	for context, level := range c.AssuranceLevels {
+		if level < 1 {
+			return fmt.Errorf("assurance_levels: %s: level must be at least 1", context)
+		}
+	}
+
+	if c.Admin.Secret != "" && len(c.Admin.Secret) < 16 {
+		return fmt.Errorf("admin config: secret must be at least 16 characters")
+	}
+	if c.Admin.SessionExportKey != "" {
+		if c.Admin.Secret == "" {
+			return fmt.Errorf("admin config: session_export_key requires secret")
+		}
+		key, err := base64.StdEncoding.DecodeString(c.Admin.SessionExportKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("admin config: session_export_key must be 32 bytes, base64 encoded")
+		}
+	}
+
 	return nil
 }
 
@@ -49,10 +124,123 @@ func (c *Config) validateServer() error {
 		return fmt.Errorf("invalid cookie_same_site: %s (must be lax, strict, or none)", c.Server.CookieSameSite)
 	}
 
+	if c.Server.SameSiteCompat && sameSite != "none" {
+		c.warnings = append(c.warnings, "server: samesite_compat only affects cookie_same_site none and has no effect")
+	}
+
+	switch strings.ToLower(c.Server.CookiePriority) {
+	case "", "low", "medium", "high":
+	default:
+		return fmt.Errorf("invalid cookie_priority: %s (must be low, medium, or high)", c.Server.CookiePriority)
+	}
+
+	switch c.Server.ConcurrentLoginPolicy {
+	case "allow", "header", "notify":
+	default:
+		return fmt.Errorf("invalid concurrent_login_policy: %s (must be allow, header, or notify)", c.Server.ConcurrentLoginPolicy)
+	}
+
+	for _, domain := range c.Server.AllowedRedirectDomains {
+		if domain == "" || domain == "." || strings.ContainsAny(domain, "/:?#@*") {
+			return fmt.Errorf("invalid allowed_redirect_domains entry: %q (must be a host name, optionally with a leading dot)", domain)
+		}
+	}
+
+	switch c.Server.OrphanedSessionPolicy {
+	case "logout", "keep":
+	default:
+		return fmt.Errorf("invalid orphaned_session_policy: %s (must be logout or keep)", c.Server.OrphanedSessionPolicy)
+	}
+
 	if c.Server.SessionTTL < time.Minute {
 		return fmt.Errorf("session_ttl must be at least 1 minute")
 	}
 
+	if c.Server.MaxTokenTTL < 0 {
+		return fmt.Errorf("max_token_ttl must not be negative")
+	}
+
+	if c.Server.MaxSessionLifetime != 0 && c.Server.MaxSessionLifetime < c.Server.SessionTTL {
+		return fmt.Errorf("max_session_lifetime must be at least session_ttl (%s)", c.Server.SessionTTL)
+	}
+
+	if (c.Server.RefreshRetries != nil && *c.Server.RefreshRetries < 0) || c.Server.RefreshRetryBackoff < 0 {
+		return fmt.Errorf("refresh_retries and refresh_retry_backoff must not be negative")
+	}
+
+	if c.Server.ElevationWindow < 0 {
+		return fmt.Errorf("elevation_window must not be negative")
+	}
+
+	for _, claim := range c.Server.SessionClaims {
+		if claim == "" {
+			return fmt.Errorf("session_claims must not contain empty claim names")
+		}
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("invalid trusted_proxies entry: %s", proxy)
+			}
+		} else if net.ParseIP(proxy) == nil {
+			return fmt.Errorf("invalid trusted_proxies entry: %s", proxy)
+		}
+	}
+
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+
+	switch c.Server.ErrorFormat {
+	case "auto", "json", "html", "text":
+	default:
+		return fmt.Errorf("invalid error_format: %s (must be auto, json, html, or text)", c.Server.ErrorFormat)
+	}
+
+	if c.Server.Mode != ModeProxy && c.Server.Mode != ModeAuthOnly {
+		return fmt.Errorf("invalid mode: %s (must be proxy or auth_only)", c.Server.Mode)
+	}
+
+	if c.Server.PostLoginRedirect == "" {
+		return fmt.Errorf("post_login_redirect is required in auth_only mode")
+	}
+	if !strings.HasPrefix(c.Server.PostLoginRedirect, "/") || strings.HasPrefix(c.Server.PostLoginRedirect, "//") {
+		u, err := url.Parse(c.Server.PostLoginRedirect)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid post_login_redirect: %s (must be a path or an http or https URL)", c.Server.PostLoginRedirect)
+		}
+	}
+
+	unauth := c.Server.UnauthenticatedResponse
+	switch unauth.Mode {
+	case "redirect", "unauthorized":
+	case "custom":
+		if unauth.Status < 200 || unauth.Status > 599 {
+			return fmt.Errorf("invalid unauthenticated_response status: %d (must be between 200 and 599)", unauth.Status)
+		}
+	default:
+		return fmt.Errorf("invalid unauthenticated_response mode: %s (must be redirect, unauthorized, or custom)", unauth.Mode)
+	}
+
+	switch c.Server.CSRFMode {
+	case "cache", "double_submit":
+	default:
+		return fmt.Errorf("invalid csrf_mode: %s (must be cache or double_submit)", c.Server.CSRFMode)
+	}
+
+	if c.Server.LoginRedirectWindow < 0 {
+		return fmt.Errorf("login_redirect_window must be positive")
+	}
+
+	if c.Server.RequestTimeout < 0 {
+		return fmt.Errorf("request_timeout must be positive")
+	}
+
+	if c.Server.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("max_concurrent_requests must not be negative")
+	}
+
 	return nil
 }
 
@@ -69,6 +257,129 @@ func (c *Config) validateBackend() error {
 		return fmt.Errorf("timeout must be positive")
 	}
 
+	if prefix := c.Backend.StripPathPrefix; prefix != "" && (!strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/")) {
+		return fmt.Errorf("invalid strip_path_prefix: %s (must start with / and not end with /)", prefix)
+	}
+
+	if c.Backend.PathRewrite != nil {
+		if c.Backend.PathRewrite.Pattern == "" {
+			return fmt.Errorf("path_rewrite: pattern is required")
+		}
+		if _, err := regexp.Compile(c.Backend.PathRewrite.Pattern); err != nil {
+			return fmt.Errorf("path_rewrite: invalid pattern: %w", err)
+		}
+	}
+
+	if c.Backend.HeaderPreset != "" && c.Backend.HeaderPreset != "oauth2-proxy" {
+		return fmt.Errorf("invalid header_preset: %s (must be oauth2-proxy)", c.Backend.HeaderPreset)
+	}
+
+	if c.Backend.ClaimsHeaderMaxSize < 0 {
+		return fmt.Errorf("claims_header_max_size must be positive")
+	}
+
+	if c.Backend.SignHeaders != nil && len(c.Backend.SignHeaders.Key) < 32 {
+		return fmt.Errorf("sign_headers: key must be at least 32 characters")
+	}
+
+	if c.Backend.PairwiseIDSalt != "" && len(c.Backend.PairwiseIDSalt) < 16 {
+		return fmt.Errorf("pairwise_id_salt must be at least 16 characters")
+	}
+
+	if c.Backend.HeaderEncryption != nil {
+		key, err := base64.StdEncoding.DecodeString(c.Backend.HeaderEncryption.Key)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("header_encryption: key must be 32 bytes, base64 encoded")
+		}
+	}
+	for _, p := range c.Providers {
+		for claim, mapping := range p.HeaderMappings {
+			if mapping.Encrypt && c.Backend.HeaderEncryption == nil {
+				return fmt.Errorf("provider %s encrypts %s but header_encryption is not configured", p.ID, claim)
+			}
+		}
+	}
+
+	switch c.Backend.Protocol {
+	case "http":
+	case "grpc":
+		if c.Backend.OnBackendUnauthorized != "passthrough" {
+			return fmt.Errorf("on_backend_unauthorized must be passthrough with protocol grpc")
+		}
+		if c.Backend.OnNoRoute == "branded_error" {
+			return fmt.Errorf("on_no_route cannot be branded_error with protocol grpc")
+		}
+	default:
+		return fmt.Errorf("invalid protocol: %s (must be http or grpc)", c.Backend.Protocol)
+	}
+
+	switch c.Backend.StartupCheck {
+	case "none", "warn", "require":
+	default:
+		return fmt.Errorf("invalid startup_check: %s (must be none, warn or require)", c.Backend.StartupCheck)
+	}
+
+	if c.Backend.StartupCheckRetries < 0 || c.Backend.StartupCheckBackoff < 0 {
+		return fmt.Errorf("startup_check_retries and startup_check_backoff must be positive")
+	}
+
+	switch c.Backend.OnBackendUnauthorized {
+	case "passthrough", "reauth", "branded":
+	default:
+		return fmt.Errorf("invalid on_backend_unauthorized: %s (must be passthrough, reauth or branded)", c.Backend.OnBackendUnauthorized)
+	}
+
+	switch c.Backend.OnNoRoute {
+	case "default_backend", "404", "branded_error":
+	default:
+		return fmt.Errorf("invalid on_no_route: %s (must be default_backend, 404 or branded_error)", c.Backend.OnNoRoute)
+	}
+
+	if c.Backend.PreserveAuthorization {
+		if strings.EqualFold(c.Backend.ClaimsHeader, "Authorization") {
+			return fmt.Errorf("claims_header cannot be Authorization when preserve_authorization is enabled")
+		}
+		for _, p := range c.Providers {
+			for claim, mapping := range p.HeaderMappings {
+				if strings.EqualFold(mapping.Header, "Authorization") {
+					return fmt.Errorf("provider %s maps %s to Authorization, which conflicts with preserve_authorization", p.ID, claim)
+				}
+			}
+		}
+	}
+
+	if c.Backend.RoutesByClaim != nil {
+		if c.Backend.RoutesByClaim.Claim == "" {
+			return fmt.Errorf("routes_by_claim: claim is required")
+		}
+		if len(c.Backend.RoutesByClaim.Routes) == 0 {
+			return fmt.Errorf("routes_by_claim: at least one route is required")
+		}
+		for value, target := range c.Backend.RoutesByClaim.Routes {
+			u, err := url.Parse(target)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("routes_by_claim: invalid url for %s: %s (must be an http or https URL with a host)", value, target)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateUI parses the external select template, so that a typo is
+// reported with its file and line before startup, or downgraded to a
+// warning under template_fallback.
+func (c *Config) validateUI() error {
+	if c.UI.SelectTemplate == "" {
+		return nil
+	}
+
+	if _, err := template.ParseFiles(c.UI.SelectTemplate); err != nil {
+		if !c.UI.TemplateFallback {
+			return fmt.Errorf("invalid select_template: %w", err)
+		}
+		c.warnings = append(c.warnings, fmt.Sprintf("ui: invalid select_template, using the embedded template: %v", err))
+	}
 	return nil
 }
 
@@ -77,24 +388,92 @@ func (c *Config) validateCache() error {
 		return fmt.Errorf("invalid type: %s (must be memory or redis)", c.Cache.Type)
 	}
 
+	if c.Cache.Serialization != "json" && c.Cache.Serialization != "msgpack" {
+		return fmt.Errorf("invalid serialization: %s (must be json or msgpack)", c.Cache.Serialization)
+	}
+
+	if c.Cache.ReadinessInterval < time.Second {
+		return fmt.Errorf("readiness_interval must be at least 1 second")
+	}
+	if c.Cache.OperationTimeout < 0 {
+		return fmt.Errorf("operation_timeout must not be negative")
+	}
+
 	if c.Cache.Type == "redis" {
 		if c.Cache.Redis == nil {
 			return fmt.Errorf("redis config is required when type is redis")
 		}
-		if c.Cache.Redis.Address == "" {
-			return fmt.Errorf("redis address is required")
+		if c.Cache.Redis.URL != "" {
+			if err := validateRedisURL(c.Cache.Redis.URL); err != nil {
+				return err
+			}
+		} else if c.Cache.Redis.Address == "" {
+			return fmt.Errorf("redis address or url is required")
+		}
+		if c.Cache.Redis.DialTimeout < 0 || c.Cache.Redis.ReadTimeout < 0 || c.Cache.Redis.WriteTimeout < 0 {
+			return fmt.Errorf("redis dial_timeout, read_timeout and write_timeout must not be negative")
 		}
 	}
 
 	return nil
 }
 
+// validateRedisURL checks a redis:// or rediss:// URL with a host and an
+// optional database number, or a unix:// socket path.
+func validateRedisURL(raw string) error {
+	// The parse error is not wrapped: it quotes the URL, password included.
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid redis url (must be redis://[:password@]host[:port][/db], rediss:// or unix://)")
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		if u.Host == "" {
+			return fmt.Errorf("invalid redis url: %s (must have a host)", u.Redacted())
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			if n, err := strconv.Atoi(db); err != nil || n < 0 {
+				return fmt.Errorf("invalid redis url: %s (database must be a non-negative number)", u.Redacted())
+			}
+		}
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("invalid redis url: %s (must have a socket path)", u.Redacted())
+		}
+	default:
+		return fmt.Errorf("invalid redis url: %s (must be redis, rediss or unix)", u.Redacted())
+	}
+	return nil
+}
+
+var (
+	providerTypesMu sync.RWMutex
+	providerTypes   = map[string]bool{"oidc": true, "saml": true, "mock": true}
+)
+
+// RegisterProviderType accepts name as a provider type in validation. It is
+// called by provider.Register.
+func RegisterProviderType(name string) {
+	providerTypesMu.Lock()
+	defer providerTypesMu.Unlock()
+	providerTypes[name] = true
+}
+
+// ProviderTypes returns the accepted provider types, sorted.
+func ProviderTypes() []string {
+	providerTypesMu.RLock()
+	defer providerTypesMu.RUnlock()
+	return slices.Sorted(maps.Keys(providerTypes))
+}
+
 func (c *Config) validateProviders() error {
 	if len(c.Providers) == 0 {
 		return fmt.Errorf("at least one provider is required")
 	}
 
 	ids := make(map[string]bool)
+	oidcProviders := make(map[string]*OIDCConfig)
 	for i, provider := range c.Providers {
 		if provider.ID == "" {
 			return fmt.Errorf("provider %d: id is required", i)
@@ -109,18 +488,32 @@ func (c *Config) validateProviders() error {
 			return fmt.Errorf("provider %s: name is required", provider.ID)
 		}
 
-		if provider.Type != "oidc" && provider.Type != "saml" {
-			return fmt.Errorf("provider %s: invalid type: %s (must be oidc or saml)", provider.ID, provider.Type)
+		if !slices.Contains(ProviderTypes(), provider.Type) {
+			return fmt.Errorf("provider %s: invalid type: %s (must be %s)", provider.ID, provider.Type, strings.Join(ProviderTypes(), ", "))
+		}
+
+		if provider.Type == "mock" && !c.DevMode {
+			return fmt.Errorf("provider %s: mock providers require dev_mode", provider.ID)
 		}
 
 		if provider.Type == "oidc" {
 			if err := validateOIDCConfig(provider.ID, provider.OIDC); err != nil {
 				return err
 			}
+			if ref := provider.OIDC.SharedDiscovery; ref != "" {
+				shared, ok := oidcProviders[ref]
+				if !ok {
+					return fmt.Errorf("provider %s: invalid shared_discovery: %s (must be the id of an earlier oidc provider)", provider.ID, ref)
+				}
+				if shared.SharedDiscovery != "" {
+					return fmt.Errorf("provider %s: shared_discovery %s itself uses shared_discovery", provider.ID, ref)
+				}
+			}
+			oidcProviders[provider.ID] = provider.OIDC
 		}
 
 		if provider.Type == "saml" {
-			if err := validateSAMLConfig(provider.ID, provider.SAML); err != nil {
+			if err := validateSAMLConfig(provider.ID, provider.SAML, c.SAML.SharedACS); err != nil {
 				return err
 			}
 		}
@@ -128,11 +521,105 @@ func (c *Config) validateProviders() error {
 		if len(provider.HeaderMappings) == 0 {
 			return fmt.Errorf("provider %s: at least one header mapping is required", provider.ID)
 		}
+		for claim, mapping := range provider.HeaderMappings {
+			if mapping.Header == "" {
+				return fmt.Errorf("provider %s: header mapping for %s has no header", provider.ID, claim)
+			}
+			switch mapping.SingleValue {
+			case "", "first", "last", "join", "error":
+			default:
+				return fmt.Errorf("provider %s: header mapping for %s has invalid single_value: %s (must be first, last, join, or error)", provider.ID, claim, mapping.SingleValue)
+			}
+			for _, transform := range mapping.Transform {
+				switch transform {
+				case "lower", "upper", "trim", "title":
+				default:
+					return fmt.Errorf("provider %s: header mapping for %s has invalid transform: %s (must be lower, upper, trim, or title)", provider.ID, claim, transform)
+				}
+			}
+			if mapping.QueryParam != "" && !c.Backend.InjectQueryParams {
+				return fmt.Errorf("provider %s: header mapping for %s sets query_param but backend.inject_query_params is disabled", provider.ID, claim)
+			}
+			if len(c.Server.SessionClaims) > 0 && !slices.Contains(c.Server.SessionClaims, claim) {
+				c.warnings = append(c.warnings, fmt.Sprintf("provider %s: header mapping for %s reads a claim that is not in server.session_claims and will never be set", provider.ID, claim))
+			}
+		}
+
+		if err := validateIDPRequestHeaders(provider.ID, provider.IDPRequestHeaders); err != nil {
+			return err
+		}
+
+		if err := validateComputedClaims(provider.ID, provider.ComputedClaims, provider.ClaimAliases); err != nil {
+			return err
+		}
+
+		if err := validatePreset(provider.ID, provider.Preset); err != nil {
+			return err
+		}
+
+		if provider.AcceptIf != nil {
+			if _, err := claimexpr.Compile(provider.AcceptIf.Expression); err != nil {
+				return fmt.Errorf("provider %s: invalid accept_if expression: %w", provider.ID, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+func validateIDPRequestHeaders(providerID string, headers map[string]string) error {
+	for name, value := range headers {
+		if !isHeaderToken(name) {
+			return fmt.Errorf("provider %s: invalid idp_request_headers name: %q", providerID, name)
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Host", "Content-Type", "Content-Length":
+			return fmt.Errorf("provider %s: idp_request_headers cannot set %s", providerID, name)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("provider %s: idp_request_headers value of %s contains control characters", providerID, name)
+		}
+	}
+	return nil
+}
+
+// isHeaderToken reports whether name is a valid HTTP header field name.
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// reservedAuthParams are the authorization request parameters that carry the
+// flow's own state and cannot be overridden by allowed_extra_params.
+var reservedAuthParams = map[string]bool{
+	"response_type":         true,
+	"client_id":             true,
+	"redirect_uri":          true,
+	"scope":                 true,
+	"state":                 true,
+	"nonce":                 true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+	"prompt":                true,
+	"max_age":               true,
+	"claims":                true,
+	"request":               true,
+	"request_uri":           true,
+	"response_mode":         true,
+	"hd":                    true,
+	"additional_scopes":     true,
+}
+
 func validateOIDCConfig(providerID string, cfg *OIDCConfig) error {
 	if cfg == nil {
 		return fmt.Errorf("provider %s: oidc config is required", providerID)
@@ -150,29 +637,80 @@ func validateOIDCConfig(providerID string, cfg *OIDCConfig) error {
 		return fmt.Errorf("provider %s: client_id is required", providerID)
 	}
 
-	if cfg.ClientSecret == "" {
-		return fmt.Errorf("provider %s: client_secret is required", providerID)
+	switch cfg.ClientAuthMethod {
+	case "", "client_secret":
+		if cfg.ClientSecret == "" {
+			return fmt.Errorf("provider %s: client_secret is required", providerID)
+		}
+	case "private_key_jwt":
+		if cfg.PrivateKeyPath == "" {
+			return fmt.Errorf("provider %s: private_key_path is required with private_key_jwt", providerID)
+		}
+	default:
+		return fmt.Errorf("provider %s: invalid client_auth_method: %s (must be client_secret or private_key_jwt)", providerID, cfg.ClientAuthMethod)
 	}
 
 	if len(cfg.Scopes) == 0 {
 		return fmt.Errorf("provider %s: at least one scope is required", providerID)
 	}
 
-	hasOpenID := false
-	for _, scope := range cfg.Scopes {
-		if scope == "openid" {
-			hasOpenID = true
-			break
+	if !hasScope(cfg.Scopes, "openid") {
+		return fmt.Errorf("provider %s: 'openid' scope is required", providerID)
+	}
+
+	if cfg.ClaimsRequest != "" {
+		var claims map[string]interface{}
+		if err := json.Unmarshal([]byte(cfg.ClaimsRequest), &claims); err != nil {
+			return fmt.Errorf("provider %s: claims_request must be a JSON object: %w", providerID, err)
 		}
 	}
-	if !hasOpenID {
-		return fmt.Errorf("provider %s: 'openid' scope is required", providerID)
+
+	for _, param := range cfg.AllowedExtraParams {
+		if reservedAuthParams[param] {
+			return fmt.Errorf("provider %s: allowed_extra_params must not contain %s, which is set by the proxy", providerID, param)
+		}
+	}
+
+	if cfg.MaxAge < 0 {
+		return fmt.Errorf("provider %s: max_age must not be negative", providerID)
+	}
+
+	for _, prompt := range strings.Fields(cfg.Prompt) {
+		switch prompt {
+		case "none", "login", "consent", "select_account":
+		default:
+			return fmt.Errorf("provider %s: invalid prompt: %s (must be none, login, consent, or select_account)", providerID, prompt)
+		}
+	}
+
+	if cfg.AccessTokenAudience != "" && !cfg.ValidateAccessToken {
+		return fmt.Errorf("provider %s: access_token_audience requires validate_access_token", providerID)
+	}
+
+	if cfg.UserInfoRequired && !cfg.FetchUserInfo {
+		return fmt.Errorf("provider %s: userinfo_required requires fetch_userinfo", providerID)
+	}
+
+	if cfg.UserInfoRefreshInterval < 0 {
+		return fmt.Errorf("provider %s: userinfo_refresh_interval must not be negative", providerID)
 	}
 
 	return nil
 }
 
-func validateSAMLConfig(providerID string, cfg *SAMLConfig) error {
+// samlNameIDFormats are the name identifier formats of SAML 2.0 Core 8.3.
+var samlNameIDFormats = []string{
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified",
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:X509SubjectName",
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:WindowsDomainQualifiedName",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:kerberos",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:transient",
+}
+
+func validateSAMLConfig(providerID string, cfg *SAMLConfig, sharedACS bool) error {
 	if cfg == nil {
 		return fmt.Errorf("provider %s: saml config is required", providerID)
 	}
@@ -195,10 +733,31 @@ func validateSAMLConfig(providerID string, cfg *SAMLConfig) error {
 		return fmt.Errorf("provider %s: acs_url is required", providerID)
 	}
 
-	if _, err := url.Parse(cfg.ACSURL); err != nil {
+	acsURL, err := url.Parse(cfg.ACSURL)
+	if err != nil {
 		return fmt.Errorf("provider %s: invalid acs_url: %w", providerID, err)
 	}
 
+	// Under shared_acs the IdP must post to the shared endpoint, which is
+	// also the Destination its responses are checked against.
+	if sharedACS && acsURL.Path != SAMLSharedACSPath {
+		return fmt.Errorf("provider %s: invalid acs_url: %s (must point at %s under saml shared_acs)", providerID, cfg.ACSURL, SAMLSharedACSPath)
+	}
+
+	if cfg.ExpectedDestination != "" {
+		if _, err := url.Parse(cfg.ExpectedDestination); err != nil {
+			return fmt.Errorf("provider %s: invalid expected_destination: %w", providerID, err)
+		}
+	}
+
+	if cfg.NameIDFormat != "" && !slices.Contains(samlNameIDFormats, cfg.NameIDFormat) {
+		return fmt.Errorf("provider %s: invalid nameid_format: %s (must be a SAML 2.0 name identifier format URI)", providerID, cfg.NameIDFormat)
+	}
+
+	if cfg.MetadataValidDuration < 2*time.Minute {
+		return fmt.Errorf("provider %s: metadata_valid_duration must be at least 2m", providerID)
+	}
+
 	if cfg.CertificatePath == "" {
 		return fmt.Errorf("provider %s: certificate_path is required", providerID)
 	}
@@ -210,6 +769,134 @@ func validateSAMLConfig(providerID string, cfg *SAMLConfig) error {
 	return nil
 }
 
+func validatePreset(providerID, preset string) error {
+	if preset == "" {
+		return nil
+	}
+	if _, ok := providerPresets[preset]; !ok {
+		return fmt.Errorf("provider %s: unknown preset: %s (must be azuread, okta, google, keycloak, or adfs)", providerID, preset)
+	}
+	return nil
+}
+
+// reservedClaims are the claims that identify and bind a login, set by the
+// IdP or from the SAML assertion, which computed claims cannot replace.
+var reservedClaims = map[string]bool{
+	"iss":                     true,
+	"sub":                     true,
+	"aud":                     true,
+	"exp":                     true,
+	"iat":                     true,
+	"nbf":                     true,
+	"jti":                     true,
+	"nonce":                   true,
+	"auth_time":               true,
+	"acr":                     true,
+	"amr":                     true,
+	"azp":                     true,
+	"sid":                     true,
+	"at_hash":                 true,
+	"c_hash":                  true,
+	"email":                   true,
+	"email_verified":          true,
+	"authn_instant":           true,
+	"authn_context_class_ref": true,
+}
+
+func validateComputedClaims(providerID string, claims []ComputedClaim, aliases map[string][]string) error {
+	names := make(map[string]bool)
+	for i, claim := range claims {
+		if claim.Name == "" {
+			return fmt.Errorf("provider %s: computed claim %d: name is required", providerID, i)
+		}
+		if reservedClaims[claim.Name] {
+			return fmt.Errorf("provider %s: computed claim %s: name is a reserved claim", providerID, claim.Name)
+		}
+		if _, exists := aliases[claim.Name]; exists {
+			return fmt.Errorf("provider %s: computed claim %s: name is also a claim alias", providerID, claim.Name)
+		}
+		if names[claim.Name] {
+			return fmt.Errorf("provider %s: duplicate computed claim: %s", providerID, claim.Name)
+		}
+		names[claim.Name] = true
+
+		if claim.Template == "" {
+			return fmt.Errorf("provider %s: computed claim %s: template is required", providerID, claim.Name)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateJWKS() error {
+	jwks := c.OIDC.JWKS
+	if jwks.MaxRetries != nil && *jwks.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if jwks.RetryBackoff < 0 {
+		return fmt.Errorf("retry_backoff must not be negative")
+	}
+	if jwks.FailureThreshold < 1 {
+		return fmt.Errorf("failure_threshold must be at least 1")
+	}
+	if jwks.NegativeCacheTTL < 0 {
+		return fmt.Errorf("negative_cache_ttl must not be negative")
+	}
+	if jwks.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Config) validateEvents() error {
+	if c.Events.QueueSize < 1 {
+		return fmt.Errorf("queue_size must be at least 1")
+	}
+	if c.Events.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+
+	for i, webhook := range c.Events.Webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %d: invalid url: %s", i, webhook.URL)
+		}
+
+		for _, event := range webhook.Events {
+			switch event {
+			case "login_success", "login_failure", "logout", "concurrent_login":
+			default:
+				return fmt.Errorf("webhook %d: unknown event: %s (must be login_success, login_failure, logout, or concurrent_login)", i, event)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateServiceClients() error {
+	ids := make(map[string]bool)
+	for i, client := range c.ServiceClients {
+		if client.ID == "" {
+			return fmt.Errorf("client %d: id is required", i)
+		}
+		if ids[client.ID] {
+			return fmt.Errorf("client %d: duplicate id: %s", i, client.ID)
+		}
+		ids[client.ID] = true
+
+		if len(client.Secret) < 16 {
+			return fmt.Errorf("client %s: secret must be at least 16 characters", client.ID)
+		}
+		if client.TokenTTL < time.Minute {
+			return fmt.Errorf("client %s: token_ttl must be at least 1 minute", client.ID)
+		}
+	}
+
+	return nil
+}
+
 func (c *Config) validateLogging() error {
 	level := strings.ToLower(c.Logging.Level)
 	if level != "debug" && level != "info" && level != "warn" && level != "error" {
@@ -221,5 +908,59 @@ func (c *Config) validateLogging() error {
 		return fmt.Errorf("invalid format: %s (must be json or text)", c.Logging.Format)
 	}
 
+	if c.Logging.BodyMaxSize < 0 {
+		return fmt.Errorf("body_max_size must be positive")
+	}
+
+	return nil
+}
+
+// validateEnvironment turns insecure settings into errors in production and
+// into warnings in development.
+func (c *Config) validateEnvironment() error {
+	var issues []string
+	if u, err := url.Parse(c.Server.BaseURL); err == nil && u.Scheme != "https" {
+		issues = append(issues, "base_url is not https")
+	}
+	if !c.Server.CookieSecure {
+		issues = append(issues, "cookie_secure is disabled")
+	}
+	if c.DevMode {
+		issues = append(issues, "dev_mode is enabled")
+	}
+	for _, provider := range c.Providers {
+		if provider.Type == "mock" {
+			issues = append(issues, fmt.Sprintf("provider %s is a mock provider", provider.ID))
+		}
+	}
+
+	switch c.Server.Environment {
+	case "":
+	case "production":
+		if len(issues) > 0 {
+			return fmt.Errorf("%s", strings.Join(issues, "; "))
+		}
+	case "development":
+		for _, issue := range issues {
+			c.warnings = append(c.warnings, "server: "+issue+", which environment production would reject")
+		}
+	default:
+		return fmt.Errorf("must be development or production")
+	}
+	return nil
+}
+
+// validateDevMode keeps dev_mode, and with it mock providers, out of
+// production-like deployments.
+func (c *Config) validateDevMode() error {
+	if !c.DevMode {
+		return nil
+	}
+	if c.Server.CookieSecure {
+		return fmt.Errorf("cannot be used with cookie_secure")
+	}
+	if c.Server.TLSCertFile != "" {
+		return fmt.Errorf("cannot be used with tls_cert_file")
+	}
 	return nil
 }
@@ -0,0 +1,704 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputedClaimNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   string
+		aliases string
+		wantErr string
+	}{
+		{name: "custom name", claim: "is_admin"},
+		{name: "reserved name", claim: "sub", wantErr: "computed claim sub: name is a reserved claim"},
+		{name: "reserved email", claim: "email", wantErr: "computed claim email: name is a reserved claim"},
+		{name: "alias name", claim: "upn", aliases: `
+    claim_aliases:
+      upn: [unique_name]`, wantErr: "computed claim upn: name is also a claim alias"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    computed_claims:
+      - name: ` + tt.claim + `
+        template: '{{ .groups }}'
+    header_mappings:
+      email: X-User-Email` + tt.aliases + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestPostLoginRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		want    string
+		wantErr string
+	}{
+		{name: "proxy default", server: `
+  base_url: https://sso.example.com
+`, want: "/"},
+		{name: "proxy path", server: `
+  base_url: https://sso.example.com
+  post_login_redirect: /home
+`, want: "/home"},
+		{name: "auth_only URL", server: `
+  base_url: https://sso.example.com
+  mode: auth_only
+  post_login_redirect: https://app.example.com/
+`, want: "https://app.example.com/"},
+		{name: "auth_only without target", server: `
+  base_url: https://sso.example.com
+  mode: auth_only
+`, wantErr: "post_login_redirect is required in auth_only mode"},
+		{name: "scheme-relative", server: `
+  base_url: https://sso.example.com
+  post_login_redirect: //evil.example.com
+`, wantErr: "invalid post_login_redirect"},
+		{name: "other scheme", server: `
+  base_url: https://sso.example.com
+  post_login_redirect: javascript:alert(1)
+`, wantErr: "invalid post_login_redirect"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": tt.server})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := cfg.Server.PostLoginRedirect; got != tt.want {
+				t.Errorf("post_login_redirect = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnauthenticatedResponseStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{name: "ok", status: 200},
+		{name: "unauthorized", status: 401},
+		{name: "upper bound", status: 599},
+		{name: "informational", status: 102, wantErr: "invalid unauthenticated_response status: 102 (must be between 200 and 599)"},
+		{name: "missing", wantErr: "invalid unauthenticated_response status: 0 (must be between 200 and 599)"},
+		{name: "too large", status: 600, wantErr: "invalid unauthenticated_response status: 600 (must be between 200 and 599)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"server": fmt.Sprintf(`
+  base_url: https://sso.example.com
+  unauthenticated_response:
+    mode: custom
+    status: %d
+`, tt.status)})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRedisURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{name: "redis", url: "redis://localhost:6379"},
+		{name: "rediss with password and db", url: "rediss://:secret@redis.example.com:6380/2"},
+		{name: "unix socket", url: "unix:///var/run/redis.sock"},
+		{name: "no host", url: "redis:///0", wantErr: "invalid redis url: redis:///0 (must have a host)"},
+		{name: "bad db", url: "redis://:secret@localhost/cache", wantErr: "invalid redis url: redis://:xxxxx@localhost/cache (database must be a non-negative number)"},
+		{name: "bad scheme", url: "http://localhost:6379", wantErr: "invalid redis url: http://localhost:6379 (must be redis, rediss or unix)"},
+		{name: "unparsable", url: "redis://:secret@local host", wantErr: "invalid redis url (must be redis://[:password@]host[:port][/db], rediss:// or unix://)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"cache": `
+  type: redis
+  redis:
+    url: "` + tt.url + `"
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSharedACSURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		sharedACS bool
+		acsURL    string
+		wantErr   string
+	}{
+		{name: "per-provider acs", acsURL: "https://sso.example.com/auth/saml/okta/acs"},
+		{name: "shared acs", sharedACS: true, acsURL: "https://sso.example.com/auth/saml/acs"},
+		{
+			name:      "shared acs with per-provider acs_url",
+			sharedACS: true,
+			acsURL:    "https://sso.example.com/auth/saml/okta/acs",
+			wantErr:   "provider okta: invalid acs_url: https://sso.example.com/auth/saml/okta/acs (must point at /auth/saml/acs under saml shared_acs)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{
+				"saml": fmt.Sprintf(`
+  shared_acs: %t
+`, tt.sharedACS),
+				"providers": `
+  - id: okta
+    name: Okta
+    type: saml
+    saml:
+      idp_metadata_url: https://idp.example.com/metadata
+      sp_entity_id: https://sso.example.com
+      acs_url: ` + tt.acsURL + `
+      certificate_path: /etc/sso-switch/sp.crt
+      private_key_path: /etc/sso-switch/sp.key
+    header_mappings:
+      email: X-User-Email
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRoutesByClaimURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr string
+	}{
+		{name: "http", target: "http://tenant-a:8080"},
+		{name: "https", target: "https://tenant-a.internal"},
+		{name: "no scheme", target: "tenant-a:8080", wantErr: "routes_by_claim: invalid url for acme: tenant-a:8080 (must be an http or https URL with a host)"},
+		{name: "no host", target: "http:///path", wantErr: "routes_by_claim: invalid url for acme: http:///path (must be an http or https URL with a host)"},
+		{name: "other scheme", target: "ftp://tenant-a", wantErr: "routes_by_claim: invalid url for acme: ftp://tenant-a (must be an http or https URL with a host)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"backend": `
+  url: http://backend:8080
+  routes_by_claim:
+    claim: tenant
+    routes:
+      acme: "` + tt.target + `"
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHeaderMappingTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		wantErr   string
+	}{
+		{name: "single", transform: "[lower]"},
+		{name: "chain", transform: "[trim, lower]"},
+		{name: "unknown", transform: "[reverse]", wantErr: "provider corp: header mapping for email has invalid transform: reverse (must be lower, upper, trim, or title)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email:
+        header: X-User-Email
+        transform: ` + tt.transform + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestPreserveAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		backend   string
+		providers string
+		wantErr   string
+	}{
+		{name: "no conflict", backend: `
+  url: http://backend:8080
+  preserve_authorization: true
+`},
+		{name: "claims header", backend: `
+  url: http://backend:8080
+  preserve_authorization: true
+  claims_header: authorization
+`, wantErr: "claims_header cannot be Authorization when preserve_authorization is enabled"},
+		{name: "header mapping", backend: `
+  url: http://backend:8080
+  preserve_authorization: true
+`, providers: `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email: Authorization
+`, wantErr: "provider corp maps email to Authorization, which conflicts with preserve_authorization"},
+		{name: "header mapping without preserve", backend: `
+  url: http://backend:8080
+`, providers: `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email: Authorization
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections := map[string]string{"backend": tt.backend}
+			if tt.providers != "" {
+				sections["providers"] = tt.providers
+			}
+			_, err := loadTestConfig(t, sections)
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestStartupCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   string
+		wantErr string
+	}{
+		{name: "warn", check: "warn"},
+		{name: "require", check: "require"},
+		{name: "unknown", check: "always", wantErr: "invalid startup_check: always (must be none, warn or require)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"backend": `
+  url: http://backend:8080
+  startup_check: ` + tt.check + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCookiePriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority string
+		wantErr  string
+	}{
+		{name: "high", priority: "high"},
+		{name: "mixed case", priority: "Medium"},
+		{name: "unknown", priority: "urgent", wantErr: "invalid cookie_priority: urgent (must be low, medium, or high)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"server": `
+  base_url: https://sso.example.com
+  cookie_priority: ` + tt.priority + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDevMode(t *testing.T) {
+	mockProvider := `
+  - id: dev
+    name: Dev
+    type: mock
+    mock:
+      claims:
+        sub: dev-user
+    header_mappings:
+      sub: X-User
+`
+
+	tests := []struct {
+		name      string
+		devMode   bool
+		server    string
+		providers string
+		wantErr   string
+	}{
+		{name: "mock provider", devMode: true, providers: mockProvider},
+		{name: "mock provider without dev_mode", providers: mockProvider, wantErr: "providers config: provider dev: mock providers require dev_mode"},
+		{name: "secure cookies", devMode: true, server: `
+  base_url: https://sso.example.com
+  cookie_secure: true
+`, wantErr: "dev_mode: cannot be used with cookie_secure"},
+		{name: "tls", devMode: true, server: `
+  base_url: https://sso.example.com
+  tls_cert_file: /etc/tls/cert.pem
+  tls_key_file: /etc/tls/key.pem
+`, wantErr: "dev_mode: cannot be used with tls_cert_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections := map[string]string{"dev_mode": fmt.Sprintf(" %t", tt.devMode)}
+			if tt.server != "" {
+				sections["server"] = tt.server
+			}
+			if tt.providers != "" {
+				sections["providers"] = tt.providers
+			}
+			_, err := loadTestConfig(t, sections)
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestClaimsRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		claimsRequest string
+		wantErr       string
+	}{
+		{name: "object", claimsRequest: `'{"id_token": {"acr": {"essential": true}}}'`},
+		{name: "invalid json", claimsRequest: `'{"id_token": '`, wantErr: "provider corp: claims_request must be a JSON object: unexpected end of JSON input"},
+		{name: "array", claimsRequest: `'["acr"]'`, wantErr: "provider corp: claims_request must be a JSON object: json: cannot unmarshal array into Go value of type map[string]interface {}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+      claims_request: ` + tt.claimsRequest + `
+    header_mappings:
+      email: X-User-Email
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHeaderMappingSingleValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "first", policy: "first"},
+		{name: "error", policy: "error"},
+		{name: "unknown", policy: "random", wantErr: "providers config: provider corp: header mapping for groups has invalid single_value: random (must be first, last, join, or error)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      groups:
+        header: X-User-Groups
+        single_value: ` + tt.policy + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHeaderMappingQueryParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		inject  bool
+		wantErr string
+	}{
+		{name: "enabled", inject: true},
+		{name: "disabled", wantErr: "providers config: provider corp: header mapping for email sets query_param but backend.inject_query_params is disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{
+				"backend": fmt.Sprintf(`
+  url: http://backend:8080
+  inject_query_params: %t
+`, tt.inject),
+				"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email:
+        header: X-User-Email
+        query_param: email
+`,
+			})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		name         string
+		environment  string
+		baseURL      string
+		cookieSecure bool
+		wantErr      string
+		wantWarnings int
+	}{
+		{name: "production secure", environment: "production", baseURL: "https://sso.example.com", cookieSecure: true},
+		{name: "production insecure cookie", environment: "production", baseURL: "https://sso.example.com", wantErr: "server config: environment production: cookie_secure is disabled"},
+		{name: "production http", environment: "production", baseURL: "http://sso.example.com", wantErr: "server config: environment production: base_url is not https; cookie_secure is disabled"},
+		{name: "development insecure", environment: "development", baseURL: "http://sso.example.com", wantWarnings: 2},
+		{name: "development secure", environment: "development", baseURL: "https://sso.example.com", cookieSecure: true},
+		{name: "unset", baseURL: "http://sso.example.com"},
+		{name: "unknown", environment: "staging", baseURL: "https://sso.example.com", wantErr: "server config: environment staging: must be development or production"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": fmt.Sprintf(`
+  base_url: %s
+  environment: %q
+  cookie_secure: %t
+`, tt.baseURL, tt.environment, tt.cookieSecure)})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := len(cfg.Warnings()); got != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", cfg.Warnings(), tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestIDPRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		wantErr string
+	}{
+		{name: "api key", headers: `X-Api-Key: gateway-key`},
+		{name: "invalid name", headers: `"X Api Key": gateway-key`, wantErr: `provider corp: invalid idp_request_headers name: "X Api Key"`},
+		{name: "reserved header", headers: `authorization: Bearer token`, wantErr: "provider corp: idp_request_headers cannot set authorization"},
+		{name: "control characters", headers: `X-Api-Key: "key\r\nX-Injected: 1"`, wantErr: "provider corp: idp_request_headers value of X-Api-Key contains control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": `
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+    header_mappings:
+      email: X-User-Email
+    idp_request_headers:
+      ` + tt.headers + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSelectTemplate(t *testing.T) {
+	malformed := filepath.Join(t.TempDir(), "select.html")
+	if err := os.WriteFile(malformed, []byte(`<h1>{{.PageTitle</h1>`), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		fallback    bool
+		wantErr     string
+		wantWarning bool
+	}{
+		{name: "fail", wantErr: "ui config: invalid select_template: template: select.html:1"},
+		{name: "fallback", fallback: true, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"ui": fmt.Sprintf(`
+  select_template: %s
+  template_fallback: %t
+`, malformed, tt.fallback)})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := len(cfg.Warnings()) > 0; got != tt.wantWarning {
+				t.Errorf("warnings = %v, want a warning: %v", cfg.Warnings(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestSessionClaims(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      string
+		wantErr     string
+		wantWarning bool
+	}{
+		{name: "mapped claim kept", claims: "[email, groups]"},
+		{name: "mapped claim dropped", claims: "[groups]", wantWarning: true},
+		{name: "empty name", claims: `[email, ""]`, wantErr: "server config: session_claims must not contain empty claim names"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"server": `
+  base_url: https://sso.example.com
+  session_claims: ` + tt.claims + `
+`})
+			checkError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := len(cfg.Warnings()) > 0; got != tt.wantWarning {
+				t.Errorf("warnings = %v, want a warning: %v", cfg.Warnings(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestOnNoRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		protocol string
+		wantErr  string
+	}{
+		{name: "404", policy: "404", protocol: "http"},
+		{name: "branded error", policy: "branded_error", protocol: "http"},
+		{name: "branded error with grpc", policy: "branded_error", protocol: "grpc", wantErr: "backend config: on_no_route cannot be branded_error with protocol grpc"},
+		{name: "unknown", policy: "redirect", protocol: "http", wantErr: "backend config: invalid on_no_route: redirect (must be default_backend, 404 or branded_error)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"backend": `
+  url: http://backend:8080
+  protocol: ` + tt.protocol + `
+  on_no_route: "` + tt.policy + `"
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestAccessTokenAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate bool
+		wantErr  string
+	}{
+		{name: "with validation", validate: true},
+		{name: "without validation", wantErr: "provider corp: access_token_audience requires validate_access_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"providers": fmt.Sprintf(`
+  - id: corp
+    name: Corp
+    type: oidc
+    oidc:
+      issuer: https://idp.example.com
+      client_id: client
+      client_secret: secret
+      scopes: [openid, email]
+      validate_access_token: %t
+      access_token_audience: https://api.example.com
+    header_mappings:
+      email: X-User-Email
+`, tt.validate)})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestOIDCClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		skew    string
+		wantErr string
+	}{
+		{name: "positive", skew: "2m"},
+		{name: "zero", skew: "0s"},
+		{name: "negative", skew: "-1m", wantErr: "oidc clock_skew must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"oidc": `
+  clock_skew: ` + tt.skew + `
+`})
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
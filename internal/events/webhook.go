@@ -0,0 +1,191 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+const (
+	TypeLoginSuccess = "login_success"
+	TypeLoginFailure = "login_failure"
+	TypeLogout       = "logout"
+	// TypeConcurrentLogin is emitted under concurrent_login_policy notify
+	// when a user logs in while already having a session. Sessions holds
+	// their active session count.
+	TypeConcurrentLogin = "concurrent_login"
+
+	// SignatureHeader carries "sha256=<hex HMAC-SHA256 of the body>".
+	SignatureHeader = "X-SSO-Switch-Signature"
+)
+
+// Event is the webhook payload. It never contains tokens.
+type Event struct {
+	Type     string `json:"type"`
+	Provider string `json:"provider,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Sessions int    `json:"sessions,omitempty"`
+	// TraceID and SpanID come from the request's W3C traceparent header, so
+	// that events can be correlated with traces and request logs.
+	TraceID   string    `json:"trace_id,omitempty"`
+	SpanID    string    `json:"span_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Dispatcher delivers events to the configured webhooks. Each webhook has its
+// own bounded queue and worker, so a slow endpoint neither blocks requests
+// nor delays the other webhooks. Events are dropped when a queue is full.
+type Dispatcher struct {
+	webhooks []*webhook
+	trusted  *security.TrustedProxies
+	logger   *slog.Logger
+	wg       sync.WaitGroup
+}
+
+type webhook struct {
+	cfg    config.WebhookConfig
+	queue  chan queuedEvent
+	client *http.Client
+}
+
+// queuedEvent is an encoded event waiting for delivery, with the attributes
+// its log lines carry.
+type queuedEvent struct {
+	body  []byte
+	attrs []any
+}
+
+func NewDispatcher(cfg config.EventsConfig, trusted *security.TrustedProxies, logger *slog.Logger) *Dispatcher {
+	d := &Dispatcher{
+		trusted: trusted,
+		logger:  logger,
+	}
+
+	for _, whCfg := range cfg.Webhooks {
+		wh := &webhook{
+			cfg:    whCfg,
+			queue:  make(chan queuedEvent, cfg.QueueSize),
+			client: &http.Client{Timeout: whCfg.Timeout},
+		}
+		d.webhooks = append(d.webhooks, wh)
+
+		d.wg.Add(1)
+		go d.run(wh, cfg.MaxRetries, cfg.RetryBackoff)
+	}
+
+	return d
+}
+
+// Emit queues event for every webhook subscribed to its type, filling in the
+// client IP and trace context from r and the timestamp.
+func (d *Dispatcher) Emit(r *http.Request, event Event) {
+	if len(d.webhooks) == 0 {
+		return
+	}
+
+	if ip := d.trusted.ClientIP(r); ip != nil {
+		event.IP = ip.String()
+	}
+	event.TraceID, event.SpanID = middleware.TraceContext(r)
+	event.Timestamp = time.Now().UTC()
+
+	attrs := []any{"type", event.Type}
+	if event.TraceID != "" {
+		attrs = append(attrs, "trace_id", event.TraceID, "span_id", event.SpanID)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("failed to encode event", append(attrs, "error", err)...)
+		return
+	}
+
+	for _, wh := range d.webhooks {
+		if len(wh.cfg.Events) > 0 && !slices.Contains(wh.cfg.Events, event.Type) {
+			continue
+		}
+
+		select {
+		case wh.queue <- queuedEvent{body: body, attrs: attrs}:
+		default:
+			d.logger.Warn("webhook queue full, dropping event", append([]any{"url", wh.cfg.URL}, attrs...)...)
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued events to be delivered.
+func (d *Dispatcher) Close() {
+	for _, wh := range d.webhooks {
+		close(wh.queue)
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(wh *webhook, maxRetries int, backoff time.Duration) {
+	defer d.wg.Done()
+
+	for event := range wh.queue {
+		delay := backoff
+		for attempt := 0; ; attempt++ {
+			err := wh.deliver(event.body)
+			if err == nil {
+				break
+			}
+
+			if attempt >= maxRetries {
+				d.logger.Warn("webhook delivery failed", append([]any{"url", wh.cfg.URL, "attempts", attempt + 1, "error", err}, event.attrs...)...)
+				break
+			}
+
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (wh *webhook) deliver(body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, wh.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign([]byte(wh.cfg.Secret), body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, as sent in
+// SignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestEmitTraceContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		status      int
+		wantTraceID string
+		wantLog     string
+	}{
+		{name: "delivered", traceparent: testTraceparent, status: http.StatusOK, wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "without trace context", status: http.StatusOK},
+		{name: "failed delivery", traceparent: testTraceparent, status: http.StatusInternalServerError, wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736", wantLog: "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received []Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var event Event
+				if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+					t.Errorf("decode event: %v", err)
+				}
+				mu.Lock()
+				received = append(received, event)
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			trusted, err := security.ParseTrustedProxies(nil)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+
+			var logs bytes.Buffer
+			d := NewDispatcher(config.EventsConfig{
+				Webhooks:     []config.WebhookConfig{{URL: server.URL, Timeout: time.Second}},
+				QueueSize:    1,
+				RetryBackoff: time.Millisecond,
+			}, trusted, slog.New(slog.NewTextHandler(&logs, nil)))
+
+			req := httptest.NewRequest("GET", "/auth/oidc/corp/callback", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("Traceparent", tt.traceparent)
+			}
+			d.Emit(req, Event{Type: TypeLoginSuccess, Provider: "corp"})
+			d.Close()
+
+			if len(received) != 1 {
+				t.Fatalf("received %d events, want 1", len(received))
+			}
+			if got := received[0].TraceID; got != tt.wantTraceID {
+				t.Errorf("trace_id = %q, want %q", got, tt.wantTraceID)
+			}
+			if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log %q does not contain %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		failures     int
+		maxRetries   int
+		events       []string
+		wantAttempts int
+		wantEvent    bool
+	}{
+		{name: "delivered", wantAttempts: 1, wantEvent: true},
+		{name: "retried until delivered", failures: 2, maxRetries: 2, wantAttempts: 3, wantEvent: true},
+		{name: "retries exhausted", failures: 3, maxRetries: 1, wantAttempts: 2},
+		{name: "subscribed", events: []string{TypeLoginSuccess}, wantAttempts: 1, wantEvent: true},
+		{name: "not subscribed", events: []string{TypeLogout}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var attempts int
+			var delivered []Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("read body: %v", err)
+				}
+				if got, want := r.Header.Get(SignatureHeader), "sha256="+Sign([]byte(secret), body); got != want {
+					t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+				}
+				if got := r.Header.Get("Content-Type"); got != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", got)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				attempts++
+				if attempts <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				var event Event
+				if err := json.Unmarshal(body, &event); err != nil {
+					t.Errorf("decode event: %v", err)
+				}
+				delivered = append(delivered, event)
+			}))
+			defer server.Close()
+
+			trusted, err := security.ParseTrustedProxies(nil)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+			d := NewDispatcher(config.EventsConfig{
+				Webhooks:     []config.WebhookConfig{{URL: server.URL, Secret: secret, Events: tt.events, Timeout: time.Second}},
+				QueueSize:    1,
+				MaxRetries:   tt.maxRetries,
+				RetryBackoff: time.Millisecond,
+			}, trusted, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+			req := httptest.NewRequest("GET", "/auth/oidc/corp/callback", nil)
+			req.RemoteAddr = "203.0.113.7:1234"
+			d.Emit(req, Event{Type: TypeLoginSuccess, Provider: "corp", Subject: "alice"})
+			d.Close()
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if !tt.wantEvent {
+				if len(delivered) > 0 {
+					t.Errorf("delivered %v, want nothing", delivered)
+				}
+				return
+			}
+			if len(delivered) != 1 {
+				t.Fatalf("delivered %d events, want 1", len(delivered))
+			}
+			event := delivered[0]
+			if event.Type != TypeLoginSuccess || event.Provider != "corp" || event.Subject != "alice" || event.IP != "203.0.113.7" {
+				t.Errorf("event = %+v, want login_success for corp/alice from 203.0.113.7", event)
+			}
+			if time.Since(event.Timestamp) > time.Minute {
+				t.Errorf("timestamp = %s, want it set to the emit time", event.Timestamp)
+			}
+		})
+	}
+}
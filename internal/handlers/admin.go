@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// AdminHandler serves the operational endpoints under /auth/admin. Requests
+// must carry the admin secret as a bearer token.
+type AdminHandler struct {
+	secret    string
+	providers map[string]auth.Provider
+	logger    *slog.Logger
+}
+
+type RefreshKeysResponse struct {
+	Provider  string `json:"provider"`
+	Refreshed bool   `json:"refreshed"`
+}
+
+func NewAdminHandler(cfg config.Config, providers map[string]auth.Provider, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		secret:    cfg.Admin.Secret,
+		providers: providers,
+		logger:    logger,
+	}
+}
+
+// RequireSecret rejects requests that do not carry the admin secret.
+func (h *AdminHandler) RequireSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(h.secret), []byte(token)) != 1 {
+			h.logger.Warn("admin request rejected", "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso-switch"`)
+			httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Invalid admin credentials")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeRefreshKeys forces the provider given by the provider query parameter
+// to fetch its discovery document and signing keys again.
+func (h *AdminHandler) ServeRefreshKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	providerID := r.URL.Query().Get("provider")
+	provider, exists := h.providers[providerID]
+	if !exists {
+		httperror.Respond(w, r, http.StatusNotFound, "invalid_provider", "Unknown provider")
+		return
+	}
+
+	refresher, ok := provider.(auth.KeyRefresher)
+	if !ok {
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Provider does not cache signing keys")
+		return
+	}
+
+	if err := refresher.RefreshKeys(r.Context()); err != nil {
+		h.logger.Error("forced key refresh failed", "provider", providerID, "error", err)
+		httperror.Respond(w, r, http.StatusBadGateway, "refresh_failed", "Failed to refresh provider keys")
+		return
+	}
+
+	h.logger.Info("provider keys refreshed", "provider", providerID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RefreshKeysResponse{
+		Provider:  providerID,
+		Refreshed: true,
+	})
+}
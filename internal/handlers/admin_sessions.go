@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// sessionExportAAD binds export payloads to their purpose, so that the key
+// cannot be used to pass off other ciphertexts as session exports.
+const sessionExportAAD = "sso-switch session export v1"
+
+// maxSessionImportSize bounds the body of an import request.
+const maxSessionImportSize = 64 << 20
+
+// SessionTransferHandler exports the live sessions of an instance and imports
+// them into another, to pre-warm a new deployment before cutover. Payloads
+// are sealed with AES-256-GCM under admin.session_export_key.
+type SessionTransferHandler struct {
+	cache  cache.Cache
+	codec  *cache.Codec
+	aead   cipher.AEAD
+	logger *slog.Logger
+}
+
+type ImportSessionsResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+func NewSessionTransferHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, logger *slog.Logger) (*SessionTransferHandler, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.Admin.SessionExportKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session export key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session export key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionTransferHandler{
+		cache:  cache,
+		codec:  codec,
+		aead:   aead,
+		logger: logger,
+	}, nil
+}
+
+// ServeExport responds with every live session, JSON encoded and sealed as
+// nonce || ciphertext || tag.
+func (h *SessionTransferHandler) ServeExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	keys, err := h.cache.Keys(r.Context(), "session:")
+	if err != nil {
+		h.logger.Error("failed to list sessions", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	sessions := make([]auth.Session, 0, len(keys))
+	for _, key := range keys {
+		data, err := h.cache.Get(r.Context(), key)
+		if err != nil {
+			continue
+		}
+
+		var session auth.Session
+		if err := h.codec.Unmarshal(data, &session); err != nil {
+			h.logger.Warn("skipping undecodable session", "key", key, "error", err)
+			continue
+		}
+		session.ID = strings.TrimPrefix(key, "session:")
+		sessions = append(sessions, session)
+	}
+
+	plaintext, err := json.Marshal(sessions)
+	if err != nil {
+		h.logger.Error("failed to encode sessions", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	nonce := make([]byte, h.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		h.logger.Error("failed to generate nonce", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	h.logger.Info("sessions exported", "count", len(sessions))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(h.aead.Seal(nonce, nonce, plaintext, []byte(sessionExportAAD)))
+}
+
+// ServeImport stores the sessions of an export, keeping their IDs and
+// expiry. Sessions that have expired since the export are skipped.
+func (h *SessionTransferHandler) ServeImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	sealed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSessionImportSize))
+	if err != nil {
+		httperror.Respond(w, r, http.StatusRequestEntityTooLarge, "too_large", "Export too large")
+		return
+	}
+
+	nonceSize := h.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_export", "Invalid session export")
+		return
+	}
+	plaintext, err := h.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(sessionExportAAD))
+	if err != nil {
+		h.logger.Warn("session import could not be decrypted", "error", err)
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_export", "Invalid session export")
+		return
+	}
+
+	var sessions []auth.Session
+	if err := json.Unmarshal(plaintext, &sessions); err != nil {
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_export", "Invalid session export")
+		return
+	}
+
+	var response ImportSessionsResponse
+	for i := range sessions {
+		session := &sessions[i]
+		ttl := time.Until(session.ExpiresAt)
+		if session.ID == "" || ttl <= 0 {
+			response.Skipped++
+			continue
+		}
+
+		data, err := h.codec.Marshal(session)
+		if err != nil {
+			h.logger.Error("failed to marshal session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		if err := h.cache.Set(r.Context(), "session:"+session.ID, data, ttl); err != nil {
+			h.logger.Error("failed to cache session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		response.Imported++
+	}
+
+	h.logger.Info("sessions imported", "imported", response.Imported, "skipped", response.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// newTransferTestHandler returns a session transfer handler with a fresh
+// memory cache, sealing exports under key.
+func newTransferTestHandler(t *testing.T, key string) (*SessionTransferHandler, cache.Cache, *cache.Codec) {
+	t.Helper()
+
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("create codec: %v", err)
+	}
+
+	h, err := NewSessionTransferHandler(config.Config{Admin: config.AdminConfig{SessionExportKey: key}}, c, codec, discardLogger())
+	if err != nil {
+		t.Fatalf("NewSessionTransferHandler: %v", err)
+	}
+	return h, c, codec
+}
+
+func TestSessionTransfer(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	otherKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	tests := []struct {
+		name string
+		// importKey is the key of the importing instance.
+		importKey string
+		// tamper changes the export before it is imported.
+		tamper       func(export []byte) []byte
+		wantStatus   int
+		wantImported int
+	}{
+		{name: "round trip", importKey: key, wantStatus: http.StatusOK, wantImported: 2},
+		{name: "different key", importKey: otherKey, wantStatus: http.StatusBadRequest},
+		{
+			name:      "tampered export",
+			importKey: key,
+			tamper: func(export []byte) []byte {
+				export[len(export)-1] ^= 1
+				return export
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "truncated export",
+			importKey:  key,
+			tamper:     func(export []byte) []byte { return export[:4] },
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			source, sourceCache, codec := newTransferTestHandler(t, key)
+			for _, id := range []string{"s1", "s2"} {
+				data, err := codec.Marshal(&auth.Session{
+					ProviderID: "corp",
+					UserInfo:   map[string]interface{}{"sub": id},
+					ExpiresAt:  time.Now().Add(time.Hour),
+				})
+				if err != nil {
+					t.Fatalf("marshal session: %v", err)
+				}
+				if err := sourceCache.Set(ctx, "session:"+id, data, time.Hour); err != nil {
+					t.Fatalf("store session: %v", err)
+				}
+			}
+
+			exportRec := httptest.NewRecorder()
+			source.ServeExport(exportRec, httptest.NewRequest("GET", "/auth/admin/sessions/export", nil))
+			if exportRec.Code != http.StatusOK {
+				t.Fatalf("export status = %d, want %d", exportRec.Code, http.StatusOK)
+			}
+			export := exportRec.Body.Bytes()
+			if bytes.Contains(export, []byte("corp")) {
+				t.Error("export is not encrypted")
+			}
+			if tt.tamper != nil {
+				export = tt.tamper(export)
+			}
+
+			target, targetCache, _ := newTransferTestHandler(t, tt.importKey)
+			importRec := httptest.NewRecorder()
+			target.ServeImport(importRec, httptest.NewRequest("POST", "/auth/admin/sessions/import", bytes.NewReader(export)))
+			if importRec.Code != tt.wantStatus {
+				t.Fatalf("import status = %d, want %d", importRec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response ImportSessionsResponse
+			if err := json.NewDecoder(importRec.Body).Decode(&response); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if response.Imported != tt.wantImported {
+				t.Errorf("imported = %d, want %d", response.Imported, tt.wantImported)
+			}
+			for _, id := range []string{"s1", "s2"} {
+				if exists, _ := targetCache.Exists(ctx, "session:"+id); !exists {
+					t.Errorf("session %s was not imported under its ID", id)
+				}
+			}
+		})
+	}
+}
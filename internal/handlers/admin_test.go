@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// refreshingStubProvider records forced key refreshes.
+type refreshingStubProvider struct {
+	stubProvider
+	err       error
+	refreshed int
+}
+
+func (p *refreshingStubProvider) RefreshKeys(ctx context.Context) error {
+	p.refreshed++
+	return p.err
+}
+
+func TestAdminRefreshKeys(t *testing.T) {
+	const secret = "0123456789abcdef"
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		provider      string
+		refreshErr    error
+		wantStatus    int
+		wantRefreshed int
+	}{
+		{name: "refreshed", method: "POST", authorization: "Bearer " + secret, provider: "corp", wantStatus: http.StatusOK, wantRefreshed: 1},
+		{name: "missing secret", method: "POST", provider: "corp", wantStatus: http.StatusUnauthorized},
+		{name: "wrong secret", method: "POST", authorization: "Bearer fedcba9876543210", provider: "corp", wantStatus: http.StatusUnauthorized},
+		{name: "get", method: "GET", authorization: "Bearer " + secret, provider: "corp", wantStatus: http.StatusMethodNotAllowed},
+		{name: "unknown provider", method: "POST", authorization: "Bearer " + secret, provider: "other", wantStatus: http.StatusNotFound},
+		{name: "no key cache", method: "POST", authorization: "Bearer " + secret, provider: "saml", wantStatus: http.StatusBadRequest},
+		{name: "refresh failed", method: "POST", authorization: "Bearer " + secret, provider: "corp", refreshErr: errors.New("idp down"), wantStatus: http.StatusBadGateway, wantRefreshed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corp := &refreshingStubProvider{stubProvider: stubProvider{id: "corp"}, err: tt.refreshErr}
+			providers := map[string]auth.Provider{"corp": corp, "saml": &stubProvider{id: "saml"}}
+			h := NewAdminHandler(config.Config{Admin: config.AdminConfig{Secret: secret}}, providers, discardLogger())
+
+			req := httptest.NewRequest(tt.method, "/auth/admin/refresh-keys?provider="+tt.provider, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.RequireSecret(http.HandlerFunc(h.ServeRefreshKeys)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if corp.refreshed != tt.wantRefreshed {
+				t.Errorf("refreshed %d times, want %d", corp.refreshed, tt.wantRefreshed)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp RefreshKeysResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Provider != "corp" || !resp.Refreshed {
+				t.Errorf("response = %+v, want corp refreshed", resp)
+			}
+		})
+	}
+}
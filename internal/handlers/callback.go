@@ -1,32 +1,49 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/saml"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
 	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
 type CallbackHandler struct {
 	cfg       config.Config
 	cache     cache.Cache
+	codec     *cache.Codec
 	providers map[string]auth.Provider
+	events    *events.Dispatcher
 	logger    *slog.Logger
+	errorPage *ForbiddenHandler
 }
 
-func NewCallbackHandler(cfg config.Config, cache cache.Cache, providers map[string]auth.Provider, logger *slog.Logger) *CallbackHandler {
+func NewCallbackHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, events *events.Dispatcher, logger *slog.Logger) (*CallbackHandler, error) {
+	errorPage, err := NewForbiddenHandler(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CallbackHandler{
 		cfg:       cfg,
 		cache:     cache,
+		codec:     codec,
 		providers: providers,
+		events:    events,
 		logger:    logger,
-	}
+		errorPage: errorPage,
+	}, nil
 }
 
 func (h *CallbackHandler) HandleOIDCCallback(providerID string) http.HandlerFunc {
@@ -34,43 +51,32 @@ func (h *CallbackHandler) HandleOIDCCallback(providerID string) http.HandlerFunc
 		provider, exists := h.providers[providerID]
 		if !exists {
 			h.logger.Error("provider not found", "provider_id", providerID)
-			http.Error(w, "Invalid provider", http.StatusBadRequest)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
 			return
 		}
 
 		session, err := provider.HandleCallback(r.Context(), r)
 		if err != nil {
 			h.logger.Error("callback failed", "provider", providerID, "error", err)
-			http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+			h.events.Emit(r, events.Event{Type: events.TypeLoginFailure, Provider: providerID, Error: err.Error()})
+			h.loginFailed(w, r, err)
 			return
 		}
 
-		sessionID := uuid.New().String()
-		session.ID = sessionID
-
-		sessionData, err := json.Marshal(session)
+		sessionID, err := storeSession(w, r, h.cache, h.codec, h.cfg, session, h.logger)
 		if err != nil {
-			h.logger.Error("failed to marshal session", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.logger.Error("failed to store session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 			return
 		}
 
-		ttl := time.Until(session.ExpiresAt)
-		if err := h.cache.Set(r.Context(), "session:"+sessionID, sessionData, ttl); err != nil {
-			h.logger.Error("failed to cache session", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		cookie := security.CreateSessionCookie(h.cfg.Server, sessionID, ttl)
-		http.SetCookie(w, cookie)
-
 		h.logger.Info("authentication successful",
 			"provider", providerID,
 			"session_id", sessionID,
 		)
+		h.emitLogin(r, session)
 
-		http.Redirect(w, r, "/", http.StatusFound)
+		http.Redirect(w, r, h.cfg.Server.PostLoginRedirect, http.StatusFound)
 	}
 }
 
@@ -79,47 +85,191 @@ func (h *CallbackHandler) HandleSAMLCallback(providerID string) http.HandlerFunc
 		provider, exists := h.providers[providerID]
 		if !exists {
 			h.logger.Error("provider not found", "provider_id", providerID)
-			http.Error(w, "Invalid provider", http.StatusBadRequest)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
 			return
 		}
 
 		session, err := provider.HandleCallback(r.Context(), r)
 		if err != nil {
 			h.logger.Error("SAML callback failed", "provider", providerID, "error", err)
-			http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+			h.events.Emit(r, events.Event{Type: events.TypeLoginFailure, Provider: providerID, Error: err.Error()})
+			h.loginFailed(w, r, err)
 			return
 		}
 
-		sessionID := uuid.New().String()
-		session.ID = sessionID
-
-		sessionData, err := json.Marshal(session)
+		sessionID, err := storeSession(w, r, h.cache, h.codec, h.cfg, session, h.logger)
 		if err != nil {
-			h.logger.Error("failed to marshal session", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.logger.Error("failed to store session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 			return
 		}
 
-		ttl := time.Until(session.ExpiresAt)
-		if err := h.cache.Set(r.Context(), "session:"+sessionID, sessionData, ttl); err != nil {
-			h.logger.Error("failed to cache session", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		cookie := security.CreateSessionCookie(h.cfg.Server, sessionID, ttl)
-		http.SetCookie(w, cookie)
-
 		h.logger.Info("SAML authentication successful",
 			"provider", providerID,
 			"session_id", sessionID,
 		)
+		h.emitLogin(r, session)
 
 		relayState := r.FormValue("RelayState")
+		if relayState != "" && !allowedRedirect(relayState, h.cfg.Server) {
+			h.logger.Warn("ignoring RelayState outside the allowed redirect domains", "provider", providerID, "relay_state", relayState)
+			relayState = ""
+		}
 		if relayState != "" {
 			http.Redirect(w, r, relayState, http.StatusFound)
 		} else {
-			http.Redirect(w, r, "/", http.StatusFound)
+			http.Redirect(w, r, h.cfg.Server.PostLoginRedirect, http.StatusFound)
+		}
+	}
+}
+
+// HandleSharedSAMLCallback serves the shared ACS, dispatching each response
+// to the provider registered for its Issuer in issuers.
+func (h *CallbackHandler) HandleSharedSAMLCallback(issuers map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_request", "Invalid form data")
+			return
+		}
+
+		issuer, err := saml.ResponseIssuer(r.PostForm.Get("SAMLResponse"))
+		if err != nil {
+			h.logger.Warn("shared ACS could not read issuer", "error", err)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_request", "Invalid SAML response")
+			return
+		}
+
+		providerID, exists := issuers[issuer]
+		if !exists {
+			h.logger.Warn("shared ACS received response from unknown issuer", "issuer", issuer)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Unknown SAML issuer")
+			return
+		}
+
+		h.HandleSAMLCallback(providerID)(w, r)
+	}
+}
+
+func storeSession(w http.ResponseWriter, r *http.Request, c cache.Cache, codec *cache.Codec, cfg config.Config, session *auth.Session, logger *slog.Logger) (string, error) {
+	serverCfg := cfg.Server
+	sessionID := uuid.New().String()
+	session.ID = sessionID
+	session.AssuranceLevel = auth.AssuranceLevel(session.UserInfo, cfg.AssuranceLevels)
+	session.AuthTime, _ = auth.AuthTime(session.UserInfo)
+	session.UserInfo = auth.FilterClaims(session.UserInfo, serverCfg.SessionClaims)
+
+	expiresAt := session.ExpiresAt
+	if auth.CapExpiry(session, serverCfg.MaxTokenTTL, time.Now()) {
+		logger.Info("capped session expiry to max_token_ttl",
+			"provider", session.ProviderID,
+			"session_id", sessionID,
+			"expires_at", expiresAt,
+			"capped_to", session.ExpiresAt,
+		)
+	}
+
+	sessionData, err := codec.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	ttl := time.Until(session.ExpiresAt)
+	if err := c.Set(r.Context(), "session:"+sessionID, sessionData, ttl); err != nil {
+		return "", fmt.Errorf("failed to cache session: %w", err)
+	}
+
+	if serverCfg.SingleSessionPerUser {
+		if err := replaceUserSession(r, c, session, ttl); err != nil {
+			return "", err
+		}
+	}
+
+	if serverCfg.ConcurrentLoginPolicy != "allow" {
+		if _, err := middleware.TrackUserSession(r.Context(), c, codec, session, ttl); err != nil {
+			return "", err
+		}
+	}
+
+	cookie := security.CreateSessionCookie(serverCfg, sessionID, ttl)
+	security.SetSessionCookie(w, r, serverCfg, cookie)
+	middleware.ResetRedirectCount(w)
+
+	return sessionID, nil
+}
+
+// emitLogin emits login_success for session and, under concurrent_login_policy
+// notify, concurrent_login when its user already had other active sessions.
+func (h *CallbackHandler) emitLogin(r *http.Request, session *auth.Session) {
+	subject := auth.Subject(session)
+	h.events.Emit(r, events.Event{Type: events.TypeLoginSuccess, Provider: session.ProviderID, Subject: subject})
+
+	if h.cfg.Server.ConcurrentLoginPolicy != "notify" {
+		return
+	}
+
+	count, err := middleware.CountUserSessions(r.Context(), h.cache, h.codec, session)
+	if err != nil {
+		h.logger.Warn("failed to count user sessions", "session_id", session.ID, "error", err)
+		return
+	}
+	if count > 1 {
+		h.logger.Info("concurrent login", "provider", session.ProviderID, "subject", subject, "sessions", count)
+		h.events.Emit(r, events.Event{Type: events.TypeConcurrentLogin, Provider: session.ProviderID, Subject: subject, Sessions: count})
+	}
+}
+
+// replaceUserSession records session as the only session of its user and
+// provider, deleting the one it replaces.
+func replaceUserSession(r *http.Request, c cache.Cache, session *auth.Session, ttl time.Duration) error {
+	subject := auth.Subject(session)
+	if subject == "" {
+		return nil
+	}
+
+	key := "user_session:" + session.ProviderID + ":" + subject
+	if previous, err := c.Get(r.Context(), key); err == nil && string(previous) != session.ID {
+		if err := c.Delete(r.Context(), "session:"+string(previous)); err != nil {
+			return fmt.Errorf("failed to delete previous session: %w", err)
 		}
 	}
+
+	if err := c.Set(r.Context(), key, []byte(session.ID), ttl); err != nil {
+		return fmt.Errorf("failed to cache user session: %w", err)
+	}
+	return nil
+}
+
+// loginFailed answers a failed callback. Under remember_last_provider the
+// remembered provider is forgotten and browsers get a page linking to the
+// select page, since a returning user sent straight to the provider never
+// saw it.
+func (h *CallbackHandler) loginFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if !h.cfg.UI.RememberLastProvider {
+		respondLoginError(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	http.SetCookie(w, lastProviderCookie(h.cfg.Server, "", -time.Second))
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		respondLoginError(w, r, http.StatusUnauthorized, err)
+		return
+	}
+	status, code, message := loginError(http.StatusUnauthorized, err)
+	h.errorPage.render(w, r, status, code, "Sign-in failed", message)
+}
+
+// respondLoginError answers a failed login, see loginError.
+func respondLoginError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	status, code, message := loginError(status, err)
+	httperror.Respond(w, r, status, code, message)
+}
+
+// loginError returns the status, error code and message of a failed login.
+// Logins rejected by accept_if get a 403 with the configured message alone.
+func loginError(status int, err error) (int, string, string) {
+	var acceptErr *auth.AcceptanceError
+	if errors.As(err, &acceptErr) {
+		return http.StatusForbidden, "login_not_accepted", acceptErr.Message
+	}
+	return status, "authentication_failed", "Authentication failed: " + err.Error()
 }
@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+func TestRespondLoginError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "rejected by accept_if",
+			err:        &auth.AcceptanceError{Message: "Only employees can sign in"},
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Only employees can sign in\n",
+		},
+		{
+			name:       "wrapped rejection",
+			err:        fmt.Errorf("saml: %w", &auth.AcceptanceError{Message: "Only employees can sign in"}),
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Only employees can sign in\n",
+		},
+		{
+			name:       "other failure",
+			err:        errors.New("invalid state"),
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "Authentication failed: invalid state\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/oidc/corp/callback", nil)
+			req.Header.Set("Accept", "text/plain")
+			rec := httptest.NewRecorder()
+
+			respondLoginError(rec, req, http.StatusUnauthorized, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+// samlStubProvider accepts every SAML response, counting the callbacks it
+// handled.
+type samlStubProvider struct {
+	auth.Provider
+	id    string
+	calls int
+}
+
+func (p *samlStubProvider) ID() string   { return p.id }
+func (p *samlStubProvider) Type() string { return "saml" }
+
+func (p *samlStubProvider) HandleCallback(ctx context.Context, req *http.Request) (*auth.Session, error) {
+	p.calls++
+	return &auth.Session{
+		ProviderID:   p.id,
+		ProviderType: "saml",
+		UserInfo:     map[string]interface{}{"name_id": "alice"},
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestHandleSharedSAMLCallback(t *testing.T) {
+	response := func(xml string) string {
+		return base64.StdEncoding.EncodeToString([]byte(xml))
+	}
+
+	tests := []struct {
+		name         string
+		samlResponse string
+		wantProvider string
+		wantStatus   int
+		wantError    string
+	}{
+		{
+			name:         "first issuer",
+			samlResponse: response(`<Response><Issuer>https://okta.example.com</Issuer></Response>`),
+			wantProvider: "okta",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:         "second issuer",
+			samlResponse: response(`<Response><Issuer> https://adfs.example.com/trust </Issuer></Response>`),
+			wantProvider: "adfs",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:         "issuer of the assertion",
+			samlResponse: response(`<Response><Assertion><Issuer>https://adfs.example.com/trust</Issuer></Assertion></Response>`),
+			wantProvider: "adfs",
+			wantStatus:   http.StatusFound,
+		},
+		{
+			name:         "unknown issuer",
+			samlResponse: response(`<Response><Issuer>https://evil.example.com</Issuer></Response>`),
+			wantStatus:   http.StatusBadRequest,
+			wantError:    "invalid_provider",
+		},
+		{
+			name:         "no issuer",
+			samlResponse: response(`<Response></Response>`),
+			wantStatus:   http.StatusBadRequest,
+			wantError:    "invalid_request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("create codec: %v", err)
+			}
+			trusted, err := security.ParseTrustedProxies(nil)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+
+			okta := &samlStubProvider{id: "okta"}
+			adfs := &samlStubProvider{id: "adfs"}
+			cfg := config.Config{Server: config.ServerConfig{
+				CookieName:            "session",
+				ConcurrentLoginPolicy: "allow",
+				PostLoginRedirect:     "/",
+			}}
+			h, err := NewCallbackHandler(cfg, c, codec, map[string]auth.Provider{"okta": okta, "adfs": adfs},
+				events.NewDispatcher(config.EventsConfig{}, trusted, discardLogger()), discardLogger())
+			if err != nil {
+				t.Fatalf("NewCallbackHandler: %v", err)
+			}
+			issuers := map[string]string{
+				"https://okta.example.com":       "okta",
+				"https://adfs.example.com/trust": "adfs",
+			}
+
+			form := url.Values{"SAMLResponse": {tt.samlResponse}}
+			req := httptest.NewRequest("POST", "/auth/saml/acs", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			h.HandleSharedSAMLCallback(issuers)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			for _, p := range []*samlStubProvider{okta, adfs} {
+				want := 0
+				if p.id == tt.wantProvider {
+					want = 1
+				}
+				if p.calls != want {
+					t.Errorf("provider %s handled %d responses, want %d", p.id, p.calls, want)
+				}
+			}
+			if tt.wantError != "" && !strings.Contains(rec.Body.String(), `"error":"`+tt.wantError+`"`) {
+				t.Errorf("body = %s, want error %s", rec.Body, tt.wantError)
+			}
+		})
+	}
+}
+
+// failingCallbackProvider rejects every callback.
+type failingCallbackProvider struct {
+	auth.Provider
+}
+
+func (p *failingCallbackProvider) HandleCallback(ctx context.Context, req *http.Request) (*auth.Session, error) {
+	return nil, &auth.AcceptanceError{Message: "Only employees can sign in"}
+}
+
+func TestLoginFailedRememberedProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		remember    bool
+		accept      string
+		wantCleared bool
+		wantLink    bool
+	}{
+		{name: "disabled", accept: "text/html"},
+		{name: "browser", remember: true, accept: "text/html", wantCleared: true, wantLink: true},
+		{name: "api client", remember: true, accept: "application/json", wantCleared: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := security.ParseTrustedProxies(nil)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+			cfg := config.Config{UI: config.UIConfig{RememberLastProvider: tt.remember}}
+			h, err := NewCallbackHandler(cfg, nil, nil, map[string]auth.Provider{"corp": &failingCallbackProvider{}},
+				events.NewDispatcher(config.EventsConfig{}, trusted, discardLogger()), discardLogger())
+			if err != nil {
+				t.Fatalf("NewCallbackHandler: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", "/auth/oidc/corp/callback?code=x&state=y", nil)
+			req.Header.Set("Accept", tt.accept)
+			req.AddCookie(&http.Cookie{Name: LastProviderCookieName, Value: "corp"})
+			rec := httptest.NewRecorder()
+			h.HandleOIDCCallback("corp")(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if !strings.Contains(rec.Body.String(), "Only employees can sign in") {
+				t.Errorf("body = %q, want the rejection message", rec.Body)
+			}
+			if got := strings.Contains(rec.Body.String(), `href="/auth/select?choose"`); got != tt.wantLink {
+				t.Errorf("choose link rendered = %v, want %v", got, tt.wantLink)
+			}
+
+			cleared := false
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == LastProviderCookieName && cookie.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if cleared != tt.wantCleared {
+				t.Errorf("last provider cleared = %v, want %v", cleared, tt.wantCleared)
+			}
+		})
+	}
+}
+
+func TestStoreSessionSingleSessionPerUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		single        bool
+		second        map[string]interface{}
+		secondID      string
+		wantFirstKept bool
+	}{
+		{name: "second login replaces the first", single: true, second: map[string]interface{}{"sub": "alice"}},
+		{name: "disabled", second: map[string]interface{}{"sub": "alice"}, wantFirstKept: true},
+		{name: "other user", single: true, second: map[string]interface{}{"sub": "bob"}, wantFirstKept: true},
+		{name: "other provider", single: true, second: map[string]interface{}{"sub": "alice"}, secondID: "partner", wantFirstKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("NewMemoryCache: %v", err)
+			}
+			t.Cleanup(func() { c.Close() })
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+			cfg := config.Config{Server: config.ServerConfig{
+				CookieName:            "session",
+				SingleSessionPerUser:  tt.single,
+				ConcurrentLoginPolicy: "allow",
+			}}
+
+			login := func(providerID string, userInfo map[string]interface{}) string {
+				t.Helper()
+				session := &auth.Session{ProviderID: providerID, ProviderType: "oidc", UserInfo: userInfo, ExpiresAt: time.Now().Add(time.Hour)}
+				req := httptest.NewRequest("GET", "/auth/oidc/"+providerID+"/callback", nil)
+				id, err := storeSession(httptest.NewRecorder(), req, c, codec, cfg, session, discardLogger())
+				if err != nil {
+					t.Fatalf("storeSession: %v", err)
+				}
+				return id
+			}
+
+			first := login("corp", map[string]interface{}{"sub": "alice"})
+			secondProvider := tt.secondID
+			if secondProvider == "" {
+				secondProvider = "corp"
+			}
+			second := login(secondProvider, tt.second)
+
+			if exists, _ := c.Exists(t.Context(), "session:"+first); exists != tt.wantFirstKept {
+				t.Errorf("first session exists = %v, want %v", exists, tt.wantFirstKept)
+			}
+			if exists, _ := c.Exists(t.Context(), "session:"+second); !exists {
+				t.Error("second session not stored")
+			}
+		})
+	}
+}
+
+func TestStoreSessionClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		want    []string
+	}{
+		{name: "all claims", want: []string{"email", "photo", "sub"}},
+		{name: "allowlisted", allowed: []string{"email"}, want: []string{"email", "sub"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("NewMemoryCache: %v", err)
+			}
+			t.Cleanup(func() { c.Close() })
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+			cfg := config.Config{Server: config.ServerConfig{
+				CookieName:            "session",
+				ConcurrentLoginPolicy: "allow",
+				SessionClaims:         tt.allowed,
+			}}
+
+			session := &auth.Session{
+				ProviderID:   "corp",
+				ProviderType: "oidc",
+				UserInfo:     map[string]interface{}{"sub": "alice", "email": "alice@example.com", "photo": "data:image/png;base64,AAAA"},
+				ExpiresAt:    time.Now().Add(time.Hour),
+			}
+			req := httptest.NewRequest("GET", "/auth/oidc/corp/callback", nil)
+			id, err := storeSession(httptest.NewRecorder(), req, c, codec, cfg, session, discardLogger())
+			if err != nil {
+				t.Fatalf("storeSession: %v", err)
+			}
+
+			data, err := c.Get(t.Context(), "session:"+id)
+			if err != nil {
+				t.Fatalf("get session: %v", err)
+			}
+			var stored auth.Session
+			if err := codec.Unmarshal(data, &stored); err != nil {
+				t.Fatalf("decode session: %v", err)
+			}
+			var got []string
+			for claim := range stored.UserInfo {
+				got = append(got, claim)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("stored claims = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreSessionAuthTime(t *testing.T) {
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryCache: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	// session_claims drops authn_instant, but the session keeps the time.
+	cfg := config.Config{Server: config.ServerConfig{
+		CookieName:            "session",
+		ConcurrentLoginPolicy: "allow",
+		SessionClaims:         []string{"email"},
+	}}
+
+	session := &auth.Session{
+		ProviderID:   "partner",
+		ProviderType: "saml",
+		UserInfo:     map[string]interface{}{"name_id": "alice", auth.AuthnInstantClaim: "2026-03-01T09:30:00Z"},
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	req := httptest.NewRequest("POST", "/auth/saml/partner/acs", nil)
+	id, err := storeSession(httptest.NewRecorder(), req, c, codec, cfg, session, discardLogger())
+	if err != nil {
+		t.Fatalf("storeSession: %v", err)
+	}
+
+	data, err := c.Get(t.Context(), "session:"+id)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	var stored auth.Session
+	if err := codec.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("decode session: %v", err)
+	}
+	if want := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC); !stored.AuthTime.Equal(want) {
+		t.Errorf("AuthTime = %v, want %v", stored.AuthTime, want)
+	}
+	if _, ok := stored.UserInfo[auth.AuthnInstantClaim]; ok {
+		t.Errorf("%s kept despite session_claims", auth.AuthnInstantClaim)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/oidc"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+// maxElevationAuthAge bounds the auth_time of the ID token returned for an
+// elevation, in case the IdP ignores prompt=login.
+const maxElevationAuthAge = 5 * time.Minute
+
+// ElevateHandler lets a logged-in OIDC user re-authenticate interactively to
+// mark their existing session elevated for server.elevation_window. Both
+// endpoints run behind the auth middleware.
+type ElevateHandler struct {
+	cfg       config.Config
+	cache     cache.Cache
+	codec     *cache.Codec
+	providers map[string]auth.Provider
+	logger    *slog.Logger
+}
+
+func NewElevateHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, logger *slog.Logger) *ElevateHandler {
+	return &ElevateHandler{
+		cfg:       cfg,
+		cache:     cache,
+		codec:     codec,
+		providers: providers,
+		logger:    logger,
+	}
+}
+
+// HandleInitiate sends the user to their provider with prompt=login. The
+// optional rd parameter is the local path to return to afterwards.
+func (h *ElevateHandler) HandleInitiate(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetSession(r.Context())
+	if !ok || session.ProviderType == auth.ServiceProviderType {
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	provider, ok := h.providers[session.ProviderID].(*oidc.Provider)
+	if !ok {
+		httperror.Respond(w, r, http.StatusBadRequest, "elevation_unsupported", "Provider does not support elevation")
+		return
+	}
+
+	redirectURL := h.cfg.Server.BaseURL + auth.OIDCElevateCallbackPath(session.ProviderID)
+
+	authRedirect, err := provider.InitiateElevation(r.Context(), redirectURL)
+	if err != nil {
+		h.logger.Error("failed to initiate elevation", "provider", session.ProviderID, "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "auth_initiation_failed", "Failed to initiate authentication")
+		return
+	}
+
+	if err := cacheAuthRedirect(r.Context(), h.cache, h.codec, authRedirect); err != nil {
+		h.logger.Error("failed to cache auth state", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	if rd := r.URL.Query().Get("rd"); isLocalPath(rd) {
+		if err := h.cache.Set(r.Context(), "elevate:rd:"+session.ID, []byte(rd), 5*time.Minute); err != nil {
+			h.logger.Warn("failed to cache elevation return path", "error", err)
+		}
+	}
+
+	http.Redirect(w, r, authRedirect.URL, http.StatusFound)
+}
+
+// HandleCallback marks the current session elevated when the fresh login is
+// for the same user.
+func (h *ElevateHandler) HandleCallback(providerID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := middleware.GetSession(r.Context())
+		if !ok || session.ProviderID != providerID {
+			httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+			return
+		}
+
+		provider, exists := h.providers[providerID]
+		if !exists {
+			h.logger.Error("provider not found", "provider_id", providerID)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
+			return
+		}
+
+		fresh, err := provider.HandleCallback(r.Context(), r)
+		if err != nil {
+			h.logger.Warn("elevation failed", "provider", providerID, "error", err)
+			respondLoginError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		if auth.Subject(fresh) != auth.Subject(session) {
+			h.logger.Warn("elevation subject mismatch", "provider", providerID, "session_id", session.ID)
+			httperror.Respond(w, r, http.StatusForbidden, "elevation_failed", "Re-authenticated as a different user")
+			return
+		}
+
+		if err := checkElevationAuthTime(fresh.UserInfo); err != nil {
+			h.logger.Warn("elevation login was not fresh", "provider", providerID, "session_id", session.ID, "error", err)
+			httperror.Respond(w, r, http.StatusForbidden, "elevation_failed", "Authentication was not fresh")
+			return
+		}
+
+		session.ElevatedUntil = time.Now().Add(h.cfg.Server.ElevationWindow)
+		if session.ElevatedUntil.After(session.ExpiresAt) {
+			session.ElevatedUntil = session.ExpiresAt
+		}
+
+		sessionData, err := h.codec.Marshal(session)
+		if err != nil {
+			h.logger.Error("failed to marshal session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+		if err := h.cache.Set(r.Context(), "session:"+session.ID, sessionData, time.Until(session.ExpiresAt)); err != nil {
+			h.logger.Error("failed to update session in cache", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+
+		h.logger.Info("session elevated",
+			"provider", providerID,
+			"session_id", session.ID,
+			"elevated_until", session.ElevatedUntil,
+		)
+
+		target := h.cfg.Server.PostLoginRedirect
+		if rd, err := h.cache.Get(r.Context(), "elevate:rd:"+session.ID); err == nil {
+			h.cache.Delete(r.Context(), "elevate:rd:"+session.ID)
+			target = string(rd)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// checkElevationAuthTime requires the auth_time of an elevation login, which
+// the IdP must send for max_age=0, to be within maxElevationAuthAge. Without
+// it an IdP ignoring prompt=login could elevate from an old IdP session.
+func checkElevationAuthTime(claims map[string]interface{}) error {
+	authTime, ok := auth.AuthTime(claims)
+	if !ok {
+		return errors.New("ID token has no auth_time")
+	}
+	if age := time.Since(authTime); age > maxElevationAuthAge {
+		return fmt.Errorf("user authenticated %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// isLocalPath reports whether path is safe to redirect to: an absolute path
+// on this host, not a scheme-relative URL.
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
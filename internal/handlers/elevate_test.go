@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckElevationAuthTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "fresh", claims: map[string]interface{}{"auth_time": float64(time.Now().Add(-time.Minute).Unix())}},
+		{name: "stale", claims: map[string]interface{}{"auth_time": float64(time.Now().Add(-time.Hour).Unix())}, wantErr: true},
+		{name: "missing", claims: map[string]interface{}{"sub": "alice"}, wantErr: true},
+		{name: "saml authn instant", claims: map[string]interface{}{"authn_instant": time.Now().UTC().Format(time.RFC3339)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkElevationAuthTime(tt.claims)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkElevationAuthTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
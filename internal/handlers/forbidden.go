@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// ForbiddenHandler renders the branded access denied page, shown in place of
+// backend 401/403 responses when on_backend_unauthorized is branded, the no
+// route page shown under on_no_route branded_error, and failed logins under
+// remember_last_provider.
+type ForbiddenHandler struct {
+	cfg      config.Config
+	logger   *slog.Logger
+	template *template.Template
+}
+
+type ForbiddenPageData struct {
+	PageTitle     string
+	GradientStart string
+	GradientEnd   string
+	LogoURL       string
+	SignInURL     string
+	Heading       string
+	Message       string
+}
+
+func NewForbiddenHandler(cfg config.Config, logger *slog.Logger) (*ForbiddenHandler, error) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/forbidden.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForbiddenHandler{
+		cfg:      cfg,
+		logger:   logger,
+		template: tmpl,
+	}, nil
+}
+
+func (h *ForbiddenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.render(w, r, http.StatusForbidden, "forbidden", "Access denied", "You are signed in, but not allowed to access this page.")
+}
+
+// NoRoute returns the handler for signed-in users that match no backend
+// route.
+func (h *ForbiddenHandler) NoRoute() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.render(w, r, http.StatusNotFound, "no_route", "No application", "You are signed in, but no application is available for your account.")
+	})
+}
+
+func (h *ForbiddenHandler) render(w http.ResponseWriter, r *http.Request, status int, code, heading, message string) {
+	logoURL := ""
+	if h.cfg.UI.LogoPath != "" {
+		logoURL = "/auth/select/logo"
+	}
+
+	data := ForbiddenPageData{
+		PageTitle:     h.cfg.UI.Title,
+		GradientStart: h.cfg.UI.GradientStart,
+		GradientEnd:   h.cfg.UI.GradientEnd,
+		LogoURL:       logoURL,
+		SignInURL:     "/auth/select?" + ChooseProviderParam,
+		Heading:       heading,
+		Message:       message,
+	}
+
+	var page bytes.Buffer
+	if err := h.template.Execute(&page, data); err != nil {
+		h.logger.Error("failed to render template", "error", err)
+		httperror.Respond(w, r, status, code, heading)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(page.Bytes())
+}
@@ -31,16 +31,28 @@ func NewHealthHandler(cfg config.Config, cache cache.Cache, providers map[string
 }
 
 type HealthResponse struct {
-	Status    string              `json:"status"`
-	Uptime    string              `json:"uptime"`
-	Cache     CacheHealth         `json:"cache"`
-	Backend   BackendHealth       `json:"backend"`
-	Providers map[string]string   `json:"providers"`
+	Status    string            `json:"status"`
+	Uptime    string            `json:"uptime"`
+	Cache     CacheHealth       `json:"cache"`
+	Backend   *BackendHealth    `json:"backend,omitempty"`
+	Providers map[string]string `json:"providers"`
 }
 
 type CacheHealth struct {
-	Type   string `json:"type"`
-	Status string `json:"status"`
+	Type      string           `json:"type"`
+	Status    string           `json:"status"`
+	LatencyMS *float64         `json:"latency_ms,omitempty"`
+	Pool      *CachePoolHealth `json:"pool,omitempty"`
+	Entries   *int             `json:"entries,omitempty"`
+}
+
+type CachePoolHealth struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
 }
 
 type BackendHealth struct {
@@ -67,18 +79,29 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.cache.Delete(ctx, "health:check")
 	}
 
-	response.Backend.URL = h.cfg.Backend.URL
-	backendResp, err := http.Get(h.cfg.Backend.URL)
-	if err != nil {
-		response.Backend.Status = "unreachable"
-		response.Status = "degraded"
-	} else {
-		backendResp.Body.Close()
-		response.Backend.Status = "reachable"
+	h.addCacheStats(ctx, &response)
+
+	if h.cfg.Server.Mode == config.ModeProxy {
+		response.Backend = &BackendHealth{URL: h.cfg.Backend.URL}
+		backendResp, err := http.Get(h.cfg.Backend.URL)
+		if err != nil {
+			response.Backend.Status = "unreachable"
+			response.Status = "degraded"
+		} else {
+			backendResp.Body.Close()
+			response.Backend.Status = "reachable"
+		}
 	}
 
 	for id, provider := range h.providers {
 		response.Providers[id] = provider.Name() + " (" + provider.Type() + ")"
+
+		if reporter, ok := provider.(auth.HealthReporter); ok {
+			if err := reporter.Health(); err != nil {
+				response.Providers[id] += ": " + err.Error()
+				response.Status = "degraded"
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -88,3 +111,31 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(response)
 }
+
+func (h *HealthHandler) addCacheStats(ctx context.Context, response *HealthResponse) {
+	switch c := cache.Unwrap(h.cache).(type) {
+	case *cache.RedisCache:
+		latency, err := c.Ping(ctx)
+		if err != nil {
+			response.Cache.Status = "error: " + err.Error()
+			response.Status = "degraded"
+		} else {
+			latencyMS := float64(latency.Microseconds()) / 1000
+			response.Cache.LatencyMS = &latencyMS
+		}
+
+		stats := c.PoolStats()
+		response.Cache.Pool = &CachePoolHealth{
+			Hits:       stats.Hits,
+			Misses:     stats.Misses,
+			Timeouts:   stats.Timeouts,
+			TotalConns: stats.TotalConns,
+			IdleConns:  stats.IdleConns,
+			StaleConns: stats.StaleConns,
+		}
+
+	case *cache.MemoryCache:
+		entries := c.Len()
+		response.Cache.Entries = &entries
+	}
+}
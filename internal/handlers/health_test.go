@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// newFakeRedis starts a server speaking enough RESP for the health check:
+// PING, SET and DEL succeed and every other command is unknown, which
+// go-redis tolerates during its connection handshake.
+func newFakeRedis(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeRedis(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveFakeRedis(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "SET":
+			reply = "+OK\r\n"
+		case "DEL":
+			reply = ":1\r\n"
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command header %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestHealthHandlerCacheStats(t *testing.T) {
+	memoryCache, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryCache: %v", err)
+	}
+	t.Cleanup(func() { memoryCache.Close() })
+	if err := memoryCache.Set(t.Context(), "session:s1", []byte("{}"), time.Hour); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	redisCache, err := cache.NewRedisCache(config.RedisConfig{
+		Address:     newFakeRedis(t),
+		DialTimeout: time.Second,
+		ReadTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisCache: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	tests := []struct {
+		name        string
+		cacheType   string
+		cache       cache.Cache
+		wantEntries int
+		wantLatency bool
+		wantPool    bool
+	}{
+		{name: "memory", cacheType: "memory", cache: memoryCache, wantEntries: 1},
+		{name: "memory with timeout", cacheType: "memory", cache: cache.WithTimeout(memoryCache, time.Second), wantEntries: 1},
+		{name: "redis", cacheType: "redis", cache: redisCache, wantLatency: true, wantPool: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				Server: config.ServerConfig{Mode: config.ModeAuthOnly},
+				Cache:  config.CacheConfig{Type: tt.cacheType},
+			}
+			h := NewHealthHandler(cfg, tt.cache, map[string]auth.Provider{}, discardLogger())
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var response HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+
+			if response.Cache.Status != "connected" {
+				t.Errorf("cache status = %q, want connected", response.Cache.Status)
+			}
+			if tt.wantEntries > 0 && (response.Cache.Entries == nil || *response.Cache.Entries != tt.wantEntries) {
+				t.Errorf("entries = %v, want %d", response.Cache.Entries, tt.wantEntries)
+			}
+			if tt.wantEntries == 0 && response.Cache.Entries != nil {
+				t.Errorf("entries = %d, want none", *response.Cache.Entries)
+			}
+			if got := response.Cache.LatencyMS != nil; got != tt.wantLatency {
+				t.Errorf("latency reported = %v, want %v", got, tt.wantLatency)
+			}
+			if tt.wantLatency && *response.Cache.LatencyMS < 0 {
+				t.Errorf("latency = %f, want it non-negative", *response.Cache.LatencyMS)
+			}
+			if got := response.Cache.Pool != nil; got != tt.wantPool {
+				t.Errorf("pool reported = %v, want %v", got, tt.wantPool)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+// KeepaliveHandler extends the proxy session of an active user by
+// session_ttl, without navigating or refreshing IdP tokens. Requests must
+// carry an X-Requested-With header, which cross-site forms cannot set.
+type KeepaliveHandler struct {
+	cfg    config.Config
+	cache  cache.Cache
+	codec  *cache.Codec
+	logger *slog.Logger
+}
+
+type KeepaliveResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewKeepaliveHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, logger *slog.Logger) *KeepaliveHandler {
+	return &KeepaliveHandler{
+		cfg:    cfg,
+		cache:  cache,
+		codec:  codec,
+		logger: logger,
+	}
+}
+
+func (h *KeepaliveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if r.Header.Get("X-Requested-With") == "" {
+		httperror.Respond(w, r, http.StatusForbidden, "csrf_missing", "Missing X-Requested-With header")
+		return
+	}
+
+	cookie, err := security.GetSessionCookie(r, h.cfg.Server.CookieName)
+	if err != nil {
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "No session")
+		return
+	}
+
+	sessionData, err := h.cache.Get(r.Context(), "session:"+cookie.Value)
+	if err != nil {
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Session expired")
+		return
+	}
+
+	var session auth.Session
+	if err := h.codec.Unmarshal(sessionData, &session); err != nil {
+		h.logger.Error("failed to unmarshal session", "error", err)
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Session expired")
+		return
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) || session.ProviderType == auth.ServiceProviderType {
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Session expired")
+		return
+	}
+
+	expiresAt := now.Add(h.cfg.Server.SessionTTL)
+	if h.cfg.Server.MaxSessionLifetime > 0 {
+		if deadline := session.CreatedAt.Add(h.cfg.Server.MaxSessionLifetime); expiresAt.After(deadline) {
+			expiresAt = deadline
+		}
+	}
+
+	if expiresAt.After(session.ExpiresAt) {
+		session.ExpiresAt = expiresAt
+
+		sessionData, err := h.codec.Marshal(&session)
+		if err != nil {
+			h.logger.Error("failed to marshal session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+
+		ttl := time.Until(session.ExpiresAt)
+		if err := h.cache.Set(r.Context(), "session:"+cookie.Value, sessionData, ttl); err != nil {
+			h.logger.Error("failed to update session in cache", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+
+		security.SetSessionCookie(w, r, h.cfg.Server, security.CreateSessionCookie(h.cfg.Server, cookie.Value, ttl))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(KeepaliveResponse{ExpiresAt: session.ExpiresAt})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestKeepaliveHandler(t *testing.T) {
+	const sessionTTL = time.Hour
+
+	tests := []struct {
+		name          string
+		method        string
+		noHeader      bool
+		session       *auth.Session
+		maxLifetime   time.Duration
+		wantStatus    int
+		wantExtended  bool
+		wantExpiresAt func(session *auth.Session) time.Time
+	}{
+		{
+			name:         "valid session is extended",
+			session:      &auth.Session{ID: "s1", ProviderType: "oidc", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(10 * time.Minute)},
+			wantStatus:   http.StatusOK,
+			wantExtended: true,
+		},
+		{
+			name:         "capped at max session lifetime",
+			session:      &auth.Session{ID: "s1", ProviderType: "oidc", CreatedAt: time.Now().Add(-50 * time.Minute), ExpiresAt: time.Now().Add(5 * time.Minute)},
+			maxLifetime:  time.Hour,
+			wantStatus:   http.StatusOK,
+			wantExtended: true,
+			wantExpiresAt: func(session *auth.Session) time.Time {
+				return session.CreatedAt.Add(time.Hour)
+			},
+		},
+		{
+			name:       "expired session",
+			session:    &auth.Session{ID: "s1", ProviderType: "oidc", CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Minute)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "service session",
+			session:    &auth.Session{ID: "s1", ProviderType: auth.ServiceProviderType, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(10 * time.Minute)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no session",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing X-Requested-With",
+			noHeader:   true,
+			session:    &auth.Session{ID: "s1", ProviderType: "oidc", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(10 * time.Minute)},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "get",
+			method:     "GET",
+			session:    &auth.Session{ID: "s1", ProviderType: "oidc", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(10 * time.Minute)},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("NewMemoryCache: %v", err)
+			}
+			t.Cleanup(func() { c.Close() })
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+
+			cfg := config.Config{Server: config.ServerConfig{
+				CookieName:         "session",
+				SessionTTL:         sessionTTL,
+				MaxSessionLifetime: tt.maxLifetime,
+			}}
+			h := NewKeepaliveHandler(cfg, c, codec, discardLogger())
+
+			if tt.session != nil {
+				data, err := codec.Marshal(tt.session)
+				if err != nil {
+					t.Fatalf("marshal session: %v", err)
+				}
+				if err := c.Set(t.Context(), "session:s1", data, time.Hour); err != nil {
+					t.Fatalf("store session: %v", err)
+				}
+			}
+
+			method := tt.method
+			if method == "" {
+				method = "POST"
+			}
+			req := httptest.NewRequest(method, "/auth/keepalive", nil)
+			if !tt.noHeader {
+				req.Header.Set("X-Requested-With", "XMLHttpRequest")
+			}
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			now := time.Now()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !tt.wantExtended {
+				return
+			}
+
+			var resp KeepaliveResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			want := now.Add(sessionTTL)
+			if tt.wantExpiresAt != nil {
+				want = tt.wantExpiresAt(tt.session)
+			}
+			if diff := resp.ExpiresAt.Sub(want); diff < -time.Second || diff > time.Second {
+				t.Errorf("expires_at = %s, want %s", resp.ExpiresAt, want)
+			}
+
+			data, err := c.Get(t.Context(), "session:s1")
+			if err != nil {
+				t.Fatalf("session not stored: %v", err)
+			}
+			var stored auth.Session
+			if err := codec.Unmarshal(data, &stored); err != nil {
+				t.Fatalf("unmarshal session: %v", err)
+			}
+			if !stored.ExpiresAt.Equal(resp.ExpiresAt) {
+				t.Errorf("stored expiry = %s, want %s", stored.ExpiresAt, resp.ExpiresAt)
+			}
+			if len(rec.Result().Cookies()) == 0 {
+				t.Error("session cookie not refreshed")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+// scopedStubProvider records the options of the last login.
+type scopedStubProvider struct {
+	stubProvider
+	opts auth.LoginOptions
+}
+
+func (p *scopedStubProvider) InitiateAuthWithOptions(ctx context.Context, redirectURL string, opts auth.LoginOptions) (*auth.AuthRedirect, error) {
+	p.opts = opts
+	return p.InitiateAuth(ctx, redirectURL)
+}
+
+func TestHandleLoginExtraParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		scoped      bool
+		wantStatus  int
+		wantParams  map[string]string
+		wantMessage string
+	}{
+		{name: "allowed param", query: "?audience=api", scoped: true, wantStatus: http.StatusFound, wantParams: map[string]string{"audience": "api"}},
+		{name: "other params ignored", query: "?audience=api&utm_source=mail&prompt=none", scoped: true, wantStatus: http.StatusFound, wantParams: map[string]string{"audience": "api"}},
+		{name: "only other params", query: "?utm_source=mail", scoped: true, wantStatus: http.StatusFound},
+		{name: "unscoped provider", query: "?audience=api", wantStatus: http.StatusBadRequest, wantMessage: "Provider does not support extra parameters"},
+		{name: "unscoped provider with scopes", query: "?additional_scopes=files.read", wantStatus: http.StatusBadRequest, wantMessage: "Provider does not support additional scopes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("create codec: %v", err)
+			}
+
+			cfg := config.Config{
+				Server: config.ServerConfig{BaseURL: "https://sso.example.com", CSRFMode: middleware.CSRFModeCache},
+				Providers: []config.ProviderConfig{{
+					ID:   "corp",
+					OIDC: &config.OIDCConfig{AllowedExtraParams: []string{"audience"}},
+				}},
+			}
+			scoped := &scopedStubProvider{stubProvider: stubProvider{id: "corp"}}
+			var provider auth.Provider = &stubProvider{id: "corp"}
+			if tt.scoped {
+				provider = scoped
+			}
+			csrf := middleware.NewCSRFMiddleware(cfg.Server, c, discardLogger())
+			h, err := NewSelectHandler(cfg, c, codec, map[string]auth.Provider{"corp": provider}, csrf, discardLogger())
+			if err != nil {
+				t.Fatalf("NewSelectHandler: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			h.HandleLogin("corp")(rec, httptest.NewRequest("GET", "/auth/oidc/corp/login"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantMessage != "" && !strings.Contains(rec.Body.String(), tt.wantMessage) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.wantMessage)
+			}
+			if len(tt.wantParams) > 0 && !maps.Equal(scoped.opts.ExtraParams, tt.wantParams) {
+				t.Errorf("extra params = %v, want %v", scoped.opts.ExtraParams, tt.wantParams)
+			}
+		})
+	}
+}
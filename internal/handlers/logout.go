@@ -1,45 +1,197 @@
 package handlers
 
 import (
+	"html/template"
 	"log/slog"
 	"net/http"
 
+	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
 	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
 type LogoutHandler struct {
-	cfg    config.Config
-	cache  cache.Cache
-	logger *slog.Logger
+	cfg       config.Config
+	cache     cache.Cache
+	codec     *cache.Codec
+	providers map[string]auth.Provider
+	csrf      *middleware.CSRFMiddleware
+	events    *events.Dispatcher
+	logger    *slog.Logger
+	template  *template.Template
+	retry     *template.Template
 }
 
-func NewLogoutHandler(cfg config.Config, cache cache.Cache, logger *slog.Logger) *LogoutHandler {
-	return &LogoutHandler{
-		cfg:    cfg,
-		cache:  cache,
-		logger: logger,
+type LoggedOutPageData struct {
+	PageTitle     string
+	GradientStart string
+	GradientEnd   string
+	LogoURL       string
+	SignInURL     string
+}
+
+type LogoutRetryPageData struct {
+	PageTitle     string
+	GradientStart string
+	GradientEnd   string
+	LogoURL       string
+	CSRFToken     string
+}
+
+func NewLogoutHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, csrf *middleware.CSRFMiddleware, events *events.Dispatcher, logger *slog.Logger) (*LogoutHandler, error) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/logged_out.html")
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := template.ParseFS(templatesFS, "templates/logout_retry.html")
+	if err != nil {
+		return nil, err
 	}
+
+	return &LogoutHandler{
+		cfg:       cfg,
+		cache:     cache,
+		codec:     codec,
+		providers: providers,
+		csrf:      csrf,
+		events:    events,
+		logger:    logger,
+		template:  tmpl,
+		retry:     retry,
+	}, nil
 }
 
 func (h *LogoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
+	var session *auth.Session
 	cookie, err := security.GetSessionCookie(r, h.cfg.Server.CookieName)
 	if err == nil {
+		if session = h.loadSession(r, cookie.Value); session != nil {
+			h.revokeSession(r, session)
+			h.events.Emit(r, events.Event{Type: events.TypeLogout, Provider: session.ProviderID, Subject: auth.Subject(session)})
+		}
+
 		if err := h.cache.Delete(r.Context(), "session:"+cookie.Value); err != nil {
 			h.logger.Warn("failed to delete session from cache", "error", err)
 		}
 	}
 
 	clearCookie := security.ClearSessionCookie(h.cfg.Server)
-	http.SetCookie(w, clearCookie)
+	security.SetSessionCookie(w, r, h.cfg.Server, clearCookie)
 
 	h.logger.Info("user logged out")
 
-	http.Redirect(w, r, "/auth/select", http.StatusFound)
+	target := h.signInURL()
+	if h.cfg.UI.LogoutConfirmation {
+		target = "/auth/logged-out"
+	}
+
+	if session != nil {
+		if initiator, ok := h.providers[session.ProviderID].(auth.EndSessionInitiator); ok {
+			if endSessionURL := initiator.EndSessionURL(session, h.cfg.Server.BaseURL+target); endSessionURL != "" {
+				http.Redirect(w, r, endSessionURL, http.StatusFound)
+				return
+			}
+		}
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ServeLoggedOut renders the logout confirmation page.
+func (h *LogoutHandler) ServeLoggedOut(w http.ResponseWriter, r *http.Request) {
+	logoURL := ""
+	if h.cfg.UI.LogoPath != "" {
+		logoURL = "/auth/select/logo"
+	}
+
+	data := LoggedOutPageData{
+		PageTitle:     h.cfg.UI.Title,
+		GradientStart: h.cfg.UI.GradientStart,
+		GradientEnd:   h.cfg.UI.GradientEnd,
+		LogoURL:       logoURL,
+		SignInURL:     h.signInURL(),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.template.Execute(w, data); err != nil {
+		h.logger.Error("failed to render template", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
+
+// ServeRetry re-renders the logout form with a fresh CSRF token, for
+// submissions whose token was missing or had expired.
+func (h *LogoutHandler) ServeRetry(w http.ResponseWriter, r *http.Request) {
+	csrfToken, err := h.csrf.GenerateCSRFToken(w, r)
+	if err != nil {
+		h.logger.Error("failed to generate CSRF token", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	logoURL := ""
+	if h.cfg.UI.LogoPath != "" {
+		logoURL = "/auth/select/logo"
+	}
+
+	data := LogoutRetryPageData{
+		PageTitle:     h.cfg.UI.Title,
+		GradientStart: h.cfg.UI.GradientStart,
+		GradientEnd:   h.cfg.UI.GradientEnd,
+		LogoURL:       logoURL,
+		CSRFToken:     csrfToken,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.retry.Execute(w, data); err != nil {
+		h.logger.Error("failed to render template", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
+
+// signInURL shows the select page rather than logging straight back in to
+// the remembered provider.
+func (h *LogoutHandler) signInURL() string {
+	if h.cfg.UI.RememberLastProvider {
+		return "/auth/select?" + ChooseProviderParam
+	}
+	return "/auth/select"
+}
+
+func (h *LogoutHandler) loadSession(r *http.Request, sessionID string) *auth.Session {
+	sessionData, err := h.cache.Get(r.Context(), "session:"+sessionID)
+	if err != nil {
+		return nil
+	}
+
+	var session auth.Session
+	if err := h.codec.Unmarshal(sessionData, &session); err != nil {
+		h.logger.Warn("failed to unmarshal session", "error", err)
+		return nil
+	}
+	return &session
+}
+
+// revokeSession revokes the session's tokens at the IdP when the provider
+// supports it. Failures are logged and never block the logout.
+func (h *LogoutHandler) revokeSession(r *http.Request, session *auth.Session) {
+	revoker, ok := h.providers[session.ProviderID].(auth.SessionRevoker)
+	if !ok {
+		return
+	}
+
+	if err := revoker.RevokeSession(r.Context(), session); err != nil {
+		h.logger.Warn("token revocation failed", "provider", session.ProviderID, "error", err)
+	}
 }
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+// endSessionStubProvider ends the IdP session on logout.
+type endSessionStubProvider struct {
+	stubProvider
+}
+
+func (p *endSessionStubProvider) EndSessionURL(session *auth.Session, postLogoutRedirectURI string) string {
+	return "https://idp.example.com/logout?post_logout_redirect_uri=" + url.QueryEscape(postLogoutRedirectURI)
+}
+
+func TestLogoutConfirmation(t *testing.T) {
+	tests := []struct {
+		name         string
+		confirmation bool
+		endSession   bool
+		wantLocation string
+	}{
+		{name: "direct redirect", wantLocation: "/auth/select"},
+		{name: "confirmation page", confirmation: true, wantLocation: "/auth/logged-out"},
+		{
+			name:         "idp logout returns to the confirmation page",
+			confirmation: true,
+			endSession:   true,
+			wantLocation: "https://idp.example.com/logout?post_logout_redirect_uri=" + url.QueryEscape("https://sso.example.com/auth/logged-out"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("NewMemoryCache: %v", err)
+			}
+			t.Cleanup(func() { c.Close() })
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+			trusted, err := security.ParseTrustedProxies(nil)
+			if err != nil {
+				t.Fatalf("ParseTrustedProxies: %v", err)
+			}
+
+			var provider auth.Provider = &stubProvider{id: "corp"}
+			if tt.endSession {
+				provider = &endSessionStubProvider{stubProvider{id: "corp"}}
+			}
+			cfg := config.Config{
+				Server: config.ServerConfig{BaseURL: "https://sso.example.com", CookieName: "session", CSRFMode: middleware.CSRFModeCache},
+				UI:     config.UIConfig{LogoutConfirmation: tt.confirmation},
+			}
+			h, err := NewLogoutHandler(cfg, c, codec, map[string]auth.Provider{"corp": provider},
+				middleware.NewCSRFMiddleware(cfg.Server, c, discardLogger()),
+				events.NewDispatcher(config.EventsConfig{}, trusted, discardLogger()), discardLogger())
+			if err != nil {
+				t.Fatalf("NewLogoutHandler: %v", err)
+			}
+
+			data, err := codec.Marshal(&auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("marshal session: %v", err)
+			}
+			if err := c.Set(t.Context(), "session:s1", data, time.Hour); err != nil {
+				t.Fatalf("store session: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/auth/logout", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if exists, _ := c.Exists(t.Context(), "session:s1"); exists {
+				t.Error("session not deleted")
+			}
+
+			if !tt.confirmation {
+				return
+			}
+			rec = httptest.NewRecorder()
+			h.ServeLoggedOut(rec, httptest.NewRequest("GET", "/auth/logged-out", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("confirmation page status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if !strings.Contains(rec.Body.String(), `href="/auth/select"`) {
+				t.Errorf("confirmation page has no sign-in link: %s", rec.Body)
+			}
+		})
+	}
+}
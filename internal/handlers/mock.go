@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/mock"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// MockLoginHandler renders the claims form of mock providers. The form posts
+// to HandleMockCallback.
+type MockLoginHandler struct {
+	cfg       config.Config
+	providers map[string]auth.Provider
+	logger    *slog.Logger
+	template  *template.Template
+}
+
+type MockLoginPageData struct {
+	PageTitle     string
+	GradientStart string
+	GradientEnd   string
+	LogoURL       string
+	ProviderName  string
+	CallbackURL   string
+	State         string
+	Claims        string
+}
+
+func NewMockLoginHandler(cfg config.Config, providers map[string]auth.Provider, logger *slog.Logger) (*MockLoginHandler, error) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/mock_login.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &MockLoginHandler{
+		cfg:       cfg,
+		providers: providers,
+		logger:    logger,
+		template:  tmpl,
+	}, nil
+}
+
+func (h *MockLoginHandler) HandleLogin(providerID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := h.providers[providerID].(*mock.Provider)
+		if !ok {
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
+			return
+		}
+
+		claims := provider.DefaultClaims()
+		if claims == nil {
+			claims = map[string]interface{}{"sub": "dev-user"}
+		}
+		claimsJSON, err := json.MarshalIndent(claims, "", "  ")
+		if err != nil {
+			h.logger.Error("failed to marshal default claims", "provider", providerID, "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+
+		logoURL := ""
+		if h.cfg.UI.LogoPath != "" {
+			logoURL = "/auth/select/logo"
+		}
+
+		data := MockLoginPageData{
+			PageTitle:     h.cfg.UI.Title,
+			GradientStart: h.cfg.UI.GradientStart,
+			GradientEnd:   h.cfg.UI.GradientEnd,
+			LogoURL:       logoURL,
+			ProviderName:  provider.Name(),
+			CallbackURL:   auth.MockCallbackPath(providerID),
+			State:         r.URL.Query().Get("state"),
+			Claims:        string(claimsJSON),
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := h.template.Execute(w, data); err != nil {
+			h.logger.Error("failed to render template", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		}
+	}
+}
+
+// HandleMockCallback creates a session from the claims submitted to a mock
+// provider's login form.
+func (h *CallbackHandler) HandleMockCallback(providerID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		provider, exists := h.providers[providerID]
+		if !exists {
+			h.logger.Error("provider not found", "provider_id", providerID)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
+			return
+		}
+
+		session, err := provider.HandleCallback(r.Context(), r)
+		if err != nil {
+			h.logger.Warn("mock login failed", "provider", providerID, "error", err)
+			h.events.Emit(r, events.Event{Type: events.TypeLoginFailure, Provider: providerID, Error: err.Error()})
+			respondLoginError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		sessionID, err := storeSession(w, r, h.cache, h.codec, h.cfg, session, h.logger)
+		if err != nil {
+			h.logger.Error("failed to store session", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+
+		h.logger.Info("mock authentication successful",
+			"provider", providerID,
+			"session_id", sessionID,
+		)
+		h.emitLogin(r, session)
+
+		http.Redirect(w, r, h.cfg.Server.PostLoginRedirect, http.StatusFound)
+	}
+}
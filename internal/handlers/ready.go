@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+)
+
+// ReadinessHandler serves /ready from the result of a background cache probe,
+// so that load balancers stop routing to an instance whose cache is down,
+// when every authenticated request would fail. It reports not ready until
+// the first successful probe.
+type ReadinessHandler struct {
+	cache  cache.Cache
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	err   error
+	ready bool
+}
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	Cache  string `json:"cache,omitempty"`
+}
+
+func NewReadinessHandler(cache cache.Cache, logger *slog.Logger) *ReadinessHandler {
+	return &ReadinessHandler{
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// Monitor probes the cache immediately and then every interval, until ctx is
+// cancelled.
+func (h *ReadinessHandler) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.probe(ctx, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *ReadinessHandler) probe(ctx context.Context, timeout time.Duration) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := h.cache.Exists(probeCtx, "health:ready")
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err != nil && (h.ready || h.err == nil):
+		h.logger.Error("cache unreachable, reporting not ready", "error", err)
+	case err == nil && !h.ready:
+		h.logger.Info("cache reachable, reporting ready")
+	}
+	h.err = err
+	h.ready = err == nil
+}
+
+func (h *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	ready, err := h.ready, h.err
+	h.mu.RUnlock()
+
+	response := ReadinessResponse{Status: "ready"}
+	status := http.StatusOK
+	if !ready {
+		response.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+		if err != nil {
+			response.Cache = "error: " + err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
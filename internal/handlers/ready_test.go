@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// flakyCache fails Exists while down is set.
+type flakyCache struct {
+	cache.Cache
+	down bool
+}
+
+func (c *flakyCache) Exists(ctx context.Context, key string) (bool, error) {
+	if c.down {
+		return false, errors.New("connection refused")
+	}
+	return c.Cache.Exists(ctx, key)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	memory, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	t.Cleanup(func() { memory.Close() })
+	c := &flakyCache{Cache: memory}
+	h := NewReadinessHandler(c, discardLogger())
+
+	// Each step probes the cache with down set, unless probe is false, and
+	// then checks /ready.
+	tests := []struct {
+		name       string
+		probe      bool
+		down       bool
+		wantStatus int
+		wantCache  string
+	}{
+		{name: "before the first probe", wantStatus: http.StatusServiceUnavailable},
+		{name: "cache reachable", probe: true, wantStatus: http.StatusOK},
+		{name: "cache unreachable", probe: true, down: true, wantStatus: http.StatusServiceUnavailable, wantCache: "error: connection refused"},
+		{name: "cache recovered", probe: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.probe {
+				c.down = tt.down
+				h.probe(context.Background(), time.Second)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var resp ReadinessResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if wantReady := tt.wantStatus == http.StatusOK; (resp.Status == "ready") != wantReady {
+				t.Errorf("status = %q, want ready %v", resp.Status, wantReady)
+			}
+			if resp.Cache != tt.wantCache {
+				t.Errorf("cache = %q, want %q", resp.Cache, tt.wantCache)
+			}
+		})
+	}
+}
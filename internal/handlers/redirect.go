@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// allowedRedirect reports whether a post-login redirect to target is allowed:
+// local paths always are, absolute http(s) URLs only when their host is the
+// base_url host or matches allowed_redirect_domains.
+func allowedRedirect(target string, cfg config.ServerConfig) bool {
+	if isLocalPath(target) {
+		return true
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+
+	if base, err := url.Parse(cfg.BaseURL); err == nil && strings.EqualFold(base.Hostname(), host) {
+		return true
+	}
+	for _, domain := range cfg.AllowedRedirectDomains {
+		domain = strings.ToLower(domain)
+		if rest, ok := strings.CutPrefix(domain, "."); ok {
+			if host == rest || strings.HasSuffix(host, domain) {
+				return true
+			}
+		} else if host == domain {
+			return true
+		}
+	}
+	return false
+}
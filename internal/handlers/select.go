@@ -1,15 +1,20 @@
 package handlers
 
 import (
+	"context"
 	"embed"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
 	"github.com/marcogenualdo/sso-switch/internal/middleware"
 )
 
@@ -19,14 +24,15 @@ var templatesFS embed.FS
 type SelectHandler struct {
 	cfg       config.Config
 	cache     cache.Cache
+	codec     *cache.Codec
 	providers map[string]auth.Provider
 	csrf      *middleware.CSRFMiddleware
 	logger    *slog.Logger
 	template  *template.Template
 }
 
-func NewSelectHandler(cfg config.Config, cache cache.Cache, providers map[string]auth.Provider, csrf *middleware.CSRFMiddleware, logger *slog.Logger) (*SelectHandler, error) {
-	tmpl, err := template.ParseFS(templatesFS, "templates/select.html")
+func NewSelectHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, csrf *middleware.CSRFMiddleware, logger *slog.Logger) (*SelectHandler, error) {
+	tmpl, err := parseSelectTemplate(cfg.UI, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -34,6 +40,7 @@ func NewSelectHandler(cfg config.Config, cache cache.Cache, providers map[string
 	return &SelectHandler{
 		cfg:       cfg,
 		cache:     cache,
+		codec:     codec,
 		providers: providers,
 		csrf:      csrf,
 		logger:    logger,
@@ -41,6 +48,23 @@ func NewSelectHandler(cfg config.Config, cache cache.Cache, providers map[string
 	}, nil
 }
 
+// parseSelectTemplate parses ui.select_template when set, falling back to
+// the embedded template under ui.template_fallback.
+func parseSelectTemplate(cfg config.UIConfig, logger *slog.Logger) (*template.Template, error) {
+	if cfg.SelectTemplate != "" {
+		tmpl, err := template.ParseFiles(cfg.SelectTemplate)
+		if err == nil {
+			return tmpl, nil
+		}
+		if !cfg.TemplateFallback {
+			return nil, fmt.Errorf("invalid select_template: %w", err)
+		}
+		logger.Warn("invalid select_template, using the embedded template", "path", cfg.SelectTemplate, "error", err)
+	}
+
+	return template.ParseFS(templatesFS, "templates/select.html")
+}
+
 type SelectPageData struct {
 	Providers     []ProviderInfo
 	CSRFToken     string
@@ -48,6 +72,20 @@ type SelectPageData struct {
 	GradientStart string
 	GradientEnd   string
 	LogoURL       string
+	// AdditionalScopes is carried through the form from the query string.
+	AdditionalScopes string
+	// Notice explains why the user was sent to log in, from the notice
+	// query parameter.
+	Notice string
+	// ChooseProviderURL links to the select page without the remembered
+	// provider, set under ui.remember_last_provider.
+	ChooseProviderURL string
+}
+
+// notices maps the notice codes of middleware.RedirectToLoginWithNotice to
+// the text shown on the select page. Unknown codes are ignored.
+var notices = map[string]string{
+	middleware.NoticeProviderRemoved: "Your session has ended because its sign-in method is no longer available. Please sign in again.",
 }
 
 type ProviderInfo struct {
@@ -63,63 +101,164 @@ func (h *SelectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		h.handlePost(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+}
+
+// HandleLogin starts a login at the OIDC provider directly, skipping the
+// select page. The query string may carry additional_scopes and the extra
+// authorization parameters allowed by the provider's allowed_extra_params;
+// other query parameters are ignored.
+func (h *SelectHandler) HandleLogin(providerID string) http.HandlerFunc {
+	var allowedParams []string
+	for _, providerCfg := range h.cfg.Providers {
+		if providerCfg.ID == providerID && providerCfg.OIDC != nil {
+			allowedParams = providerCfg.OIDC.AllowedExtraParams
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		extraParams := make(map[string]string)
+		for _, name := range allowedParams {
+			if query.Has(name) {
+				extraParams[name] = query.Get(name)
+			}
+		}
+		h.initiateAuthForProvider(w, r, h.providers[providerID], extraParams)
 	}
 }
 
-func (h *SelectHandler) initiateAuthForProvider(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+func (h *SelectHandler) initiateAuthForProvider(w http.ResponseWriter, r *http.Request, provider auth.Provider, extraParams map[string]string) {
 	var redirectURL string
-	if provider.Type() == "oidc" {
-		redirectURL = h.cfg.Server.BaseURL + "/auth/oidc/" + provider.ID() + "/callback"
-	} else {
-		redirectURL = h.cfg.Server.BaseURL + "/auth/saml/" + provider.ID() + "/acs"
+	switch provider.Type() {
+	case "oidc":
+		redirectURL = h.cfg.Server.BaseURL + auth.OIDCCallbackPath(provider.ID())
+	case "mock":
+		redirectURL = h.cfg.Server.BaseURL + auth.MockLoginPath(provider.ID())
+	case "saml":
+		redirectURL = h.cfg.Server.BaseURL + auth.SAMLACSPath(provider.ID())
+	default:
+		redirectURL = h.cfg.Server.BaseURL + auth.CallbackPath(provider.Type(), provider.ID())
 	}
 
-	authRedirect, err := provider.InitiateAuth(r.Context(), redirectURL)
+	var authRedirect *auth.AuthRedirect
+	var err error
+	opts := auth.LoginOptions{
+		AdditionalScopes: parseScopes(r.FormValue("additional_scopes")),
+		ExtraParams:      extraParams,
+	}
+	if len(opts.AdditionalScopes) > 0 || len(opts.ExtraParams) > 0 {
+		scoped, ok := provider.(auth.ScopedAuthInitiator)
+		if !ok && len(opts.AdditionalScopes) > 0 {
+			httperror.Respond(w, r, http.StatusBadRequest, "scope_not_allowed", "Provider does not support additional scopes")
+			return
+		}
+		if !ok {
+			httperror.Respond(w, r, http.StatusBadRequest, "param_not_allowed", "Provider does not support extra parameters")
+			return
+		}
+		authRedirect, err = scoped.InitiateAuthWithOptions(r.Context(), redirectURL, opts)
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			h.logger.Warn("additional scope rejected", "provider", provider.ID(), "error", err)
+			httperror.Respond(w, r, http.StatusBadRequest, "scope_not_allowed", "Requested scope is not allowed")
+			return
+		}
+		if errors.Is(err, auth.ErrParamNotAllowed) {
+			h.logger.Warn("authorization parameter rejected", "provider", provider.ID(), "error", err)
+			httperror.Respond(w, r, http.StatusBadRequest, "param_not_allowed", "Requested parameter is not allowed")
+			return
+		}
+	} else {
+		authRedirect, err = provider.InitiateAuth(r.Context(), redirectURL)
+	}
 	if err != nil {
 		h.logger.Error("failed to initiate auth", "provider", provider.ID(), "error", err)
-		http.Error(w, "Failed to initiate authentication", http.StatusInternalServerError)
+		httperror.Respond(w, r, http.StatusInternalServerError, "auth_initiation_failed", "Failed to initiate authentication")
 		return
 	}
 
-	if authRedirect.CacheKey != "" && authRedirect.CacheData != nil {
-		var data []byte
-		switch v := authRedirect.CacheData.(type) {
-		case []byte:
-			data = v
-		default:
-			var err error
-			data, err = json.Marshal(v)
-			if err != nil {
-				h.logger.Error("failed to marshal cache data", "error", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-		}
+	if err := cacheAuthRedirect(r.Context(), h.cache, h.codec, authRedirect); err != nil {
+		h.logger.Error("failed to cache auth state", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
 
-		if err := h.cache.Set(r.Context(), authRedirect.CacheKey, data, authRedirect.CacheTTL); err != nil {
-			h.logger.Error("failed to cache auth state", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+	http.Redirect(w, r, authRedirect.URL, http.StatusFound)
+}
+
+// parseScopes splits a space or comma separated scope list.
+func parseScopes(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
+
+func cacheAuthRedirect(ctx context.Context, c cache.Cache, codec *cache.Codec, authRedirect *auth.AuthRedirect) error {
+	if authRedirect.CacheKey == "" || authRedirect.CacheData == nil {
+		return nil
+	}
+
+	var data []byte
+	switch v := authRedirect.CacheData.(type) {
+	case []byte:
+		data = v
+	default:
+		var err error
+		data, err = codec.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal cache data: %w", err)
 		}
 	}
 
-	http.Redirect(w, r, authRedirect.URL, http.StatusFound)
+	return c.Set(ctx, authRedirect.CacheKey, data, authRedirect.CacheTTL)
 }
 
 func (h *SelectHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 	// If only one provider and UI is enabled (default), redirect directly to the provider
 	if len(h.providers) == 1 && h.cfg.UI.Enable != nil && *h.cfg.UI.Enable == false {
 		for _, provider := range h.providers {
-			h.initiateAuthForProvider(w, r, provider)
+			h.initiateAuthForProvider(w, r, provider, nil)
 			return
 		}
 	}
 
-	csrfToken, err := h.csrf.GenerateCSRFToken(r.Context())
+	if h.cfg.UI.RememberLastProvider {
+		if r.URL.Query().Has(ChooseProviderParam) {
+			http.SetCookie(w, lastProviderCookie(h.cfg.Server, "", -time.Second))
+		} else if cookie, err := r.Cookie(LastProviderCookieName); err == nil {
+			if provider, exists := h.providers[cookie.Value]; exists {
+				h.initiateAuthForProvider(w, r, provider, nil)
+				return
+			}
+		}
+	}
+
+	h.renderPage(w, r, notices[r.URL.Query().Get("notice")])
+}
+
+// ServeRetry re-renders the select page with a fresh CSRF token, for
+// submissions whose token was missing or had expired.
+func (h *SelectHandler) ServeRetry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	h.renderPage(w, r, retryNotice)
+}
+
+// retryNotice is shown on the select page re-rendered by ServeRetry.
+const retryNotice = "This page had expired. Please choose your identity provider again."
+
+// renderPage renders the select page with a new CSRF token and notice, which
+// may be empty.
+func (h *SelectHandler) renderPage(w http.ResponseWriter, r *http.Request, notice string) {
+	csrfToken, err := h.csrf.GenerateCSRFToken(w, r)
 	if err != nil {
 		h.logger.Error("failed to generate CSRF token", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
@@ -137,6 +276,11 @@ func (h *SelectHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 		logoURL = "/auth/select/logo"
 	}
 
+	chooseProviderURL := ""
+	if h.cfg.UI.RememberLastProvider && !r.URL.Query().Has(ChooseProviderParam) {
+		chooseProviderURL = "/auth/select?" + ChooseProviderParam
+	}
+
 	data := SelectPageData{
 		Providers:     providers,
 		CSRFToken:     csrfToken,
@@ -144,34 +288,64 @@ func (h *SelectHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 		GradientStart: h.cfg.UI.GradientStart,
 		GradientEnd:   h.cfg.UI.GradientEnd,
 		LogoURL:       logoURL,
+
+		AdditionalScopes:  r.FormValue("additional_scopes"),
+		Notice:            notice,
+		ChooseProviderURL: chooseProviderURL,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := h.template.Execute(w, data); err != nil {
 		h.logger.Error("failed to render template", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 	}
 }
 
 func (h *SelectHandler) handlePost(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_form", "Invalid form data")
 		return
 	}
 
 	providerID := r.FormValue("provider")
 	if providerID == "" {
-		http.Error(w, "Provider is required", http.StatusBadRequest)
+		httperror.Respond(w, r, http.StatusBadRequest, "provider_required", "Provider is required")
 		return
 	}
 
 	provider, exists := h.providers[providerID]
 	if !exists {
-		http.Error(w, "Invalid provider", http.StatusBadRequest)
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
 		return
 	}
 
-	h.initiateAuthForProvider(w, r, provider)
+	if h.cfg.UI.RememberLastProvider {
+		http.SetCookie(w, lastProviderCookie(h.cfg.Server, providerID, lastProviderTTL))
+	}
+
+	h.initiateAuthForProvider(w, r, provider, nil)
+}
+
+const (
+	// LastProviderCookieName remembers the provider chosen on the select page.
+	LastProviderCookieName = "sso_last_provider"
+	// ChooseProviderParam on /auth/select forgets the remembered provider and
+	// shows the select page, to use a different account.
+	ChooseProviderParam = "choose"
+
+	lastProviderTTL = 30 * 24 * time.Hour
+)
+
+func lastProviderCookie(cfg config.ServerConfig, providerID string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     LastProviderCookieName,
+		Value:    providerID,
+		Path:     "/auth",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
 }
 
 func (h *SelectHandler) ServeLogo(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+// stubProvider is an OIDC provider whose logins redirect to a fixed URL.
+type stubProvider struct {
+	auth.Provider
+	id string
+}
+
+func (p *stubProvider) ID() string   { return p.id }
+func (p *stubProvider) Name() string { return p.id }
+func (p *stubProvider) Type() string { return "oidc" }
+
+func (p *stubProvider) InitiateAuth(ctx context.Context, redirectURL string) (*auth.AuthRedirect, error) {
+	return &auth.AuthRedirect{URL: "https://idp.example.com/authorize"}, nil
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newSelectTestServer returns the select page behind CSRF validation, as
+// routed by the server, and its cache.
+func newSelectTestServer(t *testing.T, ui config.UIConfig) (http.Handler, *middleware.CSRFMiddleware, cache.Cache) {
+	t.Helper()
+
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("create codec: %v", err)
+	}
+
+	cfg := config.Config{
+		Server: config.ServerConfig{BaseURL: "https://sso.example.com", CSRFMode: middleware.CSRFModeCache},
+		UI:     ui,
+	}
+	csrf := middleware.NewCSRFMiddleware(cfg.Server, c, discardLogger())
+	providers := map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}
+
+	h, err := NewSelectHandler(cfg, c, codec, providers, csrf, discardLogger())
+	if err != nil {
+		t.Fatalf("NewSelectHandler: %v", err)
+	}
+	return csrf.ValidateCSRFForm(h, http.HandlerFunc(h.ServeRetry)), csrf, c
+}
+
+var csrfTokenInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestSelectCSRFRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		validToken bool
+		header     map[string]string
+		wantStatus int
+		wantRetry  bool
+	}{
+		{
+			name:       "valid token",
+			validToken: true,
+			header:     map[string]string{"Accept": "text/html", "Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "browser form with expired token",
+			token:      "expired",
+			header:     map[string]string{"Accept": "text/html", "Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusOK,
+			wantRetry:  true,
+		},
+		{
+			name:       "browser form without token",
+			header:     map[string]string{"Accept": "text/html", "Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusOK,
+			wantRetry:  true,
+		},
+		{
+			name:       "API client with header token",
+			header:     map[string]string{"Accept": "application/json", "Content-Type": "application/x-www-form-urlencoded", "X-CSRF-Token": "expired"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "API client without token",
+			header:     map[string]string{"Accept": "application/json", "Content-Type": "application/x-www-form-urlencoded"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, csrf, c := newSelectTestServer(t, config.UIConfig{})
+
+			token := tt.token
+			if tt.validToken {
+				var err error
+				token, err = csrf.GenerateCSRFToken(httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/select", nil))
+				if err != nil {
+					t.Fatalf("GenerateCSRFToken: %v", err)
+				}
+			}
+
+			form := url.Values{"provider": {"corp"}}
+			if token != "" {
+				form.Set("csrf_token", token)
+			}
+			req := httptest.NewRequest("POST", "/auth/select", strings.NewReader(form.Encode()))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !tt.wantRetry {
+				return
+			}
+
+			body := rec.Body.String()
+			if !strings.Contains(body, retryNotice) {
+				t.Error("retry page does not show the notice")
+			}
+			match := csrfTokenInput.FindStringSubmatch(body)
+			if match == nil {
+				t.Fatal("retry page has no CSRF token")
+			}
+			if match[1] == tt.token {
+				t.Error("retry page reuses the rejected token")
+			}
+			if exists, _ := c.Exists(context.Background(), "csrf:"+match[1]); !exists {
+				t.Error("retry page token is not valid")
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestSelectRetryTokenIsUsable(t *testing.T) {
+	handler, _, _ := newSelectTestServer(t, config.UIConfig{})
+
+	post := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{"provider": {"corp"}, "csrf_token": {token}}
+		req := httptest.NewRequest("POST", "/auth/select", strings.NewReader(form.Encode()))
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	retry := post("expired")
+	match := csrfTokenInput.FindStringSubmatch(retry.Body.String())
+	if match == nil {
+		t.Fatal("retry page has no CSRF token")
+	}
+
+	if rec := post(match[1]); rec.Code != http.StatusFound {
+		t.Errorf("resubmitted status = %d, want %d", rec.Code, http.StatusFound)
+	}
+}
+
+func TestRememberLastProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		remember     bool
+		path         string
+		cookie       string
+		wantStatus   int
+		wantLink     bool
+		wantCleared  bool
+		wantLocation string
+	}{
+		{name: "disabled", path: "/auth/select", cookie: "corp", wantStatus: http.StatusOK},
+		{name: "first visit", remember: true, path: "/auth/select", wantStatus: http.StatusOK, wantLink: true},
+		{name: "return visit", remember: true, path: "/auth/select", cookie: "corp", wantStatus: http.StatusFound, wantLocation: "https://idp.example.com/authorize"},
+		{name: "removed provider", remember: true, path: "/auth/select", cookie: "gone", wantStatus: http.StatusOK, wantLink: true},
+		{name: "choose", remember: true, path: "/auth/select?choose", cookie: "corp", wantStatus: http.StatusOK, wantCleared: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _, _ := newSelectTestServer(t, config.UIConfig{RememberLastProvider: tt.remember})
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: LastProviderCookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if got := strings.Contains(rec.Body.String(), `href="/auth/select?choose"`); got != tt.wantLink {
+				t.Errorf("choose link rendered = %v, want %v", got, tt.wantLink)
+			}
+
+			cleared := false
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == LastProviderCookieName && cookie.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if cleared != tt.wantCleared {
+				t.Errorf("cookie cleared = %v, want %v", cleared, tt.wantCleared)
+			}
+		})
+	}
+}
+
+func TestRememberLastProviderFlow(t *testing.T) {
+	handler, _, _ := newSelectTestServer(t, config.UIConfig{RememberLastProvider: true})
+
+	// First visit: the select page is shown.
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest("GET", "/auth/select", nil))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first visit status = %d, want %d", first.Code, http.StatusOK)
+	}
+	match := csrfTokenInput.FindStringSubmatch(first.Body.String())
+	if match == nil {
+		t.Fatal("select page has no CSRF token")
+	}
+
+	// Choosing a provider remembers it.
+	form := url.Values{"provider": {"corp"}, "csrf_token": {match[1]}}
+	choose := httptest.NewRequest("POST", "/auth/select", strings.NewReader(form.Encode()))
+	choose.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	chosen := httptest.NewRecorder()
+	handler.ServeHTTP(chosen, choose)
+	if chosen.Code != http.StatusFound {
+		t.Fatalf("selection status = %d, want %d", chosen.Code, http.StatusFound)
+	}
+	var remembered *http.Cookie
+	for _, cookie := range chosen.Result().Cookies() {
+		if cookie.Name == LastProviderCookieName {
+			remembered = cookie
+		}
+	}
+	if remembered == nil || remembered.Value != "corp" || remembered.MaxAge <= 0 {
+		t.Fatalf("last provider cookie = %v, want corp", remembered)
+	}
+
+	// Return visit: straight to the remembered provider.
+	back := httptest.NewRequest("GET", "/auth/select", nil)
+	back.AddCookie(remembered)
+	returned := httptest.NewRecorder()
+	handler.ServeHTTP(returned, back)
+	if returned.Code != http.StatusFound || returned.Header().Get("Location") != "https://idp.example.com/authorize" {
+		t.Errorf("return visit = %d %q, want a redirect to the provider", returned.Code, returned.Header().Get("Location"))
+	}
+}
+
+func TestParseSelectTemplate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.html")
+	malformed := filepath.Join(dir, "malformed.html")
+	if err := os.WriteFile(valid, []byte(`<h1>{{.PageTitle}}</h1>`), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	if err := os.WriteFile(malformed, []byte(`<h1>{{.PageTitle</h1>`), 0o600); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ui       config.UIConfig
+		wantName string
+		wantErr  bool
+	}{
+		{name: "embedded", wantName: "select.html"},
+		{name: "external", ui: config.UIConfig{SelectTemplate: valid}, wantName: "valid.html"},
+		{name: "malformed", ui: config.UIConfig{SelectTemplate: malformed}, wantErr: true},
+		{name: "malformed with fallback", ui: config.UIConfig{SelectTemplate: malformed, TemplateFallback: true}, wantName: "select.html"},
+		{name: "missing with fallback", ui: config.UIConfig{SelectTemplate: filepath.Join(dir, "missing.html"), TemplateFallback: true}, wantName: "select.html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseSelectTemplate(tt.ui, discardLogger())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parseSelectTemplate succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSelectTemplate: %v", err)
+			}
+			if tmpl.Name() != tt.wantName {
+				t.Errorf("template = %q, want %q", tmpl.Name(), tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/oidc"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+const (
+	SilentAuthStatusAuthenticated = "authenticated"
+	SilentAuthStatusLoginRequired = "login_required"
+	SilentAuthStatusError         = "error"
+)
+
+// SilentAuthHandler implements prompt=none re-authentication for OIDC
+// providers. It is meant to be loaded in a hidden iframe; the result is
+// posted to the parent window.
+type SilentAuthHandler struct {
+	cfg       config.Config
+	cache     cache.Cache
+	codec     *cache.Codec
+	providers map[string]auth.Provider
+	logger    *slog.Logger
+	template  *template.Template
+	origin    string
+}
+
+func NewSilentAuthHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, logger *slog.Logger) (*SilentAuthHandler, error) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/silent.html")
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL, err := url.Parse(cfg.Server.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SilentAuthHandler{
+		cfg:       cfg,
+		cache:     cache,
+		codec:     codec,
+		providers: providers,
+		logger:    logger,
+		template:  tmpl,
+		origin:    baseURL.Scheme + "://" + baseURL.Host,
+	}, nil
+}
+
+type SilentAuthPageData struct {
+	Status string
+	Origin string
+}
+
+func (h *SilentAuthHandler) HandleInitiate(providerID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := h.providers[providerID].(*oidc.Provider)
+		if !ok {
+			h.logger.Error("silent auth requires an OIDC provider", "provider_id", providerID)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
+			return
+		}
+
+		redirectURL := h.cfg.Server.BaseURL + auth.OIDCSilentCallbackPath(providerID)
+
+		authRedirect, err := provider.InitiateSilentAuth(r.Context(), redirectURL)
+		if err != nil {
+			h.logger.Error("failed to initiate silent auth", "provider", providerID, "error", err)
+			h.render(w, http.StatusInternalServerError, SilentAuthStatusError)
+			return
+		}
+
+		if err := cacheAuthRedirect(r.Context(), h.cache, h.codec, authRedirect); err != nil {
+			h.logger.Error("failed to cache auth state", "error", err)
+			h.render(w, http.StatusInternalServerError, SilentAuthStatusError)
+			return
+		}
+
+		http.Redirect(w, r, authRedirect.URL, http.StatusFound)
+	}
+}
+
+func (h *SilentAuthHandler) HandleCallback(providerID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, exists := h.providers[providerID]
+		if !exists {
+			h.logger.Error("provider not found", "provider_id", providerID)
+			httperror.Respond(w, r, http.StatusBadRequest, "invalid_provider", "Invalid provider")
+			return
+		}
+
+		session, err := provider.HandleCallback(r.Context(), r)
+		if err != nil {
+			var idpErr *auth.IdPError
+			if errors.As(err, &idpErr) && idpErr.InteractionRequired() {
+				h.logger.Debug("silent auth requires interactive login", "provider", providerID, "error", idpErr.Code)
+				h.render(w, http.StatusUnauthorized, SilentAuthStatusLoginRequired)
+				return
+			}
+
+			h.logger.Error("silent auth callback failed", "provider", providerID, "error", err)
+			h.render(w, http.StatusUnauthorized, SilentAuthStatusError)
+			return
+		}
+
+		oldCookie, cookieErr := security.GetSessionCookie(r, h.cfg.Server.CookieName)
+
+		sessionID, err := storeSession(w, r, h.cache, h.codec, h.cfg, session, h.logger)
+		if err != nil {
+			h.logger.Error("failed to store session", "error", err)
+			h.render(w, http.StatusInternalServerError, SilentAuthStatusError)
+			return
+		}
+
+		if cookieErr == nil && oldCookie.Value != "" {
+			if err := h.cache.Delete(r.Context(), "session:"+oldCookie.Value); err != nil {
+				h.logger.Warn("failed to delete previous session", "error", err)
+			}
+		}
+
+		h.logger.Info("silent authentication successful",
+			"provider", providerID,
+			"session_id", sessionID,
+		)
+
+		h.render(w, http.StatusOK, SilentAuthStatusAuthenticated)
+	}
+}
+
+func (h *SilentAuthHandler) render(w http.ResponseWriter, status int, result string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	data := SilentAuthPageData{
+		Status: result,
+		Origin: h.origin,
+	}
+	if err := h.template.Execute(w, data); err != nil {
+		h.logger.Error("failed to render template", "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+// TokenHandler implements the OAuth2 client credentials grant for the
+// configured service clients. Issued tokens are accepted as bearer tokens by
+// the auth middleware.
+type TokenHandler struct {
+	clients map[string]config.ServiceClientConfig
+	cache   cache.Cache
+	codec   *cache.Codec
+	logger  *slog.Logger
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func NewTokenHandler(cfg config.Config, cache cache.Cache, codec *cache.Codec, logger *slog.Logger) *TokenHandler {
+	clients := make(map[string]config.ServiceClientConfig, len(cfg.ServiceClients))
+	for _, client := range cfg.ServiceClients {
+		clients[client.ID] = client
+	}
+
+	return &TokenHandler{
+		clients: clients,
+		cache:   cache,
+		codec:   codec,
+		logger:  logger,
+	}
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httperror.Respond(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httperror.Respond(w, r, http.StatusBadRequest, "invalid_request", "Invalid form data")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		httperror.Respond(w, r, http.StatusBadRequest, "unsupported_grant_type", "Only client_credentials is supported")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, exists := h.clients[clientID]
+	if !exists || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		h.logger.Warn("client credentials rejected", "client_id", clientID)
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso-switch"`)
+		httperror.Respond(w, r, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+
+	token, err := security.GenerateRandomString(32)
+	if err != nil {
+		h.logger.Error("failed to generate token", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	now := time.Now()
+	session := &auth.Session{
+		ID:           token,
+		ProviderID:   client.ID,
+		ProviderType: auth.ServiceProviderType,
+		UserInfo:     map[string]interface{}{"sub": client.ID},
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(client.TokenTTL),
+	}
+
+	sessionData, err := h.codec.Marshal(session)
+	if err != nil {
+		h.logger.Error("failed to marshal session", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	if err := h.cache.Set(r.Context(), "session:"+token, sessionData, client.TokenTTL); err != nil {
+		h.logger.Error("failed to cache session", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	h.logger.Info("service token issued", "client_id", client.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(client.TokenTTL.Seconds()),
+	})
+}
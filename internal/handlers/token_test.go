@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestTokenHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		form       url.Values
+		basicAuth  []string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "basic auth",
+			form:       url.Values{"grant_type": {"client_credentials"}},
+			basicAuth:  []string{"billing", "s3cret"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "form credentials",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"billing"}, "client_secret": {"s3cret"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrong secret",
+			form:       url.Values{"grant_type": {"client_credentials"}},
+			basicAuth:  []string{"billing", "guess"},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:       "unknown client",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"payroll"}, "client_secret": {"s3cret"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:       "other grant",
+			form:       url.Values{"grant_type": {"password"}},
+			basicAuth:  []string{"billing", "s3cret"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unsupported_grant_type",
+		},
+		{
+			name:       "get",
+			method:     "GET",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "method_not_allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("create codec: %v", err)
+			}
+			cfg := config.Config{ServiceClients: []config.ServiceClientConfig{
+				{ID: "billing", Secret: "s3cret", TokenTTL: 15 * time.Minute},
+			}}
+			h := NewTokenHandler(cfg, c, codec, discardLogger())
+
+			method := tt.method
+			if method == "" {
+				method = "POST"
+			}
+			req := httptest.NewRequest(method, "/auth/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			if tt.basicAuth != nil {
+				req.SetBasicAuth(tt.basicAuth[0], tt.basicAuth[1])
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantError != "" {
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+					t.Fatalf("decode error: %v", err)
+				}
+				if body.Error != tt.wantError {
+					t.Errorf("error = %q, want %q", body.Error, tt.wantError)
+				}
+				return
+			}
+
+			var resp TokenResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode token: %v", err)
+			}
+			if resp.TokenType != "Bearer" || resp.ExpiresIn != 900 {
+				t.Errorf("token = %+v, want a Bearer token expiring in 900s", resp)
+			}
+
+			data, err := c.Get(req.Context(), "session:"+resp.AccessToken)
+			if err != nil {
+				t.Fatalf("token session not stored: %v", err)
+			}
+			var session auth.Session
+			if err := codec.Unmarshal(data, &session); err != nil {
+				t.Fatalf("unmarshal session: %v", err)
+			}
+			if session.ProviderType != auth.ServiceProviderType || session.ProviderID != "billing" {
+				t.Errorf("session = %s/%s, want %s/billing", session.ProviderType, session.ProviderID, auth.ServiceProviderType)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package httperror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const formatContextKey contextKey = "error_format"
+
+const (
+	FormatAuto = "auto"
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+type errorBody struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// WithFormat stores the configured error format in ctx for Respond.
+func WithFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, formatContextKey, format)
+}
+
+// Respond writes an error response in the configured format. code is a short
+// machine-readable identifier, message is shown to the user. gRPC calls
+// always get a gRPC status instead.
+func Respond(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if IsGRPC(r) {
+		respondGRPC(w, status, message)
+		return
+	}
+
+	format, _ := r.Context().Value(formatContextKey).(string)
+	if format == "" || format == FormatAuto {
+		format = negotiate(r)
+		AddVary(w, "Accept")
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	switch format {
+	case FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(errorBody{Status: status, Error: code, Message: message})
+	case FormatHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"UTF-8\"><title>%d %s</title></head><body><h1>%d %s</h1><p>%s</p></body></html>\n",
+			status, html.EscapeString(http.StatusText(status)),
+			status, html.EscapeString(http.StatusText(status)),
+			html.EscapeString(message))
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+func negotiate(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return FormatJSON
+	case strings.Contains(accept, "text/html"):
+		return FormatHTML
+	default:
+		return FormatText
+	}
+}
+
+// AddVary lists field in the Vary header of w, unless it already is, so that
+// caches keep the variants of a response negotiated on that request header
+// apart.
+func AddVary(w http.ResponseWriter, field string) {
+	for _, value := range w.Header().Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			listed = strings.TrimSpace(listed)
+			if listed == "*" || strings.EqualFold(listed, field) {
+				return
+			}
+		}
+	}
+	w.Header().Add("Vary", field)
+}
@@ -0,0 +1,166 @@
+package httperror
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRespondVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		accept   string
+		wantType string
+		wantVary []string
+	}{
+		{name: "auto json", format: FormatAuto, accept: "application/json", wantType: "application/json", wantVary: []string{"Accept"}},
+		{name: "auto html", format: FormatAuto, accept: "text/html", wantType: "text/html; charset=utf-8", wantVary: []string{"Accept"}},
+		{name: "unset format negotiates", accept: "*/*", wantType: "text/plain; charset=utf-8", wantVary: []string{"Accept"}},
+		{name: "fixed json", format: FormatJSON, accept: "text/html", wantType: "application/json"},
+		{name: "fixed text", format: FormatText, accept: "application/json", wantType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			if tt.format != "" {
+				req = req.WithContext(WithFormat(req.Context(), tt.format))
+			}
+
+			rec := httptest.NewRecorder()
+			Respond(rec, req, http.StatusForbidden, "denied", "Access denied")
+
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			gotVary := rec.Header().Values("Vary")
+			if len(gotVary) != len(tt.wantVary) || (len(gotVary) > 0 && gotVary[0] != tt.wantVary[0]) {
+				t.Errorf("Vary = %q, want %q", gotVary, tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		field    string
+		want     []string
+	}{
+		{name: "empty", field: "Accept", want: []string{"Accept"}},
+		{name: "already listed", existing: []string{"Accept"}, field: "Accept", want: []string{"Accept"}},
+		{name: "case insensitive", existing: []string{"accept"}, field: "Accept", want: []string{"accept"}},
+		{name: "in a list", existing: []string{"Origin, Accept"}, field: "Accept", want: []string{"Origin, Accept"}},
+		{name: "wildcard", existing: []string{"*"}, field: "Accept", want: []string{"*"}},
+		{name: "other field", existing: []string{"Accept-Language"}, field: "Accept", want: []string{"Accept-Language", "Accept"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			for _, value := range tt.existing {
+				rec.Header().Add("Vary", value)
+			}
+
+			AddVary(rec, tt.field)
+
+			got := rec.Header().Values("Vary")
+			if len(got) != len(tt.want) {
+				t.Fatalf("Vary = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Vary = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRespondFormat(t *testing.T) {
+	const message = `Access <denied> & "logged"`
+
+	tests := []struct {
+		name      string
+		accept    string
+		wantType  string
+		checkBody func(t *testing.T, body string)
+	}{
+		{
+			name:     "json",
+			accept:   "application/json, text/plain;q=0.5",
+			wantType: "application/json",
+			checkBody: func(t *testing.T, body string) {
+				var got errorBody
+				if err := json.Unmarshal([]byte(body), &got); err != nil {
+					t.Fatalf("body %q is not JSON: %v", body, err)
+				}
+				want := errorBody{Status: http.StatusForbidden, Error: "denied", Message: message}
+				if got != want {
+					t.Errorf("body = %+v, want %+v", got, want)
+				}
+			},
+		},
+		{
+			name:     "html",
+			accept:   "text/html,application/xhtml+xml",
+			wantType: "text/html; charset=utf-8",
+			checkBody: func(t *testing.T, body string) {
+				if !strings.Contains(body, "<h1>403 Forbidden</h1>") {
+					t.Errorf("body = %q, want the status heading", body)
+				}
+				if !strings.Contains(body, html.EscapeString(message)) {
+					t.Errorf("body = %q, want the escaped message", body)
+				}
+			},
+		},
+		{
+			name:     "text",
+			accept:   "text/plain",
+			wantType: "text/plain; charset=utf-8",
+			checkBody: func(t *testing.T, body string) {
+				if body != message+"\n" {
+					t.Errorf("body = %q, want %q", body, message+"\n")
+				}
+			},
+		},
+		{
+			name:     "no accept",
+			wantType: "text/plain; charset=utf-8",
+			checkBody: func(t *testing.T, body string) {
+				if body != message+"\n" {
+					t.Errorf("body = %q, want %q", body, message+"\n")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			Respond(rec, req, http.StatusForbidden, "denied", message)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+			tt.checkBody(t, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are latency buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts holds per-bucket (non-cumulative) counts; the last entry is +Inf.
+	counts    []uint64
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+// exemplar links an observation to the trace it was recorded in.
+type exemplar struct {
+	traceID   string
+	value     float64
+	timestamp time.Time
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.ObserveWithExemplar(value, "", labelValues...)
+}
+
+// ObserveWithExemplar records value and, when traceID is set, keeps it as the
+// exemplar of the bucket the value falls in. Exemplars are only rendered in
+// the OpenMetrics format.
+func (h *HistogramVec) ObserveWithExemplar(value float64, traceID string, labelValues ...string) {
+	key := labelKey(h.name, h.labels, labelValues)
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			counts:    make([]uint64, len(h.buckets)+1),
+			exemplars: make([]*exemplar, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+
+	s.counts[bucket]++
+	s.sum += value
+	s.count++
+	if traceID != "" {
+		s.exemplars[bucket] = &exemplar{traceID: traceID, value: value, timestamp: time.Now()}
+	}
+}
+
+func (h *HistogramVec) write(sb *strings.Builder, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(sb, "# TYPE %s histogram\n", h.name)
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+			}
+
+			fmt.Fprintf(sb, "%s_bucket%s %d", h.name, formatLabels(h.labels, key, "le", le), cumulative)
+			if ex := s.exemplars[i]; openMetrics && ex != nil {
+				fmt.Fprintf(sb, " # {trace_id=%s} %g %.3f", quoteLabelValue(ex.traceID), ex.value, float64(ex.timestamp.UnixMilli())/1000)
+			}
+			sb.WriteByte('\n')
+		}
+
+		fmt.Fprintf(sb, "%s_sum%s %g\n", h.name, formatLabels(h.labels, key), s.sum)
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.name, formatLabels(h.labels, key), s.count)
+	}
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Registry holds metrics and renders them in the Prometheus text format, or
+// in the OpenMetrics format (which carries exemplars) when the scraper asks
+// for it.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []collector
+}
+
+type collector interface {
+	write(sb *strings.Builder, openMetrics bool)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+var Default = NewRegistry()
+
+var (
+	TokenRefreshAttempts = Default.NewCounterVec(
+		"sso_switch_token_refresh_attempts_total",
+		"Number of OIDC token refresh attempts.",
+		"provider",
+	)
+	TokenRefreshSuccesses = Default.NewCounterVec(
+		"sso_switch_token_refresh_success_total",
+		"Number of successful OIDC token refreshes.",
+		"provider",
+	)
+	TokenRefreshFailures = Default.NewCounterVec(
+		"sso_switch_token_refresh_failures_total",
+		"Number of failed OIDC token refreshes by reason.",
+		"provider", "reason",
+	)
+	ProxyRequestDuration = Default.NewHistogramVec(
+		"sso_switch_proxy_request_duration_seconds",
+		"Time spent proxying requests to the backend.",
+		DefaultBuckets,
+	)
+)
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := labelKey(c.name, c.labels, labelValues)
+
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := labelKey(c.name, c.labels, labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(sb *strings.Builder, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	family := c.name
+	if openMetrics {
+		// OpenMetrics names the counter family without the _total suffix.
+		family = strings.TrimSuffix(c.name, "_total")
+	}
+	fmt.Fprintf(sb, "# HELP %s %s\n", family, c.help)
+	fmt.Fprintf(sb, "# TYPE %s counter\n", family)
+
+	for _, key := range sortedKeys(c.values) {
+		sb.WriteString(c.name)
+		sb.WriteString(formatLabels(c.labels, key))
+		fmt.Fprintf(sb, " %g\n", c.values[key])
+	}
+}
+
+func labelKey(name string, labels, labelValues []string) string {
+	if len(labelValues) != len(labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func formatLabels(labels []string, key string, extra ...string) string {
+	pairs := make([]string, 0, len(labels)+len(extra)/2)
+	if len(labels) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, label := range labels {
+			pairs = append(pairs, label+"="+quoteLabelValue(values[i]))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabelValue(extra[i+1]))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes label values as the Prometheus text format
+// expects: only backslash, double quote and line feed are escaped, unlike Go
+// quoting.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabelValue(value string) string {
+	return `"` + labelValueEscaper.Replace(value) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sb strings.Builder
+	for _, c := range r.collectors {
+		c.write(&sb, openMetrics)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if openMetrics {
+		sb.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", textContentType)
+	}
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLabelValueEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "azure", want: `{provider="azure"}`},
+		{name: "backslash", value: `a\b`, want: `{provider="a\\b"}`},
+		{name: "quote", value: `a"b`, want: `{provider="a\"b"}`},
+		{name: "newline", value: "a\nb", want: `{provider="a\nb"}`},
+		{name: "tab kept", value: "a\tb", want: "{provider=\"a\tb\"}"},
+		{name: "unicode kept", value: "café", want: `{provider="café"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			counter := registry.NewCounterVec("test_total", "Test counter.", "provider")
+			counter.Inc(tt.value)
+
+			rec := httptest.NewRecorder()
+			registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+			if want := "test_total" + tt.want + " 1\n"; !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestServeVary(t *testing.T) {
+	for _, accept := range []string{"text/plain", "application/openmetrics-text"} {
+		t.Run(accept, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", accept)
+			NewRegistry().ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want %q", got, "Accept")
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, accept) {
+				t.Errorf("Content-Type = %q, want %s", got, accept)
+			}
+		})
+	}
+}
+
+func TestHistogramExemplars(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	registry := NewRegistry()
+	histogram := registry.NewHistogramVec("test_seconds", "Test histogram.", []float64{.1, 1})
+	histogram.ObserveWithExemplar(.05, traceID)
+	histogram.ObserveWithExemplar(.5, "")
+
+	tests := []struct {
+		name          string
+		accept        string
+		wantExemplars int
+		wantBucketEnd string
+	}{
+		{name: "openmetrics", accept: "application/openmetrics-text", wantExemplars: 1, wantBucketEnd: `test_seconds_bucket{le="0.1"} 1 # {trace_id="` + traceID + `"} 0.05 `},
+		{name: "text", accept: "text/plain", wantBucketEnd: `test_seconds_bucket{le="0.1"} 1` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", tt.accept)
+			registry.ServeHTTP(rec, req)
+			body := rec.Body.String()
+
+			if !strings.Contains(body, tt.wantBucketEnd) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBucketEnd)
+			}
+			if got := strings.Count(body, "trace_id="); got != tt.wantExemplars {
+				t.Errorf("body has %d exemplars, want %d:\n%s", got, tt.wantExemplars, body)
+			}
+			if !strings.Contains(body, `test_seconds_bucket{le="1"} 2`) || !strings.Contains(body, "test_seconds_count 2\n") {
+				t.Errorf("body = %q, want both observations counted", body)
+			}
+		})
+	}
+}
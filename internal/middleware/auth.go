@@ -2,14 +2,16 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/metrics"
 	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
@@ -20,82 +22,311 @@ const SessionContextKey contextKey = "session"
 type AuthMiddleware struct {
 	cfg       config.ServerConfig
 	cache     cache.Cache
+	codec     *cache.Codec
 	providers map[string]auth.Provider
 	logger    *slog.Logger
 }
 
-func NewAuthMiddleware(cfg config.ServerConfig, cache cache.Cache, providers map[string]auth.Provider, logger *slog.Logger) *AuthMiddleware {
+func NewAuthMiddleware(cfg config.ServerConfig, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, logger *slog.Logger) *AuthMiddleware {
 	return &AuthMiddleware{
 		cfg:       cfg,
 		cache:     cache,
+		codec:     codec,
 		providers: providers,
 		logger:    logger,
 	}
 }
 
+// authenticated is the handler returned by RequireAuth, so that routes can
+// be checked for it with RequiresAuth.
+type authenticated struct {
+	http.Handler
+}
+
+// RequiresAuth reports whether h was returned by RequireAuth.
+func RequiresAuth(h http.Handler) bool {
+	_, ok := h.(authenticated)
+	return ok
+}
+
 func (am *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := security.GetSessionCookie(r, am.cfg.CookieName)
 		if err != nil {
+			if token := bearerToken(r); token != "" {
+				am.serveServiceSession(w, r, next, token)
+				return
+			}
+
 			am.logger.Debug("no session cookie found", "path", r.URL.Path)
-			http.Redirect(w, r, "/auth/select", http.StatusFound)
+			RedirectToLogin(w, r, am.cfg)
 			return
 		}
 
 		sessionData, err := am.cache.Get(r.Context(), "session:"+cookie.Value)
 		if err != nil {
 			am.logger.Debug("session not found in cache", "session_id", cookie.Value)
-			http.Redirect(w, r, "/auth/select", http.StatusFound)
+			RedirectToLogin(w, r, am.cfg)
 			return
 		}
 
 		var session auth.Session
-		if err := json.Unmarshal(sessionData, &session); err != nil {
+		if err := am.codec.Unmarshal(sessionData, &session); err != nil {
 			am.logger.Error("failed to unmarshal session", "error", err)
-			http.Redirect(w, r, "/auth/select", http.StatusFound)
+			RedirectToLogin(w, r, am.cfg)
+			return
+		}
+
+		if am.cfg.MaxSessionLifetime > 0 && time.Since(session.CreatedAt) > am.cfg.MaxSessionLifetime {
+			am.logger.Info("session exceeded maximum lifetime",
+				"session_id", cookie.Value,
+				"created_at", session.CreatedAt,
+			)
+			am.endSession(w, r, cookie.Value)
+			RedirectToLogin(w, r, am.cfg)
 			return
 		}
 
+		// Sessions of a provider removed from the configuration cannot be
+		// validated or refreshed there.
 		provider, exists := am.providers[session.ProviderID]
-		if !exists {
-			am.logger.Error("provider not found", "provider_id", session.ProviderID)
-			http.Redirect(w, r, "/auth/select", http.StatusFound)
+		if !exists && !am.keepOrphanedSession(w, r, &session, cookie.Value) {
 			return
 		}
 
-		if err := provider.ValidateSession(r.Context(), &session); err != nil {
-			am.logger.Debug("session validation failed", "error", err)
+		if exists {
+			if err := provider.ValidateSession(r.Context(), &session); err != nil {
+				am.logger.Debug("session validation failed", "error", err)
 
-			if session.ProviderType == "oidc" && time.Until(session.TokenExpiry) < 5*time.Minute {
-				newSession, err := provider.RefreshSession(r.Context(), &session)
-				if err != nil {
-					am.logger.Warn("token refresh failed", "error", err)
-					http.Redirect(w, r, "/auth/select", http.StatusFound)
-					return
-				}
+				if session.ProviderType == "oidc" && time.Until(session.TokenExpiry) < 5*time.Minute {
+					metrics.TokenRefreshAttempts.Inc(provider.ID())
 
-				sessionData, err := json.Marshal(newSession)
-				if err != nil {
-					am.logger.Error("failed to marshal refreshed session", "error", err)
-					http.Redirect(w, r, "/auth/select", http.StatusFound)
-					return
-				}
+					newSession, err := am.refreshSession(r.Context(), provider, &session)
+					if err != nil {
+						reason := auth.RefreshFailureReason(err)
+						metrics.TokenRefreshFailures.Inc(provider.ID(), reason)
+						am.logger.Warn("token refresh failed",
+							"provider", provider.ID(),
+							"reason", reason,
+							"error", err,
+						)
+						if auth.RefreshRetryable(err) {
+							// Keep the session: the next request tries again.
+							w.Header().Set("Retry-After", "5")
+							httperror.Respond(w, r, http.StatusServiceUnavailable, "idp_unavailable", "The identity provider is temporarily unavailable, please try again")
+							return
+						}
+						am.endSession(w, r, cookie.Value)
+						RedirectToLogin(w, r, am.cfg)
+						return
+					}
+
+					metrics.TokenRefreshSuccesses.Inc(provider.ID())
+					am.logger.Info("token refreshed", "provider", provider.ID(), "session_id", session.ID)
+
+					newSession.UserInfo = auth.FilterClaims(newSession.UserInfo, am.cfg.SessionClaims)
+
+					if am.cfg.MaxSessionLifetime > 0 {
+						deadline := newSession.CreatedAt.Add(am.cfg.MaxSessionLifetime)
+						if newSession.ExpiresAt.After(deadline) {
+							newSession.ExpiresAt = deadline
+						}
+					}
+
+					expiresAt := newSession.ExpiresAt
+					if auth.CapExpiry(newSession, am.cfg.MaxTokenTTL, time.Now()) {
+						am.logger.Info("capped refreshed session expiry to max_token_ttl",
+							"provider", provider.ID(),
+							"session_id", session.ID,
+							"expires_at", expiresAt,
+							"capped_to", newSession.ExpiresAt,
+						)
+					}
 
-				ttl := time.Until(newSession.ExpiresAt)
-				if err := am.cache.Set(r.Context(), "session:"+cookie.Value, sessionData, ttl); err != nil {
-					am.logger.Error("failed to update session in cache", "error", err)
+					sessionData, err := am.codec.Marshal(newSession)
+					if err != nil {
+						am.logger.Error("failed to marshal refreshed session", "error", err)
+						RedirectToLogin(w, r, am.cfg)
+						return
+					}
+
+					ttl := time.Until(newSession.ExpiresAt)
+					if err := am.cache.Set(r.Context(), "session:"+cookie.Value, sessionData, ttl); err != nil {
+						am.logger.Error("failed to update session in cache", "error", err)
+					}
+
+					session = *newSession
+				} else {
+					RedirectToLogin(w, r, am.cfg)
+					return
 				}
+			}
 
-				session = *newSession
-			} else {
-				http.Redirect(w, r, "/auth/select", http.StatusFound)
-				return
+			if refresher, ok := provider.(auth.UserInfoRefresher); ok {
+				am.refreshUserInfo(r, refresher, &session)
 			}
 		}
 
 		ctx := context.WithValue(r.Context(), SessionContextKey, &session)
+		if am.cfg.ConcurrentLoginPolicy == "header" {
+			count, err := CountUserSessions(r.Context(), am.cache, am.codec, &session)
+			if err != nil {
+				am.logger.Warn("failed to count user sessions", "session_id", session.ID, "error", err)
+			} else if count > 0 {
+				ctx = context.WithValue(ctx, concurrentSessionsContextKey, count)
+			}
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
+	return authenticated{handler}
+}
+
+// keepOrphanedSession applies orphaned_session_policy to a session whose
+// provider is not configured. With keep, unexpired sessions are accepted as
+// they are; otherwise the session is ended and the user is sent to log in
+// again with a notice. It reports whether the request may proceed.
+func (am *AuthMiddleware) keepOrphanedSession(w http.ResponseWriter, r *http.Request, session *auth.Session, sessionID string) bool {
+	if am.cfg.OrphanedSessionPolicy == "keep" && time.Now().Before(session.ExpiresAt) {
+		am.logger.Debug("keeping session of a removed provider", "provider_id", session.ProviderID, "session_id", sessionID)
+		return true
+	}
+
+	am.logger.Info("ending session of a removed provider",
+		"provider_id", session.ProviderID,
+		"session_id", sessionID,
+		"policy", am.cfg.OrphanedSessionPolicy,
+	)
+	am.endSession(w, r, sessionID)
+	RedirectToLoginWithNotice(w, r, am.cfg, NoticeProviderRemoved)
+	return false
+}
+
+// refreshSession refreshes the session's tokens, retrying failures that may
+// be transient with exponential backoff. When the refresh token is rejected
+// because a concurrent request already rotated it, the session that request
+// stored is used instead.
+func (am *AuthMiddleware) refreshSession(ctx context.Context, provider auth.Provider, session *auth.Session) (*auth.Session, error) {
+	backoff := am.cfg.RefreshRetryBackoff
+	for attempt := 0; ; attempt++ {
+		newSession, err := provider.RefreshSession(ctx, session)
+		if err == nil {
+			return newSession, nil
+		}
+
+		if auth.RefreshFailureReason(err) == auth.RefreshReasonInvalidGrant {
+			if rotated, ok := am.rotatedSession(ctx, session); ok {
+				am.logger.Debug("refresh token already rotated by a concurrent request", "session_id", session.ID)
+				return rotated, nil
+			}
+			return nil, err
+		}
+
+		if !auth.RefreshRetryable(err) || attempt >= am.refreshRetries() {
+			return nil, err
+		}
+
+		am.logger.Debug("retrying token refresh",
+			"provider", provider.ID(),
+			"attempt", attempt+1,
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (am *AuthMiddleware) refreshRetries() int {
+	if am.cfg.RefreshRetries == nil {
+		return 0
+	}
+	return *am.cfg.RefreshRetries
+}
+
+// rotatedSession returns the cached copy of session when it holds a
+// different, still valid refresh token.
+func (am *AuthMiddleware) rotatedSession(ctx context.Context, session *auth.Session) (*auth.Session, bool) {
+	data, err := am.cache.Get(ctx, "session:"+session.ID)
+	if err != nil {
+		return nil, false
+	}
+
+	var current auth.Session
+	if err := am.codec.Unmarshal(data, &current); err != nil {
+		return nil, false
+	}
+	if current.RefreshToken == "" || current.RefreshToken == session.RefreshToken || time.Now().After(current.ExpiresAt) {
+		return nil, false
+	}
+	return &current, true
+}
+
+// endSession deletes a session that can no longer be used and clears its
+// cookie.
+func (am *AuthMiddleware) endSession(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if err := am.cache.Delete(r.Context(), "session:"+sessionID); err != nil {
+		am.logger.Warn("failed to delete session from cache", "error", err)
+	}
+	security.SetSessionCookie(w, r, am.cfg, security.ClearSessionCookie(am.cfg))
+}
+
+// refreshUserInfo lets the provider re-read the session's claims when they
+// are due and stores the updated session. Failures keep the current claims.
+func (am *AuthMiddleware) refreshUserInfo(r *http.Request, refresher auth.UserInfoRefresher, session *auth.Session) {
+	updated, err := refresher.RefreshUserInfo(r.Context(), session)
+	if !updated {
+		return
+	}
+	if err != nil {
+		am.logger.Warn("userinfo refresh failed", "provider", session.ProviderID, "session_id", session.ID, "error", err)
+	} else {
+		session.UserInfo = auth.FilterClaims(session.UserInfo, am.cfg.SessionClaims)
+	}
+
+	sessionData, err := am.codec.Marshal(session)
+	if err != nil {
+		am.logger.Error("failed to marshal session", "error", err)
+		return
+	}
+	if err := am.cache.Set(r.Context(), "session:"+session.ID, sessionData, time.Until(session.ExpiresAt)); err != nil {
+		am.logger.Error("failed to update session in cache", "error", err)
+	}
+}
+
+// serveServiceSession authenticates a machine client by the bearer token
+// issued by the client credentials grant. Only service sessions are accepted.
+func (am *AuthMiddleware) serveServiceSession(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	sessionData, err := am.cache.Get(r.Context(), "session:"+token)
+	if err != nil {
+		httperror.Respond(w, r, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	var session auth.Session
+	if err := am.codec.Unmarshal(sessionData, &session); err != nil {
+		am.logger.Error("failed to unmarshal session", "error", err)
+		httperror.Respond(w, r, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	if session.ProviderType != auth.ServiceProviderType || time.Now().After(session.ExpiresAt) {
+		httperror.Respond(w, r, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), SessionContextKey, &session)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
 }
 
 func GetSession(ctx context.Context) (*auth.Session, bool) {
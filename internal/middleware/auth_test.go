@@ -0,0 +1,400 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/metrics"
+)
+
+// newTestAuthMiddleware returns an auth middleware over a fresh memory cache
+// holding sessions.
+func newTestAuthMiddleware(t *testing.T, cfg config.ServerConfig, providers map[string]auth.Provider, sessions ...*auth.Session) (*AuthMiddleware, cache.Cache) {
+	t.Helper()
+
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("create codec: %v", err)
+	}
+
+	for _, session := range sessions {
+		data, err := codec.Marshal(session)
+		if err != nil {
+			t.Fatalf("marshal session: %v", err)
+		}
+		if err := c.Set(context.Background(), "session:"+session.ID, data, time.Hour); err != nil {
+			t.Fatalf("store session: %v", err)
+		}
+	}
+
+	return NewAuthMiddleware(cfg, c, codec, providers, discardLogger()), c
+}
+
+// refreshStubProvider is an OIDC provider whose sessions always need a
+// refresh. Successive refreshes fail with errs in turn, then succeed. Methods
+// other than the ones overridden here are not used by the auth middleware.
+type refreshStubProvider struct {
+	auth.Provider
+	errs  []error
+	calls int
+}
+
+func (p *refreshStubProvider) ID() string { return "corp" }
+
+func (p *refreshStubProvider) ValidateSession(ctx context.Context, session *auth.Session) error {
+	return errors.New("token expired")
+}
+
+func (p *refreshStubProvider) RefreshSession(ctx context.Context, session *auth.Session) (*auth.Session, error) {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return nil, p.errs[p.calls-1]
+	}
+	refreshed := *session
+	refreshed.RefreshToken = "rotated"
+	refreshed.TokenExpiry = time.Now().Add(time.Hour)
+	return &refreshed, nil
+}
+
+// testRefreshSession returns a session of refreshStubProvider whose tokens
+// are due for a refresh.
+func testRefreshSession() *auth.Session {
+	now := time.Now()
+	return &auth.Session{
+		ID:           "s1",
+		ProviderID:   "corp",
+		ProviderType: "oidc",
+		UserInfo:     map[string]interface{}{"sub": "alice"},
+		RefreshToken: "refresh",
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Hour),
+		TokenExpiry:  now,
+	}
+}
+
+func TestRequiresAuth(t *testing.T) {
+	am, _ := newTestAuthMiddleware(t, config.ServerConfig{}, map[string]auth.Provider{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    bool
+	}{
+		{name: "wrapped", handler: am.RequireAuth(next), want: true},
+		{name: "not wrapped", handler: next},
+		{name: "behind other middleware", handler: Logging(discardLogger())(am.RequireAuth(next))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RequiresAuth(tt.handler); got != tt.want {
+				t.Errorf("RequiresAuth = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrphanedSessionPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		expiresIn    time.Duration
+		wantNext     bool
+		wantLocation string
+		wantDeleted  bool
+	}{
+		{name: "keep", policy: "keep", expiresIn: time.Hour, wantNext: true},
+		{name: "keep expired", policy: "keep", expiresIn: -time.Minute, wantLocation: "/auth/select?notice=" + NoticeProviderRemoved, wantDeleted: true},
+		{name: "logout", policy: "logout", expiresIn: time.Hour, wantLocation: "/auth/select?notice=" + NoticeProviderRemoved, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := config.ServerConfig{CookieName: "session", OrphanedSessionPolicy: tt.policy}
+			am, c := newTestAuthMiddleware(t, cfg, map[string]auth.Provider{}, &auth.Session{
+				ID:         "s1",
+				ProviderID: "removed",
+				UserInfo:   map[string]interface{}{"sub": "alice"},
+				CreatedAt:  time.Now(),
+				ExpiresAt:  time.Now().Add(tt.expiresIn),
+			})
+
+			var got *auth.Session
+			handler := am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = GetSession(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if (got != nil) != tt.wantNext {
+				t.Fatalf("request proxied = %v, want %v", got != nil, tt.wantNext)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", location, tt.wantLocation)
+			}
+			if exists, _ := c.Exists(ctx, "session:s1"); exists == tt.wantDeleted {
+				t.Errorf("session deleted = %v, want %v", !exists, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestServiceSession(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantClient    string
+	}{
+		{name: "valid token", authorization: "Bearer token", wantStatus: http.StatusOK, wantClient: "billing"},
+		{name: "lowercase scheme", authorization: "bearer token", wantStatus: http.StatusOK, wantClient: "billing"},
+		{name: "expired token", authorization: "Bearer expired", wantStatus: http.StatusUnauthorized},
+		{name: "unknown token", authorization: "Bearer forged", wantStatus: http.StatusUnauthorized},
+		{name: "user session id", authorization: "Bearer s1", wantStatus: http.StatusUnauthorized},
+		{name: "no token", wantStatus: http.StatusFound},
+	}
+
+	now := time.Now()
+	am, _ := newTestAuthMiddleware(t, config.ServerConfig{CookieName: "session"}, map[string]auth.Provider{},
+		&auth.Session{ID: "token", ProviderID: "billing", ProviderType: auth.ServiceProviderType, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		&auth.Session{ID: "expired", ProviderID: "billing", ProviderType: auth.ServiceProviderType, CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+		&auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *auth.Session
+			handler := am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = GetSession(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantClient == "" {
+				if got != nil {
+					t.Errorf("request reached the handler with session %s", got.ID)
+				}
+				return
+			}
+			if got == nil || got.ProviderID != tt.wantClient {
+				t.Errorf("session = %v, want client %s", got, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestRefreshRetries(t *testing.T) {
+	unavailable := &auth.RefreshError{Reason: auth.RefreshReasonIdPUnavailable, Err: errors.New("503 Service Unavailable")}
+	network := &auth.RefreshError{Reason: auth.RefreshReasonNetwork, Err: errors.New("connection refused")}
+	invalidGrant := &auth.RefreshError{Reason: auth.RefreshReasonInvalidGrant, Err: errors.New("invalid_grant")}
+
+	tests := []struct {
+		name        string
+		retries     int
+		errs        []error
+		wantCalls   int
+		wantStatus  int
+		wantNext    bool
+		wantDeleted bool
+	}{
+		{name: "transient then success", retries: 2, errs: []error{unavailable, network}, wantCalls: 3, wantStatus: http.StatusOK, wantNext: true},
+		{name: "transient exhausts retries", retries: 2, errs: []error{unavailable, unavailable, network}, wantCalls: 3, wantStatus: http.StatusServiceUnavailable},
+		{name: "retries disabled", retries: 0, errs: []error{network}, wantCalls: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "invalid grant not retried", retries: 2, errs: []error{invalidGrant}, wantCalls: 1, wantStatus: http.StatusFound, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &refreshStubProvider{errs: tt.errs}
+			cfg := config.ServerConfig{CookieName: "session", RefreshRetries: &tt.retries, RefreshRetryBackoff: time.Millisecond}
+			am, c := newTestAuthMiddleware(t, cfg, map[string]auth.Provider{"corp": provider}, testRefreshSession())
+
+			var got *auth.Session
+			handler := am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = GetSession(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.Header.Set("Accept", "application/json")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if provider.calls != tt.wantCalls {
+				t.Errorf("refresh calls = %d, want %d", provider.calls, tt.wantCalls)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if (got != nil) != tt.wantNext {
+				t.Errorf("request proxied = %v, want %v", got != nil, tt.wantNext)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("503 without Retry-After")
+			}
+
+			data, err := c.Get(context.Background(), "session:s1")
+			if tt.wantDeleted {
+				if err == nil {
+					t.Errorf("session kept, want it ended")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("session ended, want it kept: %v", err)
+			}
+			var stored auth.Session
+			if err := am.codec.Unmarshal(data, &stored); err != nil {
+				t.Fatalf("unmarshal session: %v", err)
+			}
+			wantToken := "refresh"
+			if tt.wantNext {
+				wantToken = "rotated"
+			}
+			if stored.RefreshToken != wantToken {
+				t.Errorf("stored refresh token = %q, want %q", stored.RefreshToken, wantToken)
+			}
+		})
+	}
+}
+
+func TestRefreshMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantReason  string
+		wantSuccess bool
+	}{
+		{name: "success", wantSuccess: true},
+		{name: "invalid grant", err: &auth.RefreshError{Reason: auth.RefreshReasonInvalidGrant, Err: errors.New("invalid_grant")}, wantReason: auth.RefreshReasonInvalidGrant},
+		{name: "idp unavailable", err: &auth.RefreshError{Reason: auth.RefreshReasonIdPUnavailable, Err: errors.New("503 Service Unavailable")}, wantReason: auth.RefreshReasonIdPUnavailable},
+		{name: "unclassified error", err: errors.New("boom"), wantReason: auth.RefreshReasonUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &refreshStubProvider{}
+			if tt.err != nil {
+				provider.errs = []error{tt.err}
+			}
+			noRetries := 0
+			cfg := config.ServerConfig{CookieName: "session", RefreshRetries: &noRetries}
+			am, _ := newTestAuthMiddleware(t, cfg, map[string]auth.Provider{"corp": provider}, testRefreshSession())
+
+			attempts := metrics.TokenRefreshAttempts.Value("corp")
+			successes := metrics.TokenRefreshSuccesses.Value("corp")
+			var failures float64
+			if tt.wantReason != "" {
+				failures = metrics.TokenRefreshFailures.Value("corp", tt.wantReason)
+			}
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := metrics.TokenRefreshAttempts.Value("corp") - attempts; got != 1 {
+				t.Errorf("attempts increased by %v, want 1", got)
+			}
+			wantSuccesses := 0.0
+			if tt.wantSuccess {
+				wantSuccesses = 1
+			}
+			if got := metrics.TokenRefreshSuccesses.Value("corp") - successes; got != wantSuccesses {
+				t.Errorf("successes increased by %v, want %v", got, wantSuccesses)
+			}
+			if tt.wantReason != "" {
+				if got := metrics.TokenRefreshFailures.Value("corp", tt.wantReason) - failures; got != 1 {
+					t.Errorf("failures{reason=%q} increased by %v, want 1", tt.wantReason, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxSessionLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		age      time.Duration
+		wantNext bool
+	}{
+		{name: "within lifetime", age: 23 * time.Hour, wantNext: true},
+		{name: "past lifetime", age: 25 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &refreshStubProvider{}
+			session := testRefreshSession()
+			session.CreatedAt = time.Now().Add(-tt.age)
+			session.ExpiresAt = time.Now().Add(2 * time.Hour)
+
+			cfg := config.ServerConfig{CookieName: "session", MaxSessionLifetime: 24 * time.Hour}
+			am, c := newTestAuthMiddleware(t, cfg, map[string]auth.Provider{"corp": provider}, session)
+
+			var got *auth.Session
+			handler := am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = GetSession(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if (got != nil) != tt.wantNext {
+				t.Fatalf("request proxied = %v, want %v", got != nil, tt.wantNext)
+			}
+
+			if tt.wantNext {
+				// The refreshed session may not outlive the maximum lifetime.
+				if deadline := session.CreatedAt.Add(24 * time.Hour); !got.ExpiresAt.Equal(deadline) {
+					t.Errorf("ExpiresAt = %s, want %s", got.ExpiresAt, deadline)
+				}
+				return
+			}
+
+			if provider.calls != 0 {
+				t.Errorf("refresh calls = %d, want the session rejected before refreshing", provider.calls)
+			}
+			if rec.Code != http.StatusFound {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusFound)
+			}
+			if exists, _ := c.Exists(context.Background(), "session:s1"); exists {
+				t.Errorf("session kept, want it deleted")
+			}
+			cleared := false
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == "session" && cookie.Value == "" && cookie.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if !cleared {
+				t.Errorf("session cookie not cleared")
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// ConcurrencyLimit sheds requests with 503 once limit requests are in
+// flight. Health and readiness checks are never limited. A limit of zero disables it.
+func ConcurrencyLimit(limit int, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+
+		sem := make(chan struct{}, limit)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				next.ServeHTTP(w, r)
+			default:
+				logger.Warn("request rejected, concurrency limit reached", "limit", limit, "path", r.URL.Path)
+				w.Header().Set("Retry-After", "1")
+				httperror.Respond(w, r, http.StatusServiceUnavailable, "overloaded", "Server is busy, please retry")
+			}
+		})
+	}
+}
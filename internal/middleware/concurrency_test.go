@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int
+		path       string
+		wantStatus int
+	}{
+		{name: "over the limit", limit: 2, path: "/app", wantStatus: http.StatusServiceUnavailable},
+		{name: "health check", limit: 2, path: "/health", wantStatus: http.StatusOK},
+		{name: "readiness check", limit: 2, path: "/ready", wantStatus: http.StatusOK},
+		{name: "disabled", path: "/app", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Requests to /app block until released, so that the limit
+			// stays reached while the extra request is made.
+			release := make(chan struct{})
+			var started sync.WaitGroup
+			handler := ConcurrencyLimit(tt.limit, discardLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/app" && r.Header.Get("X-Blocking") != "" {
+					started.Done()
+					<-release
+				}
+			}))
+
+			var done sync.WaitGroup
+			for range tt.limit {
+				started.Add(1)
+				done.Add(1)
+				go func() {
+					defer done.Done()
+					req := httptest.NewRequest("GET", "/app", nil)
+					req.Header.Set("X-Blocking", "1")
+					handler.ServeHTTP(httptest.NewRecorder(), req)
+				}()
+			}
+			started.Wait()
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			close(release)
+			done.Wait()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			wantRetryAfter := ""
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				wantRetryAfter = "1"
+			}
+			if got := rec.Header().Get("Retry-After"); got != wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, wantRetryAfter)
+			}
+
+			// The slots are released once the blocked requests finish.
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/app", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status after release = %d, want %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
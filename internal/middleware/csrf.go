@@ -1,28 +1,57 @@
 package middleware
 
 import (
-	"context"
+	"crypto/subtle"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
 	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
+const (
+	CSRFModeCache        = "cache"
+	CSRFModeDoubleSubmit = "double_submit"
+
+	// CSRFCookieName holds the token in double_submit mode.
+	CSRFCookieName = "__csrf"
+
+	csrfTokenTTL = 10 * time.Minute
+)
+
 type CSRFMiddleware struct {
+	cfg    config.ServerConfig
 	cache  cache.Cache
 	logger *slog.Logger
 }
 
-func NewCSRFMiddleware(cache cache.Cache, logger *slog.Logger) *CSRFMiddleware {
+func NewCSRFMiddleware(cfg config.ServerConfig, cache cache.Cache, logger *slog.Logger) *CSRFMiddleware {
 	return &CSRFMiddleware{
+		cfg:    cfg,
 		cache:  cache,
 		logger: logger,
 	}
 }
 
 func (cm *CSRFMiddleware) ValidateCSRF(next http.Handler) http.Handler {
+	return cm.validate(next, nil)
+}
+
+// ValidateCSRFForm is ValidateCSRF for endpoints posted to by HTML forms. A
+// browser form submission with a missing or expired token, typically from a
+// page left open, is passed to retry, which re-renders the form with a fresh
+// token. Other requests, such as API calls sending X-CSRF-Token, still get a
+// 403.
+func (cm *CSRFMiddleware) ValidateCSRFForm(next, retry http.Handler) http.Handler {
+	return cm.validate(next, retry)
+}
+
+func (cm *CSRFMiddleware) validate(next, retry http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" || r.Method == "PUT" || r.Method == "DELETE" {
 			token := r.FormValue("csrf_token")
@@ -32,39 +61,102 @@ func (cm *CSRFMiddleware) ValidateCSRF(next http.Handler) http.Handler {
 
 			if token == "" {
 				cm.logger.Warn("missing CSRF token", "path", r.URL.Path)
-				http.Error(w, "Missing CSRF token", http.StatusForbidden)
+				cm.reject(w, r, retry, "csrf_missing", "Missing CSRF token")
 				return
 			}
 
-			exists, err := cm.cache.Exists(r.Context(), "csrf:"+token)
-			if err != nil {
-				cm.logger.Error("failed to check CSRF token", "error", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
+			var valid bool
+			if cm.cfg.CSRFMode == CSRFModeDoubleSubmit {
+				valid = cm.validateDoubleSubmit(w, r, token)
+			} else {
+				exists, err := cm.cache.Exists(r.Context(), "csrf:"+token)
+				if err != nil {
+					cm.logger.Error("failed to check CSRF token", "error", err)
+					httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+					return
+				}
+				if exists {
+					cm.cache.Delete(r.Context(), "csrf:"+token)
+				}
+				valid = exists
 			}
 
-			if !exists {
+			if !valid {
 				cm.logger.Warn("invalid CSRF token", "path", r.URL.Path)
-				http.Error(w, "Invalid or expired CSRF token", http.StatusForbidden)
+				cm.reject(w, r, retry, "csrf_invalid", "Invalid or expired CSRF token")
 				return
 			}
-
-			cm.cache.Delete(r.Context(), "csrf:"+token)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (cm *CSRFMiddleware) GenerateCSRFToken(ctx context.Context) (string, error) {
+func (cm *CSRFMiddleware) reject(w http.ResponseWriter, r *http.Request, retry http.Handler, code, message string) {
+	if retry != nil {
+		// Browsers get the retry page, other clients an error.
+		httperror.AddVary(w, "Accept")
+	}
+	if retry != nil && isBrowserFormSubmission(r) {
+		retry.ServeHTTP(w, r)
+		return
+	}
+	httperror.Respond(w, r, http.StatusForbidden, code, message)
+}
+
+// isBrowserFormSubmission reports whether r was posted by an HTML form
+// rather than a script or API client.
+func isBrowserFormSubmission(r *http.Request) bool {
+	if r.Header.Get("X-CSRF-Token") != "" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// validateDoubleSubmit compares the submitted token with the CSRF cookie and
+// clears the cookie, so each token is used once.
+func (cm *CSRFMiddleware) validateDoubleSubmit(w http.ResponseWriter, r *http.Request, token string) bool {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	expired := cm.csrfCookie("")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
+
+// GenerateCSRFToken returns a token to embed in a form. In cache mode the
+// token is stored in the cache; in double_submit mode it is set as a cookie.
+func (cm *CSRFMiddleware) GenerateCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
 	token, err := security.GenerateCSRFToken()
 	if err != nil {
 		return "", err
 	}
 
-	if err := cm.cache.Set(ctx, "csrf:"+token, []byte("1"), 10*time.Minute); err != nil {
+	if cm.cfg.CSRFMode == CSRFModeDoubleSubmit {
+		http.SetCookie(w, cm.csrfCookie(token))
+		return token, nil
+	}
+
+	if err := cm.cache.Set(r.Context(), "csrf:"+token, []byte("1"), csrfTokenTTL); err != nil {
 		return "", err
 	}
 
 	return token, nil
 }
+
+func (cm *CSRFMiddleware) csrfCookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     "/auth",
+		MaxAge:   int(csrfTokenTTL.Seconds()),
+		Secure:   cm.cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
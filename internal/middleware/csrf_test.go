@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestCSRFModes(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		// submit changes the token and cookie sent with the form.
+		submit     func(token string, cookie *http.Cookie) (string, *http.Cookie)
+		wantStatus int
+	}{
+		{
+			name:       "cache valid",
+			mode:       CSRFModeCache,
+			submit:     func(token string, cookie *http.Cookie) (string, *http.Cookie) { return token, nil },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "cache unknown token",
+			mode:       CSRFModeCache,
+			submit:     func(token string, cookie *http.Cookie) (string, *http.Cookie) { return "forged", nil },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "double submit valid",
+			mode:       CSRFModeDoubleSubmit,
+			submit:     func(token string, cookie *http.Cookie) (string, *http.Cookie) { return token, cookie },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "double submit without cookie",
+			mode:       CSRFModeDoubleSubmit,
+			submit:     func(token string, cookie *http.Cookie) (string, *http.Cookie) { return token, nil },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "double submit mismatch",
+			mode: CSRFModeDoubleSubmit,
+			submit: func(token string, cookie *http.Cookie) (string, *http.Cookie) {
+				return token, &http.Cookie{Name: CSRFCookieName, Value: "forged"}
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "double submit missing token",
+			mode:       CSRFModeDoubleSubmit,
+			submit:     func(token string, cookie *http.Cookie) (string, *http.Cookie) { return "", cookie },
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			csrf := NewCSRFMiddleware(config.ServerConfig{CSRFMode: tt.mode}, c, discardLogger())
+
+			page := httptest.NewRecorder()
+			token, err := csrf.GenerateCSRFToken(page, httptest.NewRequest("GET", "/auth/select", nil))
+			if err != nil {
+				t.Fatalf("GenerateCSRFToken: %v", err)
+			}
+
+			var cookie *http.Cookie
+			for _, c := range page.Result().Cookies() {
+				if c.Name == CSRFCookieName {
+					cookie = c
+				}
+			}
+			if tt.mode == CSRFModeDoubleSubmit {
+				if cookie == nil || cookie.Value != token {
+					t.Fatalf("CSRF cookie = %v, want the token", cookie)
+				}
+				if keys, _ := c.Keys(context.Background(), "csrf:"); len(keys) > 0 {
+					t.Errorf("double_submit stored tokens in the cache: %v", keys)
+				}
+			} else if cookie != nil {
+				t.Errorf("cache mode set a CSRF cookie")
+			}
+
+			handler := csrf.ValidateCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			post := func(token string, cookie *http.Cookie) int {
+				form := url.Values{}
+				if token != "" {
+					form.Set("csrf_token", token)
+				}
+				req := httptest.NewRequest("POST", "/auth/select", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if cookie != nil {
+					req.AddCookie(cookie)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			submitted, submittedCookie := tt.submit(token, cookie)
+			if got := post(submitted, submittedCookie); got != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", got, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && tt.mode == CSRFModeCache {
+				if got := post(submitted, submittedCookie); got != http.StatusForbidden {
+					t.Errorf("reused token status = %d, want %d", got, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestCSRFRejectVary(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		withRetry  bool
+		wantStatus int
+	}{
+		{name: "browser gets retry page", accept: "text/html", withRetry: true, wantStatus: http.StatusOK},
+		{name: "api client gets error", accept: "application/json", withRetry: true, wantStatus: http.StatusForbidden},
+		{name: "without retry page", accept: "application/json", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			csrf := NewCSRFMiddleware(config.ServerConfig{}, c, discardLogger())
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			var handler http.Handler
+			if tt.withRetry {
+				handler = csrf.ValidateCSRFForm(next, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			} else {
+				handler = csrf.ValidateCSRF(next)
+			}
+
+			req := httptest.NewRequest("POST", "/auth/select", strings.NewReader("csrf_token=forged"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept" {
+				t.Errorf("Vary = %q, want a single Accept", got)
+			}
+		})
+	}
+}
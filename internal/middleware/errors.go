@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// ErrorFormat makes the configured error format available to
+// httperror.Respond for the rest of the chain.
+func ErrorFormat(format string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(httperror.WithFormat(r.Context(), format)))
+		})
+	}
+}
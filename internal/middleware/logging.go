@@ -23,6 +23,17 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush sends any buffered data to the client, so that streamed responses
+// such as gRPC and server-sent events are not held back by logging.
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -37,14 +48,15 @@ func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
 
 			duration := time.Since(start)
 
-			logger.Info("request",
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"remote_addr", r.RemoteAddr,
 				"status", rw.statusCode,
 				"bytes", rw.written,
 				"duration_ms", duration.Milliseconds(),
-			)
+			}
+			logger.Info("request", append(attrs, traceAttrs(r)...)...)
 		})
 	}
 }
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestLoggingFlush(t *testing.T) {
+	tests := []struct {
+		name  string
+		flush func(w http.ResponseWriter) error
+	}{
+		{name: "http.Flusher", flush: func(w http.ResponseWriter) error {
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				t.Fatal("writer does not implement http.Flusher")
+			}
+			flusher.Flush()
+			return nil
+		}},
+		{name: "ResponseController", flush: func(w http.ResponseWriter) error {
+			return http.NewResponseController(w).Flush()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler := Logging(discardLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("chunk"))
+				if err := tt.flush(w); err != nil {
+					t.Errorf("flush: %v", err)
+				}
+				if !rec.Flushed {
+					t.Error("flush did not reach the underlying writer")
+				}
+			}))
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		})
+	}
+}
+
+func TestLoggingUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := Logging(discardLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			t.Fatal("writer does not implement Unwrap")
+		}
+		if unwrapper.Unwrap() != rec {
+			t.Error("Unwrap did not return the underlying writer")
+		}
+	}))
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+}
+
+func TestLoggingTraceContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+	}{
+		{name: "valid", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", want: "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7"},
+		{name: "all-zero trace id", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "malformed", traceparent: "not-a-traceparent"},
+		{name: "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("Traceparent", tt.traceparent)
+			}
+			Logging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+			line := buf.String()
+			if tt.want != "" && !strings.Contains(line, tt.want) {
+				t.Errorf("log line %q does not contain %q", line, tt.want)
+			}
+			if tt.want == "" && strings.Contains(line, "trace_id") {
+				t.Errorf("log line %q has a trace_id", line)
+			}
+		})
+	}
+}
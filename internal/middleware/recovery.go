@@ -4,6 +4,8 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
 )
 
 func Recovery(logger *slog.Logger) func(http.Handler) http.Handler {
@@ -11,16 +13,14 @@ func Recovery(logger *slog.Logger) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					logger.Error("panic recovered",
+					attrs := []any{
 						"error", err,
 						"path", r.URL.Path,
 						"stack", string(debug.Stack()),
-					)
+					}
+					logger.Error("panic recovered", append(attrs, traceAttrs(r)...)...)
 
-					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-					w.Header().Set("X-Content-Type-Options", "nosniff")
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte("Internal Server Error"))
+					httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal Server Error")
 				}
 			}()
 
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// RedirectCountCookieName tracks login redirects as "<count>:<window start>".
+const RedirectCountCookieName = "__sso_redirects"
+
+// RedirectToLogin answers a request without a valid session as configured in
+// unauthenticated_response. In redirect mode the user is sent to the select
+// page, unless they have been sent there max_login_redirects times within
+// login_redirect_window, which points to a redirect loop. Then an error page
+// is shown instead. gRPC calls, which cannot follow redirects, get
+// UNAUTHENTICATED. In auth_only mode the redirect is to the select page under
+// base_url, as the response may be relayed to another host by a ForwardAuth
+// proxy.
+func RedirectToLogin(w http.ResponseWriter, r *http.Request, cfg config.ServerConfig) {
+	redirectToLogin(w, r, cfg, "/auth/select")
+}
+
+// NoticeProviderRemoved tells users that their session ended because its
+// provider is no longer configured.
+const NoticeProviderRemoved = "provider_removed"
+
+// RedirectToLoginWithNotice is RedirectToLogin with a notice, such as
+// NoticeProviderRemoved, shown on the select page.
+func RedirectToLoginWithNotice(w http.ResponseWriter, r *http.Request, cfg config.ServerConfig, notice string) {
+	redirectToLogin(w, r, cfg, "/auth/select?notice="+url.QueryEscape(notice))
+}
+
+func redirectToLogin(w http.ResponseWriter, r *http.Request, cfg config.ServerConfig, target string) {
+	if cfg.Mode == config.ModeAuthOnly {
+		target = strings.TrimSuffix(cfg.BaseURL, "/") + target
+	}
+
+	switch unauth := cfg.UnauthenticatedResponse; unauth.Mode {
+	case "unauthorized":
+		w.Header().Set("WWW-Authenticate", unauth.WWWAuthenticate)
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return
+	case "custom":
+		w.Header().Set("Content-Type", unauth.ContentType)
+		w.WriteHeader(unauth.Status)
+		w.Write([]byte(unauth.Body))
+		return
+	}
+
+	if httperror.IsGRPC(r) {
+		httperror.Respond(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return
+	}
+
+	if cfg.MaxLoginRedirects <= 0 {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	now := time.Now()
+	count, start := 0, now
+	if cookie, err := r.Cookie(RedirectCountCookieName); err == nil {
+		if c, s, ok := parseRedirectCount(cookie.Value); ok && now.Sub(s) < cfg.LoginRedirectWindow {
+			count, start = c, s
+		}
+	}
+
+	count++
+	if count > cfg.MaxLoginRedirects {
+		httperror.Respond(w, r, http.StatusLoopDetected, "redirect_loop",
+			"Login redirected too many times. The session cookie is probably not being stored or sent back: "+
+				"check server.base_url, cookie_domain, cookie_secure and cookie_same_site, and that the provider callback URL points to this server.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RedirectCountCookieName,
+		Value:    fmt.Sprintf("%d:%d", count, start.Unix()),
+		Path:     "/",
+		MaxAge:   int(cfg.LoginRedirectWindow.Seconds()),
+		Secure:   cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ResetRedirectCount clears the login redirect counter after a successful
+// login.
+func ResetRedirectCount(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   RedirectCountCookieName,
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+func parseRedirectCount(value string) (int, time.Time, bool) {
+	countStr, startStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+
+	return count, time.Unix(start, 0), true
+}
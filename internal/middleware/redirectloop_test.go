@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestRedirectToLoginLoop(t *testing.T) {
+	const window = time.Minute
+
+	tests := []struct {
+		name         string
+		maxRedirects int
+		cookie       string
+		hops         int
+		wantStatuses []int
+	}{
+		{
+			name:         "loop detected after max redirects",
+			maxRedirects: 3,
+			hops:         4,
+			wantStatuses: []int{http.StatusFound, http.StatusFound, http.StatusFound, http.StatusLoopDetected},
+		},
+		{
+			name:         "disabled",
+			hops:         5,
+			wantStatuses: []int{http.StatusFound, http.StatusFound, http.StatusFound, http.StatusFound, http.StatusFound},
+		},
+		{
+			name:         "count from an expired window restarts",
+			maxRedirects: 2,
+			cookie:       fmt.Sprintf("2:%d", time.Now().Add(-2*window).Unix()),
+			hops:         1,
+			wantStatuses: []int{http.StatusFound},
+		},
+		{
+			name:         "count within the window continues",
+			maxRedirects: 2,
+			cookie:       fmt.Sprintf("2:%d", time.Now().Unix()),
+			hops:         1,
+			wantStatuses: []int{http.StatusLoopDetected},
+		},
+		{
+			name:         "malformed cookie restarts",
+			maxRedirects: 2,
+			cookie:       "garbage",
+			hops:         1,
+			wantStatuses: []int{http.StatusFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{MaxLoginRedirects: tt.maxRedirects, LoginRedirectWindow: window}
+			cookie := tt.cookie
+
+			for hop := 0; hop < tt.hops; hop++ {
+				req := httptest.NewRequest("GET", "/app", nil)
+				if cookie != "" {
+					req.AddCookie(&http.Cookie{Name: RedirectCountCookieName, Value: cookie})
+				}
+				rec := httptest.NewRecorder()
+				RedirectToLogin(rec, req, cfg)
+
+				if rec.Code != tt.wantStatuses[hop] {
+					t.Fatalf("hop %d: status = %d, want %d", hop+1, rec.Code, tt.wantStatuses[hop])
+				}
+				if rec.Code == http.StatusFound {
+					if got := rec.Header().Get("Location"); got != "/auth/select" {
+						t.Errorf("hop %d: Location = %q, want /auth/select", hop+1, got)
+					}
+				}
+				for _, c := range rec.Result().Cookies() {
+					if c.Name == RedirectCountCookieName {
+						cookie = c.Value
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResetRedirectCount(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ResetRedirectCount(rec)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != RedirectCountCookieName || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want %s cleared", cookies, RedirectCountCookieName)
+	}
+}
+
+func TestRedirectToLoginModes(t *testing.T) {
+	tests := []struct {
+		name             string
+		cfg              config.ServerConfig
+		contentType      string
+		wantStatus       int
+		wantLocation     string
+		wantAuthenticate string
+		wantBody         string
+		wantContentType  string
+		wantGRPCStatus   string
+	}{
+		{
+			name:         "redirect",
+			cfg:          config.ServerConfig{UnauthenticatedResponse: config.UnauthenticatedResponseConfig{Mode: "redirect"}},
+			wantStatus:   http.StatusFound,
+			wantLocation: "/auth/select",
+		},
+		{
+			name:         "redirect in auth_only mode",
+			cfg:          config.ServerConfig{Mode: config.ModeAuthOnly, BaseURL: "https://sso.example.com/", UnauthenticatedResponse: config.UnauthenticatedResponseConfig{Mode: "redirect"}},
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://sso.example.com/auth/select",
+		},
+		{
+			name:           "redirect for grpc",
+			cfg:            config.ServerConfig{UnauthenticatedResponse: config.UnauthenticatedResponseConfig{Mode: "redirect"}},
+			contentType:    "application/grpc",
+			wantStatus:     http.StatusOK,
+			wantGRPCStatus: "16",
+		},
+		{
+			name: "unauthorized",
+			cfg: config.ServerConfig{UnauthenticatedResponse: config.UnauthenticatedResponseConfig{
+				Mode:            "unauthorized",
+				WWWAuthenticate: `Bearer realm="sso-switch"`,
+			}},
+			wantStatus:       http.StatusUnauthorized,
+			wantAuthenticate: `Bearer realm="sso-switch"`,
+		},
+		{
+			name: "custom",
+			cfg: config.ServerConfig{UnauthenticatedResponse: config.UnauthenticatedResponseConfig{
+				Mode:        "custom",
+				Status:      http.StatusForbidden,
+				Body:        `{"error":"login_required"}`,
+				ContentType: "application/json",
+			}},
+			wantStatus:      http.StatusForbidden,
+			wantBody:        `{"error":"login_required"}`,
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/app", nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			RedirectToLogin(rec, req, tt.cfg)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantAuthenticate {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantAuthenticate)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantContentType != "" && rec.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", rec.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if got := rec.Header().Get("Grpc-Status"); got != tt.wantGRPCStatus {
+				t.Errorf("Grpc-Status = %q, want %q", got, tt.wantGRPCStatus)
+			}
+		})
+	}
+}
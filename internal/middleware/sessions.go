@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+)
+
+const concurrentSessionsContextKey contextKey = "concurrent_sessions"
+
+// userSessionsKey is the cache key of the index of a user's session IDs for
+// a provider.
+func userSessionsKey(session *auth.Session) string {
+	return "user_sessions:" + session.ProviderID + ":" + auth.Subject(session)
+}
+
+// TrackUserSession adds session to its user's session index, dropping IDs
+// whose sessions have ended, and returns the number of active sessions. The
+// index lives as long as its newest session.
+func TrackUserSession(ctx context.Context, c cache.Cache, codec *cache.Codec, session *auth.Session, ttl time.Duration) (int, error) {
+	if auth.Subject(session) == "" {
+		return 0, nil
+	}
+
+	ids, err := activeUserSessions(ctx, c, codec, session)
+	if err != nil {
+		return 0, err
+	}
+	ids = append(ids, session.ID)
+
+	data, err := codec.Marshal(ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal user sessions: %w", err)
+	}
+	if err := c.Set(ctx, userSessionsKey(session), data, ttl); err != nil {
+		return 0, fmt.Errorf("failed to cache user sessions: %w", err)
+	}
+	return len(ids), nil
+}
+
+// CountUserSessions returns the number of active sessions of session's user
+// for its provider, including session itself.
+func CountUserSessions(ctx context.Context, c cache.Cache, codec *cache.Codec, session *auth.Session) (int, error) {
+	if auth.Subject(session) == "" {
+		return 0, nil
+	}
+
+	ids, err := activeUserSessions(ctx, c, codec, session)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids) + 1, nil
+}
+
+// activeUserSessions returns the indexed session IDs of session's user that
+// still exist, other than session itself.
+func activeUserSessions(ctx context.Context, c cache.Cache, codec *cache.Codec, session *auth.Session) ([]string, error) {
+	data, err := c.Get(ctx, userSessionsKey(session))
+	if err != nil {
+		return nil, nil
+	}
+
+	var ids []string
+	if err := codec.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user sessions: %w", err)
+	}
+
+	active := ids[:0]
+	for _, id := range ids {
+		if id == session.ID {
+			continue
+		}
+		exists, err := c.Exists(ctx, "session:"+id)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			active = append(active, id)
+		}
+	}
+	return active, nil
+}
+
+// GetConcurrentSessions returns the active session count of the request's
+// user, set under concurrent_login_policy header.
+func GetConcurrentSessions(ctx context.Context) (int, bool) {
+	count, ok := ctx.Value(concurrentSessionsContextKey).(int)
+	return count, ok
+}
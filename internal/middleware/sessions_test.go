@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestUserSessionTracking(t *testing.T) {
+	tests := []struct {
+		name string
+		// logins are the sessions tracked in order, as "subject/id".
+		logins []string
+		// ended are the session IDs deleted after the logins.
+		ended     []string
+		count     string
+		wantCount int
+	}{
+		{name: "single browser", logins: []string{"alice/a1"}, count: "alice/a1", wantCount: 1},
+		{name: "second browser", logins: []string{"alice/a1", "alice/a2"}, count: "alice/a2", wantCount: 2},
+		{name: "other user", logins: []string{"alice/a1", "bob/b1"}, count: "bob/b1", wantCount: 1},
+		{name: "ended session", logins: []string{"alice/a1", "alice/a2"}, ended: []string{"a1"}, count: "alice/a2", wantCount: 1},
+		{name: "new session not yet tracked", logins: []string{"alice/a1"}, count: "alice/a2", wantCount: 2},
+		{name: "no subject", logins: []string{"/x1"}, count: "/x1", wantCount: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c, err := cache.NewMemoryCache(config.MemoryConfig{})
+			if err != nil {
+				t.Fatalf("create cache: %v", err)
+			}
+			codec, err := cache.NewCodec("json")
+			if err != nil {
+				t.Fatalf("create codec: %v", err)
+			}
+
+			for _, login := range tt.logins {
+				session := testUserSession(login)
+				if err := c.Set(ctx, "session:"+session.ID, []byte("{}"), time.Hour); err != nil {
+					t.Fatalf("store session: %v", err)
+				}
+				if _, err := TrackUserSession(ctx, c, codec, session, time.Hour); err != nil {
+					t.Fatalf("TrackUserSession: %v", err)
+				}
+			}
+			for _, id := range tt.ended {
+				c.Delete(ctx, "session:"+id)
+			}
+
+			count, err := CountUserSessions(ctx, c, codec, testUserSession(tt.count))
+			if err != nil {
+				t.Fatalf("CountUserSessions: %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("count = %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+// testUserSession returns the session of a "subject/id" login.
+func testUserSession(login string) *auth.Session {
+	subject, id, _ := strings.Cut(login, "/")
+	userInfo := map[string]interface{}{}
+	if subject != "" {
+		userInfo["sub"] = subject
+	}
+	return &auth.Session{ID: id, ProviderID: "corp", UserInfo: userInfo}
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+)
+
+// timeoutWriter holds back the response headers until the handler writes
+// them, so that a 504 can still be sent if the deadline passes first. Once
+// the headers are written the response goes straight to the client.
+type timeoutWriter struct {
+	w http.ResponseWriter
+
+	mu        sync.Mutex
+	header    http.Header
+	committed bool
+	timedOut  bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	if tw.committed {
+		return tw.w.Header()
+	}
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.commitLocked(statusCode)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.commitLocked(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+// Flush writes the headers, if the handler has not yet, and flushes the
+// underlying writer.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+	tw.commitLocked(http.StatusOK)
+	http.NewResponseController(tw.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// commitLocked sends the held back headers with statusCode. It does nothing
+// once the headers are sent or the request has timed out.
+func (tw *timeoutWriter) commitLocked(statusCode int) {
+	if tw.timedOut || tw.committed {
+		return
+	}
+	tw.committed = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(statusCode)
+}
+
+// Timeout bounds the total time spent handling a request. Until the handler
+// writes the response headers, an exceeded deadline is answered with a 504;
+// afterwards the response is streamed and the handler's context is cancelled
+// at the deadline. Websocket, SSE and gRPC requests are passed through
+// untouched.
+func Timeout(timeout time.Duration, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreamingRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+
+				// Handlers that never write still get their headers sent.
+				tw.commitLocked(http.StatusOK)
+			case <-ctx.Done():
+				tw.mu.Lock()
+				committed := tw.committed
+				if !committed {
+					tw.timedOut = true
+				}
+				tw.mu.Unlock()
+
+				logger.Warn("request timed out",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"timeout", timeout,
+					"response_started", committed,
+				)
+
+				if !committed {
+					httperror.Respond(w, r, http.StatusGatewayTimeout, "timeout", "Gateway Timeout")
+					return
+				}
+
+				// The handler is still writing to w and must finish before
+				// this returns; its context is already cancelled.
+				select {
+				case p := <-panicChan:
+					panic(p)
+				case <-done:
+				}
+			}
+		})
+	}
+}
+
+func isStreamingRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") || httperror.IsGRPC(r)
+}
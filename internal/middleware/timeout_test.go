@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+		wantHeader bool
+	}{
+		{
+			name: "fast handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "1")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("ok"))
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "ok",
+			wantHeader: true,
+		},
+		{
+			name: "handler without writes",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "1")
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: true,
+		},
+		{
+			name: "slow before headers",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				time.Sleep(10 * time.Millisecond)
+				w.Write([]byte("late"))
+			},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name: "slow after headers",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("started"))
+				<-r.Context().Done()
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "started",
+		},
+		{
+			name: "streaming request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+				w.Write([]byte("event"))
+			},
+			header:     map[string]string{"Accept": "text/event-stream"},
+			wantStatus: http.StatusOK,
+			wantBody:   "event",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			Timeout(20*time.Millisecond, discardLogger())(tt.handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantHeader && rec.Header().Get("X-Test") != "1" {
+				t.Error("handler headers were not sent")
+			}
+		})
+	}
+}
+
+func TestTimeoutStreamsResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 1; i <= 3; i++ {
+			w.Write(chunk)
+			if got := rec.Body.Len(); got != i*len(chunk) {
+				t.Fatalf("after %d writes the client has %d bytes, want the response written through", i, got)
+			}
+		}
+
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+		if !rec.Flushed {
+			t.Error("flush did not reach the underlying writer")
+		}
+	})
+
+	Timeout(time.Second, discardLogger())(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+}
+
+func TestTimeoutFlushSendsHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.(http.Flusher).Flush()
+		if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+			t.Errorf("Content-Type after flush = %q, want the headers sent", got)
+		}
+		<-r.Context().Done()
+	})
+
+	Timeout(20*time.Millisecond, discardLogger())(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the flushed 200 kept", rec.Code)
+	}
+}
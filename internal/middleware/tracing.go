@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceContext returns the trace id and parent span id of a W3C traceparent
+// header ("version-traceid-parentid-flags"), or empty strings when there is
+// no valid one.
+func TraceContext(r *http.Request) (traceID, spanID string) {
+	parts := strings.Split(r.Header.Get("Traceparent"), "-")
+	if len(parts) != 4 || !validTraceField(parts[1], 32) || !validTraceField(parts[2], 16) {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+func validTraceField(value string, length int) bool {
+	if len(value) != length || value == strings.Repeat("0", length) {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// traceAttrs returns trace_id and span_id log attributes for r, so that log
+// lines can be correlated with traces, or nothing without a trace context.
+func traceAttrs(r *http.Request) []any {
+	traceID, spanID := TraceContext(r)
+	if traceID == "" {
+		return nil
+	}
+	return []any{"trace_id", traceID, "span_id", spanID}
+}
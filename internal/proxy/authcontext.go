@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+const (
+	AuthMethodsHeader = "X-Auth-Methods"
+	AuthTimeHeader    = "X-Auth-Time"
+)
+
+// authnContextMethods maps SAML AuthnContextClassRef values to RFC 8176
+// authentication method references, as used in the OIDC amr claim.
+var authnContextMethods = map[string][]string{
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Password":                               {"pwd"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport":             {"pwd"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Kerberos":                               {"pwd"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:X509":                                   {"swk"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:TLSClient":                              {"swk"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:Smartcard":                              {"sc"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:SmartcardPKI":                           {"sc", "mfa"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:TimeSyncToken":                          {"otp", "mfa"},
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract":                {"mfa"},
+	"http://schemas.microsoft.com/claims/multipleauthn":                             {"mfa"},
+	"http://schemas.microsoft.com/ws/2012/12/authmethod/otp":                        {"otp", "mfa"},
+	"https://refeds.org/profile/mfa":                                                {"mfa"},
+	"http://schemas.microsoft.com/ws/2008/06/identity/authenticationmethod/windows": {"pwd"},
+}
+
+// InjectAuthContext sets X-Auth-Methods to the comma separated RFC 8176
+// methods from the OIDC amr claim or the SAML AuthnContextClassRef, and
+// X-Auth-Time to the authentication time in RFC 3339. Client-supplied values
+// are always removed.
+func InjectAuthContext(req *http.Request, session *auth.Session) {
+	req.Header.Del(AuthMethodsHeader)
+	req.Header.Del(AuthTimeHeader)
+
+	if methods := authMethods(session.UserInfo); len(methods) > 0 {
+		req.Header.Set(AuthMethodsHeader, strings.Join(methods, ","))
+	}
+	if authTime, ok := authTime(session); ok {
+		req.Header.Set(AuthTimeHeader, authTime.UTC().Format(time.RFC3339))
+	}
+}
+
+func authMethods(userInfo map[string]interface{}) []string {
+	var methods []string
+	add := func(method string) {
+		method = strings.ToLower(strings.TrimSpace(method))
+		if method != "" && !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+
+	switch amr := userInfo["amr"].(type) {
+	case string:
+		add(amr)
+	case []string:
+		for _, m := range amr {
+			add(m)
+		}
+	case []interface{}:
+		for _, m := range amr {
+			if s, ok := m.(string); ok {
+				add(s)
+			}
+		}
+	}
+
+	if classRef, ok := userInfo[auth.AuthnContextClassRefClaim].(string); ok {
+		for _, m := range authnContextMethods[classRef] {
+			add(m)
+		}
+	}
+
+	return methods
+}
+
+// authTime prefers the session's AuthTime, which survives session_claims,
+// over the claims of sessions stored before it was recorded.
+func authTime(session *auth.Session) (time.Time, bool) {
+	if !session.AuthTime.IsZero() {
+		return session.AuthTime, true
+	}
+	return auth.AuthTime(session.UserInfo)
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+func TestInjectAuthContextAuthTime(t *testing.T) {
+	instant := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		session *auth.Session
+		want    string
+	}{
+		{
+			name:    "saml authn instant",
+			session: &auth.Session{UserInfo: map[string]interface{}{auth.AuthnInstantClaim: "2026-03-01T09:30:00Z"}},
+			want:    "2026-03-01T09:30:00Z",
+		},
+		{
+			name:    "oidc auth_time",
+			session: &auth.Session{UserInfo: map[string]interface{}{"auth_time": float64(instant.Unix())}},
+			want:    "2026-03-01T09:30:00Z",
+		},
+		{
+			name:    "session auth time without claims",
+			session: &auth.Session{AuthTime: instant, UserInfo: map[string]interface{}{"sub": "alice"}},
+			want:    "2026-03-01T09:30:00Z",
+		},
+		{
+			name:    "session auth time wins",
+			session: &auth.Session{AuthTime: instant, UserInfo: map[string]interface{}{auth.AuthnInstantClaim: "2020-01-01T00:00:00Z"}},
+			want:    "2026-03-01T09:30:00Z",
+		},
+		{
+			name:    "malformed instant",
+			session: &auth.Session{UserInfo: map[string]interface{}{auth.AuthnInstantClaim: "yesterday"}},
+		},
+		{
+			name:    "unknown",
+			session: &auth.Session{UserInfo: map[string]interface{}{"sub": "alice"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(AuthTimeHeader, "2000-01-01T00:00:00Z")
+			InjectAuthContext(req, tt.session)
+
+			if got := req.Header.Get(AuthTimeHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", AuthTimeHeader, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const copyBufferSize = 32 * 1024
+
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool() *bufferPool {
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, copyBufferSize)
+			},
+		},
+	}
+}
+
+func (bp *bufferPool) Get() []byte {
+	return bp.pool.Get().([]byte)
+}
+
+func (bp *bufferPool) Put(b []byte) {
+	bp.pool.Put(b)
+}
+
+// flushingWriter flushes after every write once the response content type
+// matches one of contentTypes.
+type flushingWriter struct {
+	http.ResponseWriter
+	contentTypes []string
+	flush        bool
+	wroteHeader  bool
+}
+
+func (fw *flushingWriter) WriteHeader(statusCode int) {
+	if !fw.wroteHeader {
+		fw.wroteHeader = true
+		fw.flush = matchesContentType(fw.Header().Get("Content-Type"), fw.contentTypes)
+	}
+	fw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (fw *flushingWriter) Write(b []byte) (int, error) {
+	if !fw.wroteHeader {
+		fw.WriteHeader(http.StatusOK)
+	}
+
+	n, err := fw.ResponseWriter.Write(b)
+	if fw.flush {
+		fw.Flush()
+	}
+	return n, err
+}
+
+// Flush goes through http.ResponseController, which unwraps the middleware
+// writers until one that can flush.
+func (fw *flushingWriter) Flush() {
+	http.NewResponseController(fw.ResponseWriter).Flush()
+}
+
+func (fw *flushingWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+func matchesContentType(contentType string, allowed []string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, a := range allowed {
+		if strings.HasSuffix(a, "/") {
+			if strings.HasPrefix(mediaType, a) {
+				return true
+			}
+		} else if mediaType == a {
+			return true
+		}
+	}
+	return false
+}
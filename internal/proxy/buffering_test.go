@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+func TestFlushingWriterThroughMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantFlushed bool
+	}{
+		{name: "matching type", contentType: "text/event-stream", wantFlushed: true},
+		{name: "matching type with parameters", contentType: "text/event-stream; charset=utf-8", wantFlushed: true},
+		{name: "other type", contentType: "text/html; charset=utf-8", wantFlushed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			handler := middleware.Logging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fw := &flushingWriter{ResponseWriter: w, contentTypes: []string{"text/event-stream", "application/grpc"}}
+				fw.Header().Set("Content-Type", tt.contentType)
+				fw.Write([]byte("data: 1\n\n"))
+
+				if rec.Flushed != tt.wantFlushed {
+					t.Errorf("flushed = %v, want %v", rec.Flushed, tt.wantFlushed)
+				}
+			}))
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		})
+	}
+}
+
+func TestMatchesContentType(t *testing.T) {
+	allowed := []string{"text/event-stream", "application/"}
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "text/event-stream", want: true},
+		{contentType: "text/event-stream; charset=utf-8", want: true},
+		{contentType: "application/json", want: true},
+		{contentType: "text/plain", want: false},
+		{contentType: "", want: false},
+		{contentType: "not a type;;", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := matchesContentType(tt.contentType, allowed); got != tt.want {
+				t.Errorf("matchesContentType(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
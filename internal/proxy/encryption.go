@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// NewHeaderCipher returns the AES-256-GCM cipher for header mappings marked
+// encrypt, or nil when header_encryption is not configured.
+func NewHeaderCipher(cfg *config.HeaderEncryptionConfig) (cipher.AEAD, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header encryption key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptHeaderValue seals value for the given header. The result is the
+// unpadded base64url encoding of nonce || ciphertext || tag, with the
+// lowercased header name as additional data, so that a value cannot be
+// replayed in another header.
+func EncryptHeaderValue(aead cipher.AEAD, header, value string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(strings.ToLower(header)))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
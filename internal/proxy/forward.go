@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// essentialHeaders are always forwarded, as the request cannot be proxied
+// correctly without them.
+var essentialHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Transfer-Encoding",
+	"Connection",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Extensions",
+	"Te",
+	"Grpc-Timeout",
+	"Grpc-Encoding",
+	"Grpc-Accept-Encoding",
+}
+
+// proxyHeaders are only ever set by the proxy, when their feature is enabled.
+// Client-supplied values are removed even when it is not, so that backends
+// reading them cannot be spoofed.
+var proxyHeaders = []string{
+	AuthMethodsHeader,
+	AuthTimeHeader,
+	PairwiseIDHeader,
+}
+
+// newHeaderAllowlist returns the canonical names of the headers kept from the
+// original request, or nil when all headers are forwarded.
+func newHeaderAllowlist(forward []string) map[string]bool {
+	if len(forward) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(forward)+len(essentialHeaders))
+	for _, name := range forward {
+		allowed[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range essentialHeaders {
+		allowed[name] = true
+	}
+	return allowed
+}
+
+// filterHeaders removes proxyHeaders and every header not in allowed. It runs
+// before the identity headers are injected, so injected headers always
+// survive.
+func filterHeaders(header http.Header, allowed map[string]bool) {
+	for _, name := range proxyHeaders {
+		header.Del(name)
+	}
+
+	if allowed == nil {
+		return
+	}
+
+	for name := range header {
+		if !allowed[name] {
+			header.Del(name)
+		}
+	}
+}
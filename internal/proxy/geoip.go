@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPLookup resolves the ISO country code of an IP address.
+type GeoIPLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+type maxMindLookup struct {
+	reader *maxminddb.Reader
+}
+
+func OpenGeoIPDatabase(path string) (GeoIPLookup, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &maxMindLookup{reader: reader}, nil
+}
+
+func (l *maxMindLookup) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	if err := l.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	return record.Country.ISOCode, nil
+}
@@ -0,0 +1,15 @@
+package proxy
+
+import "net/http"
+
+// newGRPCTransport returns a transport that only speaks HTTP/2, as gRPC
+// requires: negotiated over TLS for https backends and with prior knowledge
+// (h2c) for http ones. Trailers and bidirectional streams are relayed by the
+// reverse proxy as is.
+func newGRPCTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
@@ -1,35 +1,322 @@
 package proxy
 
 import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
 )
 
-func InjectHeaders(req *http.Request, session *auth.Session, provider auth.Provider) error {
-	headerMappings := provider.GetHeaderMappings()
+type presetHeader struct {
+	header string
+	claims []string
+}
 
-	for claim, header := range headerMappings {
-		value, exists := session.UserInfo[claim]
-		if !exists {
-			continue
+// headerPresets lists well-known header sets, each filled from the first
+// claim present in the session.
+var headerPresets = map[string][]presetHeader{
+	"oauth2-proxy": {
+		{header: "X-Forwarded-User", claims: []string{"sub", "name_id"}},
+		{header: "X-Forwarded-Email", claims: []string{"email"}},
+		{header: "X-Forwarded-Preferred-Username", claims: []string{"preferred_username"}},
+		{header: "X-Forwarded-Groups", claims: []string{"groups"}},
+	},
+}
+
+// ErrMissingClaim is returned by InjectHeaders when the session lacks the
+// claim of a required header mapping.
+var ErrMissingClaim = errors.New("required claim missing")
+
+// ServiceHeader carries the client id of a service session.
+const ServiceHeader = "X-Auth-Service"
+
+// AssuranceLevelHeader carries the session's level of assurance, when its
+// authentication context is mapped in assurance_levels.
+const AssuranceLevelHeader = "X-Auth-Assurance-Level"
+
+// ConcurrentSessionsHeader carries the user's active session count under
+// concurrent_login_policy header.
+const ConcurrentSessionsHeader = "X-Auth-Concurrent-Sessions"
+
+// ElevatedHeader and ElevatedUntilHeader are set while the session is within
+// the elevation window entered at /auth/elevate.
+const (
+	ElevatedHeader      = "X-Auth-Elevated"
+	ElevatedUntilHeader = "X-Auth-Elevated-Until"
+)
+
+// InjectHeaders sets the preset headers first, so that explicit header
+// mappings can override them. Mappings marked encrypt are sealed with aead.
+// Service sessions have no provider and only get the service header.
+// Client-supplied values of every preset and mapped header are removed, so a
+// claim the session lacks never lets a forged header through.
+func InjectHeaders(req *http.Request, session *auth.Session, provider auth.Provider, preset string, aead cipher.AEAD) error {
+	req.Header.Del(ServiceHeader)
+	req.Header.Del(AssuranceLevelHeader)
+	req.Header.Del(ConcurrentSessionsHeader)
+	req.Header.Del(ElevatedHeader)
+	req.Header.Del(ElevatedUntilHeader)
+
+	var headerMappings map[string]config.HeaderMapping
+	if provider != nil {
+		headerMappings = provider.GetHeaderMappings()
+	}
+
+	for _, ph := range headerPresets[preset] {
+		req.Header.Del(ph.header)
+	}
+	for _, mapping := range headerMappings {
+		req.Header.Del(mapping.Header)
+	}
+
+	if session.ProviderType == auth.ServiceProviderType {
+		req.Header.Set(ServiceHeader, session.ProviderID)
+		req.Header.Set("X-Auth-Provider", session.ProviderID)
+		req.Header.Set("X-Auth-Provider-Type", session.ProviderType)
+		req.Header.Set("X-Auth-Session-ID", session.ID)
+		return nil
+	}
+
+	for _, ph := range headerPresets[preset] {
+		for _, claim := range ph.claims {
+			value, exists := session.UserInfo[claim]
+			if !exists {
+				continue
+			}
+			if headerValue := formatHeaderValue(value); headerValue != "" {
+				req.Header.Set(ph.header, headerValue)
+				break
+			}
 		}
+	}
 
-		headerValue := formatHeaderValue(value)
+	for claim, mapping := range headerMappings {
+		headerValue, err := mappedClaimValue(session, claim, mapping, aead)
+		if err != nil {
+			return err
+		}
 		if headerValue != "" {
-			req.Header.Set(header, headerValue)
+			req.Header.Set(mapping.Header, headerValue)
+		} else if mapping.Required {
+			return fmt.Errorf("%w: %s for header %s", ErrMissingClaim, claim, mapping.Header)
 		}
 	}
 
 	req.Header.Set("X-Auth-Provider", session.ProviderID)
 	req.Header.Set("X-Auth-Provider-Type", session.ProviderType)
 	req.Header.Set("X-Auth-Session-ID", session.ID)
+	if session.AssuranceLevel > 0 {
+		req.Header.Set(AssuranceLevelHeader, strconv.Itoa(session.AssuranceLevel))
+	}
+	if count, ok := middleware.GetConcurrentSessions(req.Context()); ok {
+		req.Header.Set(ConcurrentSessionsHeader, strconv.Itoa(count))
+	}
+	if auth.Elevated(session) {
+		req.Header.Set(ElevatedHeader, "true")
+		req.Header.Set(ElevatedUntilHeader, session.ElevatedUntil.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// InjectQueryParams sets the query parameters of the header mappings that
+// have one, with the same value as their header. Client-supplied values of
+// those parameters are removed first; other parameters are left untouched.
+func InjectQueryParams(req *http.Request, session *auth.Session, provider auth.Provider, aead cipher.AEAD) error {
+	if provider == nil {
+		return nil
+	}
+
+	query := req.URL.Query()
+	injected := false
+	for claim, mapping := range provider.GetHeaderMappings() {
+		if mapping.QueryParam == "" {
+			continue
+		}
+		query.Del(mapping.QueryParam)
+		injected = true
+
+		value, err := mappedClaimValue(session, claim, mapping, aead)
+		if err != nil {
+			return err
+		}
+		if value != "" {
+			query.Set(mapping.QueryParam, value)
+		}
+	}
+
+	if injected {
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// mappedClaimValue returns the value injected for claim under mapping, or
+// an empty string when the session does not have it.
+func mappedClaimValue(session *auth.Session, claim string, mapping config.HeaderMapping, aead cipher.AEAD) (string, error) {
+	value, exists := session.UserInfo[claim]
+	if !exists {
+		return "", nil
+	}
+
+	value, err := singleValue(value, mapping.SingleValue)
+	if err != nil {
+		return "", fmt.Errorf("claim %s for header %s: %w", claim, mapping.Header, err)
+	}
+
+	headerValue := transformValue(formatHeaderValue(value), mapping.Transform)
+	if headerValue == "" || !mapping.Encrypt {
+		return headerValue, nil
+	}
+
+	if aead == nil {
+		return "", fmt.Errorf("header %s must be encrypted but no header encryption key is configured", mapping.Header)
+	}
+	return EncryptHeaderValue(aead, mapping.Header, headerValue)
+}
+
+// transformValue applies the transforms of a header mapping in order.
+func transformValue(value string, transforms []string) string {
+	for _, transform := range transforms {
+		switch transform {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "trim":
+			value = strings.TrimSpace(value)
+		case "title":
+			value = titleCase(value)
+		}
+	}
+	return value
+}
+
+// titleCase upper-cases the first letter of each space-separated word.
+func titleCase(value string) string {
+	runes := []rune(value)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// InjectClaimsHeader sets the configured claims header to the JSON encoded
+// session claims. Any client-supplied value is removed first, and the header is
+// left out if the encoded claims exceed the size limit.
+func InjectClaimsHeader(req *http.Request, session *auth.Session, cfg config.BackendConfig) error {
+	if cfg.ClaimsHeader == "" {
+		return nil
+	}
+
+	req.Header.Del(cfg.ClaimsHeader)
+
+	data, err := json.Marshal(session.UserInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	value := string(data)
+	if cfg.ClaimsHeaderBase64 {
+		value = base64.StdEncoding.EncodeToString(data)
+	}
+
+	if len(value) > cfg.ClaimsHeaderMaxSize {
+		return fmt.Errorf("claims header is %d bytes, exceeding the %d byte limit", len(value), cfg.ClaimsHeaderMaxSize)
+	}
+
+	req.Header.Set(cfg.ClaimsHeader, value)
+	return nil
+}
+
+const (
+	ClientIPHeader      = "X-Auth-Client-IP"
+	ClientCountryHeader = "X-Auth-Client-Country"
+)
 
+// InjectClientInfo sets the resolved client IP if injectIP is set and, when a
+// GeoIP database is available, its country. Client-supplied values are always
+// removed.
+func InjectClientInfo(req *http.Request, ip net.IP, injectIP bool, geoIP GeoIPLookup) error {
+	req.Header.Del(ClientIPHeader)
+	req.Header.Del(ClientCountryHeader)
+
+	if ip == nil {
+		return nil
+	}
+
+	if injectIP {
+		req.Header.Set(ClientIPHeader, ip.String())
+	}
+
+	if geoIP == nil {
+		return nil
+	}
+
+	country, err := geoIP.Country(ip)
+	if err != nil {
+		return fmt.Errorf("geoip lookup failed: %w", err)
+	}
+	if country != "" {
+		req.Header.Set(ClientCountryHeader, country)
+	}
 	return nil
 }
 
+// singleValue applies a header mapping's single_value policy to an
+// array-valued claim. Other values, and the join policy, pass through to
+// formatHeaderValue.
+func singleValue(value interface{}, policy string) (interface{}, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []string:
+		items = make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+	default:
+		return value, nil
+	}
+
+	switch policy {
+	case "first":
+		if len(items) == 0 {
+			return "", nil
+		}
+		return items[0], nil
+	case "last":
+		if len(items) == 0 {
+			return "", nil
+		}
+		return items[len(items)-1], nil
+	case "error":
+		if len(items) > 1 {
+			return nil, fmt.Errorf("expected a single value, got %d", len(items))
+		}
+		if len(items) == 0 {
+			return "", nil
+		}
+		return items[0], nil
+	default:
+		return value, nil
+	}
+}
+
 func formatHeaderValue(value interface{}) string {
 	switch v := value.(type) {
 	case string:
@@ -0,0 +1,524 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// stubProvider is an auth.Provider with fixed header mappings. Methods other
+// than the ones overridden here are not used by the proxy helpers.
+type stubProvider struct {
+	auth.Provider
+	id       string
+	mappings map[string]config.HeaderMapping
+}
+
+func (p *stubProvider) ID() string { return p.id }
+
+func (p *stubProvider) GetHeaderMappings() map[string]config.HeaderMapping {
+	return p.mappings
+}
+
+func TestInjectHeadersRemovesClientValues(t *testing.T) {
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"email":  {Header: "X-User-Email"},
+			"groups": {Header: "X-User-Groups"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		preset   string
+		expected map[string]string
+	}{
+		{
+			name:   "claims present",
+			claims: map[string]interface{}{"sub": "alice", "email": "alice@example.com"},
+			preset: "oauth2-proxy",
+			expected: map[string]string{
+				"X-Forwarded-User":  "alice",
+				"X-Forwarded-Email": "alice@example.com",
+				"X-User-Email":      "alice@example.com",
+				"X-User-Groups":     "",
+			},
+		},
+		{
+			name:   "claims missing",
+			claims: map[string]interface{}{},
+			preset: "oauth2-proxy",
+			expected: map[string]string{
+				"X-Forwarded-User":  "",
+				"X-Forwarded-Email": "",
+				"X-User-Email":      "",
+				"X-User-Groups":     "",
+			},
+		},
+		{
+			name:   "no preset",
+			claims: map[string]interface{}{"email": "alice@example.com"},
+			expected: map[string]string{
+				"X-User-Email":  "alice@example.com",
+				"X-User-Groups": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for header := range tt.expected {
+				req.Header.Set(header, "forged")
+			}
+
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.claims}
+			if err := InjectHeaders(req, session, provider, tt.preset, nil); err != nil {
+				t.Fatalf("InjectHeaders: %v", err)
+			}
+
+			for header, want := range tt.expected {
+				if got := req.Header.Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectHeadersServiceSession(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-User", "forged")
+
+	session := &auth.Session{ID: "s1", ProviderID: "ci", ProviderType: auth.ServiceProviderType}
+	if err := InjectHeaders(req, session, nil, "oauth2-proxy", nil); err != nil {
+		t.Fatalf("InjectHeaders: %v", err)
+	}
+
+	if got := req.Header.Get("X-Forwarded-User"); got != "" {
+		t.Errorf("X-Forwarded-User = %q, want it removed", got)
+	}
+	if got := req.Header.Get(ServiceHeader); got != "ci" {
+		t.Errorf("%s = %q, want %q", ServiceHeader, got, "ci")
+	}
+}
+
+func TestInjectHeadersAssuranceLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		want  string
+	}{
+		{name: "mapped", level: 2, want: "2"},
+		{name: "unknown", level: 0, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(AssuranceLevelHeader, "9")
+
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}, AssuranceLevel: tt.level}
+			if err := InjectHeaders(req, session, &stubProvider{id: "corp"}, "", nil); err != nil {
+				t.Fatalf("InjectHeaders: %v", err)
+			}
+			if got := req.Header.Get(AssuranceLevelHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", AssuranceLevelHeader, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectHeadersMappingOverridesPreset(t *testing.T) {
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"upn": {Header: "X-Forwarded-User"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   string
+	}{
+		{name: "mapping wins", claims: map[string]interface{}{"sub": "alice", "upn": "alice@corp"}, want: "alice@corp"},
+		{name: "preset kept without mapped claim", claims: map[string]interface{}{"sub": "alice"}, want: "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.claims}
+			if err := InjectHeaders(req, session, provider, "oauth2-proxy", nil); err != nil {
+				t.Fatalf("InjectHeaders: %v", err)
+			}
+			if got := req.Header.Get("X-Forwarded-User"); got != tt.want {
+				t.Errorf("X-Forwarded-User = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectHeadersTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		transform []string
+		want      string
+	}{
+		{name: "none", value: " Alice@Example.com ", want: " Alice@Example.com "},
+		{name: "lower", value: "Alice@Example.com", transform: []string{"lower"}, want: "alice@example.com"},
+		{name: "upper", value: "alice", transform: []string{"upper"}, want: "ALICE"},
+		{name: "trim", value: "  alice \t", transform: []string{"trim"}, want: "alice"},
+		{name: "title", value: "alice van der berg", transform: []string{"title"}, want: "Alice Van Der Berg"},
+		{name: "trim then lower", value: "  Alice@Example.com  ", transform: []string{"trim", "lower"}, want: "alice@example.com"},
+		{name: "lower then title", value: "ALICE SMITH", transform: []string{"lower", "title"}, want: "Alice Smith"},
+		{name: "array value", value: []interface{}{"Admins", "Users"}, transform: []string{"lower"}, want: "admins,users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &stubProvider{
+				id: "corp",
+				mappings: map[string]config.HeaderMapping{
+					"email": {Header: "X-User-Email", Transform: tt.transform},
+				},
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"email": tt.value}}
+			if err := InjectHeaders(req, session, provider, "", nil); err != nil {
+				t.Fatalf("InjectHeaders: %v", err)
+			}
+
+			if got := req.Header.Get("X-User-Email"); got != tt.want {
+				t.Errorf("X-User-Email = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectHeadersSingleValue(t *testing.T) {
+	groups := []interface{}{"admins", "users"}
+
+	tests := []struct {
+		name    string
+		policy  string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "default joins", value: groups, want: "admins,users"},
+		{name: "join", policy: "join", value: groups, want: "admins,users"},
+		{name: "first", policy: "first", value: groups, want: "admins"},
+		{name: "last", policy: "last", value: groups, want: "users"},
+		{name: "first of strings", policy: "first", value: []string{"admins", "users"}, want: "admins"},
+		{name: "first of empty", policy: "first", value: []interface{}{}, want: ""},
+		{name: "error with one element", policy: "error", value: []interface{}{"admins"}, want: "admins"},
+		{name: "error with several elements", policy: "error", value: groups, wantErr: true},
+		{name: "scalar", policy: "error", value: "admins", want: "admins"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &stubProvider{
+				id: "corp",
+				mappings: map[string]config.HeaderMapping{
+					"groups": {Header: "X-User-Groups", SingleValue: tt.policy},
+				},
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"groups": tt.value}}
+			err := InjectHeaders(req, session, provider, "", nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("InjectHeaders succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InjectHeaders: %v", err)
+			}
+
+			if got := req.Header.Get("X-User-Groups"); got != tt.want {
+				t.Errorf("X-User-Groups = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectHeadersRequired(t *testing.T) {
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"sub":   {Header: "X-User-ID", Required: true},
+			"email": {Header: "X-User-Email"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "all claims present", claims: map[string]interface{}{"sub": "alice", "email": "alice@example.com"}},
+		{name: "optional claim missing", claims: map[string]interface{}{"sub": "alice"}},
+		{name: "required claim missing", claims: map[string]interface{}{"email": "alice@example.com"}, wantErr: true},
+		{name: "required claim empty", claims: map[string]interface{}{"sub": ""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.claims}
+
+			err := InjectHeaders(req, session, provider, "", nil)
+			if got := errors.Is(err, ErrMissingClaim); got != tt.wantErr {
+				t.Errorf("InjectHeaders error = %v, want ErrMissingClaim: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInjectHeadersEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	aead, err := NewHeaderCipher(&config.HeaderEncryptionConfig{Key: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		t.Fatalf("NewHeaderCipher: %v", err)
+	}
+
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"email": {Header: "X-User-Email", Encrypt: true},
+			"sub":   {Header: "X-User-ID"},
+		},
+	}
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{
+		"email": "alice@example.com",
+		"sub":   "alice",
+	}}
+
+	// decrypt opens a header value the way a backend holding the shared key
+	// would.
+	decrypt := func(header, value string) (string, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return "", err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < gcm.NonceSize() {
+			return "", errors.New("value too short")
+		}
+		nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+		plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(strings.ToLower(header)))
+		return string(plaintext), err
+	}
+
+	var values []string
+	for range 2 {
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := InjectHeaders(req, session, provider, "", aead); err != nil {
+			t.Fatalf("InjectHeaders: %v", err)
+		}
+
+		if got := req.Header.Get("X-User-ID"); got != "alice" {
+			t.Errorf("X-User-ID = %q, want it in plaintext", got)
+		}
+
+		encrypted := req.Header.Get("X-User-Email")
+		if encrypted == "" || strings.Contains(encrypted, "alice") {
+			t.Fatalf("X-User-Email = %q, want ciphertext", encrypted)
+		}
+		if got, err := decrypt("X-User-Email", encrypted); err != nil || got != "alice@example.com" {
+			t.Errorf("decrypted X-User-Email = %q, %v, want alice@example.com", got, err)
+		}
+		if _, err := decrypt("X-User-ID", encrypted); err == nil {
+			t.Errorf("X-User-Email value decrypted as another header")
+		}
+		values = append(values, encrypted)
+	}
+
+	if values[0] == values[1] {
+		t.Errorf("encrypting the same claim twice gave the same value")
+	}
+}
+
+func TestInjectQueryParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		mappings  map[string]config.HeaderMapping
+		claims    map[string]interface{}
+		rawQuery  string
+		wantQuery url.Values
+	}{
+		{
+			name:      "injected and encoded",
+			mappings:  map[string]config.HeaderMapping{"name": {Header: "X-User-Name", QueryParam: "user"}},
+			claims:    map[string]interface{}{"name": "Alice & Bob=1"},
+			rawQuery:  "page=2&tag=a&tag=b",
+			wantQuery: url.Values{"page": {"2"}, "tag": {"a", "b"}, "user": {"Alice & Bob=1"}},
+		},
+		{
+			name:      "client value replaced",
+			mappings:  map[string]config.HeaderMapping{"email": {Header: "X-User-Email", QueryParam: "email"}},
+			claims:    map[string]interface{}{"email": "alice@example.com"},
+			rawQuery:  "email=mallory@example.com&email=eve@example.com&page=2",
+			wantQuery: url.Values{"email": {"alice@example.com"}, "page": {"2"}},
+		},
+		{
+			name:      "client value removed without the claim",
+			mappings:  map[string]config.HeaderMapping{"email": {Header: "X-User-Email", QueryParam: "email"}},
+			claims:    map[string]interface{}{"sub": "alice"},
+			rawQuery:  "email=mallory@example.com&page=2",
+			wantQuery: url.Values{"page": {"2"}},
+		},
+		{
+			name:      "header only mapping",
+			mappings:  map[string]config.HeaderMapping{"email": {Header: "X-User-Email"}},
+			claims:    map[string]interface{}{"email": "alice@example.com"},
+			rawQuery:  "email=mallory@example.com",
+			wantQuery: url.Values{"email": {"mallory@example.com"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/app?"+tt.rawQuery, nil)
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.claims}
+			if err := InjectQueryParams(req, session, &stubProvider{id: "corp", mappings: tt.mappings}, nil); err != nil {
+				t.Fatalf("InjectQueryParams: %v", err)
+			}
+
+			if got := req.URL.Query(); !reflect.DeepEqual(got, tt.wantQuery) {
+				t.Errorf("query = %v, want %v", got, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestInjectClaimsHeader(t *testing.T) {
+	userInfo := map[string]interface{}{"sub": "alice", "groups": []interface{}{"admins", "devs"}}
+
+	tests := []struct {
+		name       string
+		cfg        config.BackendConfig
+		clientSent string
+		wantSet    bool
+		wantErr    bool
+	}{
+		{name: "json", cfg: config.BackendConfig{ClaimsHeader: "X-Claims", ClaimsHeaderMaxSize: 8192}, wantSet: true},
+		{name: "base64", cfg: config.BackendConfig{ClaimsHeader: "X-Claims", ClaimsHeaderBase64: true, ClaimsHeaderMaxSize: 8192}, wantSet: true},
+		{name: "client value replaced", cfg: config.BackendConfig{ClaimsHeader: "X-Claims", ClaimsHeaderMaxSize: 8192}, clientSent: `{"sub":"mallory"}`, wantSet: true},
+		{name: "over size limit", cfg: config.BackendConfig{ClaimsHeader: "X-Claims", ClaimsHeaderMaxSize: 16}, clientSent: `{"sub":"mallory"}`, wantErr: true},
+		{name: "disabled", cfg: config.BackendConfig{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.clientSent != "" {
+				req.Header.Set("X-Claims", tt.clientSent)
+			}
+			session := &auth.Session{UserInfo: userInfo}
+
+			err := InjectClaimsHeader(req, session, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InjectClaimsHeader error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			value := req.Header.Get("X-Claims")
+			if !tt.wantSet {
+				if value != "" {
+					t.Errorf("X-Claims = %q, want it unset", value)
+				}
+				return
+			}
+
+			data := []byte(value)
+			if tt.cfg.ClaimsHeaderBase64 {
+				if data, err = base64.StdEncoding.DecodeString(value); err != nil {
+					t.Fatalf("decode base64: %v", err)
+				}
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("X-Claims = %q is not JSON: %v", value, err)
+			}
+			if !reflect.DeepEqual(got, userInfo) {
+				t.Errorf("claims = %v, want %v", got, userInfo)
+			}
+		})
+	}
+}
+
+// stubGeoIP resolves every address to country, or fails with err.
+type stubGeoIP struct {
+	country string
+	err     error
+}
+
+func (g stubGeoIP) Country(ip net.IP) (string, error) {
+	return g.country, g.err
+}
+
+func TestInjectClientInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          net.IP
+		injectIP    bool
+		geoIP       GeoIPLookup
+		wantIP      string
+		wantCountry string
+		wantErr     bool
+	}{
+		{name: "ip and country", ip: net.ParseIP("203.0.113.7"), injectIP: true, geoIP: stubGeoIP{country: "NL"}, wantIP: "203.0.113.7", wantCountry: "NL"},
+		{name: "ip only", ip: net.ParseIP("203.0.113.7"), injectIP: true, wantIP: "203.0.113.7"},
+		{name: "country only", ip: net.ParseIP("203.0.113.7"), geoIP: stubGeoIP{country: "NL"}, wantCountry: "NL"},
+		{name: "unknown country", ip: net.ParseIP("10.0.0.1"), injectIP: true, geoIP: stubGeoIP{}, wantIP: "10.0.0.1"},
+		{name: "lookup failure", ip: net.ParseIP("203.0.113.7"), injectIP: true, geoIP: stubGeoIP{err: errors.New("corrupt database")}, wantIP: "203.0.113.7", wantErr: true},
+		{name: "no ip", injectIP: true, geoIP: stubGeoIP{country: "NL"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(ClientIPHeader, "198.51.100.1")
+			req.Header.Set(ClientCountryHeader, "XX")
+
+			err := InjectClientInfo(req, tt.ip, tt.injectIP, tt.geoIP)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InjectClientInfo error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := req.Header.Get(ClientIPHeader); got != tt.wantIP {
+				t.Errorf("%s = %q, want %q", ClientIPHeader, got, tt.wantIP)
+			}
+			if got := req.Header.Get(ClientCountryHeader); got != tt.wantCountry {
+				t.Errorf("%s = %q, want %q", ClientCountryHeader, got, tt.wantCountry)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+const PairwiseIDHeader = "X-Auth-Pairwise-ID"
+
+// InjectPairwiseID sets X-Auth-Pairwise-ID to the pairwise id of the
+// session's user under salt. Client-supplied values are always removed, and
+// service sessions and sessions without a subject get none.
+func InjectPairwiseID(req *http.Request, session *auth.Session, salt string) {
+	req.Header.Del(PairwiseIDHeader)
+
+	if session.ProviderType == auth.ServiceProviderType {
+		return
+	}
+	if id := PairwiseID(session.ProviderID, auth.Subject(session), salt); id != "" {
+		req.Header.Set(PairwiseIDHeader, id)
+	}
+}
+
+// PairwiseID returns the base64url encoded HMAC-SHA256, keyed with salt, of
+// the provider id and subject, like an OIDC pairwise subject identifier: the
+// same user always gets the same id under a salt, and unrelated ids under
+// different salts. The provider id keeps equal subjects from different IdPs
+// apart. It returns an empty string without a subject.
+func PairwiseID(providerID, subject, salt string) string {
+	if subject == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(providerID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+)
+
+func TestPairwiseID(t *testing.T) {
+	const salt = "backend-a-salt-0123"
+	base := PairwiseID("corp", "alice", salt)
+
+	tests := []struct {
+		name       string
+		providerID string
+		subject    string
+		salt       string
+		wantSame   bool
+	}{
+		{name: "same user and salt", providerID: "corp", subject: "alice", salt: salt, wantSame: true},
+		{name: "other user", providerID: "corp", subject: "bob", salt: salt},
+		{name: "other salt", providerID: "corp", subject: "alice", salt: "backend-b-salt-0123"},
+		{name: "other provider", providerID: "partner", subject: "alice", salt: salt},
+		// Without the separator, "cor"+"palice" would collide with "corp"+"alice".
+		{name: "shifted boundary", providerID: "cor", subject: "palice", salt: salt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := PairwiseID(tt.providerID, tt.subject, tt.salt)
+			if id == "" || id == tt.subject {
+				t.Fatalf("PairwiseID = %q", id)
+			}
+			if got := id == base; got != tt.wantSame {
+				t.Errorf("PairwiseID = %q, same as the base id: %v, want %v", id, got, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestInjectPairwiseID(t *testing.T) {
+	const salt = "backend-a-salt-0123"
+
+	tests := []struct {
+		name    string
+		session *auth.Session
+		want    string
+	}{
+		{
+			name:    "user session",
+			session: &auth.Session{ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}},
+			want:    PairwiseID("corp", "alice", salt),
+		},
+		{
+			name:    "no subject",
+			session: &auth.Session{ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{}},
+		},
+		{
+			name:    "service session",
+			session: &auth.Session{ProviderID: "billing", ProviderType: auth.ServiceProviderType, UserInfo: map[string]interface{}{"sub": "billing"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(PairwiseIDHeader, "forged")
+
+			InjectPairwiseID(req, tt.session, salt)
+
+			if got := req.Header.Get(PairwiseIDHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", PairwiseIDHeader, got, tt.want)
+			}
+		})
+	}
+}
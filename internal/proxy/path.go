@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// pathRewriter maps public request paths to backend paths with
+// strip_path_prefix and path_rewrite.
+type pathRewriter struct {
+	prefix      string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// newPathRewriter returns nil when the backend paths are the public ones.
+func newPathRewriter(cfg config.BackendConfig) (*pathRewriter, error) {
+	if cfg.StripPathPrefix == "" && cfg.PathRewrite == nil {
+		return nil, nil
+	}
+
+	rewriter := &pathRewriter{prefix: cfg.StripPathPrefix}
+	if cfg.PathRewrite != nil {
+		pattern, err := regexp.Compile(cfg.PathRewrite.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rewriter.pattern = pattern
+		rewriter.replacement = cfg.PathRewrite.Replacement
+	}
+	return rewriter, nil
+}
+
+// rewrite strips the prefix from u's path, when the path is the prefix or
+// below it, then applies the regular expression replacement.
+func (pr *pathRewriter) rewrite(u *url.URL) {
+	path := u.Path
+	if pr.prefix != "" && (path == pr.prefix || strings.HasPrefix(path, pr.prefix+"/")) {
+		path = strings.TrimPrefix(path, pr.prefix)
+		if path == "" {
+			path = "/"
+		}
+	}
+	if pr.pattern != nil {
+		path = pr.pattern.ReplaceAllString(path, pr.replacement)
+	}
+
+	if path != u.Path {
+		u.Path = path
+		u.RawPath = ""
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestPathRewriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		rewrite *config.PathRewriteConfig
+		path    string
+		want    string
+	}{
+		{name: "strip prefix", prefix: "/app", path: "/app/users", want: "/users"},
+		{name: "prefix only", prefix: "/app", path: "/app", want: "/"},
+		{name: "outside prefix", prefix: "/app", path: "/other/users", want: "/other/users"},
+		{name: "prefix of a segment", prefix: "/app", path: "/application", want: "/application"},
+		{name: "regex rewrite", rewrite: &config.PathRewriteConfig{Pattern: `^/v1/(.*)$`, Replacement: "/api/$1"}, path: "/v1/users", want: "/api/users"},
+		{name: "strip then rewrite", prefix: "/app", rewrite: &config.PathRewriteConfig{Pattern: `^/v1/`, Replacement: "/v2/"}, path: "/app/v1/users", want: "/v2/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewriter, err := newPathRewriter(config.BackendConfig{StripPathPrefix: tt.prefix, PathRewrite: tt.rewrite})
+			if err != nil {
+				t.Fatalf("newPathRewriter: %v", err)
+			}
+
+			u := &url.URL{Path: tt.path}
+			rewriter.rewrite(u)
+			if u.Path != tt.want {
+				t.Errorf("path = %q, want %q", u.Path, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteRedirect(t *testing.T) {
+	backendURL := &url.URL{Scheme: "http", Host: "backend:8080"}
+	publicURL := &url.URL{Scheme: "https", Host: "sso.example.com"}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		status   int
+		location string
+		want     string
+	}{
+		{name: "backend host", status: http.StatusFound, location: "http://backend:8080/login", want: "https://sso.example.com/login"},
+		{name: "backend host with prefix", prefix: "/app", status: http.StatusFound, location: "http://backend:8080/login", want: "https://sso.example.com/app/login"},
+		{name: "backend root with prefix", prefix: "/app", status: http.StatusFound, location: "http://backend:8080/", want: "https://sso.example.com/app"},
+		{name: "host-relative with prefix", prefix: "/app", status: http.StatusSeeOther, location: "/login?next=1", want: "/app/login?next=1"},
+		{name: "host-relative without prefix", status: http.StatusFound, location: "/login", want: "/login"},
+		{name: "relative path", prefix: "/app", status: http.StatusFound, location: "login", want: "login"},
+		{name: "other host", prefix: "/app", status: http.StatusFound, location: "https://idp.example.com/authorize", want: "https://idp.example.com/authorize"},
+		{name: "not a redirect", prefix: "/app", status: http.StatusOK, location: "/login", want: "/login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{"Location": {tt.location}}}
+			rewriteRedirect(resp, backendURL, publicURL, tt.prefix)
+
+			if got := resp.Header.Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
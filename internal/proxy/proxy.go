@@ -1,79 +1,275 @@
 package proxy
 
 import (
+	"crypto/cipher"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/metrics"
 	"github.com/marcogenualdo/sso-switch/internal/middleware"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
 type ReverseProxy struct {
-	proxy   *httputil.ReverseProxy
-	cfg     config.BackendConfig
-	logger  *slog.Logger
-	providers map[string]auth.Provider
+	proxy        *httputil.ReverseProxy
+	claimProxies map[string]*httputil.ReverseProxy
+	cfg          config.BackendConfig
+	trusted      *security.TrustedProxies
+	geoIP        GeoIPLookup
+	allowed      map[string]bool
+	headerCipher cipher.AEAD
+	exemplars    bool
+	logger       *slog.Logger
+	providers    map[string]auth.Provider
+	noRoutePage  http.Handler
 }
 
-func NewReverseProxy(cfg config.BackendConfig, providers map[string]auth.Provider, logger *slog.Logger) (*ReverseProxy, error) {
-	backendURL, err := url.Parse(cfg.URL)
+func NewReverseProxy(cfg config.BackendConfig, serverCfg config.ServerConfig, loggingCfg config.LoggingConfig, metricsCfg config.MetricsConfig, trusted *security.TrustedProxies, providers map[string]auth.Provider, deniedPage, noRoutePage http.Handler, logger *slog.Logger) (*ReverseProxy, error) {
+	publicURL, err := url.Parse(serverCfg.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var base http.RoundTripper = http.DefaultTransport
+	if cfg.Protocol == "grpc" {
+		base = newGRPCTransport()
+	}
+	transport := newBodyLoggingTransport(base, loggingCfg, logger)
+
+	var pool httputil.BufferPool
+	if cfg.Buffering.BufferPool {
+		pool = newBufferPool()
+	}
+
+	proxy, err := newSingleHostProxy(cfg.URL, cfg, serverCfg, publicURL, transport, pool, deniedPage, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var geoIP GeoIPLookup
+	if cfg.GeoIPDatabase != "" {
+		geoIP, err = OpenGeoIPDatabase(cfg.GeoIPDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headerCipher, err := NewHeaderCipher(cfg.HeaderEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	claimProxies := make(map[string]*httputil.ReverseProxy)
+	if cfg.RoutesByClaim != nil {
+		for value, target := range cfg.RoutesByClaim.Routes {
+			claimProxies[value], err = newSingleHostProxy(target, cfg, serverCfg, publicURL, transport, pool, deniedPage, logger)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ReverseProxy{
+		proxy:        proxy,
+		claimProxies: claimProxies,
+		cfg:          cfg,
+		trusted:      trusted,
+		geoIP:        geoIP,
+		allowed:      newHeaderAllowlist(cfg.ForwardHeaders),
+		headerCipher: headerCipher,
+		exemplars:    metricsCfg.Exemplars,
+		logger:       logger,
+		providers:    providers,
+		noRoutePage:  noRoutePage,
+	}, nil
+}
+
+func newSingleHostProxy(target string, cfg config.BackendConfig, serverCfg config.ServerConfig, publicURL *url.URL, transport http.RoundTripper, pool httputil.BufferPool, deniedPage http.Handler, logger *slog.Logger) (*httputil.ReverseProxy, error) {
+	backendURL, err := url.Parse(target)
 	if err != nil {
 		return nil, err
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	proxy.Transport = transport
+	proxy.FlushInterval = cfg.Buffering.FlushInterval
+	if cfg.Protocol == "grpc" {
+		proxy.FlushInterval = -1
+	}
+	proxy.BufferPool = pool
+
+	pathRewriter, err := newPathRewriter(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
+		if pathRewriter != nil {
+			pathRewriter.rewrite(req.URL)
+		}
 		originalDirector(req)
 		req.Host = backendURL.Host
 		req.URL.Scheme = backendURL.Scheme
 		req.URL.Host = backendURL.Host
 	}
 
+	if cfg.RewriteRedirects || cfg.OnBackendUnauthorized != "passthrough" {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			if cfg.RewriteRedirects {
+				rewriteRedirect(resp, backendURL, publicURL, cfg.StripPathPrefix)
+				rewriteCookieDomains(resp, backendURL, publicURL)
+			}
+			return checkBackendUnauthorized(resp, cfg.OnBackendUnauthorized)
+		}
+	}
+
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, errBackendUnauthorized) {
+			logger.Debug("backend refused the request",
+				"policy", cfg.OnBackendUnauthorized,
+				"path", r.URL.Path,
+			)
+			respondBackendUnauthorized(w, r, cfg.OnBackendUnauthorized, serverCfg, deniedPage)
+			return
+		}
+
 		logger.Error("proxy error",
 			"error", err,
 			"backend", backendURL.String(),
 			"path", r.URL.Path,
 		)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		httperror.Respond(w, r, http.StatusBadGateway, "bad_gateway", "Bad Gateway")
 	}
 
-	return &ReverseProxy{
-		proxy:     proxy,
-		cfg:       cfg,
-		logger:    logger,
-		providers: providers,
-	}, nil
+	return proxy, nil
+}
+
+// selectProxy picks the backend for a session. It returns false when
+// routes_by_claim is set and no claim route matches.
+func (rp *ReverseProxy) selectProxy(session *auth.Session) (*httputil.ReverseProxy, bool) {
+	if rp.cfg.RoutesByClaim == nil {
+		return rp.proxy, true
+	}
+
+	value, exists := session.UserInfo[rp.cfg.RoutesByClaim.Claim]
+	if !exists {
+		return nil, false
+	}
+
+	proxy, ok := rp.claimProxies[formatHeaderValue(value)]
+	return proxy, ok
 }
 
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	session, ok := middleware.GetSession(r.Context())
 	if !ok {
-		rp.logger.Error("no session in context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		// RequireAuth always sets the session, so this is a routing bug
+		// rather than an unauthenticated client.
+		rp.logger.Error("no session in context, the proxy route is not behind RequireAuth",
+			"method", r.Method,
+			"host", r.Host,
+			"path", r.URL.Path,
+			"pattern", r.Pattern,
+		)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
-	provider, exists := rp.providers[session.ProviderID]
-	if !exists {
-		rp.logger.Error("provider not found", "provider_id", session.ProviderID)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	// Sessions of a removed provider, kept by orphaned_session_policy, have
+	// no provider and get no header mappings.
+	var provider auth.Provider
+	if session.ProviderType != auth.ServiceProviderType {
+		provider = rp.providers[session.ProviderID]
 	}
 
-	if err := InjectHeaders(r, session, provider); err != nil {
+	proxy, ok := rp.selectProxy(session)
+	if !ok {
+		rp.logger.Info("no backend route matches session",
+			"claim", rp.cfg.RoutesByClaim.Claim,
+			"value", formatHeaderValue(session.UserInfo[rp.cfg.RoutesByClaim.Claim]),
+			"policy", rp.cfg.OnNoRoute,
+			"path", r.URL.Path,
+			"session_id", session.ID,
+		)
+		switch rp.cfg.OnNoRoute {
+		case "404":
+			httperror.Respond(w, r, http.StatusNotFound, "no_route", "No application is available for this account")
+			return
+		case "branded_error":
+			rp.noRoutePage.ServeHTTP(w, r)
+			return
+		}
+		proxy = rp.proxy
+	}
+
+	// Resolve the client from forwarding headers before they may be filtered.
+	clientIP := rp.trusted.ClientIP(r)
+	forwardedHost := r.Header.Get("X-Forwarded-Host")
+	// The client's Authorization may be a bearer token for this proxy, which
+	// the backend must not be able to replay.
+	authorization := r.Header.Values("Authorization")
+	r.Header.Del("Authorization")
+	filterHeaders(r.Header, rp.allowed)
+	RemoveSignedHeaders(r, provider, rp.cfg)
+
+	if err := InjectHeaders(r, session, provider, rp.cfg.HeaderPreset, rp.headerCipher); err != nil {
+		if errors.Is(err, ErrMissingClaim) {
+			rp.logger.Warn("rejecting request without a required claim",
+				"error", err,
+				"provider", session.ProviderID,
+				"path", r.URL.Path,
+				"session_id", session.ID,
+			)
+			httperror.Respond(w, r, http.StatusForbidden, "missing_claim", "Your account is missing information required by this application")
+			return
+		}
 		rp.logger.Error("failed to inject headers", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
+	if rp.cfg.InjectQueryParams {
+		if err := InjectQueryParams(r, session, provider, rp.headerCipher); err != nil {
+			rp.logger.Error("failed to inject query parameters", "error", err)
+			httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			return
+		}
+	}
+
+	if rp.cfg.InjectAuthContext {
+		InjectAuthContext(r, session)
+	}
+
+	if rp.cfg.PairwiseIDSalt != "" {
+		InjectPairwiseID(r, session, rp.cfg.PairwiseIDSalt)
+	}
+
+	if err := InjectClaimsHeader(r, session, rp.cfg); err != nil {
+		rp.logger.Warn("claims header not injected", "session_id", session.ID, "error", err)
+	}
+
+	if rp.cfg.InjectClientIP || rp.geoIP != nil {
+		if err := InjectClientInfo(r, clientIP, rp.cfg.InjectClientIP, rp.geoIP); err != nil {
+			rp.logger.Debug("client info not fully injected", "error", err)
+		}
+	}
+
+	if rp.cfg.PreserveAuthorization && session.ProviderType != auth.ServiceProviderType && len(authorization) > 0 {
+		r.Header["Authorization"] = authorization
+	}
+
+	SignHeaders(r, provider, rp.cfg, time.Now())
+
 	if rp.cfg.PreserveHost {
-		r.Host = r.Header.Get("X-Forwarded-Host")
+		r.Host = forwardedHost
 		if r.Host == "" {
 			r.Host = r.Header.Get("Host")
 		}
@@ -85,5 +281,16 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"session_id", session.ID,
 	)
 
-	rp.proxy.ServeHTTP(w, r)
+	if len(rp.cfg.Buffering.UnbufferedContentTypes) > 0 {
+		w = &flushingWriter{ResponseWriter: w, contentTypes: rp.cfg.Buffering.UnbufferedContentTypes}
+	}
+
+	start := time.Now()
+	proxy.ServeHTTP(w, r)
+
+	var traceID string
+	if rp.exemplars {
+		traceID, _ = middleware.TraceContext(r)
+	}
+	metrics.ProxyRequestDuration.ObserveWithExemplar(time.Since(start).Seconds(), traceID)
 }
@@ -0,0 +1,561 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/metrics"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+// newTestReverseProxy builds a ReverseProxy for cfg with the defaults the
+// tests do not care about.
+func newTestReverseProxy(t *testing.T, cfg config.BackendConfig, providers map[string]auth.Provider, logger *slog.Logger) *ReverseProxy {
+	t.Helper()
+
+	trusted, err := security.ParseTrustedProxies(nil)
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	if cfg.OnBackendUnauthorized == "" {
+		cfg.OnBackendUnauthorized = "passthrough"
+	}
+	if cfg.OnNoRoute == "" {
+		cfg.OnNoRoute = "default_backend"
+	}
+
+	rp, err := NewReverseProxy(cfg, config.ServerConfig{BaseURL: "https://sso.example.com"},
+		config.LoggingConfig{}, config.MetricsConfig{}, trusted, providers, nil, nil, logger)
+	if err != nil {
+		t.Fatalf("NewReverseProxy: %v", err)
+	}
+	return rp
+}
+
+func TestReverseProxySessionContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		session     *auth.Session
+		wantStatus  int
+		wantBackend bool
+		wantLog     string
+	}{
+		{
+			name:        "session",
+			session:     &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}},
+			wantStatus:  http.StatusOK,
+			wantBackend: true,
+		},
+		{
+			name:       "no session",
+			wantStatus: http.StatusInternalServerError,
+			wantLog:    "no session in context, the proxy route is not behind RequireAuth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			defer backend.Close()
+
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+			providers := map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}
+			rp := newTestReverseProxy(t, config.BackendConfig{URL: backend.URL}, providers, logger)
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			if tt.session != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, tt.session))
+			}
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if reached != tt.wantBackend {
+				t.Errorf("backend reached = %v, want %v", reached, tt.wantBackend)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs = %q, want them to contain %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestReverseProxyAuthorization(t *testing.T) {
+	userSession := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}}
+	serviceSession := &auth.Session{ID: "token", ProviderID: "billing", ProviderType: auth.ServiceProviderType, UserInfo: map[string]interface{}{"sub": "billing"}}
+
+	tests := []struct {
+		name          string
+		session       *auth.Session
+		preserve      bool
+		forward       []string
+		authorization string
+		want          string
+	}{
+		{name: "user session", session: userSession, authorization: "Bearer client"},
+		{name: "user session in forward_headers", session: userSession, forward: []string{"Authorization"}, authorization: "Bearer client"},
+		{name: "user session preserved", session: userSession, preserve: true, authorization: "Bearer client", want: "Bearer client"},
+		{name: "service session", session: serviceSession, authorization: "Bearer token"},
+		{name: "service session preserved", session: serviceSession, preserve: true, authorization: "Bearer token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Values("Authorization")
+			}))
+			defer backend.Close()
+
+			providers := map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}
+			rp := newTestReverseProxy(t, config.BackendConfig{
+				URL:                   backend.URL,
+				PreserveAuthorization: tt.preserve,
+				ForwardHeaders:        tt.forward,
+			}, providers, discardLogger())
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, tt.session))
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if tt.want == "" && len(got) > 0 {
+				t.Errorf("Authorization = %q, want it removed", got)
+			}
+			if tt.want != "" && (len(got) != 1 || got[0] != tt.want) {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseProxyGRPC(t *testing.T) {
+	h2c := new(http.Protocols)
+	h2c.SetUnencryptedHTTP2(true)
+
+	// grpcFrame is a length-prefixed, uncompressed gRPC message.
+	grpcFrame := func(message string) []byte {
+		frame := make([]byte, 5, 5+len(message))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+		return append(frame, message...)
+	}
+
+	var gotProto int
+	var gotMetadata, gotBody string
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotProto = r.ProtoMajor
+		gotMetadata = r.Header.Get("X-User-Email")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Request-Cost")
+		w.WriteHeader(http.StatusOK)
+		w.Write(grpcFrame("pong"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+		w.Header().Set("X-Request-Cost", "3")
+	}))
+	backend.Config.Protocols = h2c
+	backend.Start()
+	defer backend.Close()
+
+	provider := &stubProvider{
+		id:       "corp",
+		mappings: map[string]config.HeaderMapping{"email": {Header: "X-User-Email"}},
+	}
+	rp := newTestReverseProxy(t, config.BackendConfig{URL: backend.URL, Protocol: "grpc"}, map[string]auth.Provider{"corp": provider}, discardLogger())
+
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"email": "alice@example.com"}}
+	front := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.SessionContextKey, session)))
+	}))
+	front.Config.Protocols = h2c
+	front.Start()
+	defer front.Close()
+
+	client := &http.Client{Transport: &http.Transport{Protocols: h2c}}
+	req, err := http.NewRequest("POST", front.URL+"/echo.Echo/Ping", bytes.NewReader(grpcFrame("ping")))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	// A client cannot assert its own identity.
+	req.Header.Set("X-User-Email", "mallory@example.com")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("gRPC call: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+
+	if gotProto != 2 {
+		t.Errorf("backend request over HTTP/%d, want HTTP/2", gotProto)
+	}
+	if gotMetadata != "alice@example.com" {
+		t.Errorf("x-user-email metadata = %q, want alice@example.com", gotMetadata)
+	}
+	if gotBody != string(grpcFrame("ping")) {
+		t.Errorf("backend request body = %q, want the ping frame", gotBody)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != string(grpcFrame("pong")) {
+		t.Errorf("response = %d %q, want 200 with the pong frame", resp.StatusCode, body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status trailer = %q, want 0", got)
+	}
+	if got := resp.Trailer.Get("X-Request-Cost"); got != "3" {
+		t.Errorf("x-request-cost trailer = %q, want 3", got)
+	}
+}
+
+func TestReverseProxyRemovesProxyHeaders(t *testing.T) {
+	session := &auth.Session{
+		ID:           "s1",
+		ProviderID:   "corp",
+		ProviderType: "oidc",
+		UserInfo:     map[string]interface{}{"sub": "alice", "amr": []interface{}{"pwd"}},
+		AuthTime:     time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		backend config.BackendConfig
+		want    map[string]string
+	}{
+		{
+			name: "auth context disabled",
+			want: map[string]string{AuthMethodsHeader: "", AuthTimeHeader: ""},
+		},
+		{
+			name:    "auth context disabled with forward_headers",
+			backend: config.BackendConfig{ForwardHeaders: []string{AuthMethodsHeader, AuthTimeHeader}},
+			want:    map[string]string{AuthMethodsHeader: "", AuthTimeHeader: ""},
+		},
+		{
+			name:    "auth context enabled",
+			backend: config.BackendConfig{InjectAuthContext: true},
+			want:    map[string]string{AuthMethodsHeader: "pwd", AuthTimeHeader: "2026-01-01T12:00:00Z"},
+		},
+		{
+			name: "no pairwise salt",
+			want: map[string]string{PairwiseIDHeader: ""},
+		},
+		{
+			name:    "no pairwise salt with forward_headers",
+			backend: config.BackendConfig{ForwardHeaders: []string{PairwiseIDHeader}},
+			want:    map[string]string{PairwiseIDHeader: ""},
+		},
+		{
+			name:    "pairwise salt",
+			backend: config.BackendConfig{PairwiseIDSalt: "0123456789abcdef"},
+			want:    map[string]string{PairwiseIDHeader: PairwiseID("corp", "alice", "0123456789abcdef")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer backend.Close()
+
+			cfg := tt.backend
+			cfg.URL = backend.URL
+			rp := newTestReverseProxy(t, cfg, map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}, discardLogger())
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			for name := range tt.want {
+				req.Header.Set(name, "forged")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rp.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got == nil {
+				t.Fatal("backend not reached")
+			}
+			for name, want := range tt.want {
+				if values := got.Values(name); (want == "" && len(values) > 0) || (want != "" && (len(values) != 1 || values[0] != want)) {
+					t.Errorf("%s = %q, want %q", name, values, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxyRoutesByClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		userInfo    map[string]interface{}
+		wantBackend string
+	}{
+		{name: "tenant a", userInfo: map[string]interface{}{"sub": "alice", "tenant": "a"}, wantBackend: "a"},
+		{name: "tenant b", userInfo: map[string]interface{}{"sub": "bob", "tenant": "b"}, wantBackend: "b"},
+		{name: "unmatched value", userInfo: map[string]interface{}{"sub": "carol", "tenant": "c"}, wantBackend: "default"},
+		{name: "missing claim", userInfo: map[string]interface{}{"sub": "dave"}, wantBackend: "default"},
+	}
+
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	backendA := newBackend("a")
+	defer backendA.Close()
+	backendB := newBackend("b")
+	defer backendB.Close()
+	fallback := newBackend("default")
+	defer fallback.Close()
+
+	rp := newTestReverseProxy(t, config.BackendConfig{
+		URL: fallback.URL,
+		RoutesByClaim: &config.ClaimRoutesConfig{
+			Claim:  "tenant",
+			Routes: map[string]string{"a": backendA.URL, "b": backendB.URL},
+		},
+	}, map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}, discardLogger())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.userInfo}
+			req := httptest.NewRequest("GET", "/app", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Body.String(); got != tt.wantBackend {
+				t.Errorf("backend = %q, want %q", got, tt.wantBackend)
+			}
+		})
+	}
+}
+
+func TestReverseProxyOnNoRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		userInfo   map[string]interface{}
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "matched route", policy: "404", userInfo: map[string]interface{}{"sub": "alice", "tenant": "a"}, wantStatus: http.StatusOK, wantBody: "a"},
+		{name: "default backend", policy: "default_backend", userInfo: map[string]interface{}{"sub": "carol", "tenant": "c"}, wantStatus: http.StatusOK, wantBody: "default"},
+		{name: "404", policy: "404", userInfo: map[string]interface{}{"sub": "carol", "tenant": "c"}, wantStatus: http.StatusNotFound},
+		{name: "404 missing claim", policy: "404", userInfo: map[string]interface{}{"sub": "dave"}, wantStatus: http.StatusNotFound},
+		{name: "branded error", policy: "branded_error", userInfo: map[string]interface{}{"sub": "carol", "tenant": "c"}, wantStatus: http.StatusNotFound, wantBody: "no application"},
+	}
+
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	backendA := newBackend("a")
+	defer backendA.Close()
+	fallback := newBackend("default")
+	defer fallback.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestReverseProxy(t, config.BackendConfig{
+				URL:       fallback.URL,
+				OnNoRoute: tt.policy,
+				RoutesByClaim: &config.ClaimRoutesConfig{
+					Claim:  "tenant",
+					Routes: map[string]string{"a": backendA.URL},
+				},
+			}, map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}, discardLogger())
+			rp.noRoutePage = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, "no application")
+			})
+
+			session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: tt.userInfo}
+			req := httptest.NewRequest("GET", "/app", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReverseProxyForwardHeaders(t *testing.T) {
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice", "email": "alice@example.com"}}
+	provider := &stubProvider{
+		id:       "corp",
+		mappings: map[string]config.HeaderMapping{"email": {Header: "X-User-Email"}},
+	}
+
+	tests := []struct {
+		name    string
+		forward []string
+		want    map[string]string
+	}{
+		{
+			name: "all headers forwarded",
+			want: map[string]string{"X-Request-Id": "abc", "X-Debug": "1", "Content-Type": "application/json", "X-User-Email": "alice@example.com"},
+		},
+		{
+			name:    "allowlist",
+			forward: []string{"x-request-id"},
+			want:    map[string]string{"X-Request-Id": "abc", "X-Debug": "", "Content-Type": "application/json", "X-User-Email": "alice@example.com"},
+		},
+		{
+			name:    "allowlisted identity header is still injected",
+			forward: []string{"X-User-Email"},
+			want:    map[string]string{"X-Request-Id": "", "X-Debug": "", "X-User-Email": "alice@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer backend.Close()
+
+			rp := newTestReverseProxy(t, config.BackendConfig{URL: backend.URL, ForwardHeaders: tt.forward},
+				map[string]auth.Provider{"corp": provider}, discardLogger())
+
+			req := httptest.NewRequest("POST", "/app", strings.NewReader("{}"))
+			req.Header.Set("X-Request-Id", "abc")
+			req.Header.Set("X-Debug", "1")
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-User-Email", "mallory@example.com")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rp.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got == nil {
+				t.Fatal("backend not reached")
+			}
+			for name, want := range tt.want {
+				if values := got.Values(name); (want == "" && len(values) > 0) || (want != "" && (len(values) != 1 || values[0] != want)) {
+					t.Errorf("%s = %q, want %q", name, values, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseProxyExemplars(t *testing.T) {
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}}
+
+	tests := []struct {
+		name         string
+		exemplars    bool
+		traceID      string
+		wantExemplar bool
+	}{
+		{name: "trace context", exemplars: true, traceID: "0af7651916cd43dd8448eb211c80319c", wantExemplar: true},
+		{name: "exemplars disabled", traceID: "1af7651916cd43dd8448eb211c80319c"},
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestReverseProxy(t, config.BackendConfig{URL: backend.URL}, map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}, discardLogger())
+			rp.exemplars = tt.exemplars
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.Header.Set("Traceparent", "00-"+tt.traceID+"-b7ad6b7169203331-01")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rp.ServeHTTP(httptest.NewRecorder(), req)
+
+			rec := httptest.NewRecorder()
+			metricsReq := httptest.NewRequest("GET", "/metrics", nil)
+			metricsReq.Header.Set("Accept", "application/openmetrics-text")
+			metrics.Default.ServeHTTP(rec, metricsReq)
+
+			if got := strings.Contains(rec.Body.String(), `trace_id="`+tt.traceID+`"`); got != tt.wantExemplar {
+				t.Errorf("exemplar recorded = %v, want %v", got, tt.wantExemplar)
+			}
+		})
+	}
+}
+
+func TestReverseProxyPreserveAuthorization(t *testing.T) {
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}}
+
+	tests := []struct {
+		name     string
+		preserve bool
+		forward  []string
+		want     string
+	}{
+		{name: "preserved", preserve: true, want: "Bearer client-token"},
+		{name: "preserved outside the allowlist", preserve: true, forward: []string{"X-Request-Id"}, want: "Bearer client-token"},
+		{name: "filtered by the allowlist", forward: []string{"X-Request-Id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Values("Authorization")
+			}))
+			defer backend.Close()
+
+			rp := newTestReverseProxy(t, config.BackendConfig{URL: backend.URL, ForwardHeaders: tt.forward, PreserveAuthorization: tt.preserve},
+				map[string]auth.Provider{"corp": &stubProvider{id: "corp"}}, discardLogger())
+
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.Header.Set("Authorization", "Bearer client-token")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, session))
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if tt.want == "" {
+				if len(got) > 0 {
+					t.Errorf("Authorization = %q, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rewriteRedirect points absolute redirects to the backend host at the public
+// URL instead. With a stripped path prefix, the prefix is added back to those
+// and to host-relative redirects such as /login. Other relative redirects and
+// redirects to other hosts are untouched.
+func rewriteRedirect(resp *http.Response, backendURL, publicURL *url.URL, prefix string) {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+
+	target, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	if !target.IsAbs() {
+		if prefix != "" && target.Host == "" && strings.HasPrefix(target.Path, "/") {
+			addPathPrefix(target, prefix)
+			resp.Header.Set("Location", target.String())
+		}
+		return
+	}
+
+	if !strings.EqualFold(target.Host, backendURL.Host) {
+		return
+	}
+
+	target.Scheme = publicURL.Scheme
+	target.Host = publicURL.Host
+	if prefix != "" {
+		addPathPrefix(target, prefix)
+	}
+	resp.Header.Set("Location", target.String())
+}
+
+func addPathPrefix(target *url.URL, prefix string) {
+	if target.Path == "" || target.Path == "/" {
+		target.Path = prefix
+	} else {
+		target.Path = prefix + target.Path
+	}
+	target.RawPath = ""
+}
+
+func rewriteCookieDomains(resp *http.Response, backendURL, publicURL *url.URL) {
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+
+	backendHost := backendURL.Hostname()
+	rewritten := false
+	for _, cookie := range cookies {
+		if strings.EqualFold(strings.TrimPrefix(cookie.Domain, "."), backendHost) {
+			cookie.Domain = publicURL.Hostname()
+			rewritten = true
+		}
+	}
+
+	if !rewritten {
+		return
+	}
+
+	resp.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+}
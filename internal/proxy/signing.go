@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+const (
+	SignatureHeader     = "X-Auth-Signature"
+	TimestampHeader     = "X-Auth-Timestamp"
+	SignedHeadersHeader = "X-Auth-Signed-Headers"
+)
+
+// SignHeaders signs the identity headers injected for provider, which is nil
+// for service sessions. The signed string is built from the lowercased header
+// names listed in X-Auth-Signed-Headers, sorted, as "name:value\n" lines,
+// followed by "x-auth-timestamp:<unix seconds>". The signature is hex encoded.
+func SignHeaders(req *http.Request, provider auth.Provider, cfg config.BackendConfig, now time.Time) {
+	if cfg.SignHeaders == nil {
+		return
+	}
+
+	names := signedHeaderNames(provider, cfg)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignedHeadersHeader, strings.Join(names, ","))
+	req.Header.Set(SignatureHeader, ComputeSignature([]byte(cfg.SignHeaders.Key), req.Header, names, timestamp))
+}
+
+// RemoveSignedHeaders deletes the client-supplied values of every header
+// SignHeaders would sign, so that only values set by the proxy are signed.
+// It must run before the identity headers are injected.
+func RemoveSignedHeaders(req *http.Request, provider auth.Provider, cfg config.BackendConfig) {
+	if cfg.SignHeaders == nil {
+		return
+	}
+	for _, name := range signedHeaderNames(provider, cfg) {
+		req.Header.Del(name)
+	}
+}
+
+// ComputeSignature returns the hex encoded HMAC-SHA256 of the canonical form
+// of the given headers and timestamp.
+func ComputeSignature(key []byte, header http.Header, names []string, timestamp string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteByte(':')
+		sb.WriteString(header.Get(name))
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("x-auth-timestamp:")
+	sb.WriteString(timestamp)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sb.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signedHeaderNames(provider auth.Provider, cfg config.BackendConfig) []string {
+	set := map[string]bool{
+		"x-auth-provider":      true,
+		"x-auth-provider-type": true,
+		"x-auth-session-id":    true,
+	}
+
+	if provider != nil {
+		for _, mapping := range provider.GetHeaderMappings() {
+			set[strings.ToLower(mapping.Header)] = true
+		}
+		set[strings.ToLower(AssuranceLevelHeader)] = true
+		set[strings.ToLower(ConcurrentSessionsHeader)] = true
+		set[strings.ToLower(ElevatedHeader)] = true
+		set[strings.ToLower(ElevatedUntilHeader)] = true
+	} else {
+		set[strings.ToLower(ServiceHeader)] = true
+	}
+	for _, ph := range headerPresets[cfg.HeaderPreset] {
+		set[strings.ToLower(ph.header)] = true
+	}
+	if cfg.InjectAuthContext {
+		set[strings.ToLower(AuthMethodsHeader)] = true
+		set[strings.ToLower(AuthTimeHeader)] = true
+	}
+	if cfg.PairwiseIDSalt != "" && provider != nil {
+		set[strings.ToLower(PairwiseIDHeader)] = true
+	}
+	if cfg.InjectClientIP {
+		set[strings.ToLower(ClientIPHeader)] = true
+	}
+	if cfg.GeoIPDatabase != "" {
+		set[strings.ToLower(ClientCountryHeader)] = true
+	}
+	if cfg.ClaimsHeader != "" {
+		set[strings.ToLower(cfg.ClaimsHeader)] = true
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestSignHeadersOnlySignsInjectedValues(t *testing.T) {
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"email": {Header: "X-User-Email"},
+		},
+	}
+	cfg := config.BackendConfig{
+		HeaderPreset:      "oauth2-proxy",
+		InjectAuthContext: true,
+		ClaimsHeader:      "X-Auth-Claims",
+		SignHeaders:       &config.SignHeadersConfig{Key: "secret"},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	forged := []string{"X-User-Email", "X-Forwarded-Email", AuthMethodsHeader, AuthTimeHeader, ElevatedHeader}
+	for _, header := range forged {
+		req.Header.Set(header, "forged")
+	}
+
+	session := &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{"sub": "alice"}}
+
+	RemoveSignedHeaders(req, provider, cfg)
+	if err := InjectHeaders(req, session, provider, cfg.HeaderPreset, nil); err != nil {
+		t.Fatalf("InjectHeaders: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	SignHeaders(req, provider, cfg, now)
+
+	signed := strings.Split(req.Header.Get(SignedHeadersHeader), ",")
+	for _, header := range forged {
+		if got := req.Header.Get(header); got != "" {
+			t.Errorf("%s = %q, want the forged value removed", header, got)
+		}
+		if !contains(signed, strings.ToLower(header)) {
+			t.Errorf("%s is not in %s", header, SignedHeadersHeader)
+		}
+	}
+
+	want := ComputeSignature([]byte("secret"), req.Header, signed, "1700000000")
+	if got := req.Header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+}
+
+func TestRemoveSignedHeadersWithoutSigning(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Auth-Claims", "kept")
+
+	RemoveSignedHeaders(req, nil, config.BackendConfig{ClaimsHeader: "X-Auth-Claims"})
+
+	if got := req.Header.Get("X-Auth-Claims"); got != "kept" {
+		t.Errorf("X-Auth-Claims = %q, want it untouched when signing is disabled", got)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+const (
+	StartupCheckNone    = "none"
+	StartupCheckWarn    = "warn"
+	StartupCheckRequire = "require"
+)
+
+// CheckBackend probes the backend according to cfg.StartupCheck. In warn
+// mode an unreachable backend is only logged; in require mode the probe is
+// retried with exponential backoff and an error is returned once retries are
+// exhausted.
+func CheckBackend(ctx context.Context, cfg config.BackendConfig, logger *slog.Logger) error {
+	if cfg.StartupCheck == StartupCheckNone {
+		return nil
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	backoff := cfg.StartupCheckBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := probeBackend(ctx, client, cfg.URL)
+		if err == nil {
+			logger.Info("backend reachable", "url", cfg.URL)
+			return nil
+		}
+
+		if cfg.StartupCheck == StartupCheckWarn {
+			logger.Warn("backend unreachable at startup", "url", cfg.URL, "error", err)
+			return nil
+		}
+
+		if attempt >= cfg.StartupCheckRetries {
+			return fmt.Errorf("backend %s unreachable after %d attempts: %w", cfg.URL, attempt+1, err)
+		}
+
+		logger.Warn("backend unreachable, retrying", "url", cfg.URL, "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// probeBackend treats any HTTP response as reachable, like the health check.
+func probeBackend(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestCheckBackend(t *testing.T) {
+	reachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer reachable.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	unreachable := closed.URL
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		mode    string
+		url     string
+		wantErr string
+	}{
+		{name: "none unreachable", mode: StartupCheckNone, url: unreachable},
+		{name: "warn reachable", mode: StartupCheckWarn, url: reachable.URL},
+		{name: "warn unreachable", mode: StartupCheckWarn, url: unreachable},
+		{name: "require reachable", mode: StartupCheckRequire, url: reachable.URL},
+		{name: "require unreachable", mode: StartupCheckRequire, url: unreachable, wantErr: "backend " + unreachable + " unreachable after 3 attempts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.BackendConfig{
+				URL:                 tt.url,
+				Timeout:             time.Second,
+				StartupCheck:        tt.mode,
+				StartupCheckRetries: 2,
+				StartupCheckBackoff: time.Millisecond,
+			}
+
+			err := CheckBackend(t.Context(), cfg, discardLogger())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckBackend: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Auth-Session-Id":   true,
+	"X-Csrf-Token":        true,
+}
+
+// bodyLoggingTransport logs a bounded prefix of textual request and response
+// bodies. Bodies are never buffered in full: the logged prefix is stitched
+// back in front of the remaining stream.
+type bodyLoggingTransport struct {
+	base   http.RoundTripper
+	cfg    config.LoggingConfig
+	logger *slog.Logger
+}
+
+func newBodyLoggingTransport(base http.RoundTripper, cfg config.LoggingConfig, logger *slog.Logger) http.RoundTripper {
+	if !cfg.LogBodies {
+		return base
+	}
+	return &bodyLoggingTransport{base: base, cfg: cfg, logger: logger}
+}
+
+func (t *bodyLoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.logger.Enabled(req.Context(), slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+
+	if req.Body != nil && t.loggable(req.Header.Get("Content-Type")) {
+		prefix, body, truncated := t.capture(req.Body)
+		req.Body = body
+		t.log(req.Context(), "proxied request body", req.Header, prefix, truncated,
+			"method", req.Method,
+			"path", req.URL.Path,
+		)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.Body != nil && t.loggable(resp.Header.Get("Content-Type")) {
+		prefix, body, truncated := t.capture(resp.Body)
+		resp.Body = body
+		t.log(req.Context(), "proxied response body", resp.Header, prefix, truncated,
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", resp.StatusCode,
+		)
+	}
+
+	return resp, nil
+}
+
+func (t *bodyLoggingTransport) loggable(contentType string) bool {
+	return matchesContentType(contentType, t.cfg.BodyContentTypes)
+}
+
+func (t *bodyLoggingTransport) capture(body io.ReadCloser) ([]byte, io.ReadCloser, bool) {
+	prefix, _ := io.ReadAll(io.LimitReader(body, int64(t.cfg.BodyMaxSize)+1))
+
+	restored := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), body), body}
+
+	truncated := len(prefix) > t.cfg.BodyMaxSize
+	if truncated {
+		prefix = prefix[:t.cfg.BodyMaxSize]
+	}
+	return prefix, restored, truncated
+}
+
+func (t *bodyLoggingTransport) log(ctx context.Context, msg string, header http.Header, body []byte, truncated bool, args ...any) {
+	args = append(args,
+		"headers", redactHeaders(header),
+		"body", string(body),
+		"truncated", truncated,
+	)
+	t.logger.DebugContext(ctx, msg, args...)
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			redacted[name] = "[REDACTED]"
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return redacted
+}
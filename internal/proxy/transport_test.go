@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// roundTripFunc answers requests without a network.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBodyLoggingTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		infoLevel   bool
+		contentType string
+		body        string
+		wantBody    string
+		wantTrunc   bool
+		wantLogged  bool
+	}{
+		{name: "disabled", contentType: "application/json", body: `{"a":1}`},
+		{name: "enabled", enabled: true, contentType: "application/json", body: `{"a":1}`, wantBody: `{"a":1}`, wantLogged: true},
+		{name: "at the cap", enabled: true, contentType: "text/plain", body: "0123456789", wantBody: "0123456789", wantLogged: true},
+		{name: "over the cap", enabled: true, contentType: "text/plain; charset=utf-8", body: "0123456789abc", wantBody: "0123456789", wantTrunc: true, wantLogged: true},
+		{name: "binary", enabled: true, contentType: "image/png", body: "\x89PNG"},
+		{name: "not debug level", enabled: true, infoLevel: true, contentType: "application/json", body: `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			level := slog.LevelDebug
+			if tt.infoLevel {
+				level = slog.LevelInfo
+			}
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: level}))
+
+			var gotRequestBody []byte
+			base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				gotRequestBody, _ = io.ReadAll(req.Body)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {tt.contentType}, "Set-Cookie": {"backend=secret"}},
+					Body:       io.NopCloser(strings.NewReader(tt.body)),
+				}, nil
+			})
+
+			transport := newBodyLoggingTransport(base, config.LoggingConfig{
+				LogBodies:        tt.enabled,
+				BodyMaxSize:      10,
+				BodyContentTypes: []string{"application/json", "text/"},
+			}, logger)
+
+			req := httptest.NewRequest("POST", "/api", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip: %v", err)
+			}
+			gotResponseBody, _ := io.ReadAll(resp.Body)
+
+			// Bodies reach the backend and the client whole, whatever is logged.
+			if string(gotRequestBody) != tt.body || string(gotResponseBody) != tt.body {
+				t.Errorf("bodies = %q/%q, want %q", gotRequestBody, gotResponseBody, tt.body)
+			}
+
+			var entries []map[string]interface{}
+			for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+				if len(line) == 0 {
+					continue
+				}
+				var entry map[string]interface{}
+				if err := json.Unmarshal(line, &entry); err != nil {
+					t.Fatalf("parse log line %q: %v", line, err)
+				}
+				entries = append(entries, entry)
+			}
+
+			if !tt.wantLogged {
+				if len(entries) > 0 {
+					t.Errorf("logged %v, want nothing", entries)
+				}
+				return
+			}
+			if len(entries) != 2 {
+				t.Fatalf("logged %d entries, want the request and response bodies", len(entries))
+			}
+			for _, entry := range entries {
+				if entry["body"] != tt.wantBody || entry["truncated"] != tt.wantTrunc {
+					t.Errorf("%s: body = %q, truncated = %v, want %q, %v", entry["msg"], entry["body"], entry["truncated"], tt.wantBody, tt.wantTrunc)
+				}
+				headers, _ := entry["headers"].(map[string]interface{})
+				for _, name := range []string{"Authorization", "Set-Cookie"} {
+					if value, ok := headers[name]; ok && value != "[REDACTED]" {
+						t.Errorf("%s: %s = %q, want it redacted", entry["msg"], name, value)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
+)
+
+// reauthCookieName marks a user who was sent to log in again after a backend
+// 401/403, so that a second refusal passes through instead of looping.
+const reauthCookieName = "__sso_reauth"
+
+const reauthWindow = 5 * time.Minute
+
+// errBackendUnauthorized is returned by ModifyResponse to hand a backend
+// 401/403 over to the error handler, which can write its own response.
+var errBackendUnauthorized = errors.New("backend refused the request")
+
+// checkBackendUnauthorized reports whether a backend response is a 401/403
+// that the on_backend_unauthorized policy replaces.
+func checkBackendUnauthorized(resp *http.Response, policy string) error {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return nil
+	}
+
+	switch policy {
+	case "reauth":
+		session, ok := middleware.GetSession(resp.Request.Context())
+		if !ok || session.ProviderType == auth.ServiceProviderType {
+			return nil
+		}
+		if _, err := resp.Request.Cookie(reauthCookieName); err == nil {
+			return nil
+		}
+		return errBackendUnauthorized
+	case "branded":
+		return errBackendUnauthorized
+	}
+	return nil
+}
+
+// respondBackendUnauthorized answers a request whose backend response was
+// replaced by checkBackendUnauthorized.
+func respondBackendUnauthorized(w http.ResponseWriter, r *http.Request, policy string, serverCfg config.ServerConfig, deniedPage http.Handler) {
+	if policy == "branded" {
+		deniedPage.ServeHTTP(w, r)
+		return
+	}
+
+	security.SetSessionCookie(w, r, serverCfg, &http.Cookie{
+		Name:     reauthCookieName,
+		Value:    "1",
+		Path:     "/",
+		MaxAge:   int(reauthWindow.Seconds()),
+		Secure:   serverCfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/auth/select", http.StatusFound)
+}
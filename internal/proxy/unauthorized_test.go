@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestReauthCookie(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     config.ServerConfig
+		wantSecure bool
+		wantSuffix string
+	}{
+		{name: "secure", server: config.ServerConfig{BaseURL: "http://sso.example.com", CookieSecure: true}, wantSecure: true},
+		{name: "not secure", server: config.ServerConfig{BaseURL: "https://sso.example.com"}},
+		{name: "priority", server: config.ServerConfig{CookieSecure: true, CookiePriority: "high"}, wantSecure: true, wantSuffix: "; Priority=High"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			respondBackendUnauthorized(rec, httptest.NewRequest("GET", "/app", nil), "reauth", tt.server, nil)
+
+			if rec.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+			}
+
+			header := rec.Header().Get("Set-Cookie")
+			if !strings.HasPrefix(header, reauthCookieName+"=1") {
+				t.Fatalf("Set-Cookie = %q, want the reauth cookie", header)
+			}
+			if got := strings.Contains(header, "; Secure"); got != tt.wantSecure {
+				t.Errorf("Secure = %v, want %v", got, tt.wantSecure)
+			}
+			if !strings.HasSuffix(header, tt.wantSuffix) {
+				t.Errorf("Set-Cookie = %q, want suffix %q", header, tt.wantSuffix)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"crypto/cipher"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+// VerifyHandler answers ForwardAuth and auth_request subrequests from a
+// front proxy: 200 with the identity headers the backend would receive when
+// the request has a session. It runs behind RequireAuth, which answers
+// requests without one.
+type VerifyHandler struct {
+	cfg          config.BackendConfig
+	providers    map[string]auth.Provider
+	headerCipher cipher.AEAD
+	logger       *slog.Logger
+}
+
+func NewVerifyHandler(cfg config.BackendConfig, providers map[string]auth.Provider, logger *slog.Logger) (*VerifyHandler, error) {
+	headerCipher, err := NewHeaderCipher(cfg.HeaderEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyHandler{
+		cfg:          cfg,
+		providers:    providers,
+		headerCipher: headerCipher,
+		logger:       logger,
+	}, nil
+}
+
+func (h *VerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetSession(r.Context())
+	if !ok {
+		h.logger.Error("no session in context, the verify route is not behind RequireAuth", "path", r.URL.Path)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	var provider auth.Provider
+	if session.ProviderType != auth.ServiceProviderType {
+		provider = h.providers[session.ProviderID]
+	}
+
+	// The headers are built on a request without the client's headers, so
+	// that only identity headers are copied to the response.
+	out := r.Clone(r.Context())
+	out.Header = make(http.Header)
+
+	if err := InjectHeaders(out, session, provider, h.cfg.HeaderPreset, h.headerCipher); err != nil {
+		if errors.Is(err, ErrMissingClaim) {
+			h.logger.Warn("rejecting request without a required claim",
+				"error", err,
+				"provider", session.ProviderID,
+				"session_id", session.ID,
+			)
+			httperror.Respond(w, r, http.StatusForbidden, "missing_claim", "Your account is missing information required by this application")
+			return
+		}
+		h.logger.Error("failed to inject headers", "error", err)
+		httperror.Respond(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+		return
+	}
+
+	if h.cfg.InjectAuthContext {
+		InjectAuthContext(out, session)
+	}
+
+	if h.cfg.PairwiseIDSalt != "" {
+		InjectPairwiseID(out, session, h.cfg.PairwiseIDSalt)
+	}
+
+	if err := InjectClaimsHeader(out, session, h.cfg); err != nil {
+		h.logger.Warn("claims header not injected", "session_id", session.ID, "error", err)
+	}
+
+	SignHeaders(out, provider, h.cfg, time.Now())
+
+	for name, values := range out.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/middleware"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestVerifyHandler(t *testing.T) {
+	provider := &stubProvider{
+		id: "corp",
+		mappings: map[string]config.HeaderMapping{
+			"email":      {Header: "X-User-Email"},
+			"department": {Header: "X-User-Department", Required: true},
+		},
+	}
+	h, err := NewVerifyHandler(config.BackendConfig{}, map[string]auth.Provider{"corp": provider}, discardLogger())
+	if err != nil {
+		t.Fatalf("NewVerifyHandler: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		session     *auth.Session
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name: "session",
+			session: &auth.Session{ID: "s1", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{
+				"email":      "alice@corp.com",
+				"department": "engineering",
+			}},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"X-User-Email": "alice@corp.com", "X-User-Department": "engineering", "X-Forwarded-User": ""},
+		},
+		{
+			name: "missing required claim",
+			session: &auth.Session{ID: "s2", ProviderID: "corp", ProviderType: "oidc", UserInfo: map[string]interface{}{
+				"email": "alice@corp.com",
+			}},
+			wantStatus:  http.StatusForbidden,
+			wantHeaders: map[string]string{"X-User-Email": ""},
+		},
+		{
+			name:       "no session",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/verify", nil)
+			req.Header.Set("X-Forwarded-User", "mallory")
+			if tt.session != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.SessionContextKey, tt.session))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
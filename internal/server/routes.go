@@ -1,53 +1,89 @@
 package server
 
 import (
-	"encoding/xml"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/auth/saml"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
 	"github.com/marcogenualdo/sso-switch/internal/handlers"
+	"github.com/marcogenualdo/sso-switch/internal/httperror"
+	"github.com/marcogenualdo/sso-switch/internal/metrics"
 	"github.com/marcogenualdo/sso-switch/internal/middleware"
 	"github.com/marcogenualdo/sso-switch/internal/proxy"
+	"github.com/marcogenualdo/sso-switch/pkg/security"
 )
 
 func (s *Server) setupRoutes() (http.Handler, error) {
 	mux := http.NewServeMux()
 
-	csrfMiddleware := middleware.NewCSRFMiddleware(s.cache, s.logger)
-	authMiddleware := middleware.NewAuthMiddleware(s.cfg.Server, s.cache, s.providers, s.logger)
+	csrfMiddleware := middleware.NewCSRFMiddleware(s.cfg.Server, s.cache, s.logger)
+	authMiddleware := middleware.NewAuthMiddleware(s.cfg.Server, s.cache, s.codec, s.providers, s.logger)
 
-	selectHandler, err := handlers.NewSelectHandler(s.cfg, s.cache, s.providers, csrfMiddleware, s.logger)
+	selectHandler, err := handlers.NewSelectHandler(s.cfg, s.cache, s.codec, s.providers, csrfMiddleware, s.logger)
 	if err != nil {
 		return nil, err
 	}
 
-	callbackHandler := handlers.NewCallbackHandler(s.cfg, s.cache, s.providers, s.logger)
-	logoutHandler := handlers.NewLogoutHandler(s.cfg, s.cache, s.logger)
+	trustedProxies, err := security.ParseTrustedProxies(s.cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	s.events = events.NewDispatcher(s.cfg.Events, trustedProxies, s.logger)
+
+	callbackHandler, err := handlers.NewCallbackHandler(s.cfg, s.cache, s.codec, s.providers, s.events, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	logoutHandler, err := handlers.NewLogoutHandler(s.cfg, s.cache, s.codec, s.providers, csrfMiddleware, s.events, s.logger)
+	if err != nil {
+		return nil, err
+	}
 	healthHandler := handlers.NewHealthHandler(s.cfg, s.cache, s.providers, s.logger)
+	s.readiness = handlers.NewReadinessHandler(s.cache, s.logger)
+	tokenHandler := handlers.NewTokenHandler(s.cfg, s.cache, s.codec, s.logger)
+	keepaliveHandler := handlers.NewKeepaliveHandler(s.cfg, s.cache, s.codec, s.logger)
+	elevateHandler := handlers.NewElevateHandler(s.cfg, s.cache, s.codec, s.providers, s.logger)
+
+	silentAuthHandler, err := handlers.NewSilentAuthHandler(s.cfg, s.cache, s.codec, s.providers, s.logger)
+	if err != nil {
+		return nil, err
+	}
 
-	reverseProxy, err := proxy.NewReverseProxy(s.cfg.Backend, s.providers, s.logger)
+	mockLoginHandler, err := handlers.NewMockLoginHandler(s.cfg, s.providers, s.logger)
 	if err != nil {
 		return nil, err
 	}
 
-	mux.HandleFunc("/auth/select", selectHandler.ServeHTTP)
+	mux.Handle("/auth/select", csrfMiddleware.ValidateCSRFForm(selectHandler, http.HandlerFunc(selectHandler.ServeRetry)))
 	mux.HandleFunc("/auth/select/logo", selectHandler.ServeLogo)
 
+	samlIssuers := make(map[string]string)
 	for id, provider := range s.providers {
 		if provider.Type() == "oidc" {
 			loginPath := "/auth/oidc/" + id + "/login"
-			callbackPath := "/auth/oidc/" + id + "/callback"
+			callbackPath := auth.OIDCCallbackPath(id)
 
-			mux.HandleFunc(loginPath, func(w http.ResponseWriter, r *http.Request) {
-				http.Redirect(w, r, "/auth/select", http.StatusFound)
-			})
+			mux.HandleFunc(loginPath, selectHandler.HandleLogin(id))
 
 			mux.HandleFunc(callbackPath, callbackHandler.HandleOIDCCallback(id))
 
+			mux.Handle("/auth/oidc/"+id+"/silent", allowSameOriginFraming(silentAuthHandler.HandleInitiate(id)))
+			mux.Handle(auth.OIDCSilentCallbackPath(id), allowSameOriginFraming(silentAuthHandler.HandleCallback(id)))
+
+			if s.cfg.Server.ElevationWindow > 0 {
+				mux.Handle(auth.OIDCElevateCallbackPath(id), authMiddleware.RequireAuth(elevateHandler.HandleCallback(id)))
+			}
+
 		} else if provider.Type() == "saml" {
 			loginPath := "/auth/saml/" + id + "/login"
-			acsPath := "/auth/saml/" + id + "/acs"
-			metadataPath := "/auth/saml/" + id + "/metadata"
+			acsPath := auth.SAMLACSPath(id)
+			metadataPath := auth.SAMLMetadataPath(id)
 
 			mux.HandleFunc(loginPath, func(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/auth/select", http.StatusFound)
@@ -57,29 +93,115 @@ func (s *Server) setupRoutes() (http.Handler, error) {
 
 			samlProvider, ok := provider.(*saml.Provider)
 			if ok {
+				if s.cfg.SAML.SharedACS {
+					issuer := samlProvider.IDPEntityID()
+					if other, exists := samlIssuers[issuer]; exists {
+						return nil, fmt.Errorf("saml providers %s and %s share IdP entity ID %s, which shared_acs cannot route", other, id, issuer)
+					}
+					samlIssuers[issuer] = id
+				}
+
 				mux.HandleFunc(metadataPath, func(w http.ResponseWriter, r *http.Request) {
-					metadata, err := samlProvider.GetMetadata()
+					metadata, err := samlProvider.MetadataDocument()
 					if err != nil {
-						http.Error(w, "Failed to generate metadata", http.StatusInternalServerError)
+						httperror.Respond(w, r, http.StatusInternalServerError, "metadata_error", "Failed to generate metadata")
+						return
+					}
+
+					maxAge := int(time.Until(metadata.ExpiresAt).Seconds())
+					w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+					w.Header().Set("Expires", metadata.ExpiresAt.UTC().Format(http.TimeFormat))
+					w.Header().Set("ETag", metadata.ETag)
+
+					if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, metadata.ETag) {
+						w.WriteHeader(http.StatusNotModified)
 						return
 					}
 
 					w.Header().Set("Content-Type", "application/xml")
-					xml.NewEncoder(w).Encode(metadata)
+					w.Write(metadata.XML)
 				})
 			}
+		} else if provider.Type() == "mock" {
+			mux.HandleFunc(auth.MockLoginPath(id), mockLoginHandler.HandleLogin(id))
+			mux.HandleFunc(auth.MockCallbackPath(id), callbackHandler.HandleMockCallback(id))
+		} else {
+			// Registered provider types get a callback handled like the OIDC
+			// one.
+			mux.HandleFunc(auth.CallbackPath(provider.Type(), id), callbackHandler.HandleOIDCCallback(id))
+		}
+	}
+
+	if s.cfg.SAML.SharedACS {
+		mux.HandleFunc(auth.SAMLSharedACSPath, callbackHandler.HandleSharedSAMLCallback(samlIssuers))
+	}
+
+	mux.Handle("/auth/logout", csrfMiddleware.ValidateCSRFForm(logoutHandler, http.HandlerFunc(logoutHandler.ServeRetry)))
+	if s.cfg.UI.LogoutConfirmation {
+		mux.HandleFunc("/auth/logged-out", logoutHandler.ServeLoggedOut)
+	}
+	mux.Handle("/auth/keepalive", keepaliveHandler)
+	if s.cfg.Server.ElevationWindow > 0 {
+		mux.Handle("/auth/elevate", authMiddleware.RequireAuth(http.HandlerFunc(elevateHandler.HandleInitiate)))
+	}
+
+	if len(s.cfg.ServiceClients) > 0 {
+		mux.Handle("/auth/token", tokenHandler)
+	}
+
+	if s.cfg.Admin.Secret != "" {
+		adminHandler := handlers.NewAdminHandler(s.cfg, s.providers, s.logger)
+		mux.Handle("/auth/admin/refresh-keys", adminHandler.RequireSecret(http.HandlerFunc(adminHandler.ServeRefreshKeys)))
+		mux.Handle("/metrics", adminHandler.RequireSecret(metrics.Default))
+
+		if s.cfg.Admin.SessionExportKey != "" {
+			transferHandler, err := handlers.NewSessionTransferHandler(s.cfg, s.cache, s.codec, s.logger)
+			if err != nil {
+				return nil, err
+			}
+			mux.Handle("/auth/admin/sessions/export", adminHandler.RequireSecret(http.HandlerFunc(transferHandler.ServeExport)))
+			mux.Handle("/auth/admin/sessions/import", adminHandler.RequireSecret(http.HandlerFunc(transferHandler.ServeImport)))
 		}
 	}
 
-	mux.Handle("/auth/logout", csrfMiddleware.ValidateCSRF(logoutHandler))
+	verifyHandler, err := proxy.NewVerifyHandler(s.cfg.Backend, s.providers, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/auth/verify", authMiddleware.RequireAuth(verifyHandler))
 
 	mux.HandleFunc("/health", healthHandler.ServeHTTP)
+	mux.Handle("/ready", s.readiness)
 
-	mux.Handle("/", authMiddleware.RequireAuth(reverseProxy))
+	if s.cfg.Server.Mode == config.ModeProxy {
+		forbiddenHandler, err := handlers.NewForbiddenHandler(s.cfg, s.logger)
+		if err != nil {
+			return nil, err
+		}
+
+		reverseProxy, err := proxy.NewReverseProxy(s.cfg.Backend, s.cfg.Server, s.cfg.Logging, s.cfg.Metrics, trustedProxies, s.providers, forbiddenHandler, forbiddenHandler.NoRoute(), s.logger)
+		if err != nil {
+			return nil, err
+		}
+
+		mux.Handle("/", authMiddleware.RequireAuth(reverseProxy))
+
+		// The proxy relies on the session set by RequireAuth: refuse to start
+		// if the catch-all route ends up anywhere else.
+		if h, _ := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/"}}); !middleware.RequiresAuth(h) {
+			return nil, fmt.Errorf("proxy route is not wrapped in authentication")
+		}
+	}
 
-	handler := middleware.Recovery(s.logger)(
-		middleware.Logging(s.logger)(
-			addSecurityHeaders(mux),
+	handler := middleware.ErrorFormat(s.cfg.Server.ErrorFormat)(
+		middleware.Recovery(s.logger)(
+			middleware.Logging(s.logger)(
+				middleware.ConcurrencyLimit(s.cfg.Server.MaxConcurrentRequests, s.logger)(
+					middleware.Timeout(s.cfg.Server.RequestTimeout, s.logger)(
+						addSecurityHeaders(mux),
+					),
+				),
+			),
 		),
 	)
 
@@ -97,3 +219,15 @@ func addSecurityHeaders(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// allowSameOriginFraming relaxes the frame restrictions set by
+// addSecurityHeaders for pages loaded in a hidden iframe by the application,
+// such as silent authentication.
+func allowSameOriginFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors 'self'")
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/auth/saml"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestFrameHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/select", ok)
+	mux.Handle("/auth/oidc/corp/silent", allowSameOriginFraming(ok))
+	handler := addSecurityHeaders(mux)
+
+	tests := []struct {
+		path         string
+		frameOptions string
+		csp          string
+	}{
+		{path: "/auth/select", frameOptions: "DENY"},
+		{path: "/auth/oidc/corp/silent", frameOptions: "SAMEORIGIN", csp: "frame-ancestors 'self'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if got := rec.Header().Get("X-Frame-Options"); got != tt.frameOptions {
+				t.Errorf("X-Frame-Options = %q, want %q", got, tt.frameOptions)
+			}
+			if got := rec.Header().Get("Content-Security-Policy"); got != tt.csp {
+				t.Errorf("Content-Security-Policy = %q, want %q", got, tt.csp)
+			}
+		})
+	}
+}
+
+func TestAuthOnlyRoutes(t *testing.T) {
+	handler := newTestRoutes(t, `
+server:
+  base_url: https://sso.example.com
+  mode: auth_only
+  post_login_redirect: https://app.example.com/
+`)
+
+	tests := []struct {
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{path: "/", wantStatus: http.StatusNotFound},
+		{path: "/app/page", wantStatus: http.StatusNotFound},
+		{path: "/auth/verify", wantStatus: http.StatusFound, wantLocation: "https://sso.example.com/auth/select"},
+		{path: "/auth/select", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	tests := []struct {
+		name          string
+		admin         string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "no admin secret", wantStatus: http.StatusNotFound},
+		{name: "no credentials", admin: "0123456789abcdef", wantStatus: http.StatusUnauthorized},
+		{name: "wrong secret", admin: "0123456789abcdef", authorization: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "admin secret", admin: "0123456789abcdef", authorization: "Bearer 0123456789abcdef", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+server:
+  base_url: https://sso.example.com
+  mode: auth_only
+  post_login_redirect: https://app.example.com/
+`
+			if tt.admin != "" {
+				yaml += "admin:\n  secret: " + tt.admin + "\n"
+			}
+			handler := newTestRoutes(t, yaml)
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// newTestRoutes builds the routes of a server without providers from the
+// given config.
+func newTestRoutes(t *testing.T, yaml string) http.Handler {
+	t.Helper()
+
+	return newTestRoutesWithProviders(t, yaml, map[string]auth.Provider{})
+}
+
+// newTestRoutesWithProviders builds the routes of a server with providers
+// from the given config.
+func newTestRoutesWithProviders(t *testing.T, yaml string, providers map[string]auth.Provider) http.Handler {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := config.Load(path, "")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	c, err := cache.NewMemoryCache(config.MemoryConfig{})
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	codec, err := cache.NewCodec("json")
+	if err != nil {
+		t.Fatalf("create codec: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s, err := New(*cfg, c, codec, providers, logger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	handler, err := s.setupRoutes()
+	if err != nil {
+		t.Fatalf("setupRoutes: %v", err)
+	}
+	return handler
+}
+
+// newTestSAMLProvider creates a SAML provider with a freshly generated SP
+// key pair and a minimal IdP metadata file.
+func newTestSAMLProvider(t *testing.T, id string, validity time.Duration) *saml.Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sso.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"sp.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		"sp.key": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		"idp.xml": []byte(`<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com">` +
+			`<IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">` +
+			`<SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.com/sso"/>` +
+			`</IDPSSODescriptor></EntityDescriptor>`),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	provider, err := saml.NewProvider(context.Background(), config.ProviderConfig{
+		ID:   id,
+		Name: id,
+		Type: "saml",
+		SAML: &config.SAMLConfig{
+			IDPMetadataXML:        filepath.Join(dir, "idp.xml"),
+			SPEntityID:            "https://sso.example.com/saml/" + id,
+			ACSURL:                "https://sso.example.com" + auth.SAMLACSPath(id),
+			CertificatePath:       filepath.Join(dir, "sp.crt"),
+			PrivateKeyPath:        filepath.Join(dir, "sp.key"),
+			MetadataValidDuration: validity,
+		},
+	}, config.SAMLDefaults{}, nil, nil, "https://sso.example.com", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider
+}
+
+func TestSAMLMetadataCaching(t *testing.T) {
+	const validity = 48 * time.Hour
+
+	handler := newTestRoutesWithProviders(t, `
+server:
+  base_url: https://sso.example.com
+backend:
+  url: http://backend:8080
+`, map[string]auth.Provider{"partner": newTestSAMLProvider(t, "partner", validity)})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", auth.SAMLMetadataPath("partner"), nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	cacheControl := rec.Header().Get("Cache-Control")
+	var maxAge int
+	if _, err := fmt.Sscanf(cacheControl, "public, max-age=%d", &maxAge); err != nil {
+		t.Fatalf("Cache-Control = %q, want public with a max-age", cacheControl)
+	}
+	if want := int((validity / 2).Seconds()); maxAge < want-60 || maxAge > want {
+		t.Errorf("max-age = %d, want about %d", maxAge, want)
+	}
+	expires, err := http.ParseTime(rec.Header().Get("Expires"))
+	if err != nil {
+		t.Fatalf("Expires = %q: %v", rec.Header().Get("Expires"), err)
+	}
+	if diff := time.Until(expires) - validity/2; diff < -time.Minute || diff > time.Minute {
+		t.Errorf("Expires = %s, want about %s from now", expires, validity/2)
+	}
+	if !strings.Contains(rec.Body.String(), "validUntil=") {
+		t.Errorf("metadata has no validUntil: %s", rec.Body)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{name: "matching etag", ifNoneMatch: etag, wantStatus: http.StatusNotModified},
+		{name: "etag in a list", ifNoneMatch: `"other", ` + etag, wantStatus: http.StatusNotModified},
+		{name: "stale etag", ifNoneMatch: `"other"`, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", auth.SAMLMetadataPath("partner"), nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() > 0 {
+				t.Errorf("304 response has a body: %s", rec.Body)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -13,20 +14,30 @@ import (
 	"github.com/marcogenualdo/sso-switch/internal/auth"
 	"github.com/marcogenualdo/sso-switch/internal/cache"
 	"github.com/marcogenualdo/sso-switch/internal/config"
+	"github.com/marcogenualdo/sso-switch/internal/events"
+	"github.com/marcogenualdo/sso-switch/internal/handlers"
+	"github.com/marcogenualdo/sso-switch/internal/proxy"
 )
 
 type Server struct {
-	cfg       config.Config
-	cache     cache.Cache
-	providers map[string]auth.Provider
-	logger    *slog.Logger
+	cfg        config.Config
+	cache      cache.Cache
+	codec      *cache.Codec
+	providers  map[string]auth.Provider
+	logger     *slog.Logger
 	httpServer *http.Server
+	events     *events.Dispatcher
+	readiness  *handlers.ReadinessHandler
+
+	stopKeyRefresh context.CancelFunc
+	stopReadiness  context.CancelFunc
 }
 
-func New(cfg config.Config, cache cache.Cache, providers map[string]auth.Provider, logger *slog.Logger) (*Server, error) {
+func New(cfg config.Config, cache cache.Cache, codec *cache.Codec, providers map[string]auth.Provider, logger *slog.Logger) (*Server, error) {
 	return &Server{
 		cfg:       cfg,
 		cache:     cache,
+		codec:     codec,
 		providers: providers,
 		logger:    logger,
 	}, nil
@@ -38,6 +49,22 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to setup routes: %w", err)
 	}
 
+	if s.cfg.Server.Mode == config.ModeProxy {
+		if err := proxy.CheckBackend(context.Background(), s.cfg.Backend, s.logger); err != nil {
+			return err
+		}
+	}
+
+	readinessCtx, cancelReadiness := context.WithCancel(context.Background())
+	s.stopReadiness = cancelReadiness
+	go s.readiness.Monitor(readinessCtx, s.cfg.Cache.ReadinessInterval)
+
+	if interval := s.keyRefreshInterval(); interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopKeyRefresh = cancel
+		go s.refreshKeys(ctx, interval)
+	}
+
 	s.httpServer = &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port),
 		Handler:      router,
@@ -45,6 +72,27 @@ func (s *Server) Start() error {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	if s.cfg.Backend.Protocol == "grpc" {
+		// gRPC clients without TLS connect with h2c prior knowledge, and
+		// streams may outlive any fixed write deadline.
+		s.httpServer.WriteTimeout = 0
+		s.httpServer.Protocols = new(http.Protocols)
+		s.httpServer.Protocols.SetHTTP1(true)
+		s.httpServer.Protocols.SetHTTP2(true)
+		s.httpServer.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	var certs *certReloader
+	if s.cfg.Server.TLSCertFile != "" {
+		certs, err = newCertReloader(s.cfg.Server.TLSCertFile, s.cfg.Server.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		s.httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
 
 	errChan := make(chan error, 1)
 	go func() {
@@ -52,8 +100,16 @@ func (s *Server) Start() error {
 			"host", s.cfg.Server.Host,
 			"port", s.cfg.Server.Port,
 			"base_url", s.cfg.Server.BaseURL,
+			"tls", certs != nil,
 		)
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+		var err error
+		if certs != nil {
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errChan <- err
 		}
 	}()
@@ -61,12 +117,26 @@ func (s *Server) Start() error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	select {
-	case err := <-errChan:
-		return err
-	case sig := <-sigChan:
-		s.logger.Info("received shutdown signal", "signal", sig)
-		return s.Shutdown()
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	for {
+		select {
+		case err := <-errChan:
+			return err
+		case <-reloadChan:
+			if certs == nil {
+				continue
+			}
+			if err := certs.Reload(); err != nil {
+				s.logger.Error("failed to reload TLS certificate", "error", err)
+				continue
+			}
+			s.logger.Info("TLS certificate reloaded")
+		case sig := <-sigChan:
+			s.logger.Info("received shutdown signal", "signal", sig)
+			return s.Shutdown()
+		}
 	}
 }
 
@@ -81,6 +151,17 @@ func (s *Server) Shutdown() error {
 		return err
 	}
 
+	if s.stopKeyRefresh != nil {
+		s.stopKeyRefresh()
+	}
+	if s.stopReadiness != nil {
+		s.stopReadiness()
+	}
+
+	if s.events != nil {
+		s.events.Close()
+	}
+
 	if err := s.cache.Close(); err != nil {
 		s.logger.Error("error closing cache", "error", err)
 	}
@@ -88,3 +169,40 @@ func (s *Server) Shutdown() error {
 	s.logger.Info("server shutdown complete")
 	return nil
 }
+
+// keyRefreshInterval is the jwks refresh_interval, or the discovery cache's
+// when it is enabled and shorter, so the persisted copies stay current.
+func (s *Server) keyRefreshInterval() time.Duration {
+	interval := s.cfg.OIDC.JWKS.RefreshInterval
+	if dc := s.cfg.OIDC.DiscoveryCache; dc.Enabled && (interval == 0 || dc.RefreshInterval < interval) {
+		interval = dc.RefreshInterval
+	}
+	return interval
+}
+
+// refreshKeys forces every provider that caches signing keys to fetch them
+// again each interval, until ctx is cancelled.
+func (s *Server) refreshKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for id, provider := range s.providers {
+			refresher, ok := provider.(auth.KeyRefresher)
+			if !ok {
+				continue
+			}
+			if err := refresher.RefreshKeys(ctx); err != nil {
+				s.logger.Warn("background key refresh failed", "provider", id, "error", err)
+				continue
+			}
+			s.logger.Debug("provider keys refreshed", "provider", id)
+		}
+	}
+}
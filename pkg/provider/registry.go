@@ -0,0 +1,89 @@
+// Package provider is the registry of provider types. Authentication systems
+// that are neither OIDC nor SAML are added as provider types of their own by
+// registering a Factory for the type, which the config can then use.
+//
+// The types a provider implementation needs are re-exported here, so that it
+// can live outside this module.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/marcogenualdo/sso-switch/internal/auth"
+	"github.com/marcogenualdo/sso-switch/internal/cache"
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+type (
+	// Provider authenticates users at an IdP.
+	Provider = auth.Provider
+	// Session is the authenticated session HandleCallback creates.
+	Session = auth.Session
+	// AuthRedirect is where InitiateAuth sends the browser.
+	AuthRedirect = auth.AuthRedirect
+	// HeaderMapping is the header a claim is injected as.
+	HeaderMapping = config.HeaderMapping
+	// Config is the config of one provider. Options of custom provider types
+	// are in Settings.
+	Config = config.ProviderConfig
+	// AppConfig is the effective config of the whole proxy.
+	AppConfig = config.Config
+	// Cache is the cache shared by the proxy.
+	Cache = cache.Cache
+	// Codec serializes values stored in the cache.
+	Codec = cache.Codec
+)
+
+// Factory creates a provider from its config.
+type Factory func(ctx context.Context, providerCfg Config, env Env) (Provider, error)
+
+// Env is what a Factory gets besides the provider's config.
+type Env struct {
+	Config AppConfig
+	Cache  Cache
+	Codec  *Codec
+	Logger *slog.Logger
+	// Providers holds the providers created so far, which are the ones
+	// listed before this one in the config.
+	Providers map[string]Provider
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes providers of type providerType available to the config. It
+// panics when the type is empty or already registered, or the factory is
+// nil, like database/sql.Register.
+func Register(providerType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if providerType == "" {
+		panic("provider: Register with an empty type")
+	}
+	if factory == nil {
+		panic("provider: Register factory is nil for " + providerType)
+	}
+	if _, exists := factories[providerType]; exists {
+		panic("provider: Register called twice for " + providerType)
+	}
+	factories[providerType] = factory
+	config.RegisterProviderType(providerType)
+}
+
+// New creates a provider with the factory registered for its type.
+func New(ctx context.Context, providerCfg Config, env Env) (Provider, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[providerCfg.Type]
+	factoriesMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unsupported provider type: %s", providerCfg.Type)
+	}
+	return factory(ctx, providerCfg, env)
+}
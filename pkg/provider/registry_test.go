@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+// legacyProvider is a custom provider type configured through settings.
+type legacyProvider struct {
+	Provider
+	id    string
+	realm string
+}
+
+func (p *legacyProvider) ID() string   { return p.id }
+func (p *legacyProvider) Type() string { return "legacy" }
+
+func (p *legacyProvider) HandleCallback(ctx context.Context, req *http.Request) (*Session, error) {
+	return &Session{ProviderID: p.id, ProviderType: p.Type()}, nil
+}
+
+func init() {
+	Register("legacy", func(ctx context.Context, providerCfg Config, env Env) (Provider, error) {
+		realm, _ := providerCfg.Settings["realm"].(string)
+		return &legacyProvider{id: providerCfg.ID, realm: realm}, nil
+	})
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name         string
+		providerType string
+		wantErr      string
+		wantRealm    string
+	}{
+		{name: "registered type", providerType: "legacy", wantRealm: "CORP"},
+		{name: "unknown type", providerType: "kerberos", wantErr: "invalid type: kerberos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(`
+server:
+  base_url: https://sso.example.com
+backend:
+  url: http://backend:8080
+providers:
+  - id: corp
+    name: Corporate Login
+    type: `+tt.providerType+`
+    settings:
+      realm: CORP
+    header_mappings:
+      sub: X-User-ID
+`), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+
+			cfg, err := config.Load(path, "")
+			if err != nil {
+				t.Fatalf("load config: %v", err)
+			}
+			if err := cfg.Validate(); err != nil {
+				if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("Validate() succeeded, want error %q", tt.wantErr)
+			}
+
+			p, err := New(context.Background(), cfg.Providers[0], Env{Config: *cfg})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			legacy, ok := p.(*legacyProvider)
+			if !ok {
+				t.Fatalf("New returned %T, want *legacyProvider", p)
+			}
+			if legacy.id != "corp" || legacy.realm != tt.wantRealm {
+				t.Errorf("provider = %+v, want id corp and realm %s", legacy, tt.wantRealm)
+			}
+		})
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(ctx context.Context, providerCfg Config, env Env) (Provider, error) { return nil, nil }
+
+	tests := []struct {
+		name         string
+		providerType string
+		factory      Factory
+	}{
+		{name: "empty type", factory: factory},
+		{name: "nil factory", providerType: "other"},
+		{name: "registered twice", providerType: "legacy", factory: factory},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Register did not panic")
+				}
+			}()
+			Register(tt.providerType, tt.factory)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies resolves the client IP of a request, trusting
+// X-Forwarded-For entries only when they were added by a trusted proxy.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+func ParseTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		tp.nets = append(tp.nets, ipNet)
+	}
+	return tp, nil
+}
+
+func (tp *TrustedProxies) trusted(ip net.IP) bool {
+	for _, ipNet := range tp.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP walks X-Forwarded-For from right to left, starting at the direct
+// peer, and returns the first address not belonging to a trusted proxy.
+func (tp *TrustedProxies) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !tp.trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !tp.trusted(ip) {
+			return ip
+		}
+	}
+	return ip
+}
@@ -0,0 +1,64 @@
+package security
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor []string
+		want          string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted peer ignores forwarded", remoteAddr: "203.0.113.7:1234", xForwardedFor: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted peer", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted chain", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"198.51.100.1, 192.0.2.1"}, want: "198.51.100.1"},
+		{name: "spoofed leftmost entry", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"6.6.6.6, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "multiple headers", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"198.51.100.1", "10.0.0.9"}, want: "198.51.100.1"},
+		{name: "malformed hop", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"garbage"}, want: "10.0.0.5"},
+		{name: "all hops trusted", remoteAddr: "10.0.0.5:1234", xForwardedFor: []string{"10.0.0.8"}, want: "10.0.0.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.xForwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := tp.ClientIP(req).String(); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		wantErr bool
+	}{
+		{name: "cidrs", cidrs: []string{"10.0.0.0/8", "fd00::/8"}},
+		{name: "bare addresses", cidrs: []string{"192.0.2.1", "2001:db8::1"}},
+		{name: "invalid", cidrs: []string{"not-an-ip"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTrustedProxies(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTrustedProxies error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
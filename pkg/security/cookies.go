@@ -35,6 +35,33 @@ func ClearSessionCookie(cfg config.ServerConfig) *http.Cookie {
 	return cookie
 }
 
+// SetSessionCookie adds cookie to the response, appending the configured
+// Priority attribute, which http.Cookie cannot represent. Under
+// samesite_compat, SameSite=None is omitted for browsers that mishandle it.
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, cfg config.ServerConfig, cookie *http.Cookie) {
+	if cfg.SameSiteCompat && cookie.SameSite == http.SameSiteNoneMode && SameSiteNoneIncompatible(r.UserAgent()) {
+		compat := *cookie
+		compat.SameSite = http.SameSiteDefaultMode
+		cookie = &compat
+	}
+
+	value := cookie.String()
+	if value == "" {
+		return
+	}
+
+	switch strings.ToLower(cfg.CookiePriority) {
+	case "low":
+		value += "; Priority=Low"
+	case "medium":
+		value += "; Priority=Medium"
+	case "high":
+		value += "; Priority=High"
+	}
+
+	w.Header().Add("Set-Cookie", value)
+}
+
 func GetSessionCookie(req *http.Request, cookieName string) (*http.Cookie, error) {
 	return req.Cookie(cookieName)
 }
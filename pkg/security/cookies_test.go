@@ -0,0 +1,77 @@
+package security
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcogenualdo/sso-switch/internal/config"
+)
+
+func TestSetSessionCookiePriority(t *testing.T) {
+	tests := []struct {
+		priority string
+		want     string
+	}{
+		{priority: "", want: ""},
+		{priority: "low", want: "; Priority=Low"},
+		{priority: "Medium", want: "; Priority=Medium"},
+		{priority: "HIGH", want: "; Priority=High"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.priority, func(t *testing.T) {
+			cfg := config.ServerConfig{CookieName: "session", CookieSecure: true, CookiePriority: tt.priority}
+			rec := httptest.NewRecorder()
+			SetSessionCookie(rec, httptest.NewRequest("GET", "/", nil), cfg, CreateSessionCookie(cfg, "abc", time.Hour))
+
+			got := rec.Header().Get("Set-Cookie")
+			if !strings.HasPrefix(got, "session=abc;") {
+				t.Fatalf("Set-Cookie = %q, want the session cookie", got)
+			}
+			if strings.Contains(got, "Priority") != (tt.want != "") || !strings.HasSuffix(got, tt.want) {
+				t.Errorf("Set-Cookie = %q, want suffix %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetSessionCookieSameSiteCompat(t *testing.T) {
+	const ios12 = "Mozilla/5.0 (iPhone; CPU iPhone OS 12_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.0 Mobile/15E148 Safari/604.1"
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+
+	tests := []struct {
+		name         string
+		compat       bool
+		sameSite     string
+		userAgent    string
+		wantSameSite string
+	}{
+		{name: "incompatible browser", compat: true, sameSite: "none", userAgent: ios12, wantSameSite: ""},
+		{name: "compatible browser", compat: true, sameSite: "none", userAgent: firefox, wantSameSite: "SameSite=None"},
+		{name: "compat disabled", sameSite: "none", userAgent: ios12, wantSameSite: "SameSite=None"},
+		{name: "lax unaffected", compat: true, sameSite: "lax", userAgent: ios12, wantSameSite: "SameSite=Lax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.ServerConfig{CookieName: "session", CookieSecure: true, CookieSameSite: tt.sameSite, SameSiteCompat: tt.compat}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			rec := httptest.NewRecorder()
+			SetSessionCookie(rec, req, cfg, CreateSessionCookie(cfg, "abc", time.Hour))
+
+			got := rec.Header().Get("Set-Cookie")
+			if tt.wantSameSite == "" {
+				if strings.Contains(got, "SameSite") {
+					t.Errorf("Set-Cookie = %q, want no SameSite attribute", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.wantSameSite) {
+				t.Errorf("Set-Cookie = %q, want %s", got, tt.wantSameSite)
+			}
+		})
+	}
+}
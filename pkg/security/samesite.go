@@ -0,0 +1,51 @@
+package security
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// User agents that reject or misinterpret SameSite=None, after the list
+// published by the Chromium project
+// (https://www.chromium.org/updates/same-site/incompatible-clients).
+var (
+	iOS12UA           = regexp.MustCompile(`\(iP.+; CPU .*OS 12[_\d]*.*\) AppleWebKit/`)
+	macOS1014UA       = regexp.MustCompile(`\(Macintosh;.*Mac OS X 10_14[_\d]*.*\) AppleWebKit/`)
+	safariUA          = regexp.MustCompile(`Version/.* Safari/`)
+	macEmbeddedUA     = regexp.MustCompile(`^Mozilla/[.\d]+ \(Macintosh;.*Mac OS X [_\d]+\) AppleWebKit/[.\d]+ \(KHTML, like Gecko\)$`)
+	chromiumUA        = regexp.MustCompile(`Chrom(?:e|ium)/(\d+)\.`)
+	chromiumBasedUA   = regexp.MustCompile(`Chrom(?:e|ium)`)
+	ucBrowserUA       = regexp.MustCompile(`UCBrowser/(\d+)\.(\d+)\.(\d+)`)
+	ucBrowserFixedVer = [3]int{12, 13, 2}
+)
+
+// SameSiteNoneIncompatible reports whether a browser with userAgent drops or
+// mishandles cookies with SameSite=None: iOS 12, Safari and embedded browsers
+// on macOS 10.14, Chrome 51 to 66 and UC Browser before 12.13.2.
+func SameSiteNoneIncompatible(userAgent string) bool {
+	if iOS12UA.MatchString(userAgent) {
+		return true
+	}
+
+	if macOS1014UA.MatchString(userAgent) &&
+		((safariUA.MatchString(userAgent) && !chromiumBasedUA.MatchString(userAgent)) || macEmbeddedUA.MatchString(userAgent)) {
+		return true
+	}
+
+	if m := chromiumUA.FindStringSubmatch(userAgent); m != nil {
+		if major, _ := strconv.Atoi(m[1]); major >= 51 && major <= 66 {
+			return true
+		}
+	}
+
+	if m := ucBrowserUA.FindStringSubmatch(userAgent); m != nil {
+		for i, want := range ucBrowserFixedVer {
+			v, _ := strconv.Atoi(m[i+1])
+			if v != want {
+				return v < want
+			}
+		}
+	}
+
+	return false
+}